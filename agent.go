@@ -0,0 +1,110 @@
+package heimdall
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+)
+
+// defaultAgentSteps caps an agent run when RunAgent is given no cap.
+const defaultAgentSteps = 10
+
+// RunAgent completes req in a loop, running the tools the model calls and
+// feeding their results back, until the model answers without calling a
+// tool. Every tool in req.Tools needs a function in tools, keyed by name; a
+// tool that fails is reported to the model as "error: " followed by its
+// error, and the loop carries on. Each model round trip is one step, and
+// after maxSteps of them (10 when maxSteps is not positive) the last
+// response is returned with ErrAgentStepLimit. The returned completion's
+// Usage covers every step.
+func (r *Router) RunAgent(
+	ctx context.Context,
+	req request.Completion,
+	tools map[string]func(json.RawMessage) (string, error),
+	maxSteps int,
+) (response.Completion, []response.Step, error) {
+	if maxSteps <= 0 {
+		maxSteps = defaultAgentSteps
+	}
+	for _, tool := range req.Tools {
+		if tools[tool.Name] == nil {
+			return response.Completion{}, nil, fmt.Errorf("no function registered for tool %q", tool.Name)
+		}
+	}
+	for _, model := range append([]models.Model{req.Model}, req.Fallback...) {
+		if err := checkTools(model); err != nil {
+			return response.Completion{}, nil, err
+		}
+	}
+
+	var steps []response.Step
+	var usage response.Usage
+	var resp response.Completion
+	for range maxSteps {
+		var err error
+		resp, err = r.Complete(ctx, req)
+		if err != nil {
+			return resp, steps, err
+		}
+		usage = usage.Add(resp.Usage)
+
+		step := response.Step{
+			Content:   resp.Content,
+			ToolCalls: resp.ToolCalls,
+			Usage:     resp.Usage,
+		}
+		if len(resp.ToolCalls) == 0 {
+			steps = append(steps, step)
+			resp.Usage = usage
+			return resp, steps, nil
+		}
+
+		req = req.WithAssistantReply(resp)
+		for _, call := range resp.ToolCalls {
+			result := runTool(tools, call)
+			step.Results = append(step.Results, result)
+			req.History = append(req.History, request.Message{
+				Role:       "tool",
+				Content:    result,
+				ToolCallID: call.ID,
+			})
+		}
+		steps = append(steps, step)
+	}
+
+	resp.Usage = usage
+
+	return resp, steps, ErrAgentStepLimit
+}
+
+// checkTools returns ErrToolsUnsupported when model cannot be sent tools,
+// see models.Capabilities.
+func checkTools(model models.Model) error {
+	if model == nil || model.Capabilities().Tools {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrToolsUnsupported, model.GetName())
+}
+
+// runTool calls the function registered for call and returns what the model
+// is told.
+func runTool(
+	tools map[string]func(json.RawMessage) (string, error),
+	call response.ToolCall,
+) string {
+	fn := tools[call.Name]
+	if fn == nil {
+		return fmt.Sprintf("error: unknown tool %q", call.Name)
+	}
+	result, err := fn(call.Arguments)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+
+	return result
+}
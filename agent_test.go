@@ -0,0 +1,189 @@
+package heimdall_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flyx-ai/heimdall"
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/providers"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterRunAgent(t *testing.T) {
+	t.Parallel()
+
+	weather := response.ToolCall{ID: "call_1", Name: "weather", Arguments: json.RawMessage(`{"city":"Oslo"}`)}
+	broken := response.ToolCall{ID: "call_2", Name: "broken", Arguments: json.RawMessage(`{}`)}
+	tools := map[string]func(json.RawMessage) (string, error){
+		"weather": func(args json.RawMessage) (string, error) {
+			var in struct{ City string }
+			if err := json.Unmarshal(args, &in); err != nil {
+				return "", err
+			}
+			return "rain in " + in.City, nil
+		},
+		"broken": func(json.RawMessage) (string, error) {
+			return "", errors.New("boom")
+		},
+	}
+	newReq := func() request.Completion {
+		return request.Completion{
+			Model:       models.GPT4OMini{},
+			UserMessage: "What is the weather in Oslo?",
+			Tools: []request.Tool{
+				{Name: "weather", Parameters: map[string]any{"type": "object"}},
+				{Name: "broken"},
+			},
+			Tags: map[string]string{},
+		}
+	}
+
+	t.Run("should run tools until the model answers", func(t *testing.T) {
+		t.Parallel()
+
+		calls := &callLog{}
+		router := heimdall.New(time.Second, []heimdall.LLMProvider{
+			fakeProvider{
+				name: models.OpenaiProvider,
+				responses: []response.Completion{
+					{ToolCalls: []response.ToolCall{weather, broken}, Usage: response.Usage{TotalTokens: 10}},
+					{Content: "It rains.", Usage: response.Usage{TotalTokens: 5}},
+				},
+				calls: calls,
+			},
+		})
+
+		res, steps, err := router.RunAgent(context.Background(), newReq(), tools, 0)
+		require.NoError(t, err)
+
+		assert.Equal(t, "It rains.", res.Content)
+		assert.Equal(t, 15, res.Usage.TotalTokens)
+		require.Len(t, steps, 2)
+		assert.Equal(t, []string{"rain in Oslo", "error: boom"}, steps[0].Results)
+		assert.Equal(t, "It rains.", steps[1].Content)
+
+		requests := calls.requests()
+		require.Len(t, requests, 2)
+		assert.Empty(t, requests[1].UserMessage)
+		assert.Equal(t, []request.Message{
+			{Role: "user", Content: "What is the weather in Oslo?"},
+			{Role: "assistant", ToolCalls: []response.ToolCall{weather, broken}},
+			{Role: "tool", Content: "rain in Oslo", ToolCallID: "call_1"},
+			{Role: "tool", Content: "error: boom", ToolCallID: "call_2"},
+		}, requests[1].History)
+	})
+
+	t.Run("should stop at the step cap", func(t *testing.T) {
+		t.Parallel()
+
+		calls := &callLog{}
+		router := heimdall.New(time.Second, []heimdall.LLMProvider{
+			fakeProvider{
+				name: models.OpenaiProvider,
+				responses: []response.Completion{
+					{ToolCalls: []response.ToolCall{weather}},
+					{ToolCalls: []response.ToolCall{weather}},
+				},
+				calls: calls,
+			},
+		})
+
+		_, steps, err := router.RunAgent(context.Background(), newReq(), tools, 2)
+		require.ErrorIs(t, err, heimdall.ErrAgentStepLimit)
+		assert.Len(t, steps, 2)
+		assert.Len(t, calls.requests(), 2)
+	})
+
+	t.Run("should refuse a fallback without tool support", func(t *testing.T) {
+		t.Parallel()
+
+		calls := &callLog{}
+		router := heimdall.New(time.Second, []heimdall.LLMProvider{
+			fakeProvider{name: models.OpenaiProvider, calls: calls},
+			fakeProvider{name: models.AnthropicProvider, calls: calls},
+		})
+		req := newReq()
+		req.Fallback = []models.Model{models.Claude45Haiku{}}
+
+		_, _, err := router.RunAgent(context.Background(), req, tools, 0)
+		require.ErrorIs(t, err, heimdall.ErrToolsUnsupported)
+		assert.Empty(t, calls.requests())
+	})
+
+	t.Run("should require a function for every tool", func(t *testing.T) {
+		t.Parallel()
+
+		calls := &callLog{}
+		router := heimdall.New(time.Second, []heimdall.LLMProvider{
+			fakeProvider{name: models.OpenaiProvider, calls: calls},
+		})
+
+		_, _, err := router.RunAgent(context.Background(), newReq(), map[string]func(json.RawMessage) (string, error){}, 0)
+		require.Error(t, err)
+		assert.Empty(t, calls.requests())
+	})
+}
+
+// roundTripFunc answers HTTP requests with a function, standing in for a
+// provider's API.
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRouterRunAgentRateLimited(t *testing.T) {
+	t.Parallel()
+
+	events := [][]string{
+		{
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"weather","arguments":"{\"city\":\"Oslo\"}"}}]},"finish_reason":"tool_calls"}]}`,
+		},
+		{
+			`{"choices":[{"delta":{"content":"It rains."},"finish_reason":"stop"}]}`,
+		},
+	}
+	var calls atomic.Int32
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		var body strings.Builder
+		for _, event := range events[calls.Add(1)-1] {
+			body.WriteString("data: " + event + "\n\n")
+		}
+		body.WriteString("data: [DONE]\n\n")
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+			Body:       io.NopCloser(strings.NewReader(body.String())),
+		}, nil
+	})
+	router := heimdall.New(time.Second, []heimdall.LLMProvider{
+		providers.WithRateLimit(providers.NewOpenAI([]string{"test-key"}), 0, 1),
+	}, heimdall.WithTransport(transport))
+
+	res, steps, err := router.RunAgent(context.Background(), request.Completion{
+		Model:       models.GPT4OMini{},
+		UserMessage: "What is the weather in Oslo?",
+		Tools:       []request.Tool{{Name: "weather", Parameters: map[string]any{"type": "object"}}},
+		Tags:        map[string]string{},
+	}, map[string]func(json.RawMessage) (string, error){
+		"weather": func(json.RawMessage) (string, error) { return "rain", nil },
+	}, 0)
+	require.NoError(t, err)
+
+	assert.Equal(t, "It rains.", res.Content)
+	require.Len(t, steps, 2)
+	assert.Equal(t, []string{"rain"}, steps[0].Results)
+	assert.EqualValues(t, 2, calls.Load())
+}
@@ -0,0 +1,464 @@
+package heimdall
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+)
+
+type chatCompletionRequest struct {
+	Model               string            `json:"model"`
+	Messages            []chatMessage     `json:"messages"`
+	Stream              bool              `json:"stream"`
+	StreamOptions       chatStreamOptions `json:"stream_options"`
+	Temperature         *float32          `json:"temperature"`
+	TopP                *float32          `json:"top_p"`
+	MaxTokens           int               `json:"max_tokens"`
+	MaxCompletionTokens int               `json:"max_completion_tokens"`
+	Stop                json.RawMessage   `json:"stop"`
+	PresencePenalty     float32           `json:"presence_penalty"`
+	ServiceTier         string            `json:"service_tier"`
+	Store               bool              `json:"store"`
+	Metadata            map[string]string `json:"metadata"`
+	// Fallback is a heimdall extension listing the models to try, in
+	// order, when Model fails.
+	Fallback []string `json:"fallback"`
+}
+
+type chatStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type chatMessage struct {
+	Role string `json:"role"`
+	// Content is either a string or an array of content parts.
+	Content json.RawMessage `json:"content"`
+}
+
+type chatContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL struct {
+		URL string `json:"url"`
+	} `json:"image_url"`
+}
+
+type chatCompletion struct {
+	ID          string       `json:"id"`
+	Object      string       `json:"object"`
+	Created     int64        `json:"created"`
+	Model       string       `json:"model"`
+	Choices     []chatChoice `json:"choices"`
+	Usage       *chatUsage   `json:"usage,omitempty"`
+	ServiceTier string       `json:"service_tier,omitempty"`
+}
+
+type chatChoice struct {
+	Index        int         `json:"index"`
+	Message      *chatOutput `json:"message,omitempty"`
+	Delta        *chatOutput `json:"delta,omitempty"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+type chatOutput struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type chatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type chatError struct {
+	Error chatErrorBody `json:"error"`
+}
+
+type chatErrorBody struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+}
+
+// ChatCompletionsHandler returns an http.Handler that serves OpenAI's chat
+// completions API, POST /v1/chat/completions, through the router, so OpenAI
+// SDKs and other compatible clients can use heimdall as a gateway.
+//
+// The model name is resolved with models.ByName, and the non-standard
+// "fallback" field lists further model names to fall back to. System and
+// developer messages become the system message. The last message is the
+// user message, or an assistant turn to be continued; a last user message
+// with images is sent as a turn of its own. Sampling parameters the target
+// provider cannot send, such as presence_penalty for Anthropic models, are
+// rejected. Streamed responses are sent as server-sent events ending in
+// "data: [DONE]".
+func (r *Router) ChatCompletionsHandler() http.Handler {
+	return http.HandlerFunc(r.serveChatCompletions)
+}
+
+func (r *Router) serveChatCompletions(w http.ResponseWriter, httpReq *http.Request) {
+	if httpReq.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeChatError(w, http.StatusMethodNotAllowed, "invalid_request_error", "", "method not allowed")
+		return
+	}
+
+	var body chatCompletionRequest
+	httpReq.Body = http.MaxBytesReader(w, httpReq.Body, maxChatRequestBytes)
+	if err := json.NewDecoder(httpReq.Body).Decode(&body); err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeChatError(w, status, "invalid_request_error", "",
+			fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	req, err := body.completion()
+	if err != nil {
+		code := ""
+		status := http.StatusBadRequest
+		if errors.Is(err, errUnknownModel) {
+			code = "model_not_found"
+			status = http.StatusNotFound
+		}
+		writeChatError(w, status, "invalid_request_error", code, err.Error())
+		return
+	}
+
+	id := requestID(req)
+	req.Tags["request_id"] = id
+	out := chatCompletion{
+		ID:      "chatcmpl-" + id,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   body.Model,
+	}
+
+	if body.Stream {
+		r.streamChatCompletion(w, httpReq, req, out, body.StreamOptions.IncludeUsage)
+		return
+	}
+
+	resp, err := r.Complete(httpReq.Context(), req)
+	if err != nil {
+		writeRouterError(w, err)
+		return
+	}
+
+	if resp.RequestLog.Model != nil {
+		out.Model = resp.RequestLog.Model.GetName()
+	}
+	out.Choices = []chatChoice{{
+		Message:      &chatOutput{Role: "assistant", Content: resp.Content},
+		FinishReason: chatFinishReason(resp),
+	}}
+	out.Usage = newChatUsage(resp.Usage)
+	out.ServiceTier = resp.ServiceTier
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func (r *Router) streamChatCompletion(
+	w http.ResponseWriter,
+	httpReq *http.Request,
+	req request.Completion,
+	out chatCompletion,
+	includeUsage bool,
+) {
+	out.Object = "chat.completion.chunk"
+	flusher, _ := w.(http.Flusher)
+
+	started := false
+	send := func(chunk chatCompletion) error {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		return nil
+	}
+	delta := func(d chatOutput, finishReason *string) chatCompletion {
+		chunk := out
+		chunk.Choices = []chatChoice{{Delta: &d, FinishReason: finishReason}}
+		return chunk
+	}
+
+	resp, err := r.Stream(httpReq.Context(), req, func(text string) error {
+		d := chatOutput{Content: text}
+		if !started {
+			d.Role = "assistant"
+		}
+		return send(delta(d, nil))
+	})
+	if err != nil {
+		if !started {
+			writeRouterError(w, err)
+			return
+		}
+		// The status line has been sent, so the error goes out as a final
+		// event instead.
+		_, typ, code := chatErrorStatus(err)
+		data, _ := json.Marshal(chatError{Error: chatErrorBody{
+			Message: err.Error(),
+			Type:    typ,
+			Code:    code,
+		}})
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", data)
+		return
+	}
+
+	final := chatOutput{}
+	if !started {
+		final.Role = "assistant"
+	}
+	if err := send(delta(final, chatFinishReason(resp))); err != nil {
+		return
+	}
+
+	if includeUsage {
+		chunk := out
+		chunk.Choices = []chatChoice{}
+		chunk.Usage = newChatUsage(resp.Usage)
+		if err := send(chunk); err != nil {
+			return
+		}
+	}
+
+	_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// maxChatRequestBytes caps the chat completion request body, matching the
+// providers' default cap on the request they send.
+const maxChatRequestBytes = 64 << 20
+
+var errUnknownModel = errors.New("unknown model")
+
+// completion translates an OpenAI chat completion request into the
+// router's request.
+func (c chatCompletionRequest) completion() (request.Completion, error) {
+	model, ok := models.ByName(c.Model)
+	if !ok {
+		return request.Completion{}, fmt.Errorf("%w: %q", errUnknownModel, c.Model)
+	}
+
+	req := request.Completion{
+		Model:           model,
+		Temperature:     chatSampling(c.Temperature),
+		TopP:            chatSampling(c.TopP),
+		MaxTokens:       c.MaxCompletionTokens,
+		PresencePenalty: c.PresencePenalty,
+		ServiceTier:     c.ServiceTier,
+		Store:           c.Store,
+		Tags:            map[string]string{},
+	}
+	if req.MaxTokens == 0 {
+		req.MaxTokens = c.MaxTokens
+	}
+	for k, v := range c.Metadata {
+		req.Tags[request.MetadataTagPrefix+k] = v
+	}
+
+	for _, name := range c.Fallback {
+		fallback, ok := models.ByName(name)
+		if !ok {
+			return request.Completion{}, fmt.Errorf("%w: %q", errUnknownModel, name)
+		}
+		req.Fallback = append(req.Fallback, fallback)
+	}
+	if c.PresencePenalty != 0 {
+		for _, m := range append([]models.Model{model}, req.Fallback...) {
+			if m.GetProvider() == models.AnthropicProvider {
+				return request.Completion{}, fmt.Errorf(
+					"presence_penalty is not supported by model %q", m.GetName(),
+				)
+			}
+		}
+	}
+
+	if len(c.Stop) > 0 {
+		var stop string
+		if err := json.Unmarshal(c.Stop, &stop); err == nil {
+			req.StopSequences = []string{stop}
+		} else if err := json.Unmarshal(c.Stop, &req.StopSequences); err != nil {
+			return request.Completion{}, errors.New("stop must be a string or an array of strings")
+		}
+	}
+
+	var system []string
+	var turns []request.Message
+	for _, msg := range c.Messages {
+		parts, err := msg.parts()
+		if err != nil {
+			return request.Completion{}, err
+		}
+
+		switch msg.Role {
+		case "system", "developer":
+			for _, part := range parts {
+				if part.Image != nil {
+					return request.Completion{}, errors.New("system messages cannot contain images")
+				}
+				system = append(system, part.Text)
+			}
+		case "user", "assistant":
+			turns = append(turns, request.Message{Role: msg.Role, Parts: parts})
+		default:
+			return request.Completion{}, fmt.Errorf("unsupported message role %q", msg.Role)
+		}
+	}
+	if len(turns) == 0 {
+		return request.Completion{}, errors.New("messages must contain a user or assistant message")
+	}
+	req.SystemMessage = strings.Join(system, "\n\n")
+
+	// A last user message with images stays a turn of its own, answered in
+	// place of an empty user message.
+	last := turns[len(turns)-1]
+	if last.Role == "user" && !slices.ContainsFunc(last.Parts, func(p request.Part) bool {
+		return p.Image != nil
+	}) {
+		var text strings.Builder
+		for _, part := range last.Parts {
+			text.WriteString(part.Text)
+		}
+		req.UserMessage = text.String()
+		turns = turns[:len(turns)-1]
+	}
+	req.History = turns
+
+	return req, nil
+}
+
+// chatSampling converts a sampling parameter. The router treats zero as
+// unset, so an explicit zero becomes the smallest positive value, which
+// samples the same way.
+func chatSampling(v *float32) float32 {
+	if v == nil {
+		return 0
+	}
+	if *v == 0 {
+		return math.SmallestNonzeroFloat32
+	}
+
+	return *v
+}
+
+// parts decodes the message content, a plain string or an array of text
+// and image_url parts.
+func (m chatMessage) parts() ([]request.Part, error) {
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return []request.Part{{Text: text}}, nil
+	}
+
+	var contentParts []chatContentPart
+	if err := json.Unmarshal(m.Content, &contentParts); err != nil {
+		return nil, errors.New("message content must be a string or an array of parts")
+	}
+
+	parts := make([]request.Part, 0, len(contentParts))
+	for _, p := range contentParts {
+		switch p.Type {
+		case "text":
+			parts = append(parts, request.Part{Text: p.Text})
+		case "image_url":
+			parts = append(parts, request.Part{Image: chatImage(p.ImageURL.URL)})
+		default:
+			return nil, fmt.Errorf("unsupported content part type %q", p.Type)
+		}
+	}
+
+	return parts, nil
+}
+
+// chatImage turns an image_url, either a link or a base64 data URL, into an
+// image.
+func chatImage(url string) *request.Image {
+	meta, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	if ok && strings.HasPrefix(url, "data:") && strings.HasSuffix(meta, ";base64") {
+		return &request.Image{
+			MimeType: request.MimeType(strings.TrimSuffix(meta, ";base64")),
+			Data:     data,
+		}
+	}
+
+	return &request.Image{URL: url}
+}
+
+func chatFinishReason(resp response.Completion) *string {
+	reason := string(response.FinishStop)
+	switch resp.FinishReason {
+	case response.FinishLength, response.FinishContentFilter, response.FinishToolCalls:
+		reason = string(resp.FinishReason)
+	}
+
+	return &reason
+}
+
+func newChatUsage(usage response.Usage) *chatUsage {
+	return &chatUsage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+	}
+}
+
+// chatErrorStatus maps a router error to the HTTP status, error type and
+// code an OpenAI client expects.
+func chatErrorStatus(err error) (int, string, string) {
+	switch {
+	case errors.Is(err, ErrContentFiltered):
+		return http.StatusBadRequest, "invalid_request_error", "content_filter"
+	case errors.Is(err, ErrUnsupportedInput), errors.Is(err, ErrModelNotSupportedByProvider):
+		return http.StatusBadRequest, "invalid_request_error", ""
+	case isRateLimited(err):
+		return http.StatusTooManyRequests, "rate_limit_error", ""
+	default:
+		return http.StatusBadGateway, "api_error", ""
+	}
+}
+
+func writeRouterError(w http.ResponseWriter, err error) {
+	status, typ, code := chatErrorStatus(err)
+	writeChatError(w, status, typ, code, err.Error())
+}
+
+func writeChatError(w http.ResponseWriter, status int, typ, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(chatError{Error: chatErrorBody{
+		Message: message,
+		Type:    typ,
+		Code:    code,
+	}})
+}
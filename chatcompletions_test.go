@@ -0,0 +1,254 @@
+package heimdall_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flyx-ai/heimdall"
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newChatServer(t *testing.T, calls *callLog) *httptest.Server {
+	t.Helper()
+
+	router := heimdall.New(time.Second, []heimdall.LLMProvider{
+		fakeProvider{
+			name:   models.OpenaiProvider,
+			chunks: []string{"Hel", "lo!"},
+			usage:  response.Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15},
+			calls:  calls,
+		},
+	})
+	srv := httptest.NewServer(router.ChatCompletionsHandler())
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestChatCompletionsHandler(t *testing.T) {
+	t.Parallel()
+
+	calls := &callLog{}
+	srv := newChatServer(t, calls)
+
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(`{
+		"model": "gpt-4o-mini-2024-07-18",
+		"messages": [
+			{"role": "system", "content": "you are a helpful assistant."},
+			{"role": "user", "content": "Hi."},
+			{"role": "assistant", "content": "Hello, how can I help?"},
+			{"role": "user", "content": [{"type": "text", "text": "Say hello in one sentence."}]}
+		],
+		"stop": "\n",
+		"max_completion_tokens": 64,
+		"metadata": {"experiment": "gateway"}
+	}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		Model   string `json:"model"`
+		Choices []struct {
+			Message struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage struct {
+			TotalTokens int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	assert.True(t, strings.HasPrefix(body.ID, "chatcmpl-"))
+	assert.Equal(t, "chat.completion", body.Object)
+	assert.Equal(t, models.GPT4OMiniAlias, body.Model)
+	require.Len(t, body.Choices, 1)
+	assert.Equal(t, "assistant", body.Choices[0].Message.Role)
+	assert.Equal(t, "Hello!", body.Choices[0].Message.Content)
+	assert.Equal(t, "stop", body.Choices[0].FinishReason)
+	assert.Equal(t, 15, body.Usage.TotalTokens)
+
+	requests := calls.requests()
+	require.Len(t, requests, 1)
+	req := requests[0]
+	assert.Equal(t, "you are a helpful assistant.", req.SystemMessage)
+	assert.Equal(t, "Say hello in one sentence.", req.UserMessage)
+	require.Len(t, req.History, 2)
+	assert.Equal(t, "assistant", req.History[1].Role)
+	assert.Equal(t, []string{"\n"}, req.StopSequences)
+	assert.Equal(t, 64, req.MaxTokens)
+	assert.Equal(t, map[string]string{"experiment": "gateway"}, req.Metadata())
+}
+
+func TestChatCompletionsHandlerRequest(t *testing.T) {
+	t.Parallel()
+
+	calls := &callLog{}
+	srv := newChatServer(t, calls)
+
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(`{
+		"model": "gpt-4o-mini-2024-07-18",
+		"messages": [{"role": "user", "content": [
+			{"type": "text", "text": "What is in this image?"},
+			{"type": "image_url", "image_url": {"url": "https://example.com/cat.png"}}
+		]}],
+		"temperature": 0,
+		"top_p": 0.5,
+		"presence_penalty": 0.25
+	}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	requests := calls.requests()
+	require.Len(t, requests, 1)
+	req := requests[0]
+	assert.Empty(t, req.UserMessage)
+	require.Len(t, req.History, 1)
+	assert.Equal(t, "user", req.History[0].Role)
+	assert.Equal(t, []request.Part{
+		{Text: "What is in this image?"},
+		{Image: &request.Image{URL: "https://example.com/cat.png"}},
+	}, req.History[0].Parts)
+	assert.Positive(t, req.Temperature, "an explicit zero temperature must not read as unset")
+	assert.Less(t, req.Temperature, float32(1e-6))
+	assert.Equal(t, float32(0.5), req.TopP)
+	assert.Equal(t, float32(0.25), req.PresencePenalty)
+}
+
+func TestChatCompletionsHandlerStream(t *testing.T) {
+	t.Parallel()
+
+	calls := &callLog{}
+	srv := newChatServer(t, calls)
+
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(`{
+		"model": "gpt-4o-mini-2024-07-18",
+		"messages": [{"role": "user", "content": "Say hello in one sentence."}],
+		"stream": true,
+		"stream_options": {"include_usage": true}
+	}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	var events []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	require.NoError(t, scanner.Err())
+
+	require.Len(t, events, 5)
+	assert.Equal(t, "[DONE]", events[4])
+
+	type chunk struct {
+		Object  string `json:"object"`
+		Choices []struct {
+			Delta struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"delta"`
+			FinishReason *string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage *struct {
+			TotalTokens int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	var chunks []chunk
+	for _, event := range events[:4] {
+		var c chunk
+		require.NoError(t, json.Unmarshal([]byte(event), &c))
+		assert.Equal(t, "chat.completion.chunk", c.Object)
+		chunks = append(chunks, c)
+	}
+
+	assert.Equal(t, "assistant", chunks[0].Choices[0].Delta.Role)
+	assert.Equal(t, "Hel", chunks[0].Choices[0].Delta.Content)
+	assert.Nil(t, chunks[0].Choices[0].FinishReason)
+	assert.Equal(t, "lo!", chunks[1].Choices[0].Delta.Content)
+	require.NotNil(t, chunks[2].Choices[0].FinishReason)
+	assert.Equal(t, "stop", *chunks[2].Choices[0].FinishReason)
+	assert.Empty(t, chunks[3].Choices)
+	require.NotNil(t, chunks[3].Usage)
+	assert.Equal(t, 15, chunks[3].Usage.TotalTokens)
+}
+
+func TestChatCompletionsHandlerErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		"should reject unknown models": {
+			body:       `{"model": "gpt-unknown", "messages": [{"role": "user", "content": "Hi."}]}`,
+			wantStatus: http.StatusNotFound,
+			wantCode:   "model_not_found",
+		},
+		"should reject unsupported roles": {
+			body:       `{"model": "gpt-4o-mini-2024-07-18", "messages": [{"role": "tool", "content": "42"}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		"should reject malformed bodies": {
+			body:       `{"model":`,
+			wantStatus: http.StatusBadRequest,
+		},
+		"should reject parameters the provider cannot send": {
+			body: `{"model": "claude-sonnet-4-5-20250929", "presence_penalty": 0.5,
+				"messages": [{"role": "user", "content": "Hi."}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		"should reject oversized bodies": {
+			body: `{"model": "gpt-4o-mini-2024-07-18", "messages": [{"role": "user", "content": "` +
+				strings.Repeat("a", 64<<20) + `"}]}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			calls := &callLog{}
+			srv := newChatServer(t, calls)
+
+			resp, err := http.Post(srv.URL, "application/json", strings.NewReader(tt.body))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			var body struct {
+				Error struct {
+					Message string `json:"message"`
+					Type    string `json:"type"`
+					Code    string `json:"code"`
+				} `json:"error"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			assert.Equal(t, "invalid_request_error", body.Error.Type)
+			assert.Equal(t, tt.wantCode, body.Error.Code)
+			assert.NotEmpty(t, body.Error.Message)
+			assert.Empty(t, calls.requests())
+		})
+	}
+}
@@ -16,8 +16,16 @@ func (r *Router) Complete(
 ) (response.Completion, error) {
 	now := time.Now()
 
+	req = req.ApplyDefaults(request.DefaultsFromContext(ctx))
+	if err := req.Validate(); err != nil {
+		return response.Completion{}, err
+	}
+
 	req.Tags["request_type"] = "completion"
 
+	id := requestID(req)
+	ctx = request.WithID(ctx, id)
+
 	requestLog := response.Logging{
 		Events: []response.Event{
 			{
@@ -25,16 +33,18 @@ func (r *Router) Complete(
 				Description: "start of call to Complete",
 			},
 		},
+		RequestID: id,
 		SystemMsg: req.SystemMessage,
 		UserMsg:   req.UserMessage,
 		Start:     now,
 	}
 
-	models := append([]models.Model{req.Model}, req.Fallback...)
+	var served models.Model
+	candidates := r.latency.order(append([]models.Model{req.Model}, req.Fallback...))
 	var err error
 	resp := response.Completion{}
 
-	for _, model := range models {
+	for _, model := range candidates {
 		if r.providers[model.GetProvider()] == nil {
 			requestLog.Events = append(requestLog.Events, response.Event{
 				Timestamp: time.Now(),
@@ -44,6 +54,26 @@ func (r *Router) Complete(
 					model.GetProvider(),
 				),
 			})
+			if err == nil {
+				// Fail rather than return an empty response when no
+				// model has a provider, but keep a real provider error.
+				err = fmt.Errorf("%w: %s", ErrUnsupportedProvider, model.GetProvider())
+			}
+
+			continue
+		}
+		if supportErr := r.checkSupported(model); supportErr != nil {
+			requestLog.Events = append(requestLog.Events, response.Event{
+				Timestamp: time.Now(),
+				Description: fmt.Sprintf(
+					"attempting tryWithModel using model: %s but provider: %s does not support it. attempting with next model.",
+					model.GetName(),
+					model.GetProvider(),
+				),
+			})
+			if err == nil {
+				err = supportErr
+			}
 
 			continue
 		}
@@ -54,18 +84,27 @@ func (r *Router) Complete(
 				model.GetName(),
 			),
 		})
+		attemptStart := time.Now()
 		resp, err = r.tryWithModel(ctx, req, model, &requestLog)
 		if err == nil {
+			r.latency.observe(model, time.Since(attemptStart))
+		}
+		if err != nil && resp.Partial {
+			// A response cut off by the provider's content limit is
+			// returned as it stands rather than regenerated elsewhere.
+			break
+		}
+		if err != nil {
+			resp, model, err = r.degrade(ctx, req, model, err, nil, &requestLog)
+		}
+		if err == nil {
+			served = model
+			resp, err = r.continueTruncated(ctx, req, model, resp, &requestLog)
 			break
 		}
 	}
 
-	requestLog.Completed = err == nil
-	if err == nil {
-		requestLog.Response = resp.Content
-	}
-
-	requestLog.End = time.Now()
+	r.finishLog(&requestLog, req, served, resp, err)
 
 	resp.RequestLog = requestLog
 
@@ -78,6 +117,74 @@ func (r *Router) tryWithModel(
 	model models.Model,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	req.Model = model
 	provider := r.providers[model.GetProvider()]
-	return provider.CompleteResponse(ctx, req, r.client, requestLog)
+	resp, err := provider.CompleteResponse(ctx, req, r.client, requestLog)
+	if err == nil && resp.Provider == "" {
+		resp.Provider = provider.Name()
+	}
+
+	return resp, err
+}
+
+// continueTruncated asks the model that served resp to carry on while resp
+// was cut off by the token limit, up to req.AutoContinue times, and joins
+// the parts into one response. Only providers that continue an assistant
+// turn in place are asked, as others would start a new answer. A failed
+// continuation returns the text so far, marked Partial, with its error. A
+// response still cut off is repaired into partial JSON when
+// req.SalvagePartialJSON is set.
+func (r *Router) continueTruncated(
+	ctx context.Context,
+	req request.Completion,
+	model models.Model,
+	resp response.Completion,
+	requestLog *response.Logging,
+) (response.Completion, error) {
+	req.Model = model
+	if req.AutoContinue > 0 && resp.Truncated() && !supportsPrefill(r.providers[model.GetProvider()]) {
+		requestLog.Events = append(requestLog.Events, response.Event{
+			Timestamp: time.Now(),
+			Description: fmt.Sprintf(
+				"response was cut off by the token limit but provider: %s cannot continue it",
+				model.GetProvider(),
+			),
+		})
+		req.AutoContinue = 0
+	}
+	for i := 0; i < req.AutoContinue && resp.Truncated(); i++ {
+		requestLog.Events = append(requestLog.Events, response.Event{
+			Timestamp: time.Now(),
+			Description: fmt.Sprintf(
+				"response was cut off by the token limit, continuing. continuation: %v",
+				i+1,
+			),
+		})
+
+		next, err := r.tryWithModel(ctx, req.WithAssistantReply(resp), model, requestLog)
+		if err != nil {
+			resp.Partial = true
+			return resp, err
+		}
+
+		resp.Content += next.Content
+		resp.Thoughts += next.Thoughts
+		resp.FinishReason = next.FinishReason
+		resp.Usage = resp.Usage.Add(next.Usage)
+	}
+
+	if req.SalvagePartialJSON && resp.Truncated() {
+		if repaired, ok := response.RepairJSON(resp.Content); ok {
+			requestLog.Events = append(requestLog.Events, response.Event{
+				Timestamp:   time.Now(),
+				Description: "response was cut off by the token limit, returning repaired partial JSON",
+			})
+
+			resp.TruncatedContent = resp.Content
+			resp.Content = repaired
+			resp.Partial = true
+		}
+	}
+
+	return resp, nil
 }
@@ -0,0 +1,530 @@
+package heimdall_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flyx-ai/heimdall"
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterRequestID(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should propagate the caller's request id", func(t *testing.T) {
+		var seen string
+		router := heimdall.New(time.Second, []heimdall.LLMProvider{
+			fakeProvider{
+				name: models.OpenaiProvider,
+				onCall: func(ctx context.Context, requestLog *response.Logging) {
+					seen = request.IDFromContext(ctx)
+					requestLog.Events = append(requestLog.Events, response.Event{
+						Timestamp:   time.Now(),
+						Description: "provider event",
+					})
+				},
+			},
+		})
+
+		res, err := router.Complete(context.Background(), request.Completion{
+			Model: models.GPT4OMini{},
+			Tags:  map[string]string{"request_id": "req-123"},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "req-123", seen)
+		assert.Equal(t, "req-123", res.RequestLog.RequestID)
+		for _, event := range res.RequestLog.Events {
+			assert.Equal(t, "req-123", event.RequestID, event.Description)
+		}
+	})
+
+	t.Run("should generate a fresh id per call", func(t *testing.T) {
+		router := heimdall.New(time.Second, []heimdall.LLMProvider{
+			fakeProvider{name: models.OpenaiProvider},
+		})
+		req := request.Completion{
+			Model: models.GPT4OMini{},
+			Tags:  map[string]string{},
+		}
+
+		first, err := router.Complete(context.Background(), req)
+		require.NoError(t, err)
+		second, err := router.Complete(context.Background(), req)
+		require.NoError(t, err)
+
+		assert.NotEmpty(t, first.RequestLog.RequestID)
+		assert.NotEqual(t, first.RequestLog.RequestID, second.RequestLog.RequestID)
+	})
+}
+
+func TestRouterLoggingSink(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	router := heimdall.New(
+		time.Second,
+		[]heimdall.LLMProvider{fakeProvider{name: models.OpenaiProvider}},
+		heimdall.WithLoggingSink(response.NewWriterSink(&buf)),
+	)
+
+	_, err := router.Complete(context.Background(), request.Completion{
+		Model:       models.GPT4OMini{},
+		UserMessage: "hello",
+		Tags:        map[string]string{"team": "data"},
+	})
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var summary map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &summary))
+
+	assert.Equal(t, "summary", summary["type"])
+	assert.Equal(t, models.GPT4OMiniAlias, summary["model"])
+	assert.Equal(t, models.OpenaiProvider, summary["provider"])
+	assert.Equal(t, "data", summary["tags"].(map[string]any)["team"])
+}
+
+func TestRouterCostUsesCachedRate(t *testing.T) {
+	t.Parallel()
+
+	router := heimdall.New(time.Second, []heimdall.LLMProvider{
+		fakeProvider{
+			name: models.OpenaiProvider,
+			usage: response.Usage{
+				PromptTokens:     1_000_000,
+				CachedTokens:     400_000,
+				CompletionTokens: 100_000,
+				TotalTokens:      1_100_000,
+			},
+		},
+	})
+
+	res, err := router.Complete(context.Background(), request.Completion{
+		Model: models.GPT4O{},
+		Tags:  map[string]string{},
+	})
+	require.NoError(t, err)
+
+	// 600k uncached at $2.50/1M, 400k cached at $1.25/1M, 100k output at $10/1M.
+	assert.InDelta(t, 1.5+0.5, res.RequestLog.InputCost, 1e-9)
+	assert.InDelta(t, 1.0, res.RequestLog.OutputCost, 1e-9)
+	assert.InDelta(t, 1.5+0.5+1.0, res.RequestLog.Cost, 1e-9)
+}
+
+func TestRouterCostUsesPricingOverride(t *testing.T) {
+	t.Parallel()
+
+	// No other test prices GPT-4.1 nano, so the global override is safe to
+	// set while tests run in parallel.
+	models.SetPricing(models.GPT41NanoAlias, 1.0, 4.0)
+	models.SetCachedPricing(models.GPT41NanoAlias, 0.5)
+	t.Cleanup(func() { models.ClearPricing(models.GPT41NanoAlias) })
+
+	router := heimdall.New(time.Second, []heimdall.LLMProvider{
+		fakeProvider{
+			name: models.OpenaiProvider,
+			usage: response.Usage{
+				PromptTokens:     1_000_000,
+				CachedTokens:     400_000,
+				CompletionTokens: 100_000,
+				TotalTokens:      1_100_000,
+			},
+		},
+	})
+
+	res, err := router.Complete(context.Background(), request.Completion{
+		Model: models.GPT41Nano{},
+		Tags:  map[string]string{},
+	})
+	require.NoError(t, err)
+
+	// 600k uncached at $1/1M, 400k cached at $0.50/1M, 100k output at $4/1M.
+	assert.InDelta(t, 0.6+0.2, res.RequestLog.InputCost, 1e-9)
+	assert.InDelta(t, 0.4, res.RequestLog.OutputCost, 1e-9)
+	assert.InDelta(t, 0.6+0.2+0.4, res.RequestLog.Cost, 1e-9)
+}
+
+func TestRouterContextDefaults(t *testing.T) {
+	t.Parallel()
+
+	calls := &callLog{}
+	router := heimdall.New(time.Second, []heimdall.LLMProvider{
+		fakeProvider{
+			name:      models.OpenaiProvider,
+			responses: []response.Completion{{Content: "one"}, {Content: "two"}},
+			calls:     calls,
+		},
+	})
+	defaults := request.Defaults{
+		Model:       models.GPT4OMini{},
+		Tags:        map[string]string{"tenant": "acme", "env": "prod"},
+		Temperature: 0.2,
+	}
+	ctx := request.WithDefaults(context.Background(), defaults)
+
+	_, err := router.Complete(ctx, request.Completion{UserMessage: "Hello"})
+	require.NoError(t, err)
+	_, err = router.Complete(ctx, request.Completion{
+		Model:       models.GPT4O{},
+		UserMessage: "Hello",
+		Temperature: 0.9,
+		Tags:        map[string]string{"env": "staging"},
+	})
+	require.NoError(t, err)
+
+	requests := calls.requests()
+	require.Len(t, requests, 2)
+	assert.Equal(t, models.GPT4OMini{}, requests[0].Model)
+	assert.Equal(t, float32(0.2), requests[0].Temperature)
+	assert.Equal(t, "acme", requests[0].Tags["tenant"])
+	assert.Equal(t, "prod", requests[0].Tags["env"])
+
+	assert.Equal(t, models.GPT4O{}, requests[1].Model, "the request's own model should win")
+	assert.Equal(t, float32(0.9), requests[1].Temperature)
+	assert.Equal(t, "acme", requests[1].Tags["tenant"])
+	assert.Equal(t, "staging", requests[1].Tags["env"], "the request's own tags should win")
+
+	assert.Equal(t, map[string]string{"tenant": "acme", "env": "prod"}, defaults.Tags,
+		"the router should not write to the default tags")
+}
+
+func TestRouterAutoContinue(t *testing.T) {
+	t.Parallel()
+
+	parts := []response.Completion{
+		{Content: "Once upon", FinishReason: response.FinishLength, Usage: response.Usage{PromptTokens: 10, CompletionTokens: 2, TotalTokens: 12}},
+		{Content: " a time", FinishReason: response.FinishLength, Usage: response.Usage{PromptTokens: 12, CompletionTokens: 2, TotalTokens: 14}},
+		{Content: " it ended.", FinishReason: response.FinishStop, Usage: response.Usage{PromptTokens: 14, CompletionTokens: 3, TotalTokens: 17}},
+	}
+
+	tests := map[string]struct {
+		autoContinue int
+		wantCalls    int
+		wantContent  string
+		wantTotal    int
+		wantCut      bool
+	}{
+		"should return a truncated response untouched when disabled": {
+			autoContinue: 0,
+			wantCalls:    1,
+			wantContent:  "Once upon",
+			wantTotal:    12,
+			wantCut:      true,
+		},
+		"should stop at the continuation cap": {
+			autoContinue: 1,
+			wantCalls:    2,
+			wantContent:  "Once upon a time",
+			wantTotal:    26,
+			wantCut:      true,
+		},
+		"should stop once the model finishes": {
+			autoContinue: 5,
+			wantCalls:    3,
+			wantContent:  "Once upon a time it ended.",
+			wantTotal:    43,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			calls := &callLog{}
+			router := heimdall.New(time.Second, []heimdall.LLMProvider{
+				fakeProvider{name: models.AnthropicProvider, responses: parts, calls: calls},
+			})
+
+			res, err := router.Complete(context.Background(), request.Completion{
+				Model:        models.Claude45Haiku{},
+				UserMessage:  "Tell me a story.",
+				AutoContinue: tt.autoContinue,
+				Tags:         map[string]string{},
+			})
+			require.NoError(t, err)
+
+			require.Len(t, calls.requests(), tt.wantCalls)
+			assert.Equal(t, tt.wantContent, res.Content)
+			assert.Equal(t, tt.wantTotal, res.Usage.TotalTokens)
+			assert.Equal(t, tt.wantCut, res.Truncated())
+
+			for i, req := range calls.requests()[1:] {
+				assert.Empty(t, req.UserMessage)
+				assert.Equal(t, []request.Message{
+					{Role: "user", Content: "Tell me a story."},
+					{Role: "assistant", Content: strings.Join([]string{"Once upon", " a time"}[:i+1], "")},
+				}, req.History)
+			}
+		})
+	}
+}
+
+func TestRouterAutoContinueWithoutPrefill(t *testing.T) {
+	t.Parallel()
+
+	calls := &callLog{}
+	router := heimdall.New(time.Second, []heimdall.LLMProvider{
+		fakeProvider{
+			name: models.OpenaiProvider,
+			responses: []response.Completion{
+				{Content: "Once upon", FinishReason: response.FinishLength},
+				{Content: "Here is a new story.", FinishReason: response.FinishStop},
+			},
+			calls: calls,
+		},
+	})
+
+	res, err := router.Complete(context.Background(), request.Completion{
+		Model:        models.GPT4OMini{},
+		UserMessage:  "Tell me a story.",
+		AutoContinue: 2,
+		Tags:         map[string]string{},
+	})
+	require.NoError(t, err)
+
+	assert.Len(t, calls.requests(), 1, "a provider without prefill would start a new answer")
+	assert.Equal(t, "Once upon", res.Content)
+	assert.True(t, res.Truncated())
+}
+
+func TestRouterAutoContinueKeepsTextOnFailure(t *testing.T) {
+	t.Parallel()
+
+	errUpstream := errors.New("upstream failed")
+	calls := &callLog{}
+	router := heimdall.New(time.Second, []heimdall.LLMProvider{
+		fakeProvider{
+			name: models.AnthropicProvider,
+			responses: []response.Completion{
+				{Content: "Once upon", FinishReason: response.FinishLength},
+				{Content: " a time", FinishReason: response.FinishLength},
+			},
+			callErrs: []error{nil, nil, errUpstream},
+			calls:    calls,
+		},
+	})
+
+	res, err := router.Complete(context.Background(), request.Completion{
+		Model:        models.Claude45Haiku{},
+		UserMessage:  "Tell me a story.",
+		AutoContinue: 3,
+		Tags:         map[string]string{},
+	})
+	require.ErrorIs(t, err, errUpstream)
+
+	assert.Len(t, calls.requests(), 3)
+	assert.Equal(t, "Once upon a time", res.Content)
+	assert.True(t, res.Partial)
+}
+
+func TestRouterSalvagePartialJSON(t *testing.T) {
+	t.Parallel()
+
+	parts := []response.Completion{
+		{Content: `{"name": "Ada", "langs": ["Fortran", `, FinishReason: response.FinishLength},
+		{Content: `"COBOL", "Lis`, FinishReason: response.FinishLength},
+	}
+
+	tests := map[string]struct {
+		salvage     bool
+		wantContent string
+		wantPartial bool
+	}{
+		"should repair JSON still cut off after continuing": {
+			salvage:     true,
+			wantContent: `{"name": "Ada", "langs": ["Fortran", "COBOL", "Lis"]}`,
+			wantPartial: true,
+		},
+		"should leave truncated JSON untouched when disabled": {
+			wantContent: `{"name": "Ada", "langs": ["Fortran", "COBOL", "Lis`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			calls := &callLog{}
+			router := heimdall.New(time.Second, []heimdall.LLMProvider{
+				fakeProvider{name: models.AnthropicProvider, responses: parts, calls: calls},
+			})
+
+			res, err := router.Complete(context.Background(), request.Completion{
+				Model:              models.Claude45Haiku{},
+				UserMessage:        "Describe Ada Lovelace as JSON.",
+				AutoContinue:       1,
+				SalvagePartialJSON: tt.salvage,
+				Tags:               map[string]string{},
+			})
+			require.NoError(t, err)
+
+			require.Len(t, calls.requests(), 2, "continuation should be tried before salvage")
+			assert.Equal(t, tt.wantContent, res.Content)
+			assert.Equal(t, tt.wantPartial, res.Partial)
+			assert.True(t, res.Truncated())
+			if tt.wantPartial {
+				assert.Equal(t, parts[0].Content+parts[1].Content, res.TruncatedContent)
+			} else {
+				assert.Empty(t, res.TruncatedContent)
+			}
+		})
+	}
+}
+
+// statusError is a provider error carrying the upstream status code.
+type statusError int
+
+func (e statusError) Error() string   { return http.StatusText(int(e)) }
+func (e statusError) StatusCode() int { return int(e) }
+
+func TestRouterDegradeOnRateLimit(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		model        models.Model
+		limited      []string
+		degrade      bool
+		wantModel    string
+		wantDegraded string
+		wantErr      bool
+	}{
+		"should fail when not opted in": {
+			model:   models.GPT4O{},
+			limited: []string{models.GPT4OAlias},
+			wantErr: true,
+		},
+		"should move to the smaller sibling": {
+			model:        models.GPT4O{},
+			limited:      []string{models.GPT4OAlias},
+			degrade:      true,
+			wantModel:    models.GPT4OMiniAlias,
+			wantDegraded: models.GPT4OAlias,
+		},
+		"should keep stepping down while rate limited": {
+			model:        models.GPT41{},
+			limited:      []string{models.GPT41Alias, models.GPT41MiniAlias},
+			degrade:      true,
+			wantModel:    models.GPT41NanoAlias,
+			wantDegraded: models.GPT41Alias,
+		},
+		"should fail when the smallest sibling is rate limited too": {
+			model:   models.GPT4O{},
+			limited: []string{models.GPT4OAlias, models.GPT4OMiniAlias},
+			degrade: true,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			errs := map[string]error{}
+			for _, m := range tt.limited {
+				errs[m] = statusError(http.StatusTooManyRequests)
+			}
+			router := heimdall.New(time.Second, []heimdall.LLMProvider{
+				fakeProvider{name: models.OpenaiProvider, errs: errs},
+			})
+
+			res, err := router.Complete(context.Background(), request.Completion{
+				Model:              tt.model,
+				UserMessage:        "Say hello in one sentence.",
+				DegradeOnRateLimit: tt.degrade,
+				Tags:               map[string]string{},
+			})
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantModel, res.Model)
+			assert.Equal(t, tt.wantModel, res.RequestLog.Model.GetName())
+			assert.Equal(t, tt.wantDegraded, res.RequestLog.DegradedFrom)
+		})
+	}
+}
+
+func TestRouterModelNotSupportedByProvider(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should fail before calling the provider", func(t *testing.T) {
+		t.Parallel()
+
+		calls := &callLog{}
+		router := heimdall.New(time.Second, []heimdall.LLMProvider{
+			fakeProvider{
+				name:      models.OpenaiProvider,
+				supported: models.GPT4OMiniAlias,
+				calls:     calls,
+			},
+		})
+
+		_, err := router.Complete(context.Background(), request.Completion{
+			Model:       models.GPT4O{},
+			UserMessage: "Say hello in one sentence.",
+			Tags:        map[string]string{},
+		})
+		require.ErrorIs(t, err, heimdall.ErrModelNotSupportedByProvider)
+		assert.Empty(t, calls.requests())
+	})
+
+	t.Run("should move on to a supported fallback", func(t *testing.T) {
+		t.Parallel()
+
+		calls := &callLog{}
+		router := heimdall.New(time.Second, []heimdall.LLMProvider{
+			fakeProvider{
+				name:      models.OpenaiProvider,
+				supported: models.GPT4OMiniAlias,
+				calls:     calls,
+			},
+		})
+
+		res, err := router.Complete(context.Background(), request.Completion{
+			Model:       models.GPT4O{},
+			Fallback:    []models.Model{models.GPT4OMini{}},
+			UserMessage: "Say hello in one sentence.",
+			Tags:        map[string]string{},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, models.GPT4OMiniAlias, res.RequestLog.Model.GetName())
+		assert.Len(t, calls.requests(), 1)
+	})
+
+	t.Run("should keep the error of an earlier model", func(t *testing.T) {
+		t.Parallel()
+
+		calls := &callLog{}
+		router := heimdall.New(time.Second, []heimdall.LLMProvider{
+			fakeProvider{
+				name:      models.OpenaiProvider,
+				errs:      map[string]error{models.GPT4OMiniAlias: statusError(http.StatusTooManyRequests)},
+				supported: models.GPT4OMiniAlias,
+				calls:     calls,
+			},
+		})
+
+		_, err := router.Complete(context.Background(), request.Completion{
+			Model:       models.GPT4OMini{},
+			Fallback:    []models.Model{models.GPT4O{}},
+			UserMessage: "Say hello in one sentence.",
+			Tags:        map[string]string{},
+		})
+		require.ErrorIs(t, err, statusError(http.StatusTooManyRequests))
+		assert.NotErrorIs(t, err, heimdall.ErrModelNotSupportedByProvider)
+		assert.Len(t, calls.requests(), 1)
+	})
+}
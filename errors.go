@@ -1,6 +1,11 @@
 package heimdall
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/response"
+)
 
 var (
 	ErrRateLimitHit        = errors.New("rate limit exceeded")
@@ -8,4 +13,24 @@ var (
 	ErrNoChunkHandler      = errors.New(
 		"a chunk handler must be provided to stream response",
 	)
+	// ErrModelNotSupportedByProvider is returned when the provider registered
+	// for a model reports that it cannot serve it, before any request is
+	// sent.
+	ErrModelNotSupportedByProvider = errors.New("model not supported by provider")
+	// ErrUnsupportedInput is returned before any provider is called when the
+	// request carries images or tools a model cannot consume.
+	ErrUnsupportedInput = models.ErrUnsupportedInput
+	// ErrContentFiltered is returned, wrapped in a
+	// *response.ContentFilteredError, when the provider blocked the prompt or
+	// cut the response short on safety grounds.
+	ErrContentFiltered = response.ErrContentFiltered
+	// ErrOverBudget is returned when a FallbackPolicy skips a model whose
+	// estimated cost exceeds the policy's maximum.
+	ErrOverBudget = errors.New("estimated cost over budget")
+	// ErrToolsUnsupported is returned by RunAgent when the request's model or
+	// one of its fallbacks cannot be sent tools.
+	ErrToolsUnsupported = errors.New("model does not support tools")
+	// ErrAgentStepLimit is returned by RunAgent, along with the last
+	// response, when the model still calls tools after the step cap.
+	ErrAgentStepLimit = errors.New("agent step limit reached")
 )
@@ -2,7 +2,10 @@ module github.com/flyx-ai/heimdall
 
 go 1.24.0
 
-require golang.org/x/oauth2 v0.29.0
+require (
+	golang.org/x/oauth2 v0.29.0
+	golang.org/x/time v0.11.0
+)
 
 require (
 	cloud.google.com/go v0.120.1 // indirect
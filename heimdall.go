@@ -2,9 +2,17 @@ package heimdall
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/flyx-ai/heimdall/models"
 	"github.com/flyx-ai/heimdall/request"
 	"github.com/flyx-ai/heimdall/response"
 )
@@ -34,9 +42,38 @@ type RouterConfig struct {
 type Router struct {
 	providers map[string]LLMProvider
 	client    http.Client
+	sink      response.LoggingSink
+	// latency tracks whole completions and streamLatency the time to a
+	// stream's first chunk, so each path orders models by its own samples.
+	latency       *latencyTracker
+	streamLatency *latencyTracker
 }
 
-func New(timeout time.Duration, llmProviders []LLMProvider) *Router {
+// Option configures a Router at construction time.
+type Option func(*Router)
+
+// WithLoggingSink hands the log of every finished request to sink. Sink
+// errors are not returned to the caller, whose request already completed.
+func WithLoggingSink(sink response.LoggingSink) Option {
+	return func(r *Router) {
+		r.sink = sink
+	}
+}
+
+// WithTransport sends the router's provider requests through rt instead of
+// the default transport, such as one that goes through a proxy or records
+// traffic. The timeout given to New still applies to the whole request.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(r *Router) {
+		r.client.Transport = rt
+	}
+}
+
+func New(
+	timeout time.Duration,
+	llmProviders []LLMProvider,
+	opts ...Option,
+) *Router {
 	c := http.Client{
 		Timeout: timeout,
 		Transport: &http.Transport{
@@ -50,8 +87,228 @@ func New(timeout time.Duration, llmProviders []LLMProvider) *Router {
 		providers[provider.Name()] = provider
 	}
 
-	return &Router{
-		providers,
-		c,
+	r := &Router{
+		providers:     providers,
+		client:        c,
+		latency:       &latencyTracker{stats: map[string]LatencyStat{}},
+		streamLatency: &latencyTracker{stats: map[string]LatencyStat{}},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Close shuts down the router's providers and releases its idle
+// connections. Providers that own long-lived clients, such as VertexAI,
+// implement io.Closer and are closed here; stateless providers need not
+// implement it, or can implement a no-op. The router must not be used after
+// Close.
+func (r *Router) Close() error {
+	var errs []error
+	for _, provider := range r.providers {
+		if closer, ok := provider.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	r.client.CloseIdleConnections()
+
+	return errors.Join(errs...)
+}
+
+// checkSupported returns ErrModelNotSupportedByProvider when the provider
+// registered for model reports, through a Supports method, that it cannot
+// serve it. Providers without one are trusted with every model routed to
+// them.
+func (r *Router) checkSupported(model models.Model) error {
+	provider := r.providers[model.GetProvider()]
+	checker, ok := provider.(interface{ Supports(m models.Model) bool })
+	if !ok || checker.Supports(model) {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"%w: %s is not served by %s",
+		ErrModelNotSupportedByProvider,
+		model.GetName(),
+		provider.Name(),
+	)
+}
+
+// supportsPrefill reports whether provider continues a trailing assistant
+// turn in place, see providers.LLMProvider.SupportsPrefill. A provider that
+// does not say is taken not to, as a chat API answers such a turn afresh.
+func supportsPrefill(provider LLMProvider) bool {
+	p, ok := provider.(interface{ SupportsPrefill() bool })
+	return ok && p.SupportsPrefill()
+}
+
+// degrade retries a request that was rate limited on model with the model's
+// smaller siblings, one step down at a time, when the request opts in with
+// DegradeOnRateLimit. Streams are retried as streams when chunkHandler is
+// set. It returns the model that served the response, or the last one tried
+// along with its error.
+func (r *Router) degrade(
+	ctx context.Context,
+	req request.Completion,
+	model models.Model,
+	err error,
+	chunkHandler func(chunk string) error,
+	requestLog *response.Logging,
+) (response.Completion, models.Model, error) {
+	for req.DegradeOnRateLimit && isRateLimited(err) {
+		downgrader, ok := model.(models.Downgrader)
+		if !ok {
+			break
+		}
+		target := downgrader.DowngradeTarget()
+		if r.providers[target.GetProvider()] == nil || r.checkSupported(target) != nil {
+			break
+		}
+
+		requestLog.Events = append(requestLog.Events, response.Event{
+			Timestamp: time.Now(),
+			Description: fmt.Sprintf(
+				"model: %s was rate limited, degrading to model: %s",
+				model.GetName(),
+				target.GetName(),
+			),
+		})
+		if requestLog.DegradedFrom == "" {
+			requestLog.DegradedFrom = model.GetName()
+		}
+
+		req.Model = target
+		var resp response.Completion
+		if chunkHandler != nil {
+			resp, err = r.tryStreamWithModel(ctx, req, target, chunkHandler, requestLog)
+		} else {
+			resp, err = r.tryWithModel(ctx, req, target, requestLog)
+		}
+		if err == nil {
+			return resp, target, nil
+		}
+		model = target
+	}
+
+	return response.Completion{}, model, err
+}
+
+// isRateLimited reports whether err ended with the provider answering 429.
+func isRateLimited(err error) bool {
+	var coded interface{ StatusCode() int }
+
+	return errors.As(err, &coded) && coded.StatusCode() == http.StatusTooManyRequests
+}
+
+// finishLog records the outcome of a request on its log and passes the log
+// to the configured sink. model is the model that served the response, or
+// nil if every attempt failed.
+func (r *Router) finishLog(
+	requestLog *response.Logging,
+	req request.Completion,
+	model models.Model,
+	resp response.Completion,
+	err error,
+) {
+	requestLog.Completed = err == nil
+	requestLog.Tags = maps.Clone(req.Tags)
+	if err == nil {
+		requestLog.Response = resp.Content
+		requestLog.Model = model
+		requestLog.Provider = resp.Provider
+		requestLog.Usage = resp.Usage
+		if model != nil {
+			requestLog.InputCost, requestLog.OutputCost = estimateCost(model, req, resp)
+			requestLog.Cost = requestLog.InputCost + requestLog.OutputCost
+		}
+	}
+
+	requestLog.End = time.Now()
+	requestLog.StampRequestID()
+
+	if r.sink != nil {
+		_ = r.sink.Write(requestLog)
+	}
+}
+
+// requestID returns the caller-supplied request_id tag, or a new random ID.
+func requestID(req request.Completion) string {
+	if id := req.Tags["request_id"]; id != "" {
+		return id
 	}
+
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+
+	return hex.EncodeToString(b[:])
+}
+
+// promptText joins the text of every message sent with req, for estimates
+// made without reported token usage.
+func promptText(req request.Completion) string {
+	var b strings.Builder
+	b.WriteString(req.SystemMessage)
+	for _, msg := range req.History {
+		for _, part := range msg.ContentParts() {
+			b.WriteString(part.Text)
+		}
+	}
+	b.WriteString(req.UserMessage)
+
+	return b.String()
+}
+
+// estimateCost prices the prompt and the response of a completion from its
+// reported token usage, billing cached prompt tokens at the cached rate where
+// there is one. Rates registered with models.SetPricing take precedence over
+// the model's own. Without either, it falls back to the model's text-based
+// estimate.
+func estimateCost(
+	model models.Model,
+	req request.Completion,
+	resp response.Completion,
+) (input, output float64) {
+	prompt := promptText(req)
+
+	override, overridden := models.Pricing(model.GetName())
+	rates, ok := model.(models.CostBreakdown)
+	if !overridden && (!ok || resp.Usage.TotalTokens == 0) {
+		return model.EstimateCost(prompt), model.EstimateCost(resp.Content)
+	}
+
+	var inputRate, outputRate, cachedRate float64
+	if overridden {
+		inputRate, outputRate = override.InputPer1M, override.OutputPer1M
+		cachedRate = override.CachedInputPer1M
+		if cachedRate == 0 {
+			cachedRate = inputRate
+		}
+	} else {
+		inputRate, outputRate = rates.GetInputCostPer1M(), rates.GetOutputCostPer1M()
+		cachedRate = inputRate
+		if cached, ok := model.(models.CachedCostBreakdown); ok {
+			cachedRate = cached.GetCachedInputCostPer1M()
+		}
+	}
+
+	// Without reported usage, tokens are approximated as four characters
+	// each, as the models' text-based estimates do.
+	promptTokens := float64(len(prompt)) / 4
+	cachedTokens := 0.0
+	completionTokens := float64(len(resp.Content)) / 4
+	if usage := resp.Usage; usage.TotalTokens > 0 {
+		promptTokens = float64(usage.PromptTokens)
+		cachedTokens = float64(usage.CachedTokens)
+		completionTokens = float64(usage.CompletionTokens)
+	}
+
+	input = ((promptTokens-cachedTokens)*inputRate + cachedTokens*cachedRate) / 1_000_000
+	output = completionTokens * outputRate / 1_000_000
+
+	return input, output
 }
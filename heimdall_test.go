@@ -0,0 +1,227 @@
+package heimdall_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flyx-ai/heimdall"
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProvider stands in for a provider in router tests. It answers with
+// its name as the content for the model it was asked for, after delay, and
+// streams that content as a single chunk, or as its chunks when set.
+type fakeProvider struct {
+	name string
+	// delay holds each answer back. A call whose context ends first fails
+	// with the context's error and closes cancelled, when set.
+	delay     time.Duration
+	cancelled chan struct{}
+	onCall    func(ctx context.Context, requestLog *response.Logging)
+	// err fails every call, and errs the calls for the model names they
+	// are keyed by.
+	err  error
+	errs map[string]error
+	// responses answer the provider's successive calls in turn, and
+	// callErrs fail them where set. Both need calls to count the calls.
+	responses []response.Completion
+	callErrs  []error
+	chunks    []string
+	usage     response.Usage
+	// supported, when set, is the only model name the provider serves.
+	supported string
+	calls     *callLog
+}
+
+func (f fakeProvider) CompleteResponse(
+	ctx context.Context,
+	req request.Completion,
+	client http.Client,
+	requestLog *response.Logging,
+) (response.Completion, error) {
+	n := f.calls.add(f.name, req)
+	if f.onCall != nil {
+		f.onCall(ctx, requestLog)
+	}
+
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		if f.cancelled != nil {
+			close(f.cancelled)
+		}
+		return response.Completion{}, ctx.Err()
+	}
+	if f.err != nil {
+		return response.Completion{}, f.err
+	}
+	if err := f.errs[req.Model.GetName()]; err != nil {
+		return response.Completion{}, err
+	}
+	if n < len(f.callErrs) && f.callErrs[n] != nil {
+		return response.Completion{}, f.callErrs[n]
+	}
+	if n < len(f.responses) {
+		return f.responses[n], nil
+	}
+
+	res := response.Completion{
+		Content: f.name,
+		Model:   req.Model.GetName(),
+		Usage:   f.usage,
+	}
+	if len(f.chunks) > 0 {
+		res.Content = strings.Join(f.chunks, "")
+		res.FinishReason = response.FinishStop
+	}
+
+	return res, nil
+}
+
+func (f fakeProvider) StreamResponse(
+	ctx context.Context,
+	client http.Client,
+	req request.Completion,
+	chunkHandler func(chunk string) error,
+	requestLog *response.Logging,
+) (response.Completion, error) {
+	res, err := f.CompleteResponse(ctx, req, client, requestLog)
+	if err != nil || chunkHandler == nil {
+		return res, err
+	}
+
+	chunks := f.chunks
+	if len(chunks) == 0 {
+		chunks = []string{res.Content}
+	}
+	for _, chunk := range chunks {
+		if err := chunkHandler(chunk); err != nil {
+			return response.Completion{}, err
+		}
+	}
+
+	return res, nil
+}
+
+func (f fakeProvider) Name() string {
+	return f.name
+}
+
+func (f fakeProvider) Supports(m models.Model) bool {
+	return f.supported == "" || m.GetName() == f.supported
+}
+
+// SupportsPrefill reports prefill for Anthropic, as the real providers do.
+func (f fakeProvider) SupportsPrefill() bool {
+	return f.name == models.AnthropicProvider
+}
+
+// callLog records the calls fake providers receive, in order. Race calls
+// providers concurrently, so it is safe for concurrent use.
+type callLog struct {
+	mu        sync.Mutex
+	reqs      []request.Completion
+	providers []string
+}
+
+// add records a call to provider and returns how many calls provider had
+// received before it. A nil log records nothing.
+func (l *callLog) add(provider string, req request.Completion) int {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := 0
+	for _, p := range l.providers {
+		if p == provider {
+			n++
+		}
+	}
+	l.reqs = append(l.reqs, req)
+	l.providers = append(l.providers, provider)
+
+	return n
+}
+
+// requests returns the requests received so far.
+func (l *callLog) requests() []request.Completion {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]request.Completion(nil), l.reqs...)
+}
+
+// models returns the name of the model of every request received so far.
+func (l *callLog) models() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var names []string
+	for _, req := range l.reqs {
+		names = append(names, req.Model.GetName())
+	}
+
+	return names
+}
+
+// names returns the name of the provider of every call so far.
+func (l *callLog) names() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]string(nil), l.providers...)
+}
+
+// closingProvider is a fakeProvider that owns a client needing Close.
+type closingProvider struct {
+	fakeProvider
+	closed   *bool
+	closeErr error
+}
+
+func (c closingProvider) Close() error {
+	*c.closed = true
+	return c.closeErr
+}
+
+func TestRouterClose(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should close providers that own clients", func(t *testing.T) {
+		var closed bool
+		router := heimdall.New(time.Second, []heimdall.LLMProvider{
+			closingProvider{fakeProvider: fakeProvider{name: models.VertexProvider}, closed: &closed},
+			fakeProvider{name: models.OpenaiProvider},
+		})
+
+		require.NoError(t, router.Close())
+		assert.True(t, closed)
+	})
+
+	t.Run("should report provider close errors", func(t *testing.T) {
+		var closed bool
+		closeErr := errors.New("close failed")
+		router := heimdall.New(time.Second, []heimdall.LLMProvider{
+			closingProvider{
+				fakeProvider: fakeProvider{name: models.VertexProvider},
+				closed:       &closed,
+				closeErr:     closeErr,
+			},
+		})
+
+		require.ErrorIs(t, router.Close(), closeErr)
+		assert.True(t, closed)
+	})
+}
@@ -0,0 +1,120 @@
+package heimdall
+
+import (
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/flyx-ai/heimdall/models"
+)
+
+// latencyWeight is the weight of the newest sample in the moving average.
+const latencyWeight = 0.2
+
+// LatencyStat is the observed latency of one model on one provider: the time
+// to the whole response for completions, or to the first chunk for streams.
+type LatencyStat struct {
+	// Average is an exponentially weighted moving average, so recent
+	// requests count the most.
+	Average time.Duration
+	Samples int
+}
+
+// WithLatencyRouting has Complete and Stream try the request's Model and
+// Fallback models in order of their observed latency, lowest first, instead
+// of the order given. Complete orders by completion time and Stream by time
+// to the first chunk. Models without samples go first so every one gets
+// measured. With probability explore, a randomly chosen other model is tried
+// first instead, so a model that was slow once is measured again. Use it
+// when the models are interchangeable, such as one model served by several
+// providers.
+func WithLatencyRouting(explore float64) Option {
+	return func(r *Router) {
+		for _, t := range []*latencyTracker{r.latency, r.streamLatency} {
+			t.routing = true
+			t.explore = min(max(explore, 0), 1)
+		}
+	}
+}
+
+// latencyTracker keeps a LatencyStat per provider and model.
+type latencyTracker struct {
+	mu      sync.Mutex
+	stats   map[string]LatencyStat
+	routing bool
+	explore float64
+}
+
+func latencyKey(model models.Model) string {
+	return model.GetProvider() + "/" + model.GetName()
+}
+
+// observe adds a latency sample for model.
+func (t *latencyTracker) observe(model models.Model, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := latencyKey(model)
+	stat := t.stats[key]
+	if stat.Samples == 0 {
+		stat.Average = latency
+	} else {
+		stat.Average += time.Duration(latencyWeight * float64(latency-stat.Average))
+	}
+	stat.Samples++
+	t.stats[key] = stat
+}
+
+// order returns candidates in the order they should be tried.
+func (t *latencyTracker) order(candidates []models.Model) []models.Model {
+	if !t.routing || len(candidates) < 2 {
+		return candidates
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ordered := slices.Clone(candidates)
+	slices.SortStableFunc(ordered, func(a, b models.Model) int {
+		sa, sb := t.stats[latencyKey(a)], t.stats[latencyKey(b)]
+		switch {
+		case sa.Samples == 0 || sb.Samples == 0:
+			return min(sa.Samples, 1) - min(sb.Samples, 1)
+		case sa.Average < sb.Average:
+			return -1
+		case sa.Average > sb.Average:
+			return 1
+		}
+		return 0
+	})
+
+	if t.explore > 0 && rand.Float64() < t.explore {
+		i := 1 + rand.IntN(len(ordered)-1)
+		explored := ordered[i]
+		copy(ordered[1:i+1], ordered[:i])
+		ordered[0] = explored
+	}
+
+	return ordered
+}
+
+func (t *latencyTracker) snapshot() map[string]LatencyStat {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return maps.Clone(t.stats)
+}
+
+// LatencyStats returns the observed completion time of every model that has
+// served a call to Complete, keyed by "provider/model".
+func (r *Router) LatencyStats() map[string]LatencyStat {
+	return r.latency.snapshot()
+}
+
+// StreamLatencyStats returns the observed time to the first chunk of every
+// model that has served a call to Stream, keyed by "provider/model".
+func (r *Router) StreamLatencyStats() map[string]LatencyStat {
+	return r.streamLatency.snapshot()
+}
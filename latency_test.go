@@ -0,0 +1,114 @@
+package heimdall_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flyx-ai/heimdall"
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterLatencyRouting(t *testing.T) {
+	t.Parallel()
+
+	calls := &callLog{}
+	router := heimdall.New(time.Second, []heimdall.LLMProvider{
+		fakeProvider{name: models.OpenaiProvider, delay: 50 * time.Millisecond, calls: calls},
+		fakeProvider{name: models.AnthropicProvider, calls: calls},
+	}, heimdall.WithLatencyRouting(0))
+	newReq := func() request.Completion {
+		return request.Completion{
+			Model:       models.GPT4OMini{},
+			Fallback:    []models.Model{models.Claude45Haiku{}},
+			UserMessage: "Say hello.",
+			Tags:        map[string]string{},
+		}
+	}
+
+	// The first two requests measure each model in turn; the rest go to the
+	// faster one.
+	for range 2 {
+		_, err := router.Complete(context.Background(), newReq())
+		require.NoError(t, err)
+	}
+	res, err := router.Complete(context.Background(), newReq())
+	require.NoError(t, err)
+
+	assert.Equal(t, models.AnthropicProvider, res.Content)
+	assert.Equal(t, []string{models.OpenaiProvider, models.AnthropicProvider, models.AnthropicProvider}, calls.names())
+
+	stats := router.LatencyStats()
+	require.Len(t, stats, 2)
+	openai := stats[models.OpenaiProvider+"/"+models.GPT4OMini{}.GetName()]
+	anthropic := stats[models.AnthropicProvider+"/"+models.Claude45Haiku{}.GetName()]
+	assert.Equal(t, 1, openai.Samples)
+	assert.Equal(t, 2, anthropic.Samples)
+	assert.Greater(t, openai.Average, anthropic.Average)
+	assert.Empty(t, router.StreamLatencyStats(), "completions should not feed the stream stats")
+}
+
+func TestRouterStreamLatencyRouting(t *testing.T) {
+	t.Parallel()
+
+	calls := &callLog{}
+	router := heimdall.New(time.Second, []heimdall.LLMProvider{
+		fakeProvider{name: models.OpenaiProvider, calls: calls},
+		fakeProvider{name: models.AnthropicProvider, delay: 50 * time.Millisecond, calls: calls},
+	}, heimdall.WithLatencyRouting(0))
+	newReq := func() request.Completion {
+		return request.Completion{
+			Model:       models.GPT4OMini{},
+			Fallback:    []models.Model{models.Claude45Haiku{}},
+			UserMessage: "Say hello.",
+			Tags:        map[string]string{},
+		}
+	}
+
+	// Completions find Anthropic slow, but streams measure every model
+	// themselves before ordering by time to the first chunk.
+	_, err := router.Complete(context.Background(), newReq())
+	require.NoError(t, err)
+	_, err = router.Complete(context.Background(), newReq())
+	require.NoError(t, err)
+	res, err := router.Complete(context.Background(), newReq())
+	require.NoError(t, err)
+	require.Equal(t, models.OpenaiProvider, res.Content)
+
+	for range 3 {
+		res, err = router.Stream(context.Background(), newReq(), func(string) error { return nil })
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{models.OpenaiProvider, models.AnthropicProvider, models.OpenaiProvider}, calls.names()[3:])
+	assert.Positive(t, res.RequestLog.TimeToFirstToken)
+	stats := router.StreamLatencyStats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, 2, stats[models.OpenaiProvider+"/"+models.GPT4OMini{}.GetName()].Samples)
+}
+
+func TestRouterWithoutLatencyRouting(t *testing.T) {
+	t.Parallel()
+
+	calls := &callLog{}
+	router := heimdall.New(time.Second, []heimdall.LLMProvider{
+		fakeProvider{name: models.OpenaiProvider, delay: 10 * time.Millisecond, calls: calls},
+		fakeProvider{name: models.AnthropicProvider, calls: calls},
+	})
+
+	for range 3 {
+		_, err := router.Complete(context.Background(), request.Completion{
+			Model:       models.GPT4OMini{},
+			Fallback:    []models.Model{models.Claude45Haiku{}},
+			UserMessage: "Say hello.",
+			Tags:        map[string]string{},
+		})
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{models.OpenaiProvider, models.OpenaiProvider, models.OpenaiProvider}, calls.names())
+	assert.Len(t, router.LatencyStats(), 1, "latency is tracked even when not routing by it")
+}
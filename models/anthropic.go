@@ -2,6 +2,12 @@ package models
 
 const AnthropicProvider = "anthropic"
 
+// Model families, as returned by Family.
+const (
+	FamilyClaude3 = "claude-3"
+	FamilyClaude4 = "claude-4"
+)
+
 const (
 	AnthropicClaude3OpusAlias    = "claude-3-opus-latest"
 	AnthropicClaude35SonnetAlias = "claude-3-5-sonnet-latest"
@@ -15,9 +21,38 @@ const (
 	AnthropicClaude46OpusAlias   = "claude-opus-4-6"
 )
 
+// AnthropicServerTools selects the tools Anthropic runs on its own side
+// during generation. Their calls and results are reported in
+// response.Completion.ServerToolCalls.
+type AnthropicServerTools struct {
+	// WebSearch lets Claude search the web and cite what it finds. It is
+	// disabled when nil.
+	WebSearch *AnthropicWebSearch
+	// CodeExecution lets Claude run Python and bash commands in a sandboxed
+	// container hosted by Anthropic.
+	CodeExecution bool
+}
+
+// AnthropicWebSearch configures Claude's web search tool.
+type AnthropicWebSearch struct {
+	// MaxUses caps the number of searches in one request. Zero leaves the
+	// limit to Anthropic.
+	MaxUses int
+	// AllowedDomains restricts results to the listed domains. It cannot be
+	// combined with BlockedDomains.
+	AllowedDomains []string
+	// BlockedDomains excludes results from the listed domains.
+	BlockedDomains []string
+}
+
 type (
+	// AnthropicImageType is the mime type an ImageFile entry is keyed by.
+	// The entry's value is the image as base64, optionally as a data URI,
+	// or an https:// URL that Claude fetches itself.
 	AnthropicImageType string
-	AnthropicPdf       string
+	// AnthropicPdf is a PDF given as base64, optionally as a data URI, or
+	// as an https:// URL that Claude fetches itself.
+	AnthropicPdf string
 )
 
 const (
@@ -45,6 +80,20 @@ func (c Claude3Opus) GetProvider() string {
 	return AnthropicProvider
 }
 
+func (c Claude3Opus) Family() string {
+	return FamilyClaude3
+}
+
+func (c Claude3Opus) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Claude3Opus)
 
 type Claude35Sonnet struct {
@@ -65,6 +114,20 @@ func (c Claude35Sonnet) GetProvider() string {
 	return AnthropicProvider
 }
 
+func (c Claude35Sonnet) Family() string {
+	return FamilyClaude3
+}
+
+func (c Claude35Sonnet) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Claude35Sonnet)
 
 type Claude35Haiku struct {
@@ -85,12 +148,29 @@ func (c Claude35Haiku) GetProvider() string {
 	return AnthropicProvider
 }
 
+func (c Claude35Haiku) Family() string {
+	return FamilyClaude3
+}
+
+func (c Claude35Haiku) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Claude35Haiku)
 
 type Claude37Sonnet struct {
 	ImageFile        map[AnthropicImageType]string
 	PdfFiles         []AnthropicPdf
 	StructuredOutput map[string]any
+	// ServerTools enables tools, such as web search, that Anthropic runs on
+	// its side while Claude generates.
+	ServerTools AnthropicServerTools
 }
 
 func (c Claude37Sonnet) EstimateCost(text string) float64 {
@@ -105,12 +185,29 @@ func (c Claude37Sonnet) GetProvider() string {
 	return AnthropicProvider
 }
 
+func (c Claude37Sonnet) Family() string {
+	return FamilyClaude3
+}
+
+func (c Claude37Sonnet) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Claude37Sonnet)
 
 type Claude4Sonnet struct {
 	ImageFile        map[AnthropicImageType]string
 	PdfFiles         []AnthropicPdf
 	StructuredOutput map[string]any
+	// ServerTools enables tools, such as web search, that Anthropic runs on
+	// its side while Claude generates.
+	ServerTools AnthropicServerTools
 }
 
 func (c Claude4Sonnet) EstimateCost(text string) float64 {
@@ -125,12 +222,29 @@ func (c Claude4Sonnet) GetProvider() string {
 	return AnthropicProvider
 }
 
+func (c Claude4Sonnet) Family() string {
+	return FamilyClaude4
+}
+
+func (c Claude4Sonnet) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Claude4Sonnet)
 
 type Claude4Opus struct {
 	ImageFile        map[AnthropicImageType]string
 	PdfFiles         []AnthropicPdf
 	StructuredOutput map[string]any
+	// ServerTools enables tools, such as web search, that Anthropic runs on
+	// its side while Claude generates.
+	ServerTools AnthropicServerTools
 }
 
 func (c Claude4Opus) EstimateCost(text string) float64 {
@@ -145,12 +259,29 @@ func (c Claude4Opus) GetProvider() string {
 	return AnthropicProvider
 }
 
+func (c Claude4Opus) Family() string {
+	return FamilyClaude4
+}
+
+func (c Claude4Opus) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Claude4Opus)
 
 type Claude45Haiku struct {
 	ImageFile        map[AnthropicImageType]string
 	PdfFiles         []AnthropicPdf
 	StructuredOutput map[string]any
+	// ServerTools enables tools, such as web search, that Anthropic runs on
+	// its side while Claude generates.
+	ServerTools AnthropicServerTools
 }
 
 func (c Claude45Haiku) EstimateCost(text string) float64 {
@@ -165,12 +296,29 @@ func (c Claude45Haiku) GetProvider() string {
 	return AnthropicProvider
 }
 
+func (c Claude45Haiku) Family() string {
+	return FamilyClaude4
+}
+
+func (c Claude45Haiku) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Claude45Haiku)
 
 type Claude45Sonnet struct {
 	ImageFile        map[AnthropicImageType]string
 	PdfFiles         []AnthropicPdf
 	StructuredOutput map[string]any
+	// ServerTools enables tools, such as web search, that Anthropic runs on
+	// its side while Claude generates.
+	ServerTools AnthropicServerTools
 }
 
 func (c Claude45Sonnet) EstimateCost(text string) float64 {
@@ -185,12 +333,34 @@ func (c Claude45Sonnet) GetProvider() string {
 	return AnthropicProvider
 }
 
+func (c Claude45Sonnet) Family() string {
+	return FamilyClaude4
+}
+
+func (c Claude45Sonnet) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
+// DowngradeTarget returns Claude45Haiku with the same inputs.
+func (m Claude45Sonnet) DowngradeTarget() Model {
+	return Claude45Haiku(m)
+}
+
 var _ Model = new(Claude45Sonnet)
 
 type Claude45Opus struct {
 	ImageFile        map[AnthropicImageType]string
 	PdfFiles         []AnthropicPdf
 	StructuredOutput map[string]any
+	// ServerTools enables tools, such as web search, that Anthropic runs on
+	// its side while Claude generates.
+	ServerTools AnthropicServerTools
 }
 
 func (c Claude45Opus) EstimateCost(text string) float64 {
@@ -213,6 +383,25 @@ func (c Claude45Opus) GetProvider() string {
 	return AnthropicProvider
 }
 
+func (c Claude45Opus) Family() string {
+	return FamilyClaude4
+}
+
+func (c Claude45Opus) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
+// DowngradeTarget returns Claude45Sonnet with the same inputs.
+func (m Claude45Opus) DowngradeTarget() Model {
+	return Claude45Sonnet(m)
+}
+
 var _ Model = new(Claude45Opus)
 var _ CostBreakdown = new(Claude45Opus)
 
@@ -220,11 +409,15 @@ type Claude46Opus struct {
 	ImageFile        map[AnthropicImageType]string
 	PdfFiles         []AnthropicPdf
 	StructuredOutput map[string]any
+	// ServerTools enables tools, such as web search, that Anthropic runs on
+	// its side while Claude generates.
+	ServerTools AnthropicServerTools
 	// ExtendedContext enables the 1M token context window (beta).
 	// Requires the context-1m-2025-08-07 beta header.
 	ExtendedContext bool
 	// MaxOutputTokens sets the maximum output tokens (up to 128K).
-	// Defaults to 4096 when zero.
+	// Defaults to 4096 when zero. A request's lower MaxTokens takes
+	// precedence.
 	MaxOutputTokens int
 }
 
@@ -248,5 +441,19 @@ func (c Claude46Opus) GetProvider() string {
 	return AnthropicProvider
 }
 
+func (c Claude46Opus) Family() string {
+	return FamilyClaude4
+}
+
+func (c Claude46Opus) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Claude46Opus)
 var _ CostBreakdown = new(Claude46Opus)
@@ -2,6 +2,13 @@ package models
 
 const GoogleProvider = "google"
 
+// Gemini model families, shared by the Google and Vertex AI models.
+const (
+	FamilyGemini20 = "gemini-2.0"
+	FamilyGemini25 = "gemini-2.5"
+	FamilyGemini3  = "gemini-3"
+)
+
 const (
 	// NOTE: Gemini 1.5 models (gemini-1.5-flash-002, gemini-1.5-pro-002) have been retired by Google as of 2025
 	Gemini20FlashModel      = "gemini-2.0-flash-001"
@@ -15,6 +22,28 @@ const (
 	Gemini3FlashModel       = "gemini-3-flash-preview"
 )
 
+// Gemini response MIME types, see ResponseMimeType on the Gemini models.
+const (
+	GoogleMimeText = "text/plain"
+	GoogleMimeJSON = "application/json"
+	GoogleMimeEnum = "text/x.enum"
+)
+
+// GoogleEnumSchema returns a StructuredOutput schema that restricts the
+// response to exactly one of labels. Pair it with GoogleMimeEnum for
+// classification tasks.
+func GoogleEnumSchema(labels ...string) map[string]any {
+	enum := make([]any, len(labels))
+	for i, label := range labels {
+		enum[i] = label
+	}
+
+	return map[string]any{
+		"type": "STRING",
+		"enum": enum,
+	}
+}
+
 type ThinkBudget string
 
 const (
@@ -96,6 +125,11 @@ type Gemini20Flash struct {
 	// 		},
 	// 	}
 	StructuredOutput map[string]any
+	// ResponseMimeType sets the response format: GoogleMimeText,
+	// GoogleMimeJSON, or GoogleMimeEnum to classify into the labels of a
+	// GoogleEnumSchema. It defaults to GoogleMimeJSON when StructuredOutput
+	// is set.
+	ResponseMimeType string
 	// PdfFiles accepts one or more PDFs, either URIs or base64 data
 	PdfFiles  []GooglePdf
 	ImageFile []GoogleImagePayload
@@ -116,6 +150,20 @@ func (g Gemini20Flash) GetProvider() string {
 	return GoogleProvider
 }
 
+func (g Gemini20Flash) Family() string {
+	return FamilyGemini20
+}
+
+func (g Gemini20Flash) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Gemini20Flash)
 
 type Gemini20FlashLite struct {
@@ -132,6 +180,11 @@ type Gemini20FlashLite struct {
 	// 		},
 	// 	}
 	StructuredOutput map[string]any
+	// ResponseMimeType sets the response format: GoogleMimeText,
+	// GoogleMimeJSON, or GoogleMimeEnum to classify into the labels of a
+	// GoogleEnumSchema. It defaults to GoogleMimeJSON when StructuredOutput
+	// is set.
+	ResponseMimeType string
 	// PdfFiles accepts one or more PDFs, either URIs or base64 data
 	PdfFiles  []GooglePdf
 	ImageFile []GoogleImagePayload
@@ -152,9 +205,23 @@ func (g Gemini20FlashLite) GetProvider() string {
 	return GoogleProvider
 }
 
+func (g Gemini20FlashLite) Family() string {
+	return FamilyGemini20
+}
+
+func (g Gemini20FlashLite) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Gemini20FlashLite)
 
-type Gemini25FlashPreview struct {
+type Gemini25Flash struct {
 	Tools GoogleTool
 	// StructuredOutput represents a subset of the OpenAPI 3.0 Schema Object. Refer to gemini documentation for complete and up-to-date information. An example structure could be:
 	//
@@ -168,6 +235,11 @@ type Gemini25FlashPreview struct {
 	// 		},
 	// 	}
 	StructuredOutput map[string]any
+	// ResponseMimeType sets the response format: GoogleMimeText,
+	// GoogleMimeJSON, or GoogleMimeEnum to classify into the labels of a
+	// GoogleEnumSchema. It defaults to GoogleMimeJSON when StructuredOutput
+	// is set.
+	ResponseMimeType string
 	// PdfFiles accepts one or more PDFs, either URIs or base64 data
 	PdfFiles  []GooglePdf
 	ImageFile []GoogleImagePayload
@@ -176,23 +248,51 @@ type Gemini25FlashPreview struct {
 	Thinking ThinkBudget
 }
 
-func (g Gemini25FlashPreview) EstimateCost(text string) float64 {
+func (g Gemini25Flash) EstimateCost(text string) float64 {
 	return (float64(len(text)) / 4) * 0.0000001
 }
 
-func (g Gemini25FlashPreview) GetName() string {
+func (g Gemini25Flash) GetName() string {
 	return Gemini25FlashModel
 }
 
-func (g Gemini25FlashPreview) GetProvider() string {
+func (g Gemini25Flash) GetProvider() string {
 	return GoogleProvider
 }
 
-var _ Model = new(Gemini25FlashPreview)
+func (g Gemini25Flash) Family() string {
+	return FamilyGemini25
+}
+
+func (g Gemini25Flash) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
+// DowngradeTarget returns Gemini25FlashLite with the same inputs.
+func (m Gemini25Flash) DowngradeTarget() Model {
+	return Gemini25FlashLite(m)
+}
+
+var _ Model = new(Gemini25Flash)
+
+// Deprecated: Gemini25FlashPreview targets the stable model despite its name. Use
+// Gemini25Flash instead.
+type Gemini25FlashPreview = Gemini25Flash
 
 type Gemini25FlashLite struct {
 	Tools            GoogleTool
 	StructuredOutput map[string]any
+	// ResponseMimeType sets the response format: GoogleMimeText,
+	// GoogleMimeJSON, or GoogleMimeEnum to classify into the labels of a
+	// GoogleEnumSchema. It defaults to GoogleMimeJSON when StructuredOutput
+	// is set.
+	ResponseMimeType string
 	PdfFiles         []GooglePdf
 	ImageFile        []GoogleImagePayload
 	Files            []GoogleFilePayload
@@ -219,10 +319,24 @@ func (g Gemini25FlashLite) GetProvider() string {
 	return GoogleProvider
 }
 
+func (g Gemini25FlashLite) Family() string {
+	return FamilyGemini25
+}
+
+func (g Gemini25FlashLite) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Gemini25FlashLite)
 var _ CostBreakdown = new(Gemini25FlashLite)
 
-type Gemini25ProPreview struct {
+type Gemini25Pro struct {
 	Tools GoogleTool
 	// StructuredOutput represents a subset of the OpenAPI 3.0 Schema Object. Refer to gemini documentation for complete and up-to-date information. An example structure could be:
 	//
@@ -236,6 +350,11 @@ type Gemini25ProPreview struct {
 	// 		},
 	// 	}
 	StructuredOutput map[string]any
+	// ResponseMimeType sets the response format: GoogleMimeText,
+	// GoogleMimeJSON, or GoogleMimeEnum to classify into the labels of a
+	// GoogleEnumSchema. It defaults to GoogleMimeJSON when StructuredOutput
+	// is set.
+	ResponseMimeType string
 	// PdfFiles accepts one or more PDFs, either URIs or base64 data
 	PdfFiles  []GooglePdf
 	ImageFile []GoogleImagePayload
@@ -244,19 +363,42 @@ type Gemini25ProPreview struct {
 	Thinking ThinkBudget
 }
 
-func (g Gemini25ProPreview) EstimateCost(text string) float64 {
+func (g Gemini25Pro) EstimateCost(text string) float64 {
 	return (float64(len(text)) / 4) * 0.00000125
 }
 
-func (g Gemini25ProPreview) GetName() string {
+func (g Gemini25Pro) GetName() string {
 	return Gemini25ProModel
 }
 
-func (g Gemini25ProPreview) GetProvider() string {
+func (g Gemini25Pro) GetProvider() string {
 	return GoogleProvider
 }
 
-var _ Model = new(Gemini25ProPreview)
+func (g Gemini25Pro) Family() string {
+	return FamilyGemini25
+}
+
+func (g Gemini25Pro) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
+// DowngradeTarget returns Gemini25Flash with the same inputs.
+func (m Gemini25Pro) DowngradeTarget() Model {
+	return Gemini25Flash(m)
+}
+
+var _ Model = new(Gemini25Pro)
+
+// Deprecated: Gemini25ProPreview targets the stable model despite its name. Use
+// Gemini25Pro instead.
+type Gemini25ProPreview = Gemini25Pro
 
 // AspectRatio represents the supported aspect ratios for image generation
 type AspectRatio string
@@ -321,12 +463,29 @@ func (g Gemini25FlashImage) GetProvider() string {
 	return GoogleProvider
 }
 
+func (g Gemini25FlashImage) Family() string {
+	return FamilyGemini25
+}
+
+func (g Gemini25FlashImage) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:       true,
+		PDF:          true,
+		SystemPrompt: true,
+	}
+}
+
 var _ Model = new(Gemini25FlashImage)
 var _ CostBreakdown = new(Gemini25FlashImage)
 
 type Gemini3ProPreview struct {
 	Tools            GoogleTool
 	StructuredOutput map[string]any
+	// ResponseMimeType sets the response format: GoogleMimeText,
+	// GoogleMimeJSON, or GoogleMimeEnum to classify into the labels of a
+	// GoogleEnumSchema. It defaults to GoogleMimeJSON when StructuredOutput
+	// is set.
+	ResponseMimeType string
 	PdfFiles         []GooglePdf
 	ImageFile        []GoogleImagePayload
 	Files            []GoogleFilePayload
@@ -354,6 +513,20 @@ func (g Gemini3ProPreview) GetProvider() string {
 	return GoogleProvider
 }
 
+func (g Gemini3ProPreview) Family() string {
+	return FamilyGemini3
+}
+
+func (g Gemini3ProPreview) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Gemini3ProPreview)
 var _ CostBreakdown = new(Gemini3ProPreview)
 
@@ -391,12 +564,29 @@ func (g Gemini3ProImagePreview) GetProvider() string {
 	return GoogleProvider
 }
 
+func (g Gemini3ProImagePreview) Family() string {
+	return FamilyGemini3
+}
+
+func (g Gemini3ProImagePreview) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:       true,
+		PDF:          true,
+		SystemPrompt: true,
+	}
+}
+
 var _ Model = new(Gemini3ProImagePreview)
 var _ CostBreakdown = new(Gemini3ProImagePreview)
 
 type Gemini3FlashPreview struct {
 	Tools            GoogleTool
 	StructuredOutput map[string]any
+	// ResponseMimeType sets the response format: GoogleMimeText,
+	// GoogleMimeJSON, or GoogleMimeEnum to classify into the labels of a
+	// GoogleEnumSchema. It defaults to GoogleMimeJSON when StructuredOutput
+	// is set.
+	ResponseMimeType string
 	PdfFiles         []GooglePdf
 	ImageFile        []GoogleImagePayload
 	Files            []GoogleFilePayload
@@ -424,5 +614,19 @@ func (g Gemini3FlashPreview) GetProvider() string {
 	return GoogleProvider
 }
 
+func (g Gemini3FlashPreview) Family() string {
+	return FamilyGemini3
+}
+
+func (g Gemini3FlashPreview) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Gemini3FlashPreview)
 var _ CostBreakdown = new(Gemini3FlashPreview)
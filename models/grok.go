@@ -1,7 +1,16 @@
 package models
 
+import "time"
+
 const GrokProvider = "grok"
 
+// Model families, as returned by Family.
+const (
+	FamilyGrok2 = "grok-2"
+	FamilyGrok3 = "grok-3"
+	FamilyGrok4 = "grok-4"
+)
+
 const (
 	Grok2VisionAlias   = "grok-2-vision-1212"
 	Grok3Alias         = "grok-3"
@@ -17,6 +26,38 @@ type GrokImagePayload struct {
 	Detail string
 }
 
+// GrokSearch configures Live Search, which lets Grok 3 and 4 models look up
+// the web, news and X posts before answering. The zero value leaves it off.
+type GrokSearch struct {
+	// Mode is "auto" to let the model decide whether to search, "on" to
+	// always search or "off".
+	Mode string
+	// Sources limits where to search. Empty searches the web, news and X.
+	Sources []GrokSearchSource
+	// MaxResults caps the number of sources consulted. Zero leaves the API
+	// default in place.
+	MaxResults int
+	// FromDate and ToDate limit results to the given date range. Zero
+	// values leave that end of the range open.
+	FromDate time.Time
+	ToDate   time.Time
+}
+
+// GrokSearchSource is one place Live Search may look.
+type GrokSearchSource struct {
+	// Type is "web", "news", "x" or "rss".
+	Type string
+	// Country is an ISO alpha-2 country code for web and news results.
+	Country string
+	// AllowedWebsites and ExcludedWebsites filter web and news results.
+	AllowedWebsites  []string
+	ExcludedWebsites []string
+	// XHandles limits X results to posts by these handles.
+	XHandles []string
+	// Links are the feeds searched by an "rss" source.
+	Links []string
+}
+
 type Grok2Vision struct {
 	ImageFile        []GrokImagePayload
 	StructuredOutput map[string]any
@@ -37,11 +78,25 @@ func (Grok2Vision) GetProvider() string {
 	return GrokProvider
 }
 
+func (Grok2Vision) Family() string {
+	return FamilyGrok2
+}
+
+func (Grok2Vision) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Grok2Vision)
 
 type Grok3 struct {
 	ImageFile        []GrokImagePayload
 	StructuredOutput map[string]any
+	Search           GrokSearch
 }
 
 func (g Grok3) EstimateCost(text string) float64 {
@@ -59,10 +114,24 @@ func (Grok3) GetProvider() string {
 	return GrokProvider
 }
 
+func (Grok3) Family() string {
+	return FamilyGrok3
+}
+
+func (Grok3) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Grok3)
 
 type Grok3Mini struct {
 	StructuredOutput map[string]any
+	Search           GrokSearch
 }
 
 func (g Grok3Mini) EstimateCost(text string) float64 {
@@ -80,11 +149,24 @@ func (Grok3Mini) GetProvider() string {
 	return GrokProvider
 }
 
+func (Grok3Mini) Family() string {
+	return FamilyGrok3
+}
+
+func (Grok3Mini) Capabilities() Capabilities {
+	return Capabilities{
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Grok3Mini)
 
 type Grok3Fast struct {
 	ImageFile        []GrokImagePayload
 	StructuredOutput map[string]any
+	Search           GrokSearch
 }
 
 func (g Grok3Fast) EstimateCost(text string) float64 {
@@ -102,10 +184,24 @@ func (Grok3Fast) GetProvider() string {
 	return GrokProvider
 }
 
+func (Grok3Fast) Family() string {
+	return FamilyGrok3
+}
+
+func (Grok3Fast) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Grok3Fast)
 
 type Grok3MiniFast struct {
 	StructuredOutput map[string]any
+	Search           GrokSearch
 }
 
 func (g Grok3MiniFast) EstimateCost(text string) float64 {
@@ -123,11 +219,24 @@ func (Grok3MiniFast) GetProvider() string {
 	return GrokProvider
 }
 
+func (Grok3MiniFast) Family() string {
+	return FamilyGrok3
+}
+
+func (Grok3MiniFast) Capabilities() Capabilities {
+	return Capabilities{
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Grok3MiniFast)
 
 type Grok4 struct {
 	ImageFile        []GrokImagePayload
 	StructuredOutput map[string]any
+	Search           GrokSearch
 }
 
 func (g Grok4) EstimateCost(text string) float64 {
@@ -145,11 +254,25 @@ func (Grok4) GetProvider() string {
 	return GrokProvider
 }
 
+func (Grok4) Family() string {
+	return FamilyGrok4
+}
+
+func (Grok4) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Grok4)
 
 type Grok4Fast struct {
 	ImageFile        []GrokImagePayload
 	StructuredOutput map[string]any
+	Search           GrokSearch
 }
 
 func (g Grok4Fast) EstimateCost(text string) float64 {
@@ -167,4 +290,17 @@ func (Grok4Fast) GetProvider() string {
 	return GrokProvider
 }
 
+func (Grok4Fast) Family() string {
+	return FamilyGrok4
+}
+
+func (Grok4Fast) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Grok4Fast)
@@ -1,9 +1,46 @@
 package models
 
+import "strings"
+
 type Model interface {
 	GetProvider() string
 	GetName() string
+	// Family groups a model with its variants across providers, such as
+	// FamilyGemini25 for every Gemini 2.5 model on Google and Vertex AI.
+	// It is stable across model versions and empty when unknown.
+	Family() string
 	EstimateCost(text string) float64
+	Capabilities() Capabilities
+}
+
+// Downgrader is implemented by models with a smaller, cheaper sibling in the
+// same family, which the router falls back to when the model is rate limited
+// and the request sets DegradeOnRateLimit.
+type Downgrader interface {
+	DowngradeTarget() Model
+}
+
+// Capabilities describes which inputs and features a model accepts. A false
+// field means heimdall will not send that kind of input to the model.
+type Capabilities struct {
+	// Vision reports whether the model accepts image inputs.
+	Vision bool
+	// PDF reports whether the model accepts PDF documents.
+	PDF bool
+	// Tools reports whether the model can be sent request.Completion.Tools,
+	// functions it may ask the caller to run. Tools configured on the model
+	// itself, such as Gemini's grounding, do not count.
+	Tools bool
+	// StructuredOutput reports whether the model can be constrained to a
+	// JSON schema.
+	StructuredOutput bool
+	// Streaming reports whether responses are streamed chunk by chunk.
+	// Models without it, such as image generation models, still work with
+	// Stream: the provider makes a regular completion and passes the whole
+	// response to the chunk handler as a single chunk.
+	Streaming bool
+	// SystemPrompt reports whether the model honours a system message.
+	SystemPrompt bool
 }
 
 type CostBreakdown interface {
@@ -11,6 +48,12 @@ type CostBreakdown interface {
 	GetOutputCostPer1M() float64
 }
 
+// CachedCostBreakdown is implemented by models whose provider bills prompt
+// tokens served from its cache at a discounted rate.
+type CachedCostBreakdown interface {
+	GetCachedInputCostPer1M() float64
+}
+
 type StructuredOutput interface {
 	GetStructuredOutput() map[string]any
 }
@@ -46,6 +89,7 @@ func GetAll() []string {
 		O3MiniAlias,
 		GPT4OAlias,
 		GPT4OMiniAlias,
+		GPT4OAudioAlias,
 		O1Alias,
 		GPT4Alias,
 		GPT4TurboAlias,
@@ -75,6 +119,111 @@ func GetAll() []string {
 		Grok4Alias,
 		Grok4FastAlias,
 
+		QwenMaxAlias,
+		QwenPlusAlias,
+		QwenTurboAlias,
+
 		Gemini25FlashImageModel,
 	}
 }
+
+// chatModels lists a zero value of every chat model ByName can resolve.
+// Embedding and image generation models are left out, and models behind a
+// build tag add themselves.
+var chatModels = []Model{
+	Claude3Opus{},
+	Claude35Sonnet{},
+	Claude35Haiku{},
+	Claude37Sonnet{},
+	Claude4Sonnet{},
+	Claude4Opus{},
+	Claude45Haiku{},
+	Claude45Sonnet{},
+	Claude45Opus{},
+	Claude46Opus{},
+
+	Gemini20Flash{},
+	Gemini20FlashLite{},
+	Gemini25Flash{},
+	Gemini25FlashLite{},
+	Gemini25Pro{},
+	Gemini3ProPreview{},
+	Gemini3FlashPreview{},
+
+	GPT4{},
+	GPT4Turbo{},
+	GPT4O{},
+	GPT4OMini{},
+	GPT4OAudio{},
+	GPT41{},
+	GPT41Mini{},
+	GPT41Nano{},
+	GPT5{},
+	GPT5Mini{},
+	GPT5Nano{},
+	GPT5Chat{},
+	GPT51{},
+	GPT51Chat{},
+	GPT51Codex{},
+	GPT51CodexMini{},
+	O1{},
+	O3Mini{},
+
+	Grok2Vision{},
+	Grok3{},
+	Grok3Mini{},
+	Grok3Fast{},
+	Grok3MiniFast{},
+	Grok4{},
+	Grok4Fast{},
+
+	QwenMax{},
+	QwenPlus{},
+	QwenTurbo{},
+
+	VertexGemini20Flash{},
+	VertexGemini20FlashLite{},
+	VertexGemini25Pro{},
+	VertexGemini25Flash{},
+	VertexGemini25FlashLite{},
+	VertexGemini3ProPreview{},
+	VertexGemini3FlashPreview{},
+}
+
+// ByName resolves a chat model name, as returned by GetName, to a zero value
+// of that model. Vertex AI serves Gemini under the same names as Google, so
+// a name may be prefixed with its provider, as in "vertexai/gemini-2.5-pro";
+// unprefixed Gemini names resolve to Google. Names prefixed with
+// "openrouter/" resolve to an OpenRouterModel for the rest of the name.
+func ByName(name string) (Model, bool) {
+	provider, rest, found := strings.Cut(name, "/")
+	if found && provider == OpenRouterProvider && rest != "" {
+		return OpenRouterModel{ModelName: rest}, true
+	}
+	if !found {
+		provider, rest = "", name
+	}
+
+	for _, model := range chatModels {
+		if model.GetName() != rest {
+			continue
+		}
+		if provider == "" || provider == model.GetProvider() {
+			return model, true
+		}
+	}
+
+	return nil, false
+}
+
+// ByFamily groups the chat models ByName can resolve by their Family, in
+// the order they are listed.
+func ByFamily() map[string][]Model {
+	families := make(map[string][]Model)
+	for _, model := range chatModels {
+		family := model.Family()
+		families[family] = append(families[family], model)
+	}
+
+	return families
+}
@@ -0,0 +1,59 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestByFamily(t *testing.T) {
+	t.Parallel()
+
+	families := models.ByFamily()
+
+	assert.NotContains(t, families, "", "every chat model should have a family")
+	assert.ElementsMatch(t, []models.Model{
+		models.Gemini25Flash{},
+		models.Gemini25FlashLite{},
+		models.Gemini25Pro{},
+		models.VertexGemini25Pro{},
+		models.VertexGemini25Flash{},
+		models.VertexGemini25FlashLite{},
+	}, families[models.FamilyGemini25])
+	assert.ElementsMatch(t, []models.Model{
+		models.GPT4O{},
+		models.GPT4OMini{},
+		models.GPT4OAudio{},
+	}, families[models.FamilyGPT4O])
+
+	for family, members := range families {
+		for _, model := range members {
+			assert.Equal(t, family, model.Family(), model.GetName())
+		}
+	}
+}
+
+func TestFloatingAlias(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		name   string
+		want   string
+		wantOK bool
+	}{
+		"should drop a full date":          {name: models.GPT4OAlias, want: "gpt-4o", wantOK: true},
+		"should drop a month and day":      {name: models.GPT4Alias, want: "gpt-4", wantOK: true},
+		"should keep names without a date": {name: models.GPT51Alias, want: models.GPT51Alias},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := models.FloatingAlias(tt.name)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantOK, ok)
+		})
+	}
+}
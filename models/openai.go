@@ -1,11 +1,39 @@
 package models
 
+import "regexp"
+
 const OpenaiProvider = "openai"
 
+// openAISnapshotSuffix matches the date an OpenAI snapshot name is pinned
+// to, as in "gpt-4o-2024-11-20" or "gpt-4-0613".
+var openAISnapshotSuffix = regexp.MustCompile(`-(\d{4}-\d{2}-\d{2}|\d{4})$`)
+
+// FloatingAlias returns the undated name an OpenAI snapshot such as
+// "gpt-4o-2024-11-20" belongs to, "gpt-4o", which keeps pointing at a
+// current snapshot once the dated one is retired. ok is false for names
+// that are not pinned to a date.
+func FloatingAlias(name string) (alias string, ok bool) {
+	alias = openAISnapshotSuffix.ReplaceAllString(name, "")
+	return alias, alias != name
+}
+
+// Model families, as returned by Family.
+const (
+	FamilyGPT4     = "gpt-4"
+	FamilyGPT4O    = "gpt-4o"
+	FamilyGPT41    = "gpt-4.1"
+	FamilyGPT5     = "gpt-5"
+	FamilyGPT51    = "gpt-5.1"
+	FamilyO1       = "o1"
+	FamilyO3       = "o3"
+	FamilyGPTImage = "gpt-image"
+)
+
 const (
 	O3MiniAlias         = "o3-mini-2025-01-31"
 	GPT4OAlias          = "gpt-4o-2024-11-20"
 	GPT4OMiniAlias      = "gpt-4o-mini-2024-07-18"
+	GPT4OAudioAlias     = "gpt-4o-audio-preview"
 	O1Alias             = "o1-2024-12-17"
 	GPT4Alias           = "gpt-4-0613"
 	GPT4TurboAlias      = "gpt-4-turbo"
@@ -60,6 +88,18 @@ func (g GPT41) EstimateCost(text string) float64 {
 	return (float64(len(text)) / 4) * 0.00000200
 }
 
+func (g GPT41) GetInputCostPer1M() float64 {
+	return 2.0
+}
+
+func (g GPT41) GetCachedInputCostPer1M() float64 {
+	return 0.5
+}
+
+func (g GPT41) GetOutputCostPer1M() float64 {
+	return 8.0
+}
+
 func (GPT41) GetName() string {
 	return GPT41Alias
 }
@@ -68,7 +108,29 @@ func (GPT41) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (GPT41) Family() string {
+	return FamilyGPT41
+}
+
+func (GPT41) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		Tools:            true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
+// DowngradeTarget returns GPT41Mini with the same inputs.
+func (m GPT41) DowngradeTarget() Model {
+	return GPT41Mini(m)
+}
+
 var _ Model = new(GPT41)
+var _ CostBreakdown = new(GPT41)
+var _ CachedCostBreakdown = new(GPT41)
 
 type GPT41Mini struct {
 	// StructuredOutput represents a subset of the JSON Schema Language. Refer to openai documentation for complete and up-to-date information. An example structure could be:
@@ -108,6 +170,26 @@ func (GPT41Mini) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (GPT41Mini) Family() string {
+	return FamilyGPT41
+}
+
+func (GPT41Mini) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		Tools:            true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
+// DowngradeTarget returns GPT41Nano with the same inputs.
+func (m GPT41Mini) DowngradeTarget() Model {
+	return GPT41Nano(m)
+}
+
 var _ Model = new(GPT41Mini)
 
 type GPT41Nano struct {
@@ -148,6 +230,21 @@ func (GPT41Nano) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (GPT41Nano) Family() string {
+	return FamilyGPT41
+}
+
+func (GPT41Nano) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		Tools:            true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(GPT41Nano)
 
 type O3Mini struct {
@@ -181,6 +278,19 @@ func (o O3Mini) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (o O3Mini) Family() string {
+	return FamilyO3
+}
+
+func (o O3Mini) Capabilities() Capabilities {
+	return Capabilities{
+		Tools:            true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(O3Mini)
 
 type O1 struct {
@@ -222,6 +332,21 @@ func (o O1) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (o O1) Family() string {
+	return FamilyO1
+}
+
+func (o O1) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		Tools:            true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(O1)
 
 type GPT4 struct {
@@ -241,6 +366,18 @@ func (g GPT4) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (g GPT4) Family() string {
+	return FamilyGPT4
+}
+
+func (g GPT4) Capabilities() Capabilities {
+	return Capabilities{
+		Tools:        true,
+		Streaming:    true,
+		SystemPrompt: true,
+	}
+}
+
 var _ Model = new(GPT4)
 
 type GPT4Turbo struct {
@@ -262,6 +399,19 @@ func (g GPT4Turbo) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (g GPT4Turbo) Family() string {
+	return FamilyGPT4
+}
+
+func (g GPT4Turbo) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:       true,
+		Tools:        true,
+		Streaming:    true,
+		SystemPrompt: true,
+	}
+}
+
 var _ Model = new(GPT4Turbo)
 
 type GPT4O struct {
@@ -295,6 +445,18 @@ func (g GPT4O) EstimateCost(text string) float64 {
 	return (float64(len(text)) / 4) * 0.00000250
 }
 
+func (g GPT4O) GetInputCostPer1M() float64 {
+	return 2.5
+}
+
+func (g GPT4O) GetCachedInputCostPer1M() float64 {
+	return 1.25
+}
+
+func (g GPT4O) GetOutputCostPer1M() float64 {
+	return 10.0
+}
+
 func (g GPT4O) GetName() string {
 	return GPT4OAlias
 }
@@ -303,7 +465,29 @@ func (g GPT4O) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (g GPT4O) Family() string {
+	return FamilyGPT4O
+}
+
+func (g GPT4O) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		Tools:            true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
+// DowngradeTarget returns GPT4OMini with the same inputs.
+func (m GPT4O) DowngradeTarget() Model {
+	return GPT4OMini(m)
+}
+
 var _ Model = new(GPT4O)
+var _ CostBreakdown = new(GPT4O)
+var _ CachedCostBreakdown = new(GPT4O)
 
 type (
 	GPT4OMini struct {
@@ -338,6 +522,18 @@ func (g GPT4OMini) EstimateCost(text string) float64 {
 	return (float64(len(text)) / 4) * 0.00000015
 }
 
+func (g GPT4OMini) GetInputCostPer1M() float64 {
+	return 0.15
+}
+
+func (g GPT4OMini) GetCachedInputCostPer1M() float64 {
+	return 0.075
+}
+
+func (g GPT4OMini) GetOutputCostPer1M() float64 {
+	return 0.6
+}
+
 func (g GPT4OMini) GetName() string {
 	return GPT4OMiniAlias
 }
@@ -346,7 +542,74 @@ func (g GPT4OMini) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (g GPT4OMini) Family() string {
+	return FamilyGPT4O
+}
+
+func (g GPT4OMini) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		Tools:            true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(GPT4OMini)
+var _ CostBreakdown = new(GPT4OMini)
+var _ CachedCostBreakdown = new(GPT4OMini)
+
+// OpenAIAudioOutput configures the spoken audio an audio model returns.
+type OpenAIAudioOutput struct {
+	// Voice is the voice to speak with, such as "alloy" or "coral".
+	Voice string
+	// Format is the audio encoding. Responses are streamed, for which OpenAI
+	// only accepts "pcm16", the default.
+	Format string
+}
+
+type GPT4OAudio struct {
+	// AudioOutput asks for spoken audio alongside the text, returned in
+	// response.Completion.Audio. Nil returns text only.
+	AudioOutput *OpenAIAudioOutput
+}
+
+func (g GPT4OAudio) EstimateCost(text string) float64 {
+	return (float64(len(text)) / 4) * 0.00000250
+}
+
+func (g GPT4OAudio) GetInputCostPer1M() float64 {
+	return 2.5
+}
+
+func (g GPT4OAudio) GetOutputCostPer1M() float64 {
+	return 10.0
+}
+
+func (g GPT4OAudio) GetName() string {
+	return GPT4OAudioAlias
+}
+
+func (g GPT4OAudio) GetProvider() string {
+	return OpenaiProvider
+}
+
+func (g GPT4OAudio) Family() string {
+	return FamilyGPT4O
+}
+
+func (g GPT4OAudio) Capabilities() Capabilities {
+	return Capabilities{
+		Tools:        true,
+		Streaming:    true,
+		SystemPrompt: true,
+	}
+}
+
+var _ Model = new(GPT4OAudio)
+var _ CostBreakdown = new(GPT4OAudio)
 
 type GPT5 struct {
 	// StructuredOutput represents a subset of the JSON Schema Language. Refer to openai documentation for complete and up-to-date information. An example structure could be:
@@ -379,6 +642,18 @@ func (g GPT5) EstimateCost(text string) float64 {
 	return (float64(len(text)) / 4) * 0.00000125
 }
 
+func (g GPT5) GetInputCostPer1M() float64 {
+	return 1.25
+}
+
+func (g GPT5) GetCachedInputCostPer1M() float64 {
+	return 0.125
+}
+
+func (g GPT5) GetOutputCostPer1M() float64 {
+	return 10.0
+}
+
 func (g GPT5) GetName() string {
 	return GPT5Alias
 }
@@ -387,7 +662,29 @@ func (g GPT5) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (g GPT5) Family() string {
+	return FamilyGPT5
+}
+
+func (g GPT5) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		Tools:            true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
+// DowngradeTarget returns GPT5Mini with the same inputs.
+func (m GPT5) DowngradeTarget() Model {
+	return GPT5Mini(m)
+}
+
 var _ Model = new(GPT5)
+var _ CostBreakdown = new(GPT5)
+var _ CachedCostBreakdown = new(GPT5)
 
 type GPT5Mini struct {
 	// StructuredOutput represents a subset of the JSON Schema Language. Refer to openai documentation for complete and up-to-date information. An example structure could be:
@@ -420,6 +717,18 @@ func (g GPT5Mini) EstimateCost(text string) float64 {
 	return (float64(len(text)) / 4) * 0.00000025
 }
 
+func (g GPT5Mini) GetInputCostPer1M() float64 {
+	return 0.25
+}
+
+func (g GPT5Mini) GetCachedInputCostPer1M() float64 {
+	return 0.025
+}
+
+func (g GPT5Mini) GetOutputCostPer1M() float64 {
+	return 2.0
+}
+
 func (g GPT5Mini) GetName() string {
 	return GPT5MiniAlias
 }
@@ -428,7 +737,29 @@ func (g GPT5Mini) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (g GPT5Mini) Family() string {
+	return FamilyGPT5
+}
+
+func (g GPT5Mini) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		Tools:            true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
+// DowngradeTarget returns GPT5Nano with the same inputs.
+func (m GPT5Mini) DowngradeTarget() Model {
+	return GPT5Nano(m)
+}
+
 var _ Model = new(GPT5Mini)
+var _ CostBreakdown = new(GPT5Mini)
+var _ CachedCostBreakdown = new(GPT5Mini)
 
 type GPT5Nano struct {
 	// StructuredOutput represents a subset of the JSON Schema Language. Refer to openai documentation for complete and up-to-date information. An example structure could be:
@@ -461,6 +792,18 @@ func (g GPT5Nano) EstimateCost(text string) float64 {
 	return (float64(len(text)) / 4) * 5e-8
 }
 
+func (g GPT5Nano) GetInputCostPer1M() float64 {
+	return 0.05
+}
+
+func (g GPT5Nano) GetCachedInputCostPer1M() float64 {
+	return 0.005
+}
+
+func (g GPT5Nano) GetOutputCostPer1M() float64 {
+	return 0.4
+}
+
 func (g GPT5Nano) GetName() string {
 	return GPT5NanoAlias
 }
@@ -469,7 +812,24 @@ func (g GPT5Nano) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (g GPT5Nano) Family() string {
+	return FamilyGPT5
+}
+
+func (g GPT5Nano) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		Tools:            true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(GPT5Nano)
+var _ CostBreakdown = new(GPT5Nano)
+var _ CachedCostBreakdown = new(GPT5Nano)
 
 type GPT5Chat struct {
 	// StructuredOutput represents a subset of the JSON Schema Language. Refer to openai documentation for complete and up-to-date information. An example structure could be:
@@ -510,6 +870,20 @@ func (g GPT5Chat) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (g GPT5Chat) Family() string {
+	return FamilyGPT5
+}
+
+func (g GPT5Chat) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(GPT5Chat)
 
 type GPT51 struct {
@@ -530,6 +904,21 @@ func (g GPT51) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (g GPT51) Family() string {
+	return FamilyGPT51
+}
+
+func (g GPT51) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		Tools:            true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(GPT51)
 
 type GPT51Chat struct {
@@ -550,6 +939,21 @@ func (g GPT51Chat) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (g GPT51Chat) Family() string {
+	return FamilyGPT51
+}
+
+func (g GPT51Chat) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		Tools:            true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(GPT51Chat)
 
 type GPT51Codex struct {
@@ -570,6 +974,21 @@ func (g GPT51Codex) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (g GPT51Codex) Family() string {
+	return FamilyGPT51
+}
+
+func (g GPT51Codex) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		Tools:            true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(GPT51Codex)
 
 type GPT51CodexMini struct {
@@ -590,6 +1009,21 @@ func (g GPT51CodexMini) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (g GPT51CodexMini) Family() string {
+	return FamilyGPT51
+}
+
+func (g GPT51CodexMini) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		Tools:            true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(GPT51CodexMini)
 
 const ImageModelAlias = "gpt-image-1"
@@ -656,4 +1090,14 @@ func (d GPTImage) GetProvider() string {
 	return OpenaiProvider
 }
 
+func (d GPTImage) Family() string {
+	return FamilyGPTImage
+}
+
+func (d GPTImage) Capabilities() Capabilities {
+	return Capabilities{
+		Vision: true,
+	}
+}
+
 var _ Model = new(GPTImage)
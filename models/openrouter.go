@@ -7,11 +7,27 @@ type OpenRouterImagePayload struct {
 	Detail string
 }
 
+// OpenRouterReasoning configures the reasoning of models that think before
+// answering. Set either Effort or MaxTokens; OpenRouter translates them for
+// the upstream model.
+type OpenRouterReasoning struct {
+	// Effort is "low", "medium" or "high".
+	Effort string
+	// MaxTokens caps the tokens spent on reasoning.
+	MaxTokens int
+	// Exclude has the model reason without returning the reasoning text.
+	Exclude bool
+}
+
 type OpenRouterModel struct {
 	ModelName        string
 	ImageFile        []OpenRouterImagePayload
 	PdfFile          map[string]string
 	StructuredOutput map[string]any
+	// Reasoning, when set, is sent as OpenRouter's reasoning parameter. The
+	// reasoning text streams to the request's ThoughtHandler and is returned
+	// in response.Completion.Thoughts.
+	Reasoning *OpenRouterReasoning
 }
 
 func (o OpenRouterModel) EstimateCost(text string) float64 {
@@ -26,4 +42,19 @@ func (o OpenRouterModel) GetProvider() string {
 	return OpenRouterProvider
 }
 
+// Family is empty, as an OpenRouter model can name any upstream model.
+func (o OpenRouterModel) Family() string {
+	return ""
+}
+
+func (o OpenRouterModel) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(OpenRouterModel)
@@ -4,8 +4,28 @@ package models
 
 const PerplexityProvider = "perplexity"
 
+// Model families, as returned by Family.
+const (
+	FamilySonar = "sonar"
+)
+
+func init() {
+	chatModels = append(chatModels, SonarReasoningPro{}, SonarReasoning{}, SonarPro{}, Sonar{})
+}
+
+// SonarSearch constrains the web search a Sonar model runs before answering.
+type SonarSearch struct {
+	// RecencyFilter limits results to those published within the last
+	// "hour", "day", "week", "month" or "year".
+	RecencyFilter string
+	// DomainFilter limits results to the listed domains, or excludes a
+	// domain when it is prefixed with "-".
+	DomainFilter []string
+}
+
 type SonarReasoningPro struct {
 	StructuredOutput map[string]any
+	Search           SonarSearch
 }
 
 func (s SonarReasoningPro) EstimateCost(text string) float64 {
@@ -20,10 +40,23 @@ func (s SonarReasoningPro) GetProvider() string {
 	return PerplexityProvider
 }
 
+func (s SonarReasoningPro) Family() string {
+	return FamilySonar
+}
+
+func (s SonarReasoningPro) Capabilities() Capabilities {
+	return Capabilities{
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(SonarReasoningPro)
 
 type SonarReasoning struct {
 	StructuredOutput map[string]any
+	Search           SonarSearch
 }
 
 func (s SonarReasoning) EstimateCost(text string) float64 {
@@ -38,10 +71,23 @@ func (s SonarReasoning) GetProvider() string {
 	return PerplexityProvider
 }
 
+func (s SonarReasoning) Family() string {
+	return FamilySonar
+}
+
+func (s SonarReasoning) Capabilities() Capabilities {
+	return Capabilities{
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(SonarReasoning)
 
 type SonarPro struct {
 	StructuredOutput map[string]any
+	Search           SonarSearch
 }
 
 func (s SonarPro) EstimateCost(text string) float64 {
@@ -56,10 +102,23 @@ func (s SonarPro) GetProvider() string {
 	return PerplexityProvider
 }
 
+func (s SonarPro) Family() string {
+	return FamilySonar
+}
+
+func (s SonarPro) Capabilities() Capabilities {
+	return Capabilities{
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(SonarPro)
 
 type Sonar struct {
 	StructuredOutput map[string]any
+	Search           SonarSearch
 }
 
 func (s Sonar) EstimateCost(text string) float64 {
@@ -74,4 +133,16 @@ func (s Sonar) GetProvider() string {
 	return PerplexityProvider
 }
 
+func (s Sonar) Family() string {
+	return FamilySonar
+}
+
+func (s Sonar) Capabilities() Capabilities {
+	return Capabilities{
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(Sonar)
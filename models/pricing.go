@@ -0,0 +1,65 @@
+package models
+
+import "sync"
+
+// PricingOverride replaces a model's built-in rates, in USD per million
+// tokens, for deployments with negotiated prices. A zero CachedInputPer1M
+// bills cached prompt tokens at InputPer1M.
+type PricingOverride struct {
+	InputPer1M       float64
+	OutputPer1M      float64
+	CachedInputPer1M float64
+}
+
+var (
+	pricingMu sync.RWMutex
+	pricing   = map[string]PricingOverride{}
+)
+
+// SetPricing overrides the input and output rates of the model named
+// modelName, as returned by GetName, for every cost the router reports.
+// A cached rate set earlier with SetCachedPricing is kept.
+func SetPricing(modelName string, inPer1M, outPer1M float64) {
+	pricingMu.Lock()
+	defer pricingMu.Unlock()
+
+	p := pricing[modelName]
+	p.InputPer1M = inPer1M
+	p.OutputPer1M = outPer1M
+	pricing[modelName] = p
+}
+
+// SetCachedPricing overrides the rate for prompt tokens served from the
+// provider's cache. It takes effect together with the rates set by
+// SetPricing.
+func SetCachedPricing(modelName string, cachedInPer1M float64) {
+	pricingMu.Lock()
+	defer pricingMu.Unlock()
+
+	p := pricing[modelName]
+	p.CachedInputPer1M = cachedInPer1M
+	pricing[modelName] = p
+}
+
+// ClearPricing removes the overrides for modelName, restoring its built-in
+// rates.
+func ClearPricing(modelName string) {
+	pricingMu.Lock()
+	defer pricingMu.Unlock()
+
+	delete(pricing, modelName)
+}
+
+// Pricing returns the override registered for modelName. It reports false
+// when SetPricing has not been called for the model.
+func Pricing(modelName string) (PricingOverride, bool) {
+	pricingMu.RLock()
+	defer pricingMu.RUnlock()
+
+	p, ok := pricing[modelName]
+	if !ok || (p.InputPer1M == 0 && p.OutputPer1M == 0) {
+		return PricingOverride{}, false
+	}
+
+	return p, true
+}
@@ -0,0 +1,139 @@
+package models
+
+const QwenProvider = "qwen"
+
+// FamilyQwen is the family of the Qwen commercial models, as returned by
+// Family.
+const FamilyQwen = "qwen"
+
+const (
+	QwenMaxAlias   = "qwen-max"
+	QwenPlusAlias  = "qwen-plus"
+	QwenTurboAlias = "qwen-turbo"
+)
+
+type QwenMax struct {
+	// EnableSearch lets the model search the web before answering.
+	EnableSearch bool
+}
+
+func (q QwenMax) EstimateCost(text string) float64 {
+	inputCostPerToken := 0.0000016
+	outputCostPerToken := 0.0000064
+	averageCost := (inputCostPerToken + outputCostPerToken) / 2
+	return (float64(len(text)) / 4) * averageCost
+}
+
+func (q QwenMax) GetInputCostPer1M() float64 {
+	return 1.6
+}
+
+func (q QwenMax) GetOutputCostPer1M() float64 {
+	return 6.4
+}
+
+func (QwenMax) GetName() string {
+	return QwenMaxAlias
+}
+
+func (QwenMax) GetProvider() string {
+	return QwenProvider
+}
+
+func (QwenMax) Family() string {
+	return FamilyQwen
+}
+
+func (QwenMax) Capabilities() Capabilities {
+	return Capabilities{
+		Streaming:    true,
+		SystemPrompt: true,
+	}
+}
+
+var _ Model = new(QwenMax)
+var _ CostBreakdown = new(QwenMax)
+
+type QwenPlus struct {
+	// EnableSearch lets the model search the web before answering.
+	EnableSearch bool
+}
+
+func (q QwenPlus) EstimateCost(text string) float64 {
+	inputCostPerToken := 0.0000004
+	outputCostPerToken := 0.0000012
+	averageCost := (inputCostPerToken + outputCostPerToken) / 2
+	return (float64(len(text)) / 4) * averageCost
+}
+
+func (q QwenPlus) GetInputCostPer1M() float64 {
+	return 0.4
+}
+
+func (q QwenPlus) GetOutputCostPer1M() float64 {
+	return 1.2
+}
+
+func (QwenPlus) GetName() string {
+	return QwenPlusAlias
+}
+
+func (QwenPlus) GetProvider() string {
+	return QwenProvider
+}
+
+func (QwenPlus) Family() string {
+	return FamilyQwen
+}
+
+func (QwenPlus) Capabilities() Capabilities {
+	return Capabilities{
+		Streaming:    true,
+		SystemPrompt: true,
+	}
+}
+
+var _ Model = new(QwenPlus)
+var _ CostBreakdown = new(QwenPlus)
+
+type QwenTurbo struct {
+	// EnableSearch lets the model search the web before answering.
+	EnableSearch bool
+}
+
+func (q QwenTurbo) EstimateCost(text string) float64 {
+	inputCostPerToken := 0.00000005
+	outputCostPerToken := 0.0000002
+	averageCost := (inputCostPerToken + outputCostPerToken) / 2
+	return (float64(len(text)) / 4) * averageCost
+}
+
+func (q QwenTurbo) GetInputCostPer1M() float64 {
+	return 0.05
+}
+
+func (q QwenTurbo) GetOutputCostPer1M() float64 {
+	return 0.2
+}
+
+func (QwenTurbo) GetName() string {
+	return QwenTurboAlias
+}
+
+func (QwenTurbo) GetProvider() string {
+	return QwenProvider
+}
+
+func (QwenTurbo) Family() string {
+	return FamilyQwen
+}
+
+func (QwenTurbo) Capabilities() Capabilities {
+	return Capabilities{
+		Streaming:    true,
+		SystemPrompt: true,
+	}
+}
+
+var _ Model = new(QwenTurbo)
+var _ CostBreakdown = new(QwenTurbo)
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var ErrUnsupportedInput = errors.New("unsupported input")
+
+// UnsupportedInputError reports input attached to a model whose capabilities
+// do not allow it. It matches ErrUnsupportedInput with errors.Is.
+type UnsupportedInputError struct {
+	Model string
+	Input string
+}
+
+func (e *UnsupportedInputError) Error() string {
+	return fmt.Sprintf("model %s does not support %s input", e.Model, e.Input)
+}
+
+func (e *UnsupportedInputError) Unwrap() error {
+	return ErrUnsupportedInput
+}
+
+// HasMediaInputs reports whether the model carries images, PDFs or other
+// files, which can stand in for the user message.
+func HasMediaInputs(m Model) bool {
+	for _, field := range []string{"ImageFile", "PdfFile", "PdfFiles", "Files"} {
+		if hasField(m, field) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasField reports whether the model struct has a non-empty map or slice
+// field with the given name.
+func hasField(m Model, name string) bool {
+	v := reflect.ValueOf(m)
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return false
+	}
+
+	f := v.FieldByName(name)
+	switch f.Kind() {
+	case reflect.Map, reflect.Slice:
+		return f.Len() > 0
+	default:
+		return false
+	}
+}
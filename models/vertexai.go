@@ -2,6 +2,17 @@ package models
 
 const VertexProvider = "vertexai"
 
+// vertexGooglePublisher prefixes Google's first-party models on Vertex AI.
+const vertexGooglePublisher = "publishers/google/models/"
+
+// VertexModel is implemented by every model that can be served through Vertex
+// AI. VertexModelID returns the publisher-qualified resource name Vertex
+// expects, which is not always the public Gemini API name from GetName.
+type VertexModel interface {
+	Model
+	VertexModelID() string
+}
+
 // NOTE: VertexGemini15FlashThinking and VertexGemini15Pro types have been removed as these models were retired by Google in 2025
 
 // Gemini 2.0 Models
@@ -9,6 +20,11 @@ const VertexProvider = "vertexai"
 type VertexGemini20Flash struct {
 	Tools            GoogleTool
 	StructuredOutput map[string]any
+	// ResponseMimeType sets the response format: GoogleMimeText,
+	// GoogleMimeJSON, or GoogleMimeEnum to classify into the labels of a
+	// GoogleEnumSchema. It defaults to GoogleMimeJSON when StructuredOutput
+	// is set.
+	ResponseMimeType string
 	PdfFiles         []GooglePdf
 	ImageFile        []GoogleImagePayload
 	Files            []GoogleFilePayload
@@ -31,16 +47,40 @@ func (v VertexGemini20Flash) GetName() string {
 	return "gemini-2.0-flash-001"
 }
 
+func (v VertexGemini20Flash) VertexModelID() string {
+	return vertexGooglePublisher + v.GetName()
+}
+
 func (v VertexGemini20Flash) GetProvider() string {
 	return VertexProvider
 }
 
+func (v VertexGemini20Flash) Family() string {
+	return FamilyGemini20
+}
+
+func (v VertexGemini20Flash) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(VertexGemini20Flash)
+var _ VertexModel = new(VertexGemini20Flash)
 var _ CostBreakdown = new(VertexGemini20Flash)
 
 type VertexGemini20FlashLite struct {
 	Tools            GoogleTool
 	StructuredOutput map[string]any
+	// ResponseMimeType sets the response format: GoogleMimeText,
+	// GoogleMimeJSON, or GoogleMimeEnum to classify into the labels of a
+	// GoogleEnumSchema. It defaults to GoogleMimeJSON when StructuredOutput
+	// is set.
+	ResponseMimeType string
 	PdfFiles         []GooglePdf
 	ImageFile        []GoogleImagePayload
 	Files            []GoogleFilePayload
@@ -63,11 +103,30 @@ func (v VertexGemini20FlashLite) GetName() string {
 	return "gemini-2.0-flash-lite-001"
 }
 
+func (v VertexGemini20FlashLite) VertexModelID() string {
+	return vertexGooglePublisher + v.GetName()
+}
+
 func (v VertexGemini20FlashLite) GetProvider() string {
 	return VertexProvider
 }
 
+func (v VertexGemini20FlashLite) Family() string {
+	return FamilyGemini20
+}
+
+func (v VertexGemini20FlashLite) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(VertexGemini20FlashLite)
+var _ VertexModel = new(VertexGemini20FlashLite)
 var _ CostBreakdown = new(VertexGemini20FlashLite)
 
 // Gemini 2.5 Models (GA)
@@ -75,6 +134,11 @@ var _ CostBreakdown = new(VertexGemini20FlashLite)
 type VertexGemini25Pro struct {
 	Tools            GoogleTool
 	StructuredOutput map[string]any
+	// ResponseMimeType sets the response format: GoogleMimeText,
+	// GoogleMimeJSON, or GoogleMimeEnum to classify into the labels of a
+	// GoogleEnumSchema. It defaults to GoogleMimeJSON when StructuredOutput
+	// is set.
+	ResponseMimeType string
 	PdfFiles         []GooglePdf
 	ImageFile        []GoogleImagePayload
 	Files            []GoogleFilePayload
@@ -97,16 +161,40 @@ func (v VertexGemini25Pro) GetName() string {
 	return "gemini-2.5-pro"
 }
 
+func (v VertexGemini25Pro) VertexModelID() string {
+	return vertexGooglePublisher + v.GetName()
+}
+
 func (v VertexGemini25Pro) GetProvider() string {
 	return VertexProvider
 }
 
+func (v VertexGemini25Pro) Family() string {
+	return FamilyGemini25
+}
+
+func (v VertexGemini25Pro) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(VertexGemini25Pro)
+var _ VertexModel = new(VertexGemini25Pro)
 var _ CostBreakdown = new(VertexGemini25Pro)
 
 type VertexGemini25Flash struct {
 	Tools            GoogleTool
 	StructuredOutput map[string]any
+	// ResponseMimeType sets the response format: GoogleMimeText,
+	// GoogleMimeJSON, or GoogleMimeEnum to classify into the labels of a
+	// GoogleEnumSchema. It defaults to GoogleMimeJSON when StructuredOutput
+	// is set.
+	ResponseMimeType string
 	PdfFiles         []GooglePdf
 	ImageFile        []GoogleImagePayload
 	Files            []GoogleFilePayload
@@ -129,16 +217,40 @@ func (v VertexGemini25Flash) GetName() string {
 	return "gemini-2.5-flash"
 }
 
+func (v VertexGemini25Flash) VertexModelID() string {
+	return vertexGooglePublisher + v.GetName()
+}
+
 func (v VertexGemini25Flash) GetProvider() string {
 	return VertexProvider
 }
 
+func (v VertexGemini25Flash) Family() string {
+	return FamilyGemini25
+}
+
+func (v VertexGemini25Flash) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(VertexGemini25Flash)
+var _ VertexModel = new(VertexGemini25Flash)
 var _ CostBreakdown = new(VertexGemini25Flash)
 
 type VertexGemini25FlashLite struct {
 	Tools            GoogleTool
 	StructuredOutput map[string]any
+	// ResponseMimeType sets the response format: GoogleMimeText,
+	// GoogleMimeJSON, or GoogleMimeEnum to classify into the labels of a
+	// GoogleEnumSchema. It defaults to GoogleMimeJSON when StructuredOutput
+	// is set.
+	ResponseMimeType string
 	PdfFiles         []GooglePdf
 	ImageFile        []GoogleImagePayload
 	Files            []GoogleFilePayload
@@ -161,11 +273,30 @@ func (v VertexGemini25FlashLite) GetName() string {
 	return "gemini-2.5-flash-lite"
 }
 
+func (v VertexGemini25FlashLite) VertexModelID() string {
+	return vertexGooglePublisher + v.GetName()
+}
+
 func (v VertexGemini25FlashLite) GetProvider() string {
 	return VertexProvider
 }
 
+func (v VertexGemini25FlashLite) Family() string {
+	return FamilyGemini25
+}
+
+func (v VertexGemini25FlashLite) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(VertexGemini25FlashLite)
+var _ VertexModel = new(VertexGemini25FlashLite)
 var _ CostBreakdown = new(VertexGemini25FlashLite)
 
 type VertexGemini25FlashImage struct {
@@ -197,11 +328,28 @@ func (v VertexGemini25FlashImage) GetName() string {
 	return "gemini-2.5-flash-image"
 }
 
+func (v VertexGemini25FlashImage) VertexModelID() string {
+	return vertexGooglePublisher + v.GetName()
+}
+
 func (v VertexGemini25FlashImage) GetProvider() string {
 	return VertexProvider
 }
 
+func (v VertexGemini25FlashImage) Family() string {
+	return FamilyGemini25
+}
+
+func (v VertexGemini25FlashImage) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:       true,
+		PDF:          true,
+		SystemPrompt: true,
+	}
+}
+
 var _ Model = new(VertexGemini25FlashImage)
+var _ VertexModel = new(VertexGemini25FlashImage)
 var _ CostBreakdown = new(VertexGemini25FlashImage)
 
 // Gemini 3 Models (Preview)
@@ -209,6 +357,11 @@ var _ CostBreakdown = new(VertexGemini25FlashImage)
 type VertexGemini3ProPreview struct {
 	Tools            GoogleTool
 	StructuredOutput map[string]any
+	// ResponseMimeType sets the response format: GoogleMimeText,
+	// GoogleMimeJSON, or GoogleMimeEnum to classify into the labels of a
+	// GoogleEnumSchema. It defaults to GoogleMimeJSON when StructuredOutput
+	// is set.
+	ResponseMimeType string
 	PdfFiles         []GooglePdf
 	ImageFile        []GoogleImagePayload
 	Files            []GoogleFilePayload
@@ -232,16 +385,40 @@ func (v VertexGemini3ProPreview) GetName() string {
 	return "gemini-3-pro-preview"
 }
 
+func (v VertexGemini3ProPreview) VertexModelID() string {
+	return vertexGooglePublisher + v.GetName()
+}
+
 func (v VertexGemini3ProPreview) GetProvider() string {
 	return VertexProvider
 }
 
+func (v VertexGemini3ProPreview) Family() string {
+	return FamilyGemini3
+}
+
+func (v VertexGemini3ProPreview) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(VertexGemini3ProPreview)
+var _ VertexModel = new(VertexGemini3ProPreview)
 var _ CostBreakdown = new(VertexGemini3ProPreview)
 
 type VertexGemini3FlashPreview struct {
 	Tools            GoogleTool
 	StructuredOutput map[string]any
+	// ResponseMimeType sets the response format: GoogleMimeText,
+	// GoogleMimeJSON, or GoogleMimeEnum to classify into the labels of a
+	// GoogleEnumSchema. It defaults to GoogleMimeJSON when StructuredOutput
+	// is set.
+	ResponseMimeType string
 	PdfFiles         []GooglePdf
 	ImageFile        []GoogleImagePayload
 	Files            []GoogleFilePayload
@@ -265,11 +442,30 @@ func (v VertexGemini3FlashPreview) GetName() string {
 	return "gemini-3-flash-preview"
 }
 
+func (v VertexGemini3FlashPreview) VertexModelID() string {
+	return vertexGooglePublisher + v.GetName()
+}
+
 func (v VertexGemini3FlashPreview) GetProvider() string {
 	return VertexProvider
 }
 
+func (v VertexGemini3FlashPreview) Family() string {
+	return FamilyGemini3
+}
+
+func (v VertexGemini3FlashPreview) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:           true,
+		PDF:              true,
+		StructuredOutput: true,
+		Streaming:        true,
+		SystemPrompt:     true,
+	}
+}
+
 var _ Model = new(VertexGemini3FlashPreview)
+var _ VertexModel = new(VertexGemini3FlashPreview)
 var _ CostBreakdown = new(VertexGemini3FlashPreview)
 
 type VertexGemini3ProImagePreview struct {
@@ -303,9 +499,26 @@ func (v VertexGemini3ProImagePreview) GetName() string {
 	return "gemini-3-pro-image-preview"
 }
 
+func (v VertexGemini3ProImagePreview) VertexModelID() string {
+	return vertexGooglePublisher + v.GetName()
+}
+
 func (v VertexGemini3ProImagePreview) GetProvider() string {
 	return VertexProvider
 }
 
+func (v VertexGemini3ProImagePreview) Family() string {
+	return FamilyGemini3
+}
+
+func (v VertexGemini3ProImagePreview) Capabilities() Capabilities {
+	return Capabilities{
+		Vision:       true,
+		PDF:          true,
+		SystemPrompt: true,
+	}
+}
+
 var _ Model = new(VertexGemini3ProImagePreview)
+var _ VertexModel = new(VertexGemini3ProImagePreview)
 var _ CostBreakdown = new(VertexGemini3ProImagePreview)
@@ -0,0 +1,89 @@
+package models
+
+const VoyageProvider = "voyage"
+
+// Model families, as returned by Family.
+const (
+	FamilyVoyage3 = "voyage-3"
+)
+
+const (
+	Voyage3Alias      = "voyage-3"
+	Voyage3LiteAlias  = "voyage-3-lite"
+	Voyage3LargeAlias = "voyage-3-large"
+)
+
+// Voyage3 is Voyage AI's general-purpose embedding model.
+type Voyage3 struct{}
+
+func (Voyage3) EstimateCost(text string) float64 {
+	return (float64(len(text)) / 4) * 0.00000006
+}
+
+func (Voyage3) GetName() string {
+	return Voyage3Alias
+}
+
+func (Voyage3) GetProvider() string {
+	return VoyageProvider
+}
+
+func (Voyage3) Family() string {
+	return FamilyVoyage3
+}
+
+func (Voyage3) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+var _ Model = new(Voyage3)
+
+// Voyage3Lite trades some retrieval quality for lower latency and cost.
+type Voyage3Lite struct{}
+
+func (Voyage3Lite) EstimateCost(text string) float64 {
+	return (float64(len(text)) / 4) * 0.00000002
+}
+
+func (Voyage3Lite) GetName() string {
+	return Voyage3LiteAlias
+}
+
+func (Voyage3Lite) GetProvider() string {
+	return VoyageProvider
+}
+
+func (Voyage3Lite) Family() string {
+	return FamilyVoyage3
+}
+
+func (Voyage3Lite) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+var _ Model = new(Voyage3Lite)
+
+// Voyage3Large is Voyage AI's highest-quality embedding model.
+type Voyage3Large struct{}
+
+func (Voyage3Large) EstimateCost(text string) float64 {
+	return (float64(len(text)) / 4) * 0.00000018
+}
+
+func (Voyage3Large) GetName() string {
+	return Voyage3LargeAlias
+}
+
+func (Voyage3Large) GetProvider() string {
+	return VoyageProvider
+}
+
+func (Voyage3Large) Family() string {
+	return FamilyVoyage3
+}
+
+func (Voyage3Large) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+var _ Model = new(Voyage3Large)
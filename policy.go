@@ -0,0 +1,234 @@
+package heimdall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+)
+
+// FallbackPolicy chooses the next model to try from the way the previous
+// attempt failed. Build one by chaining its methods on the zero value:
+//
+//	policy := heimdall.FallbackPolicy{}.
+//		Primary(models.Claude45Sonnet{}).
+//		OnRateLimit(models.Claude45Haiku{}).
+//		OnError(models.GPT41{}).
+//		WithMaxCost(0.05)
+//
+// A rate-limited attempt moves to the next OnRateLimit model, and to the
+// OnError models once those are used up. Any other provider error moves to
+// the next OnError model. A response blocked by a content filter only moves
+// to OnContentFilter models, since another model is usually refused for the
+// same reason. Context errors end the request straight away. Each model is
+// tried at most once.
+type FallbackPolicy struct {
+	primary         models.Model
+	onRateLimit     []models.Model
+	onError         []models.Model
+	onContentFilter []models.Model
+	maxCost         float64
+}
+
+// Primary sets the model tried first. It defaults to the request's Model.
+func (p FallbackPolicy) Primary(model models.Model) FallbackPolicy {
+	p.primary = model
+	return p
+}
+
+// OnRateLimit adds models to try, in order, after an attempt is rate
+// limited.
+func (p FallbackPolicy) OnRateLimit(fallback ...models.Model) FallbackPolicy {
+	p.onRateLimit = slices.Concat(p.onRateLimit, fallback)
+	return p
+}
+
+// OnError adds models to try, in order, after an attempt fails for any
+// reason other than a content filter, such as an outage.
+func (p FallbackPolicy) OnError(fallback ...models.Model) FallbackPolicy {
+	p.onError = slices.Concat(p.onError, fallback)
+	return p
+}
+
+// OnContentFilter adds models to try, in order, after the provider blocks
+// the prompt or response.
+func (p FallbackPolicy) OnContentFilter(fallback ...models.Model) FallbackPolicy {
+	p.onContentFilter = slices.Concat(p.onContentFilter, fallback)
+	return p
+}
+
+// WithMaxCost skips any model whose estimated cost for the request's prompt,
+// in USD, exceeds maxCost, as though it had failed with ErrOverBudget. The
+// prompt includes the History, and is priced at any rates registered with
+// models.SetPricing. Zero leaves the cost unbounded.
+func (p FallbackPolicy) WithMaxCost(maxCost float64) FallbackPolicy {
+	p.maxCost = maxCost
+	return p
+}
+
+// fallbacks lists every model the policy may fall back to.
+func (p FallbackPolicy) fallbacks() []models.Model {
+	return slices.Concat(p.onRateLimit, p.onError, p.onContentFilter)
+}
+
+// policyRun tracks the models of a FallbackPolicy already handed out.
+type policyRun struct {
+	policy FallbackPolicy
+	tried  map[string]bool
+}
+
+// after returns the model to try after one failed with err, or nil when the
+// policy has none left for that kind of failure.
+func (run *policyRun) after(err error) models.Model {
+	queues := [][]models.Model{run.policy.onError}
+	switch {
+	case isRateLimited(err):
+		queues = [][]models.Model{run.policy.onRateLimit, run.policy.onError}
+	case errors.Is(err, ErrContentFiltered):
+		queues = [][]models.Model{run.policy.onContentFilter}
+	}
+
+	for _, queue := range queues {
+		for _, model := range queue {
+			key := model.GetProvider() + "/" + model.GetName()
+			if !run.tried[key] {
+				run.tried[key] = true
+				return model
+			}
+		}
+	}
+
+	return nil
+}
+
+// CompleteWithPolicy completes req like Complete, but picks fallback models
+// with policy instead of req.Fallback. Every move from one model to the next
+// is recorded on the request log.
+func (r *Router) CompleteWithPolicy(
+	ctx context.Context,
+	req request.Completion,
+	policy FallbackPolicy,
+) (response.Completion, error) {
+	now := time.Now()
+
+	req = req.ApplyDefaults(request.DefaultsFromContext(ctx))
+	if policy.primary == nil {
+		policy.primary = req.Model
+	}
+	if policy.primary == nil {
+		return response.Completion{}, errors.New("fallback policy has no primary model")
+	}
+	req.Model = policy.primary
+	req.Fallback = policy.fallbacks()
+	if err := req.Validate(); err != nil {
+		return response.Completion{}, err
+	}
+
+	req.Tags["request_type"] = "completion"
+
+	id := requestID(req)
+	ctx = request.WithID(ctx, id)
+
+	requestLog := response.Logging{
+		Events: []response.Event{
+			{
+				Timestamp:   now,
+				Description: "start of call to CompleteWithPolicy",
+			},
+		},
+		RequestID: id,
+		SystemMsg: req.SystemMessage,
+		UserMsg:   req.UserMessage,
+		Start:     now,
+	}
+
+	run := policyRun{
+		policy: policy,
+		tried: map[string]bool{
+			policy.primary.GetProvider() + "/" + policy.primary.GetName(): true,
+		},
+	}
+
+	var served models.Model
+	var resp response.Completion
+	var err error
+	for model := policy.primary; model != nil; {
+		resp, err = r.tryPolicyModel(ctx, req, model, policy.maxCost, &requestLog)
+		if err == nil {
+			served = model
+			resp, err = r.continueTruncated(ctx, req, model, resp, &requestLog)
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		next := run.after(err)
+		if next != nil {
+			requestLog.Events = append(requestLog.Events, response.Event{
+				Timestamp: time.Now(),
+				Description: fmt.Sprintf(
+					"fallback policy: model: %s failed with err: %v, moving to model: %s",
+					model.GetName(),
+					err,
+					next.GetName(),
+				),
+			})
+		}
+		model = next
+	}
+
+	r.finishLog(&requestLog, req, served, resp, err)
+
+	resp.RequestLog = requestLog
+
+	return resp, err
+}
+
+// tryPolicyModel makes one attempt on model for CompleteWithPolicy, failing
+// without a provider call when the model cannot be routed or is over
+// budget.
+func (r *Router) tryPolicyModel(
+	ctx context.Context,
+	req request.Completion,
+	model models.Model,
+	maxCost float64,
+	requestLog *response.Logging,
+) (response.Completion, error) {
+	if r.providers[model.GetProvider()] == nil {
+		return response.Completion{}, fmt.Errorf(
+			"%w: %s", ErrUnsupportedProvider, model.GetProvider(),
+		)
+	}
+	if err := r.checkSupported(model); err != nil {
+		return response.Completion{}, err
+	}
+	if maxCost > 0 {
+		// Only the prompt is known before the call, so it alone is held
+		// against the budget, priced as the request log will price it.
+		if cost, _ := estimateCost(model, req, response.Completion{}); cost > maxCost {
+			return response.Completion{}, fmt.Errorf(
+				"%w: %s is estimated at $%.4f, above $%.4f",
+				ErrOverBudget,
+				model.GetName(),
+				cost,
+				maxCost,
+			)
+		}
+	}
+
+	requestLog.Events = append(requestLog.Events, response.Event{
+		Timestamp: time.Now(),
+		Description: fmt.Sprintf(
+			"attempting tryWithModel using model: %s",
+			model.GetName(),
+		),
+	})
+
+	return r.tryWithModel(ctx, req, model, requestLog)
+}
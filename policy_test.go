@@ -0,0 +1,187 @@
+package heimdall_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flyx-ai/heimdall"
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterCompleteWithPolicy(t *testing.T) {
+	t.Parallel()
+
+	rateLimited := statusError(http.StatusTooManyRequests)
+	outage := statusError(http.StatusServiceUnavailable)
+	filtered := &response.ContentFilteredError{Provider: models.OpenaiProvider, Reason: "content_filter"}
+
+	policy := heimdall.FallbackPolicy{}.
+		Primary(models.GPT4O{}).
+		OnRateLimit(models.GPT4OMini{}).
+		OnError(models.GPT41{})
+
+	tests := map[string]struct {
+		policy    heimdall.FallbackPolicy
+		errs      map[string]error
+		wantCalls []string
+		wantModel string
+		wantErr   error
+	}{
+		"should serve the primary when it succeeds": {
+			policy:    policy,
+			wantCalls: []string{models.GPT4OAlias},
+			wantModel: models.GPT4OAlias,
+		},
+		"should move to the rate limit fallback when rate limited": {
+			policy:    policy,
+			errs:      map[string]error{models.GPT4OAlias: rateLimited},
+			wantCalls: []string{models.GPT4OAlias, models.GPT4OMiniAlias},
+			wantModel: models.GPT4OMiniAlias,
+		},
+		"should skip the rate limit fallback on an outage": {
+			policy:    policy,
+			errs:      map[string]error{models.GPT4OAlias: outage},
+			wantCalls: []string{models.GPT4OAlias, models.GPT41Alias},
+			wantModel: models.GPT41Alias,
+		},
+		"should move on to the error fallbacks once the rate limit ones are used": {
+			policy: policy,
+			errs: map[string]error{
+				models.GPT4OAlias:     rateLimited,
+				models.GPT4OMiniAlias: rateLimited,
+			},
+			wantCalls: []string{models.GPT4OAlias, models.GPT4OMiniAlias, models.GPT41Alias},
+			wantModel: models.GPT41Alias,
+		},
+		"should fail on a content filter without a fallback for it": {
+			policy:    policy,
+			errs:      map[string]error{models.GPT4OAlias: filtered},
+			wantCalls: []string{models.GPT4OAlias},
+			wantErr:   heimdall.ErrContentFiltered,
+		},
+		"should move to the content filter fallback when filtered": {
+			policy:    policy.OnContentFilter(models.GPT5{}),
+			errs:      map[string]error{models.GPT4OAlias: filtered},
+			wantCalls: []string{models.GPT4OAlias, models.GPT5Alias},
+			wantModel: models.GPT5Alias,
+		},
+		"should skip models over the maximum cost": {
+			policy: heimdall.FallbackPolicy{}.
+				Primary(models.GPT4{}).
+				OnError(models.GPT4OMini{}).
+				WithMaxCost(0.000001),
+			wantCalls: []string{models.GPT4OMiniAlias},
+			wantModel: models.GPT4OMiniAlias,
+		},
+		"should return the last error once the policy is exhausted": {
+			policy: policy,
+			errs: map[string]error{
+				models.GPT4OAlias: outage,
+				models.GPT41Alias: outage,
+			},
+			wantCalls: []string{models.GPT4OAlias, models.GPT41Alias},
+			wantErr:   outage,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			calls := &callLog{}
+			router := heimdall.New(time.Second, []heimdall.LLMProvider{
+				fakeProvider{name: models.OpenaiProvider, errs: tt.errs, calls: calls},
+			})
+
+			res, err := router.CompleteWithPolicy(context.Background(), request.Completion{
+				UserMessage: "Hello",
+				Tags:        map[string]string{},
+			}, tt.policy)
+
+			assert.Equal(t, tt.wantCalls, calls.models())
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantModel, res.Model)
+			assert.Equal(t, tt.wantModel, res.RequestLog.Model.GetName())
+		})
+	}
+}
+
+func TestRouterCompleteWithPolicyMaxCostCountsHistory(t *testing.T) {
+	t.Parallel()
+
+	// No other test prices o3-mini, so the global override is safe to set
+	// while tests run in parallel. At $1,000 per million tokens, the history
+	// of about a thousand tokens alone costs about $1.
+	models.SetPricing(models.O3MiniAlias, 1000, 1000)
+	t.Cleanup(func() { models.ClearPricing(models.O3MiniAlias) })
+
+	calls := &callLog{}
+	router := heimdall.New(time.Second, []heimdall.LLMProvider{
+		fakeProvider{name: models.OpenaiProvider, calls: calls},
+	})
+	policy := heimdall.FallbackPolicy{}.
+		Primary(models.O3Mini{}).
+		OnError(models.GPT4OMini{}).
+		WithMaxCost(0.5)
+
+	res, err := router.CompleteWithPolicy(context.Background(), request.Completion{
+		History: []request.Message{
+			{Role: "user", Content: strings.Repeat("a", 4000)},
+			{Role: "assistant", Content: "ok"},
+		},
+		UserMessage: "Hello",
+		Tags:        map[string]string{},
+	}, policy)
+	require.NoError(t, err)
+	assert.Equal(t, models.GPT4OMiniAlias, res.Model)
+	assert.Equal(t, []string{models.GPT4OMiniAlias}, calls.models())
+
+	res, err = router.CompleteWithPolicy(context.Background(), request.Completion{
+		UserMessage: "Hello",
+		Tags:        map[string]string{},
+	}, policy)
+	require.NoError(t, err)
+	assert.Equal(t, models.O3MiniAlias, res.Model)
+	assert.Equal(t, []string{models.GPT4OMiniAlias, models.O3MiniAlias}, calls.models())
+}
+
+func TestRouterCompleteWithPolicyLogsTransitions(t *testing.T) {
+	t.Parallel()
+
+	calls := &callLog{}
+	router := heimdall.New(time.Second, []heimdall.LLMProvider{
+		fakeProvider{
+			name:  models.OpenaiProvider,
+			errs:  map[string]error{models.GPT4OAlias: statusError(http.StatusTooManyRequests)},
+			calls: calls,
+		},
+	})
+
+	res, err := router.CompleteWithPolicy(
+		context.Background(),
+		request.Completion{Model: models.GPT4O{}, UserMessage: "Hello", Tags: map[string]string{}},
+		heimdall.FallbackPolicy{}.OnRateLimit(models.GPT4OMini{}),
+	)
+	require.NoError(t, err)
+
+	var transitions []string
+	for _, event := range res.RequestLog.Events {
+		if strings.HasPrefix(event.Description, "fallback policy:") {
+			transitions = append(transitions, event.Description)
+		}
+	}
+	require.Len(t, transitions, 1)
+	assert.Contains(t, transitions[0], models.GPT4OAlias)
+	assert.Contains(t, transitions[0], "moving to model: "+models.GPT4OMiniAlias)
+}
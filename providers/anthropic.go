@@ -3,15 +3,17 @@ package providers
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"context"
-	"crypto/rand"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/flyx-ai/heimdall/models"
 	"github.com/flyx-ai/heimdall/request"
@@ -22,20 +24,27 @@ const anthropicBaseUrl = "https://api.anthropic.com/v1"
 
 type Anthropic struct {
 	apiKeys []string
+	keys    *KeyDistributor
+	opts    options
 }
 
 // NewAnthropic creates a new Anthropic LLM provider with the given API keys.
-func NewAnthropic(apiKeys []string) Anthropic {
+func NewAnthropic(apiKeys []string, opts ...Option) Anthropic {
+	o := newOptions(opts)
+
 	return Anthropic{
 		apiKeys: apiKeys,
+		keys:    o.keyDistributor(apiKeys),
+		opts:    o,
 	}
 }
 
 type (
 	mediaSource struct {
 		Type      string `json:"type"`
-		MediaType string `json:"media_type"`
-		Data      string `json:"data"`
+		MediaType string `json:"media_type,omitempty"`
+		Data      string `json:"data,omitempty"`
+		URL       string `json:"url,omitempty"`
 	}
 	anthropicMediaPayload struct {
 		Type   string      `json:"type"`
@@ -47,6 +56,26 @@ type (
 	}
 )
 
+// mapAnthropicStop normalises an Anthropic stop_reason. A refusal is
+// reported as a content filter, and pause_turn, which Anthropic sends when a
+// long server tool turn is paused, as a natural stop.
+func mapAnthropicStop(reason string) response.FinishReason {
+	switch reason {
+	case "":
+		return ""
+	case "end_turn", "stop_sequence", "pause_turn":
+		return response.FinishStop
+	case "max_tokens", "model_context_window_exceeded":
+		return response.FinishLength
+	case "tool_use":
+		return response.FinishToolCalls
+	case "refusal":
+		return response.FinishContentFilter
+	default:
+		return response.FinishError
+	}
+}
+
 type anthropicMsg struct {
 	Role    string `json:"role"`
 	Content any    `json:"content"`
@@ -60,9 +89,56 @@ type anthropicRequest struct {
 	MaxTokens   int            `json:"max_tokens"`
 	Temperature float32        `json:"temperature,omitempty"`
 	TopP        float32        `json:"top_p,omitempty"`
+	TopK        int            `json:"top_k,omitempty"`
+	Stop        []string       `json:"stop_sequences,omitempty"`
+	Tools       []any          `json:"tools,omitempty"`
 	Betas       []string       `json:"-"` // Sent as header, not in body
 }
 
+// anthropicWebSearchTool enables Anthropic's server-side web search.
+type anthropicWebSearchTool struct {
+	Type           string   `json:"type"`
+	Name           string   `json:"name"`
+	MaxUses        int      `json:"max_uses,omitempty"`
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	BlockedDomains []string `json:"blocked_domains,omitempty"`
+}
+
+// anthropicServerTool enables a server-side tool that takes no options.
+type anthropicServerTool struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+const anthropicCodeExecutionBeta = "code-execution-2025-08-25"
+
+// anthropicServerTools returns the tool definitions for the enabled server
+// tools, and the betas they need.
+func anthropicServerTools(tools models.AnthropicServerTools) ([]any, []string) {
+	var (
+		defs  []any
+		betas []string
+	)
+	if search := tools.WebSearch; search != nil {
+		defs = append(defs, anthropicWebSearchTool{
+			Type:           "web_search_20250305",
+			Name:           "web_search",
+			MaxUses:        search.MaxUses,
+			AllowedDomains: search.AllowedDomains,
+			BlockedDomains: search.BlockedDomains,
+		})
+	}
+	if tools.CodeExecution {
+		defs = append(defs, anthropicServerTool{
+			Type: "code_execution_20250825",
+			Name: "code_execution",
+		})
+		betas = append(betas, anthropicCodeExecutionBeta)
+	}
+
+	return defs, betas
+}
+
 type anthropicRequestWithStructuredOutput struct {
 	anthropicRequest
 	OutputConfig map[string]any `json:"output_config,omitempty"`
@@ -75,6 +151,12 @@ func (a Anthropic) CompleteResponse(
 	client http.Client,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if len(a.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
+	req = withIdempotencyKey(req)
+
 	reqLog := &response.Logging{}
 	if requestLog == nil {
 		req.Tags["request_type"] = "completion"
@@ -96,6 +178,12 @@ func (a Anthropic) CompleteResponse(
 	}
 
 	for i, key := range a.apiKeys {
+		select {
+		case <-ctx.Done():
+			return response.Completion{}, ctx.Err()
+		default:
+		}
+
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
 			Description: fmt.Sprintf(
@@ -108,6 +196,12 @@ func (a Anthropic) CompleteResponse(
 		if err == nil {
 			return res, nil
 		}
+		if exceedsSizeLimit(err) {
+			return res, err
+		}
+		if errors.Is(err, response.ErrContentFiltered) {
+			return response.Completion{}, err
+		}
 
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
@@ -121,35 +215,40 @@ func (a Anthropic) CompleteResponse(
 	return a.tryWithBackup(ctx, req, client, nil, reqLog)
 }
 
-// doRequest implements LLMProvider.
-func (a Anthropic) doRequest(
-	ctx context.Context,
-	req request.Completion,
-	client http.Client,
-	chunkHandler func(chunk string) error,
-	key string,
-) (response.Completion, int, error) {
-	modelName := req.Model.GetName()
-
+// prepareMessages builds the conversation sent to Anthropic: the request's
+// history followed by the user message with any attached files.
+func (a Anthropic) prepareMessages(req request.Completion) ([]anthropicMsg, error) {
 	var messages []anthropicMsg
 
 	if len(req.History) > 0 {
 		for _, his := range req.History {
-			messages = append(messages, anthropicMsg{
-				Role:    his.Role,
-				Content: his.Content,
-			})
+			messages = append(messages, historyMessage(his))
 		}
 	}
 
-	switch modelName {
+	// An empty user message after an assistant turn prefills that turn for
+	// the model to continue. The API rejects a prefill ending in whitespace.
+	if req.UserMessage == "" && len(messages) > 0 && messages[len(messages)-1].Role == "assistant" {
+		if text, ok := messages[len(messages)-1].Content.(string); ok {
+			messages[len(messages)-1].Content = strings.TrimRightFunc(text, unicode.IsSpace)
+		}
+		return messages, nil
+	}
+	// After a user turn, an empty user message leaves that turn as the one
+	// to answer.
+	if req.UserMessage == "" && len(messages) > 0 && messages[len(messages)-1].Role == "user" &&
+		!models.HasMediaInputs(req.Model) {
+		return messages, nil
+	}
+
+	switch req.Model.GetName() {
 	case models.AnthropicClaude3OpusAlias:
 		msgs, err := prepareClaude3Opus(
 			req.Model,
 			req.UserMessage,
 		)
 		if err != nil {
-			return response.Completion{}, 0, err
+			return nil, err
 		}
 		messages = append(messages, msgs...)
 	case models.AnthropicClaude35HaikuAlias:
@@ -158,7 +257,7 @@ func (a Anthropic) doRequest(
 			req.UserMessage,
 		)
 		if err != nil {
-			return response.Completion{}, 0, err
+			return nil, err
 		}
 
 		messages = append(messages, msgs...)
@@ -168,7 +267,7 @@ func (a Anthropic) doRequest(
 			req.UserMessage,
 		)
 		if err != nil {
-			return response.Completion{}, 0, err
+			return nil, err
 		}
 
 		messages = append(messages, msgs...)
@@ -178,7 +277,7 @@ func (a Anthropic) doRequest(
 			req.UserMessage,
 		)
 		if err != nil {
-			return response.Completion{}, 0, err
+			return nil, err
 		}
 		messages = append(messages, msgs...)
 	case models.AnthropicClaude4SonnetAlias:
@@ -187,7 +286,7 @@ func (a Anthropic) doRequest(
 			req.UserMessage,
 		)
 		if err != nil {
-			return response.Completion{}, 0, err
+			return nil, err
 		}
 		messages = append(messages, msgs...)
 	case models.AnthropicClaude4OpusAlias:
@@ -196,7 +295,7 @@ func (a Anthropic) doRequest(
 			req.UserMessage,
 		)
 		if err != nil {
-			return response.Completion{}, 0, err
+			return nil, err
 		}
 		messages = append(messages, msgs...)
 	case models.AnthropicClaude45HaikuAlias:
@@ -205,7 +304,7 @@ func (a Anthropic) doRequest(
 			req.UserMessage,
 		)
 		if err != nil {
-			return response.Completion{}, 0, err
+			return nil, err
 		}
 		messages = append(messages, msgs...)
 	case models.AnthropicClaude45OpusAlias:
@@ -214,7 +313,7 @@ func (a Anthropic) doRequest(
 			req.UserMessage,
 		)
 		if err != nil {
-			return response.Completion{}, 0, err
+			return nil, err
 		}
 		messages = append(messages, msgs...)
 	case models.AnthropicClaude45SonnetAlias:
@@ -223,7 +322,7 @@ func (a Anthropic) doRequest(
 			req.UserMessage,
 		)
 		if err != nil {
-			return response.Completion{}, 0, err
+			return nil, err
 		}
 		messages = append(messages, msgs...)
 	case models.AnthropicClaude46OpusAlias:
@@ -232,15 +331,41 @@ func (a Anthropic) doRequest(
 			req.UserMessage,
 		)
 		if err != nil {
-			return response.Completion{}, 0, err
+			return nil, err
 		}
 		messages = append(messages, msgs...)
 	}
 
+	return messages, nil
+}
+
+// doRequest implements LLMProvider.
+func (a Anthropic) doRequest(
+	ctx context.Context,
+	req request.Completion,
+	client http.Client,
+	chunkHandler func(chunk string) error,
+	key string,
+) (response.Completion, int, error) {
+	key, release, err := a.keys.Acquire(ctx, key)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	defer release()
+
+	modelName := req.Model.GetName()
+
+	messages, err := a.prepareMessages(req)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+
 	maxTokens := 4096
+	var modelMaxTokens int
 
 	// Extract structured output and model-specific options
 	var structuredOutput map[string]any
+	var serverTools models.AnthropicServerTools
 	var betas []string
 	switch m := req.Model.(type) {
 	case models.Claude3Opus:
@@ -251,29 +376,45 @@ func (a Anthropic) doRequest(
 		structuredOutput = m.StructuredOutput
 	case models.Claude37Sonnet:
 		structuredOutput = m.StructuredOutput
+		serverTools = m.ServerTools
 	case models.Claude4Sonnet:
 		structuredOutput = m.StructuredOutput
+		serverTools = m.ServerTools
 	case models.Claude4Opus:
 		structuredOutput = m.StructuredOutput
+		serverTools = m.ServerTools
 	case models.Claude45Haiku:
 		structuredOutput = m.StructuredOutput
+		serverTools = m.ServerTools
 	case models.Claude45Sonnet:
 		structuredOutput = m.StructuredOutput
+		serverTools = m.ServerTools
 	case models.Claude45Opus:
 		structuredOutput = m.StructuredOutput
+		serverTools = m.ServerTools
 	case models.Claude46Opus:
 		structuredOutput = m.StructuredOutput
+		serverTools = m.ServerTools
 		if m.MaxOutputTokens > 0 {
 			maxTokens = m.MaxOutputTokens
+			modelMaxTokens = m.MaxOutputTokens
 		}
 		if m.ExtendedContext {
 			betas = append(betas, "context-1m-2025-08-07")
 		}
 	}
 
+	// The request's MaxTokens and the model's MaxOutputTokens are both caps,
+	// so the lower one applies when both are set.
+	if req.MaxTokens > 0 && (modelMaxTokens == 0 || req.MaxTokens < modelMaxTokens) {
+		maxTokens = req.MaxTokens
+	}
+
 	if len(structuredOutput) > 0 {
 		betas = append(betas, "structured-outputs-2025-11-13")
 	}
+	tools, toolBetas := anthropicServerTools(serverTools)
+	betas = append(betas, toolBetas...)
 
 	apiReq := anthropicRequest{
 		System:      req.SystemMessage,
@@ -283,10 +424,12 @@ func (a Anthropic) doRequest(
 		MaxTokens:   maxTokens,
 		Temperature: req.Temperature,
 		TopP:        req.TopP,
+		TopK:        req.TopK,
+		Stop:        req.StopSequences,
+		Tools:       tools,
 	}
 
 	var body []byte
-	var err error
 	if len(structuredOutput) > 0 {
 		reqWithOutput := anthropicRequestWithStructuredOutput{
 			anthropicRequest: apiReq,
@@ -304,6 +447,21 @@ func (a Anthropic) doRequest(
 	if err != nil {
 		return response.Completion{}, 0, err
 	}
+	body, err = withRawMessages(body, "messages", req.RawMessages)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	body, err = withExtraBody(body, req.ExtraBody)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	if err := a.opts.checkRequestSize(body); err != nil {
+		return response.Completion{}, 0, err
+	}
+
+	// Stop upstream generation if we return before the stream is drained.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	httpReq, err := http.NewRequestWithContext(ctx, "POST",
 		fmt.Sprintf("%s/messages", anthropicBaseUrl),
@@ -312,9 +470,13 @@ func (a Anthropic) doRequest(
 		return response.Completion{}, 0, err
 	}
 
+	a.opts.setHeaders(httpReq)
+
 	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Idempotency-Key", req.IdempotencyKey)
 	httpReq.Header.Set("X-Api-Key", key)
-	httpReq.Header.Set("Anthropic-Version", "2023-06-01")
+	httpReq.Header.Set("Anthropic-Version", a.version())
+	betas = a.betas(betas)
 	if len(betas) > 0 {
 		httpReq.Header.Set("anthropic-beta", strings.Join(betas, ","))
 	}
@@ -325,73 +487,126 @@ func (a Anthropic) doRequest(
 	}
 	defer resp.Body.Close()
 
+	a.keys.Observe(key, resp.Header)
+
 	if resp.StatusCode != http.StatusOK {
-		return response.Completion{}, resp.StatusCode, fmt.Errorf(
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return response.Completion{}, resp.StatusCode, withBody(fmt.Errorf(
 			"anthropic returned status %d",
 			resp.StatusCode,
-		)
+		), bodyBytes)
 	}
 
-	scanner := bufio.NewScanner(resp.Body)
-	var fullContent strings.Builder
+	stream := newStreamBody(ctx, a.opts.limitResponse(resp.Body))
+	defer stream.Close()
+
+	scanner := bufio.NewScanner(stream)
+	replays := replayFilter{enabled: req.DedupeChunks}
+	fullContent := a.opts.newContent()
 	var rawEvents []json.RawMessage
 
+	var stopReason string
+	var usage anthropicUsage
 	chunks := 0
 	now := time.Now()
-	isRunning := true
 
 	type DeltaEvent struct {
 		Type  string `json:"type"`
 		Index int    `json:"index"`
 		Delta struct {
-			Type string `json:"type"`
-			Text string `json:"text"`
+			Type        string `json:"type"`
+			Text        string `json:"text"`
+			PartialJSON string `json:"partial_json"`
+			StopReason  string `json:"stop_reason"`
+			Citation    struct {
+				URL string `json:"url"`
+			} `json:"citation"`
 		} `json:"delta"`
+		ContentBlock json.RawMessage `json:"content_block"`
+		Message      struct {
+			Usage anthropicUsage `json:"usage"`
+		} `json:"message"`
+		Usage anthropicUsage `json:"usage"`
 	}
 
-	for isRunning {
-		if chunks == 0 && time.Since(now).Seconds() > 3.0 {
+	var toolCalls anthropicServerToolCalls
+
+	// Every event is handled in one pass, through message_stop: the final
+	// message_delta, carrying the stop reason and output token count,
+	// arrives after the last content block.
+	for scanner.Scan() {
+		if chunks == 0 && a.opts.firstChunkTimedOut(now) {
 			return response.Completion{}, 0, context.Canceled
 		}
 
-		var completeText strings.Builder
-
-		for scanner.Scan() {
-			line := scanner.Text()
-
-			if strings.HasPrefix(line, "data: ") {
-				dataStr := strings.TrimPrefix(line, "data: ")
-				var event DeltaEvent
-				err := json.Unmarshal([]byte(dataStr), &event)
-				if err != nil {
-					return response.Completion{}, 0, err
-				}
+		line := scanner.Text()
+		if replays.replayed(line) {
+			continue
+		}
+		dataStr, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
 
-				rawEvents = append(rawEvents, json.RawMessage(dataStr))
+		var event DeltaEvent
+		if err := json.Unmarshal([]byte(dataStr), &event); err != nil {
+			return response.Completion{}, 0, err
+		}
 
-				if event.Type == "content_block_delta" &&
-					event.Delta.Type == "text_delta" {
-					completeText.WriteString(event.Delta.Text)
+		rawEvents = append(rawEvents, json.RawMessage(dataStr))
+		chunks++
 
-					if chunkHandler != nil {
-						if err := chunkHandler(event.Delta.Text); err != nil {
-							return response.Completion{}, 0, err
-						}
+		switch event.Type {
+		case "message_start":
+			usage = event.Message.Usage
+		case "content_block_start":
+			if err := toolCalls.start(event.Index, event.ContentBlock); err != nil {
+				return response.Completion{}, 0, err
+			}
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				text, limitErr := fullContent.add(event.Delta.Text)
+				if chunkHandler != nil {
+					if err := chunkHandler(text); err != nil {
+						return response.Completion{}, 0, err
 					}
 				}
-
-				chunks++
+				if limitErr != nil {
+					return fullContent.partial(req.Model.GetName(), a.Name()), 0, limitErr
+				}
+			case "input_json_delta":
+				toolCalls.input(event.Index, event.Delta.PartialJSON)
+			case "citations_delta":
+				toolCalls.cite(event.Delta.Citation.URL)
 			}
+		case "content_block_stop":
+			toolCalls.stop(event.Index)
+		case "message_delta":
+			if event.Delta.StopReason != "" {
+				stopReason = event.Delta.StopReason
+			}
+			usage = usage.merge(event.Usage)
 		}
 
-		err := scanner.Err()
-		switch err {
-		case nil:
-			fullContent = completeText
-			isRunning = false
-		default:
-			fmt.Println("Error reading input:", err)
-			return response.Completion{}, 0, context.Canceled
+		if event.Type == "message_stop" {
+			break
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, ErrResponseTooLarge) {
+			return response.Completion{}, 0, err
+		}
+		a.opts.log().DebugContext(ctx, "reading anthropic stream failed", "error", err)
+		return response.Completion{}, 0, context.Canceled
+	}
+
+	if stopReason == "refusal" {
+		return response.Completion{}, 0, &response.ContentFilteredError{
+			Provider: a.Name(),
+			Reason:   stopReason,
+			Content:  fullContent.String(),
 		}
 	}
 
@@ -400,23 +615,447 @@ func (a Anthropic) doRequest(
 		return response.Completion{}, 0, fmt.Errorf("marshal raw response events: %w", err)
 	}
 
-	return response.Completion{
-		Content: fullContent.String(),
-		Model:   req.Model.GetName(),
-		// TODO: try to standardize this across providers
-		Usage: response.Usage{
-			// CompletionTokens: lastResponse.Usage.OutputTokens,
-			// PromptTokens:     lastResponse.Usage.InputTokens,
-		},
-		RawRequest:  body,
-		RawResponse: rawResp,
-	}, 0, nil
+	return finishStream(req, chunkHandler, a.opts.sampleRaw(ctx, response.Completion{
+		Content:         fullContent.String(),
+		Model:           req.Model.GetName(),
+		Provider:        a.Name(),
+		FinishReason:    mapAnthropicStop(stopReason),
+		SearchResults:   toolCalls.searchResults,
+		Citations:       toolCalls.citations,
+		ServerToolCalls: toolCalls.calls,
+		Usage:           usage.usage(),
+		RawRequest:      body,
+		RawResponse:     rawResp,
+	}))
+}
+
+// anthropicUsage is the token usage reported in message_start and updated
+// by message_delta events. Input tokens exclude those read from or written
+// to the prompt cache.
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+}
+
+// merge applies a message_delta's usage, whose counts are cumulative. Input
+// counts are only sent when they changed, so zero keeps the earlier value.
+func (u anthropicUsage) merge(delta anthropicUsage) anthropicUsage {
+	if delta.InputTokens != 0 {
+		u.InputTokens = delta.InputTokens
+	}
+	if delta.CacheCreationInputTokens != 0 {
+		u.CacheCreationInputTokens = delta.CacheCreationInputTokens
+	}
+	if delta.CacheReadInputTokens != 0 {
+		u.CacheReadInputTokens = delta.CacheReadInputTokens
+	}
+	if delta.OutputTokens != 0 {
+		u.OutputTokens = delta.OutputTokens
+	}
+
+	return u
+}
+
+func (u anthropicUsage) usage() response.Usage {
+	prompt := u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+
+	return response.Usage{
+		PromptTokens:     prompt,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      prompt + u.OutputTokens,
+		CachedTokens:     u.CacheReadInputTokens,
+	}
+}
+
+// anthropicServerToolCalls assembles the server tool calls, search results
+// and citations streamed in a response's content blocks.
+type anthropicServerToolCalls struct {
+	calls         []response.ServerToolCall
+	searchResults []response.SearchResult
+	citations     []string
+
+	// inputs accumulates the streamed input of the server_tool_use blocks
+	// still open, by content block index.
+	inputs map[int]*pendingToolInput
+}
+
+// pendingToolInput is the input of calls[call] as streamed so far.
+type pendingToolInput struct {
+	call int
+	json strings.Builder
+}
+
+// start records a content block as it opens. Tool calls arrive with an
+// empty input that is streamed afterwards, and results arrive whole.
+func (t *anthropicServerToolCalls) start(index int, raw json.RawMessage) error {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var block struct {
+		Type      string          `json:"type"`
+		ID        string          `json:"id"`
+		Name      string          `json:"name"`
+		Input     json.RawMessage `json:"input"`
+		ToolUseID string          `json:"tool_use_id"`
+		Content   json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(raw, &block); err != nil {
+		return fmt.Errorf("unmarshal content block: %w", err)
+	}
+
+	switch {
+	case block.Type == "server_tool_use":
+		t.calls = append(t.calls, response.ServerToolCall{
+			ID:    block.ID,
+			Name:  block.Name,
+			Input: block.Input,
+		})
+		if t.inputs == nil {
+			t.inputs = map[int]*pendingToolInput{}
+		}
+		t.inputs[index] = &pendingToolInput{call: len(t.calls) - 1}
+	case strings.HasSuffix(block.Type, "_tool_result"):
+		for i := range t.calls {
+			if t.calls[i].ID == block.ToolUseID {
+				t.calls[i].Result = raw
+			}
+		}
+		if block.Type == "web_search_tool_result" {
+			t.addSearchResults(block.Content)
+		}
+	}
+
+	return nil
+}
+
+// addSearchResults records the pages of a web search result. A failed
+// search reports an error object rather than a list, which is skipped.
+func (t *anthropicServerToolCalls) addSearchResults(content json.RawMessage) {
+	var results []struct {
+		Type    string `json:"type"`
+		URL     string `json:"url"`
+		Title   string `json:"title"`
+		PageAge string `json:"page_age"`
+	}
+	if err := json.Unmarshal(content, &results); err != nil {
+		return
+	}
+
+	for _, result := range results {
+		if result.Type != "web_search_result" {
+			continue
+		}
+		t.searchResults = append(t.searchResults, response.SearchResult{
+			Title: result.Title,
+			URL:   result.URL,
+			Date:  result.PageAge,
+		})
+	}
+}
+
+func (t *anthropicServerToolCalls) input(index int, partial string) {
+	if input, ok := t.inputs[index]; ok {
+		input.json.WriteString(partial)
+	}
+}
+
+// stop closes a content block, setting a tool call's input once it has
+// streamed in full.
+func (t *anthropicServerToolCalls) stop(index int) {
+	input, ok := t.inputs[index]
+	if !ok {
+		return
+	}
+	delete(t.inputs, index)
+
+	if input.json.Len() > 0 {
+		t.calls[input.call].Input = json.RawMessage(input.json.String())
+	}
+}
+
+func (t *anthropicServerToolCalls) cite(url string) {
+	if url != "" && !slices.Contains(t.citations, url) {
+		t.citations = append(t.citations, url)
+	}
 }
 
 func (a Anthropic) Name() string {
 	return models.AnthropicProvider
 }
 
+// Supports implements LLMProvider for the Claude models prepareMessages
+// knows how to build a conversation for.
+func (a Anthropic) Supports(m models.Model) bool {
+	if m == nil || m.GetProvider() != a.Name() {
+		return false
+	}
+
+	switch m.GetName() {
+	case models.AnthropicClaude3OpusAlias,
+		models.AnthropicClaude35HaikuAlias,
+		models.AnthropicClaude35SonnetAlias,
+		models.AnthropicClaude37SonnetAlias,
+		models.AnthropicClaude4SonnetAlias,
+		models.AnthropicClaude4OpusAlias,
+		models.AnthropicClaude45HaikuAlias,
+		models.AnthropicClaude45OpusAlias,
+		models.AnthropicClaude45SonnetAlias,
+		models.AnthropicClaude46OpusAlias:
+		return true
+	default:
+		return false
+	}
+}
+
+// SupportsPrefill implements LLMProvider. A trailing assistant turn is sent
+// as a prefill, which the model continues in place.
+func (a Anthropic) SupportsPrefill() bool {
+	return true
+}
+
+// Validate checks every API key against the API concurrently and returns the
+// outcome per key, nil meaning the key works. Keys the API rejects are
+// excluded from later requests.
+func (a Anthropic) Validate(ctx context.Context) map[string]error {
+	return validateKeys(ctx, a.apiKeys, a.keys, func(ctx context.Context, key string) (int, error) {
+		return pingURL(ctx, a.opts, anthropicBaseUrl+"/models", func(req *http.Request) {
+			req.Header.Set("X-Api-Key", key)
+			req.Header.Set("Anthropic-Version", a.version())
+		})
+	})
+}
+
+type anthropicCountTokensRequest struct {
+	System   string         `json:"system,omitempty"`
+	Model    string         `json:"model"`
+	Messages []anthropicMsg `json:"messages"`
+}
+
+// CountTokens returns the exact number of input tokens req would consume,
+// using Anthropic's count_tokens endpoint. Nothing is generated or billed.
+func (a Anthropic) CountTokens(
+	ctx context.Context,
+	req request.Completion,
+) (int, error) {
+	return a.countTokens(ctx, &http.Client{Timeout: 30 * time.Second}, req)
+}
+
+func (a Anthropic) countTokens(
+	ctx context.Context,
+	client *http.Client,
+	req request.Completion,
+) (int, error) {
+	if len(a.apiKeys) == 0 {
+		return 0, ErrNoAPIKeys
+	}
+
+	key := a.apiKeys[0]
+
+	messages, err := a.prepareMessages(req)
+	if err != nil {
+		return 0, err
+	}
+
+	body, err := json.Marshal(anthropicCountTokensRequest{
+		System:   req.SystemMessage,
+		Model:    req.Model.GetName(),
+		Messages: messages,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		anthropicBaseUrl+"/messages/count_tokens", bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	a.opts.setHeaders(httpReq)
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Api-Key", key)
+	httpReq.Header.Set("Anthropic-Version", a.version())
+	if betas := a.betas(nil); len(betas) > 0 {
+		httpReq.Header.Set("anthropic-beta", strings.Join(betas, ","))
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return 0, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf(
+			"unexpected status code %d: %s",
+			resp.StatusCode,
+			string(body),
+		)
+	}
+
+	var result struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return result.InputTokens, nil
+}
+
+// ContextFit configures Anthropic.FitContext.
+type ContextFit struct {
+	// ContextWindow is the model's context size in tokens. Zero uses
+	// 200,000, or 1,000,000 for Claude46Opus with ExtendedContext.
+	ContextWindow int
+	// Reserve is the number of tokens kept free for the response. Zero
+	// reserves the request's MaxTokens, or the output limit the provider
+	// would send.
+	Reserve int
+	// DropSystem lets the system message go as a last resort, once every
+	// history turn has been dropped and the request still does not fit.
+	DropSystem bool
+}
+
+// FitContext returns req with its oldest history turns dropped until the
+// prompt, as measured by the count_tokens endpoint, leaves room for the
+// reserved output within the context window. History is cut so that it
+// still starts with a user turn. It returns ErrContextTooLong when the
+// request does not fit even without history.
+//
+// The search for the cut-off point costs a handful of count_tokens calls,
+// logarithmic in the length of the history.
+func (a Anthropic) FitContext(
+	ctx context.Context,
+	client http.Client,
+	req request.Completion,
+	fit ContextFit,
+) (request.Completion, error) {
+	window := fit.ContextWindow
+	if window == 0 {
+		window = 200_000
+		if m, ok := req.Model.(models.Claude46Opus); ok && m.ExtendedContext {
+			window = 1_000_000
+		}
+	}
+	budget := window - fit.Reserve
+	if fit.Reserve == 0 {
+		budget = window - anthropicOutputLimit(req)
+	}
+
+	fits := func(req request.Completion) (bool, error) {
+		tokens, err := a.countTokens(ctx, &client, req)
+		if err != nil {
+			return false, err
+		}
+
+		return tokens <= budget, nil
+	}
+
+	ok, err := fits(req)
+	if err != nil {
+		return request.Completion{}, err
+	}
+	if ok {
+		return req, nil
+	}
+
+	trimmed := func(drop int) request.Completion {
+		r := req
+		r.History = dropOldestTurns(req.History, drop)
+		return r
+	}
+
+	// Dropping more turns never adds tokens, so the smallest cut that fits
+	// is found by binary search between a known miss (low) and a known fit
+	// (high). Without the system message, even the full history may fit.
+	low := 0
+	ok, err = fits(trimmed(len(req.History)))
+	if err != nil {
+		return request.Completion{}, err
+	}
+	if !ok && fit.DropSystem && req.SystemMessage != "" {
+		req.SystemMessage = ""
+		low = -1
+		if ok, err = fits(trimmed(len(req.History))); err != nil {
+			return request.Completion{}, err
+		}
+	}
+	if !ok {
+		return request.Completion{}, ErrContextTooLong
+	}
+
+	high := len(req.History)
+	for high-low > 1 {
+		mid := (low + high) / 2
+		ok, err := fits(trimmed(mid))
+		if err != nil {
+			return request.Completion{}, err
+		}
+		if ok {
+			high = mid
+		} else {
+			low = mid
+		}
+	}
+
+	return trimmed(high), nil
+}
+
+// anthropicOutputLimit is the max_tokens value sent for req.
+func anthropicOutputLimit(req request.Completion) int {
+	if req.MaxTokens > 0 {
+		return req.MaxTokens
+	}
+	if m, ok := req.Model.(models.Claude46Opus); ok && m.MaxOutputTokens > 0 {
+		return m.MaxOutputTokens
+	}
+
+	return 4096
+}
+
+// dropOldestTurns removes the first n messages of history, and any that
+// follow until the remainder starts with a user turn as the API requires.
+func dropOldestTurns(history []request.Message, n int) []request.Message {
+	history = history[n:]
+	for len(history) > 0 && history[0].Role != "user" {
+		history = history[1:]
+	}
+
+	return history
+}
+
+const defaultAnthropicVersion = "2023-06-01"
+
+func (a Anthropic) version() string {
+	if a.opts.anthropicVersion != "" {
+		return a.opts.anthropicVersion
+	}
+
+	return defaultAnthropicVersion
+}
+
+// betas merges the betas configured on the provider with those a request
+// needs, dropping duplicates.
+func (a Anthropic) betas(required []string) []string {
+	betas := slices.Concat(a.opts.anthropicBetas, required)
+	slices.Sort(betas)
+
+	return slices.Compact(betas)
+}
+
+// RemainingQuota returns the number of requests the provider's keys can still
+// make and when the earliest rate-limit window resets, as last reported by
+// the API and reduced by requests dispatched since.
+func (a Anthropic) RemainingQuota() (requests uint32, resetAt time.Time) {
+	return a.keys.RemainingQuota()
+}
+
 // StreamResponse implements LLMProvider.
 func (a Anthropic) StreamResponse(
 	ctx context.Context,
@@ -425,6 +1064,16 @@ func (a Anthropic) StreamResponse(
 	chunkHandler func(chunk string) error,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if !streams(req.Model) {
+		return completeAsStream(ctx, a, client, req, chunkHandler, requestLog)
+	}
+
+	if len(a.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
+	req = withIdempotencyKey(req)
+
 	reqLog := &response.Logging{}
 	if requestLog == nil {
 		req.Tags["request_type"] = "streaming"
@@ -445,7 +1094,15 @@ func (a Anthropic) StreamResponse(
 		reqLog = requestLog
 	}
 
+	chunkHandler, delivered := trackDelivery(chunkHandler)
+
 	for i, key := range a.apiKeys {
+		select {
+		case <-ctx.Done():
+			return response.Completion{}, ctx.Err()
+		default:
+		}
+
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
 			Description: fmt.Sprintf(
@@ -457,6 +1114,15 @@ func (a Anthropic) StreamResponse(
 		if err == nil {
 			return res, nil
 		}
+		if exceedsSizeLimit(err) {
+			return res, err
+		}
+		if delivered() {
+			return response.Completion{}, err
+		}
+		if errors.Is(err, response.ErrContentFiltered) {
+			return response.Completion{}, err
+		}
 
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
@@ -478,6 +1144,10 @@ func (a Anthropic) tryWithBackup(
 	chunkHandler func(chunk string) error,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if len(a.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
 	key := a.apiKeys[0]
 
 	maxRetries := 5
@@ -485,6 +1155,9 @@ func (a Anthropic) tryWithBackup(
 	maxBackoff := 10 * time.Second
 
 	var lastErr error
+	var lastStatusCode int
+	start := a.opts.now()
+	backoffStep := 0
 	for attempt := range maxRetries {
 		requestLog.Events = append(requestLog.Events, response.Event{
 			Timestamp: time.Now(),
@@ -505,16 +1178,24 @@ func (a Anthropic) tryWithBackup(
 			})
 			return response.Completion{}, ctx.Err()
 		default:
+			var progress streamProgress
 			res, resCode, err := a.doRequest(
 				ctx,
 				req,
-				client,
-				chunkHandler,
+				progress.client(client),
+				progress.wrap(chunkHandler),
 				key,
 			)
 			if err == nil {
 				return res, nil
 			}
+			dropped, delivered := progress.failed(ctx, err, requestLog)
+			if delivered {
+				return response.Completion{}, err
+			}
+			if dropped {
+				backoffStep = 0
+			}
 			requestLog.Events = append(requestLog.Events, response.Event{
 				Timestamp: time.Now(),
 				Description: fmt.Sprintf(
@@ -523,7 +1204,7 @@ func (a Anthropic) tryWithBackup(
 				),
 			})
 
-			if !isRetryableError(resCode) {
+			if !dropped && !a.opts.retryable(resCode, err) {
 				requestLog.Events = append(requestLog.Events, response.Event{
 					Timestamp: time.Now(),
 					Description: fmt.Sprintf(
@@ -531,39 +1212,34 @@ func (a Anthropic) tryWithBackup(
 						err,
 					),
 				})
-				return response.Completion{}, err
+				return res, err
 			}
 
 			lastErr = err
+			lastStatusCode = resCode
 
 			backoff := min(initialBackoff*time.Duration(
-				1<<attempt,
+				1<<backoffStep,
 			), maxBackoff)
+			backoffStep++
 
-			var randomBytes [8]byte
-			var jitter time.Duration
-			if _, err := rand.Read(randomBytes[:]); err != nil {
-				jitter = backoff
-			} else {
-				randFloat := float64(binary.LittleEndian.Uint64(randomBytes[:])) / (1 << 64)
-				jitter = time.Duration(float64(backoff) * (0.8 + 0.4*randFloat))
-			}
-
-			timer := time.NewTimer(jitter)
+			timer := a.opts.newTimer(a.opts.jitter(backoff))
 			select {
 			case <-ctx.Done():
 				timer.Stop()
 				return response.Completion{}, ctx.Err()
-			case <-timer.C:
+			case <-timer.C():
 				continue
 			}
 		}
 	}
 
-	return response.Completion{}, fmt.Errorf(
-		"max retries exceeded: %w",
-		lastErr,
-	)
+	return response.Completion{}, &RetryExhaustedError{
+		Attempts:       maxRetries,
+		Elapsed:        a.opts.now().Sub(start),
+		LastStatusCode: lastStatusCode,
+		Err:            lastErr,
+	}
 }
 
 var _ LLMProvider = new(Anthropic)
@@ -898,6 +1574,61 @@ func prepareClaude46Opus(
 	}, nil
 }
 
+// historyMessage renders a history turn, as content blocks in the turn's
+// order when it carries images.
+func historyMessage(his request.Message) anthropicMsg {
+	if len(his.Images) == 0 && len(his.Parts) == 0 {
+		return anthropicMsg{
+			Role:    his.Role,
+			Content: his.Content,
+		}
+	}
+
+	var content []any
+	for _, p := range his.ContentParts() {
+		if p.Image == nil {
+			content = append(content, anthropicTextPayload{
+				Type: "text",
+				Text: p.Text,
+			})
+			continue
+		}
+
+		content = append(content, anthropicMediaPayload{
+			Type:   "image",
+			Source: anthropicSource(string(p.Image.MimeType), cmp.Or(p.Image.URL, p.Image.Data)),
+		})
+	}
+
+	return anthropicMsg{
+		Role:    his.Role,
+		Content: content,
+	}
+}
+
+// anthropicSource returns the source of an image or PDF given either as an
+// http(s) URL, which Claude fetches itself, or as base64 data. A data URI
+// prefix such as "data:application/pdf;base64," is stripped, as the other
+// providers do, since Anthropic rejects it; its media type stands in for a
+// missing mimeType.
+func anthropicSource(mimeType, data string) mediaSource {
+	if strings.HasPrefix(data, "https://") || strings.HasPrefix(data, "http://") {
+		return mediaSource{Type: "url", URL: data}
+	}
+	if uri, ok := strings.CutPrefix(data, "data:"); ok {
+		if prefixed, encoded, ok := strings.Cut(uri, ";base64,"); ok {
+			mimeType = cmp.Or(mimeType, prefixed)
+			data = encoded
+		}
+	}
+
+	return mediaSource{
+		Type:      "base64",
+		MediaType: mimeType,
+		Data:      data,
+	}
+}
+
 func handleMedia(
 	userMsg string,
 	imageFile map[models.AnthropicImageType]string,
@@ -913,12 +1644,8 @@ func handleMedia(
 			}
 
 			content = append(content, anthropicMediaPayload{
-				Type: "image",
-				Source: mediaSource{
-					Type:      "base64",
-					MediaType: mimeType,
-					Data:      val,
-				},
+				Type:   "image",
+				Source: anthropicSource(mimeType, val),
 			})
 		}
 	}
@@ -926,20 +1653,18 @@ func handleMedia(
 	if len(pdfFiles) > 0 {
 		for _, pdfFile := range pdfFiles {
 			content = append(content, anthropicMediaPayload{
-				Type: "document",
-				Source: mediaSource{
-					Type:      "base64",
-					MediaType: "application/pdf",
-					Data:      string(pdfFile),
-				},
+				Type:   "document",
+				Source: anthropicSource("application/pdf", string(pdfFile)),
 			})
 		}
 	}
 
-	content = append(content, anthropicTextPayload{
-		Type: "text",
-		Text: userMsg,
-	})
+	if userMsg != "" {
+		content = append(content, anthropicTextPayload{
+			Type: "text",
+			Text: userMsg,
+		})
+	}
 
 	return []anthropicMsg{
 		{
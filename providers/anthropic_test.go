@@ -3,14 +3,17 @@ package providers_test
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/flyx-ai/heimdall/models"
 	"github.com/flyx-ai/heimdall/providers"
 	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -148,19 +151,16 @@ func TestAnthropicModelsWithStreaming(t *testing.T) {
 		},
 	}
 
-	var chunkHandlerCollection string
+	var collected providers.Collector
 	res, err := anthropicProvider.StreamResponse(
 		context.Background(),
 		client,
 		req,
-		func(chunk string) error {
-			chunkHandlerCollection = chunkHandlerCollection + chunk
-			return nil
-		},
+		collected.Handle,
 		nil,
 	)
 	require.NoError(t, err, "StreamResponse returned an unexpected error")
-	assert.NotEmpty(t, chunkHandlerCollection, "chunkHandlerCollection should not be empty")
+	assert.NotEmpty(t, collected.String(), "collected chunks should not be empty")
 	assert.NotEmpty(t, res.Content, "content should not be empty")
 	assert.NotEmpty(t, res.Model, "model should not be empty")
 	assert.NotEmpty(t, res.RawRequest, "RawRequest should not be empty")
@@ -168,3 +168,777 @@ func TestAnthropicModelsWithStreaming(t *testing.T) {
 	assert.True(t, json.Valid(res.RawRequest), "RawRequest should be valid JSON")
 	assert.True(t, json.Valid(res.RawResponse), "RawResponse should be valid JSON")
 }
+
+func TestAnthropicWithoutAPIKeys(t *testing.T) {
+	t.Parallel()
+
+	provider := providers.NewAnthropic(nil)
+	req := request.Completion{
+		Model:       models.Claude45Haiku{},
+		UserMessage: "Say hello in one sentence.",
+	}
+
+	_, err := provider.CompleteResponse(context.Background(), req, http.Client{}, nil)
+	require.ErrorIs(t, err, providers.ErrNoAPIKeys)
+
+	_, err = provider.CountTokens(context.Background(), req)
+	require.ErrorIs(t, err, providers.ErrNoAPIKeys)
+
+	_, err = provider.StreamResponse(
+		context.Background(),
+		http.Client{},
+		req,
+		func(chunk string) error { return nil },
+		nil,
+	)
+	require.ErrorIs(t, err, providers.ErrNoAPIKeys)
+}
+
+func TestAnthropicContentFiltered(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			calls.Add(1)
+			return sseResponse(
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"I can't"}}`,
+				`{"type":"message_delta","delta":{"stop_reason":"refusal"}}`,
+			), nil
+		}),
+	}
+	anthropic := providers.NewAnthropic([]string{"key-a", "key-b"})
+
+	_, err := anthropic.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.Claude45Haiku{},
+			UserMessage: "Say hello in one sentence.",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.ErrorIs(t, err, response.ErrContentFiltered)
+
+	var filtered *response.ContentFilteredError
+	require.ErrorAs(t, err, &filtered)
+	assert.Equal(t, "refusal", filtered.Reason)
+	assert.Equal(t, "I can't", filtered.Content)
+	assert.Equal(t, int32(1), calls.Load(), "filtered responses should not be retried")
+}
+
+func TestAnthropicResponseTooLarge(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			calls.Add(1)
+			return sseResponse(
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello there, this is a long answer."}}`,
+				`{"type":"message_delta","delta":{"stop_reason":"end_turn"}}`,
+			), nil
+		}),
+	}
+	anthropic := providers.NewAnthropic(
+		[]string{"first-key", "second-key"},
+		providers.WithMaxResponseBytes(64),
+	)
+
+	_, err := anthropic.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.Claude45Haiku{},
+			UserMessage: "Say hello in one sentence.",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.ErrorIs(t, err, providers.ErrResponseTooLarge)
+
+	assert.EqualValues(t, 1, calls.Load())
+}
+
+func TestAnthropicSamplingParameters(t *testing.T) {
+	t.Parallel()
+
+	var body map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}`,
+			), nil
+		}),
+	}
+	anthropic := providers.NewAnthropic([]string{"test-key"})
+
+	_, err := anthropic.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.Claude45Haiku{},
+			UserMessage: "Say hello in one sentence.",
+			Temperature: 0.25,
+			TopP:        0.5,
+			TopK:        40,
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.InDelta(t, 0.25, body["temperature"], 1e-6)
+	assert.InDelta(t, 0.5, body["top_p"], 1e-6)
+	assert.Equal(t, float64(40), body["top_k"])
+}
+
+func TestAnthropicMaxTokens(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		model     models.Model
+		maxTokens int
+		want      float64
+	}{
+		"should default to 4096": {
+			model: models.Claude45Haiku{},
+			want:  4096,
+		},
+		"should send the request's max tokens": {
+			model:     models.Claude45Haiku{},
+			maxTokens: 8000,
+			want:      8000,
+		},
+		"should send the model's max output tokens": {
+			model: models.Claude46Opus{MaxOutputTokens: 8192},
+			want:  8192,
+		},
+		"should send a lower request max over the model's": {
+			model:     models.Claude46Opus{MaxOutputTokens: 8192},
+			maxTokens: 1000,
+			want:      1000,
+		},
+		"should keep the model's max under a higher request max": {
+			model:     models.Claude46Opus{MaxOutputTokens: 2000},
+			maxTokens: 8000,
+			want:      2000,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var body map[string]any
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+						return nil, err
+					}
+					return sseResponse(
+						`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ok"}}`,
+					), nil
+				}),
+			}
+
+			_, err := providers.NewAnthropic([]string{"test-key"}).CompleteResponse(
+				context.Background(),
+				request.Completion{
+					Model:       tt.model,
+					UserMessage: "Say hello.",
+					MaxTokens:   tt.maxTokens,
+				},
+				client,
+				&response.Logging{},
+			)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, body["max_tokens"])
+		})
+	}
+}
+
+func TestAnthropicHistoryImages(t *testing.T) {
+	t.Parallel()
+
+	var body struct {
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"a cat"}}`,
+			), nil
+		}),
+	}
+	anthropic := providers.NewAnthropic([]string{"test-key"})
+
+	_, err := anthropic.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model: models.Claude45Haiku{},
+			History: []request.Message{
+				{
+					Role:    "user",
+					Content: "What is in these pictures?",
+					Images: []request.Image{
+						{MimeType: request.MimeTypePNG, Data: "aGVsbG8="},
+						{URL: "https://example.com/cat.jpg"},
+						{URL: "data:image/jpeg;base64,Y2F0"},
+						{MimeType: request.MimeTypePNG, Data: "data:image/png;base64,ZG9n"},
+					},
+				},
+				{Role: "assistant", Content: "A dog and a cat."},
+			},
+			UserMessage: "Which one is bigger?",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, body.Messages, 3)
+	assert.JSONEq(t, `[
+		{"type":"image","source":{"type":"base64","media_type":"image/png","data":"aGVsbG8="}},
+		{"type":"image","source":{"type":"url","url":"https://example.com/cat.jpg"}},
+		{"type":"image","source":{"type":"base64","media_type":"image/jpeg","data":"Y2F0"}},
+		{"type":"image","source":{"type":"base64","media_type":"image/png","data":"ZG9n"}},
+		{"type":"text","text":"What is in these pictures?"}
+	]`, string(body.Messages[0].Content))
+	assert.JSONEq(t, `"A dog and a cat."`, string(body.Messages[1].Content))
+}
+
+func TestAnthropicMediaSources(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		model models.Model
+		want  string
+	}{
+		"should strip a data URI prefix from a PDF": {
+			model: models.Claude45Haiku{PdfFiles: []models.AnthropicPdf{"data:application/pdf;base64,JVBERi0="}},
+			want:  `{"type":"document","source":{"type":"base64","media_type":"application/pdf","data":"JVBERi0="}}`,
+		},
+		"should send a PDF URL as a url source": {
+			model: models.Claude45Haiku{PdfFiles: []models.AnthropicPdf{"https://example.com/report.pdf"}},
+			want:  `{"type":"document","source":{"type":"url","url":"https://example.com/report.pdf"}}`,
+		},
+		"should strip a data URI prefix from an image": {
+			model: models.Claude45Haiku{ImageFile: map[models.AnthropicImageType]string{
+				models.AnthropicImagePng: "data:image/png;base64,aGVsbG8=",
+			}},
+			want: `{"type":"image","source":{"type":"base64","media_type":"image/png","data":"aGVsbG8="}}`,
+		},
+		"should send an image URL as a url source": {
+			model: models.Claude45Haiku{ImageFile: map[models.AnthropicImageType]string{
+				models.AnthropicImageJpeg: "https://example.com/cat.jpg",
+			}},
+			want: `{"type":"image","source":{"type":"url","url":"https://example.com/cat.jpg"}}`,
+		},
+		"should keep plain base64 as is": {
+			model: models.Claude45Haiku{PdfFiles: []models.AnthropicPdf{"JVBERi0="}},
+			want:  `{"type":"document","source":{"type":"base64","media_type":"application/pdf","data":"JVBERi0="}}`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var body struct {
+				Messages []struct {
+					Content []json.RawMessage `json:"content"`
+				} `json:"messages"`
+			}
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+						return nil, err
+					}
+					return sseResponse(
+						`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ok"}}`,
+					), nil
+				}),
+			}
+
+			_, err := providers.NewAnthropic([]string{"test-key"}).CompleteResponse(
+				context.Background(),
+				request.Completion{Model: tt.model, UserMessage: "Summarize it."},
+				client,
+				&response.Logging{},
+			)
+			require.NoError(t, err)
+
+			require.Len(t, body.Messages, 1)
+			require.Len(t, body.Messages[0].Content, 2)
+			assert.JSONEq(t, tt.want, string(body.Messages[0].Content[0]))
+		})
+	}
+}
+
+func TestAnthropicContinuesAssistantTurn(t *testing.T) {
+	t.Parallel()
+
+	var body struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content any    `json:"content"`
+		} `json:"messages"`
+	}
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" a time"}}`,
+				`{"type":"message_delta","delta":{"stop_reason":"max_tokens"}}`,
+			), nil
+		}),
+	}
+	anthropic := providers.NewAnthropic([]string{"test-key"})
+
+	res, err := anthropic.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model: models.Claude45Haiku{},
+			History: []request.Message{
+				{Role: "user", Content: "Tell me a story."},
+				{Role: "assistant", Content: "Once upon "},
+			},
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, body.Messages, 2)
+	assert.Equal(t, "assistant", body.Messages[1].Role)
+	assert.Equal(t, "Once upon", body.Messages[1].Content, "the prefill should not end in whitespace")
+	assert.Equal(t, response.FinishLength, res.FinishReason)
+	assert.True(t, res.Truncated())
+}
+
+func TestAnthropicFinishReason(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		stopReason string
+		want       response.FinishReason
+	}{
+		"should report end_turn as a stop": {
+			stopReason: "end_turn",
+			want:       response.FinishStop,
+		},
+		"should report stop_sequence as a stop": {
+			stopReason: "stop_sequence",
+			want:       response.FinishStop,
+		},
+		"should report max_tokens as length": {
+			stopReason: "max_tokens",
+			want:       response.FinishLength,
+		},
+		"should report tool_use as tool calls": {
+			stopReason: "tool_use",
+			want:       response.FinishToolCalls,
+		},
+		"should report an unknown reason as an error": {
+			stopReason: "something_new",
+			want:       response.FinishError,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					return sseResponse(
+						`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`,
+						fmt.Sprintf(`{"type":"message_delta","delta":{"stop_reason":%q}}`, tt.stopReason),
+					), nil
+				}),
+			}
+			anthropic := providers.NewAnthropic([]string{"test-key"})
+
+			res, err := anthropic.CompleteResponse(
+				context.Background(),
+				request.Completion{Model: models.Claude45Haiku{}, UserMessage: "Hello"},
+				client,
+				&response.Logging{},
+			)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, res.FinishReason)
+		})
+	}
+}
+
+func TestAnthropicStreamUsage(t *testing.T) {
+	t.Parallel()
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return sseResponse(
+				`{"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[],"stop_reason":null,"usage":{"input_tokens":25,"cache_creation_input_tokens":0,"cache_read_input_tokens":100,"output_tokens":1}}}`,
+				`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+				`{"type":"ping"}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" there"}}`,
+				`{"type":"content_block_stop","index":0}`,
+				`{"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":15}}`,
+				`{"type":"message_stop"}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" ignored"}}`,
+			), nil
+		}),
+	}
+	anthropic := providers.NewAnthropic([]string{"test-key"})
+
+	res, err := anthropic.CompleteResponse(
+		context.Background(),
+		request.Completion{Model: models.Claude45Haiku{}, UserMessage: "Hello"},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Hello there", res.Content, "events after message_stop should be ignored")
+	assert.Equal(t, response.FinishStop, res.FinishReason)
+	assert.Equal(t, response.Usage{
+		PromptTokens:     125,
+		CompletionTokens: 15,
+		TotalTokens:      140,
+		CachedTokens:     100,
+	}, res.Usage)
+}
+
+func TestAnthropicRawMessages(t *testing.T) {
+	t.Parallel()
+
+	raw := `[{"role":"user","content":[` +
+		`{"type":"text","text":"A long shared document.","cache_control":{"type":"ephemeral"}},` +
+		`{"type":"text","text":"Summarise it."}]}]`
+
+	var body struct {
+		System   string          `json:"system"`
+		Messages json.RawMessage `json:"messages"`
+	}
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"A summary."}}`,
+				`{"type":"message_delta","delta":{"stop_reason":"end_turn"}}`,
+			), nil
+		}),
+	}
+	anthropic := providers.NewAnthropic([]string{"test-key"})
+
+	res, err := anthropic.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:         models.Claude45Haiku{},
+			SystemMessage: "you are a helpful assistant.",
+			UserMessage:   "ignored in favour of the raw messages",
+			RawMessages:   json.RawMessage(raw),
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.JSONEq(t, raw, string(body.Messages))
+	assert.Equal(t, "you are a helpful assistant.", body.System)
+	assert.Equal(t, "A summary.", res.Content)
+}
+
+func TestAnthropicStructuredOutput(t *testing.T) {
+	t.Parallel()
+
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"greeting": map[string]any{"type": "string"},
+		},
+		"required":             []any{"greeting"},
+		"additionalProperties": false,
+	}
+
+	var (
+		body  map[string]any
+		betas string
+	)
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			betas = req.Header.Get("anthropic-beta")
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"{\"greeting\":\"hi\"}"}}`,
+			), nil
+		}),
+	}
+	anthropic := providers.NewAnthropic([]string{"test-key"})
+
+	res, err := anthropic.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.Claude45Sonnet{StructuredOutput: schema},
+			UserMessage: "Say hello in one sentence.",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Contains(t, betas, "structured-outputs-2025-11-13")
+	assert.Equal(t, map[string]any{
+		"format": map[string]any{
+			"type":   "json_schema",
+			"schema": schema,
+		},
+	}, body["output_config"])
+	assert.JSONEq(t, `{"greeting":"hi"}`, res.Content)
+}
+
+func TestAnthropicServerTools(t *testing.T) {
+	t.Parallel()
+
+	result := `{"type":"web_search_tool_result","tool_use_id":"srvtoolu_1","content":[` +
+		`{"type":"web_search_result","url":"https://example.com/heimdall","title":"Heimdall","page_age":"2025-05-01","encrypted_content":"abc"}]}`
+
+	var (
+		body  map[string]any
+		betas string
+	)
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			betas = req.Header.Get("anthropic-beta")
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"type":"content_block_start","index":0,"content_block":{"type":"server_tool_use","id":"srvtoolu_1","name":"web_search","input":{}}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"query\":"}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"\"heimdall router\"}"}}`,
+				`{"type":"content_block_stop","index":0}`,
+				`{"type":"content_block_start","index":1,"content_block":`+result+`}`,
+				`{"type":"content_block_stop","index":1}`,
+				`{"type":"content_block_start","index":2,"content_block":{"type":"text","text":""}}`,
+				`{"type":"content_block_delta","index":2,"delta":{"type":"citations_delta","citation":{"type":"web_search_result_location","url":"https://example.com/heimdall","title":"Heimdall","cited_text":"A router."}}}`,
+				`{"type":"content_block_delta","index":2,"delta":{"type":"text_delta","text":"Heimdall is an LLM router."}}`,
+				`{"type":"content_block_stop","index":2}`,
+				`{"type":"message_delta","delta":{"stop_reason":"end_turn"}}`,
+			), nil
+		}),
+	}
+	anthropic := providers.NewAnthropic([]string{"test-key"})
+
+	res, err := anthropic.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model: models.Claude45Sonnet{
+				ServerTools: models.AnthropicServerTools{
+					WebSearch:     &models.AnthropicWebSearch{MaxUses: 3, AllowedDomains: []string{"example.com"}},
+					CodeExecution: true,
+				},
+			},
+			UserMessage: "What is heimdall?",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, []any{
+		map[string]any{
+			"type":            "web_search_20250305",
+			"name":            "web_search",
+			"max_uses":        float64(3),
+			"allowed_domains": []any{"example.com"},
+		},
+		map[string]any{
+			"type": "code_execution_20250825",
+			"name": "code_execution",
+		},
+	}, body["tools"])
+	assert.Contains(t, betas, "code-execution-2025-08-25")
+
+	assert.Equal(t, "Heimdall is an LLM router.", res.Content)
+	require.Len(t, res.ServerToolCalls, 1)
+	call := res.ServerToolCalls[0]
+	assert.Equal(t, "srvtoolu_1", call.ID)
+	assert.Equal(t, "web_search", call.Name)
+	assert.JSONEq(t, `{"query":"heimdall router"}`, string(call.Input))
+	assert.JSONEq(t, result, string(call.Result))
+	assert.Equal(t, []response.SearchResult{
+		{Title: "Heimdall", URL: "https://example.com/heimdall", Date: "2025-05-01"},
+	}, res.SearchResults)
+	assert.Equal(t, []string{"https://example.com/heimdall"}, res.Citations)
+}
+
+func TestAnthropicVersionAndBetas(t *testing.T) {
+	t.Parallel()
+
+	var header http.Header
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			header = req.Header.Clone()
+			return sseResponse(
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}`,
+			), nil
+		}),
+	}
+	req := request.Completion{
+		Model:       models.Claude46Opus{ExtendedContext: true},
+		UserMessage: "Say hello in one sentence.",
+	}
+
+	t.Run("should default to the stable version", func(t *testing.T) {
+		anthropic := providers.NewAnthropic([]string{"test-key"})
+
+		_, err := anthropic.CompleteResponse(context.Background(), req, client, &response.Logging{})
+		require.NoError(t, err)
+
+		assert.Equal(t, "2023-06-01", header.Get("Anthropic-Version"))
+		assert.Equal(t, "context-1m-2025-08-07", header.Get("anthropic-beta"))
+	})
+
+	t.Run("should send the configured version and betas", func(t *testing.T) {
+		anthropic := providers.NewAnthropic(
+			[]string{"test-key"},
+			providers.WithAnthropicVersion("2025-01-01"),
+			providers.WithAnthropicBetas("token-counting-2024-11-01", "context-1m-2025-08-07"),
+		)
+
+		_, err := anthropic.CompleteResponse(context.Background(), req, client, &response.Logging{})
+		require.NoError(t, err)
+
+		assert.Equal(t, "2025-01-01", header.Get("Anthropic-Version"))
+		assert.Equal(t,
+			"context-1m-2025-08-07,token-counting-2024-11-01",
+			header.Get("anthropic-beta"),
+		)
+	})
+}
+
+func TestAnthropicCountTokens(t *testing.T) {
+	t.Parallel()
+
+	apiKey := os.Getenv("ANTHROPIC_API_KEY")
+	if apiKey == "" {
+		t.Skip("ANTHROPIC_API_KEY not set")
+	}
+
+	anthropicProvider := providers.NewAnthropic([]string{apiKey})
+
+	count, err := anthropicProvider.CountTokens(
+		context.Background(),
+		request.Completion{
+			Model:         models.Claude45Haiku{},
+			SystemMessage: "you are a helpful assistant.",
+			UserMessage:   "Say hello in one sentence.",
+		},
+	)
+	require.NoError(t, err)
+	assert.Positive(t, count)
+}
+
+func TestAnthropicFitContext(t *testing.T) {
+	t.Parallel()
+
+	// Each message costs 10 tokens and a system message 5 more.
+	countingClient := func(calls *atomic.Int32) http.Client {
+		return http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				calls.Add(1)
+				var body struct {
+					System   string            `json:"system"`
+					Messages []json.RawMessage `json:"messages"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					return nil, err
+				}
+				tokens := 10 * len(body.Messages)
+				if body.System != "" {
+					tokens += 5
+				}
+				return jsonResponse(fmt.Sprintf(`{"input_tokens":%d}`, tokens)), nil
+			}),
+		}
+	}
+
+	history := []request.Message{
+		{Role: "user", Content: "one"},
+		{Role: "assistant", Content: "two"},
+		{Role: "user", Content: "three"},
+		{Role: "assistant", Content: "four"},
+		{Role: "user", Content: "five"},
+		{Role: "assistant", Content: "six"},
+	}
+	req := request.Completion{
+		Model:         models.Claude45Haiku{},
+		SystemMessage: "you are a helpful assistant.",
+		UserMessage:   "Say hello in one sentence.",
+		History:       history,
+	}
+	anthropic := providers.NewAnthropic([]string{"test-key"})
+
+	t.Run("should leave a request that fits untouched", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int32
+		got, err := anthropic.FitContext(context.Background(), countingClient(&calls), req,
+			providers.ContextFit{ContextWindow: 1000, Reserve: 100})
+		require.NoError(t, err)
+
+		assert.Equal(t, req, got)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("should drop the oldest turns until it fits", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int32
+		got, err := anthropic.FitContext(context.Background(), countingClient(&calls), req,
+			providers.ContextFit{ContextWindow: 145, Reserve: 100})
+		require.NoError(t, err)
+
+		assert.Equal(t, history[4:], got.History)
+		assert.Equal(t, req.SystemMessage, got.SystemMessage)
+		assert.Equal(t, req.UserMessage, got.UserMessage)
+	})
+
+	t.Run("should keep the system message by default", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int32
+		_, err := anthropic.FitContext(context.Background(), countingClient(&calls), req,
+			providers.ContextFit{ContextWindow: 112, Reserve: 100})
+		require.ErrorIs(t, err, providers.ErrContextTooLong)
+	})
+
+	t.Run("should drop the system message when allowed", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int32
+		got, err := anthropic.FitContext(context.Background(), countingClient(&calls), req,
+			providers.ContextFit{ContextWindow: 112, Reserve: 100, DropSystem: true})
+		require.NoError(t, err)
+
+		assert.Empty(t, got.History)
+		assert.Empty(t, got.SystemMessage)
+	})
+}
@@ -0,0 +1,37 @@
+package providers
+
+import "time"
+
+// Clock is the source of time for quota windows and retry backoffs. It lets
+// tests drive both deterministically instead of sleeping.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the part of *time.Timer a Clock hands out.
+type Timer interface {
+	// C returns the channel the current time is sent on when the timer
+	// fires.
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// systemClock is the real Clock, backed by the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
@@ -0,0 +1,312 @@
+package providers_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/providers"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a deterministic providers.Clock. Its timers fire straight
+// away and move the clock forward by their duration, so retry loops run
+// without sleeping while still accounting for the time they would wait.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) providers.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+
+	fired := make(chan time.Time, 1)
+	fired <- c.now
+
+	return fakeTimer{c: fired}
+}
+
+// Advance moves the clock forward by d.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// Waits returns the durations of every timer created so far, in order.
+func (c *fakeClock) Waits() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.waits)
+}
+
+type fakeTimer struct {
+	c chan time.Time
+}
+
+func (t fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t fakeTimer) Stop() bool { return false }
+
+func TestKeyDistributorQuotaReset(t *testing.T) {
+	t.Parallel()
+
+	clock := newFakeClock()
+	d := providers.NewKeyDistributor([]string{"a"}, 0)
+	d.SetClock(clock)
+
+	header := http.Header{}
+	header.Set("x-ratelimit-remaining-requests", "3")
+	header.Set("x-ratelimit-reset-requests", "1m0s")
+	d.Observe("a", header)
+
+	requests, resetAt := d.RemainingQuota()
+	assert.Equal(t, uint32(3), requests)
+	assert.Equal(t, clock.Now().Add(time.Minute), resetAt)
+
+	clock.Advance(59 * time.Second)
+	requests, _ = d.RemainingQuota()
+	assert.Equal(t, uint32(3), requests, "window has not reset yet")
+
+	clock.Advance(time.Second)
+	requests, _ = d.RemainingQuota()
+	assert.Equal(t, uint32(1<<32-1), requests, "window has reset")
+}
+
+func TestBackoffUsesClock(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+			if calls <= 3 {
+				return &http.Response{
+					StatusCode: http.StatusServiceUnavailable,
+					Body:       io.NopCloser(strings.NewReader("")),
+				}, nil
+			}
+			return sseResponse(`{"choices":[{"delta":{"content":"hi"}}]}`, "[DONE]"), nil
+		}),
+	}
+	clock := newFakeClock()
+	openRouter := providers.NewOpenRouter(
+		[]string{"test-key"},
+		providers.WithClock(clock),
+		providers.WithJitter(1, 1),
+	)
+
+	res, err := openRouter.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.OpenRouterModel{ModelName: "openai/gpt-4o-mini"},
+			UserMessage: "Say hello.",
+			Tags:        map[string]string{},
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	// The first failure is on the key loop; the next two are backed off.
+	assert.Equal(t, "hi", res.Content)
+	assert.Equal(t, 4, calls)
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, clock.Waits())
+}
+
+func TestBackoffResetsAfterProgress(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+			switch calls {
+			case 1, 2, 3:
+				return &http.Response{
+					StatusCode: http.StatusServiceUnavailable,
+					Body:       io.NopCloser(strings.NewReader("")),
+				}, nil
+			case 4:
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body: io.NopCloser(io.MultiReader(
+						strings.NewReader(": OPENROUTER PROCESSING\n\n"),
+						failingReader{err: io.ErrUnexpectedEOF},
+					)),
+				}, nil
+			}
+			return sseResponse(`{"choices":[{"delta":{"content":"hello"}}]}`, "[DONE]"), nil
+		}),
+	}
+	clock := newFakeClock()
+	openRouter := providers.NewOpenRouter(
+		[]string{"test-key"},
+		providers.WithClock(clock),
+		providers.WithJitter(1, 1),
+	)
+	requestLog := &response.Logging{}
+
+	res, err := openRouter.StreamResponse(
+		context.Background(),
+		client,
+		request.Completion{
+			Model:       models.OpenRouterModel{ModelName: "openai/gpt-4o-mini"},
+			UserMessage: "Say hello.",
+			Tags:        map[string]string{},
+		},
+		func(string) error { return nil },
+		requestLog,
+	)
+	require.NoError(t, err)
+
+	// The drop on the fourth call, before any chunk reached the handler,
+	// follows two backoffs but starts over at the shortest delay.
+	assert.Equal(t, "hello", res.Content)
+	assert.Equal(t, 5, calls)
+	assert.Equal(t, []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		100 * time.Millisecond,
+	}, clock.Waits())
+	assert.Equal(t, 1, requestLog.MidStreamFailures)
+}
+
+func TestStreamNotRetriedAfterDeliveringChunks(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+			if calls == 1 {
+				return &http.Response{
+					StatusCode: http.StatusServiceUnavailable,
+					Body:       io.NopCloser(strings.NewReader("")),
+				}, nil
+			}
+			if calls == 2 {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body: io.NopCloser(io.MultiReader(
+						strings.NewReader(`data: {"choices":[{"delta":{"content":"hel"}}]}`+"\n\n"),
+						failingReader{err: io.ErrUnexpectedEOF},
+					)),
+				}, nil
+			}
+			return sseResponse(`{"choices":[{"delta":{"content":"hello"}}]}`, "[DONE]"), nil
+		}),
+	}
+	openRouter := providers.NewOpenRouter(
+		[]string{"test-key"},
+		providers.WithClock(newFakeClock()),
+	)
+	requestLog := &response.Logging{}
+	var chunks []string
+
+	_, err := openRouter.StreamResponse(
+		context.Background(),
+		client,
+		request.Completion{
+			Model:       models.OpenRouterModel{ModelName: "openai/gpt-4o-mini"},
+			UserMessage: "Say hello.",
+			Tags:        map[string]string{},
+		},
+		func(chunk string) error {
+			chunks = append(chunks, chunk)
+			return nil
+		},
+		requestLog,
+	)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// The backoff loop retries the outage but not the drop, which would
+	// hand "hel" to the handler a second time.
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, []string{"hel"}, chunks)
+	assert.Equal(t, 1, requestLog.MidStreamFailures)
+}
+
+func TestBackoffResetsAfterProgressWithoutChunkHandler(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+			switch calls {
+			case 1, 2, 3:
+				return &http.Response{
+					StatusCode: http.StatusServiceUnavailable,
+					Body:       io.NopCloser(strings.NewReader("")),
+				}, nil
+			case 4:
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body: io.NopCloser(io.MultiReader(
+						strings.NewReader(`data: {"choices":[{"delta":{"content":"hel"}}]}`+"\n\n"),
+						failingReader{err: io.ErrUnexpectedEOF},
+					)),
+				}, nil
+			}
+			return sseResponse(`{"choices":[{"delta":{"content":"hello"}}]}`, "[DONE]"), nil
+		}),
+	}
+	clock := newFakeClock()
+	openRouter := providers.NewOpenRouter(
+		[]string{"test-key"},
+		providers.WithClock(clock),
+		providers.WithJitter(1, 1),
+	)
+	requestLog := &response.Logging{}
+
+	res, err := openRouter.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.OpenRouterModel{ModelName: "openai/gpt-4o-mini"},
+			UserMessage: "Say hello.",
+			Tags:        map[string]string{},
+		},
+		client,
+		requestLog,
+	)
+	require.NoError(t, err)
+
+	// A completion has no chunk handler, so the drop is told apart by the
+	// response body breaking off.
+	assert.Equal(t, "hello", res.Content)
+	assert.Equal(t, 5, calls)
+	assert.Equal(t, []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		100 * time.Millisecond,
+	}, clock.Waits())
+	assert.Equal(t, 1, requestLog.MidStreamFailures)
+}
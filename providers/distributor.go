@@ -0,0 +1,216 @@
+package providers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// KeyDistributor tracks a provider's API keys and caps how many requests may
+// be in flight on each key at once. A nil *KeyDistributor, or one built
+// without a limit, never blocks.
+type KeyDistributor struct {
+	keys []string
+
+	mu       sync.Mutex
+	slots    map[string]chan struct{}
+	inFlight map[string]int
+	quotas   map[string]keyQuota
+	invalid  map[string]bool
+	clock    Clock
+}
+
+// keyQuota is the request allowance last reported for a key, reduced by every
+// request dispatched since.
+type keyQuota struct {
+	remaining uint32
+	resetAt   time.Time
+}
+
+// NewKeyDistributor returns a distributor for keys that allows at most
+// maxConcurrencyPerKey simultaneous requests per key. A limit of zero or less
+// leaves concurrency unbounded.
+func NewKeyDistributor(keys []string, maxConcurrencyPerKey int) *KeyDistributor {
+	d := &KeyDistributor{
+		keys:     keys,
+		inFlight: make(map[string]int, len(keys)),
+		quotas:   make(map[string]keyQuota, len(keys)),
+		invalid:  make(map[string]bool),
+		clock:    systemClock{},
+	}
+
+	if maxConcurrencyPerKey > 0 {
+		d.slots = make(map[string]chan struct{}, len(keys))
+		for _, key := range keys {
+			d.slots[key] = make(chan struct{}, maxConcurrencyPerKey)
+		}
+	}
+
+	return d
+}
+
+// SetClock replaces the system clock used to tell whether a key's quota
+// window has reset. It must be called before the distributor is used.
+func (d *KeyDistributor) SetClock(clock Clock) {
+	d.clock = clock
+}
+
+// Acquire reserves a request slot on key and returns the key it was reserved
+// on. When key is saturated, a free slot on another valid key is taken
+// instead, and only when every key is saturated does Acquire queue for key
+// until a slot frees up or ctx is done. A key marked invalid is replaced by
+// another valid key, and ErrInvalidKey is returned only when none is left.
+// The returned release func must be called once the request has finished.
+func (d *KeyDistributor) Acquire(ctx context.Context, key string) (string, func(), error) {
+	if d == nil {
+		return key, func() {}, nil
+	}
+
+	d.mu.Lock()
+	if d.invalid[key] {
+		valid := slices.IndexFunc(d.keys, func(k string) bool { return !d.invalid[k] })
+		if valid < 0 {
+			d.mu.Unlock()
+			return "", nil, ErrInvalidKey
+		}
+		key = d.keys[valid]
+	}
+	d.mu.Unlock()
+
+	if d.slots[key] != nil {
+		acquired, ok := d.tryAcquire(key)
+		if !ok {
+			select {
+			case d.slots[key] <- struct{}{}:
+				acquired = key
+			case <-ctx.Done():
+				return "", nil, ctx.Err()
+			}
+		}
+		key = acquired
+	}
+	slot := d.slots[key]
+
+	d.mu.Lock()
+	d.inFlight[key]++
+	if q, ok := d.quotas[key]; ok && q.remaining > 0 {
+		q.remaining--
+		d.quotas[key] = q
+	}
+	d.mu.Unlock()
+
+	var once sync.Once
+	return key, func() {
+		once.Do(func() {
+			d.mu.Lock()
+			d.inFlight[key]--
+			d.mu.Unlock()
+
+			if slot != nil {
+				<-slot
+			}
+		})
+	}, nil
+}
+
+// tryAcquire takes a free slot without blocking, on key if it has one and
+// otherwise on the first other valid key that does.
+func (d *KeyDistributor) tryAcquire(key string) (string, bool) {
+	d.mu.Lock()
+	candidates := make([]string, 0, len(d.keys))
+	candidates = append(candidates, key)
+	for _, other := range d.keys {
+		if other != key && !d.invalid[other] {
+			candidates = append(candidates, other)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, candidate := range candidates {
+		select {
+		case d.slots[candidate] <- struct{}{}:
+			return candidate, true
+		default:
+		}
+	}
+
+	return "", false
+}
+
+// MarkInvalid stops key from being used for further requests.
+func (d *KeyDistributor) MarkInvalid(key string) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	d.invalid[key] = true
+	d.mu.Unlock()
+}
+
+// InFlight returns the number of requests currently holding a slot on key.
+func (d *KeyDistributor) InFlight(key string) int {
+	if d == nil {
+		return 0
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.inFlight[key]
+}
+
+// Observe records the rate-limit headers of a response received on key.
+// Responses without rate-limit headers are ignored.
+func (d *KeyDistributor) Observe(key string, header http.Header) {
+	if d == nil {
+		return
+	}
+
+	remaining, resetAt, ok := parseRateLimit(header, d.clock.Now())
+	if !ok {
+		return
+	}
+
+	d.mu.Lock()
+	d.quotas[key] = keyQuota{remaining: remaining, resetAt: resetAt}
+	d.mu.Unlock()
+}
+
+// RemainingQuota returns how many more requests the keys can make together
+// and the earliest time one of their windows resets. Keys that have not
+// reported a limit yet, or whose window has already reset, are treated as
+// unlimited, in which case requests is math.MaxUint32.
+func (d *KeyDistributor) RemainingQuota() (requests uint32, resetAt time.Time) {
+	if d == nil || len(d.keys) == 0 {
+		return 0, time.Time{}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.clock.Now()
+	var total uint64
+	unlimited := false
+	for _, key := range d.keys {
+		q, ok := d.quotas[key]
+		if !ok || !now.Before(q.resetAt) {
+			unlimited = true
+			continue
+		}
+
+		total += uint64(q.remaining)
+		if resetAt.IsZero() || q.resetAt.Before(resetAt) {
+			resetAt = q.resetAt
+		}
+	}
+
+	if unlimited || total > math.MaxUint32 {
+		return math.MaxUint32, resetAt
+	}
+
+	return uint32(total), resetAt
+}
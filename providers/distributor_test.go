@@ -0,0 +1,227 @@
+package providers_test
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/providers"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxConcurrencyPerKey(t *testing.T) {
+	t.Parallel()
+
+	const (
+		limit    = 2
+		requests = 10
+	)
+
+	var mu sync.Mutex
+	inFlight := map[string]int{}
+	peak := map[string]int{}
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			key := req.Header.Get("Authorization")
+
+			mu.Lock()
+			inFlight[key]++
+			peak[key] = max(peak[key], inFlight[key])
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			inFlight[key]--
+			mu.Unlock()
+
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"hi"}}]}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	openai := providers.NewOpenAI(
+		[]string{"key-a"},
+		providers.WithMaxConcurrencyPerKey(limit),
+	)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := openai.CompleteResponse(
+				context.Background(),
+				request.Completion{
+					Model:       models.GPT4OMini{},
+					UserMessage: "Say hello in one sentence.",
+				},
+				client,
+				&response.Logging{},
+			)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, limit, peak["Bearer key-a"], "in-flight requests should reach but never exceed the limit")
+}
+
+func TestKeyDistributorAcquireRespectsContext(t *testing.T) {
+	t.Parallel()
+
+	distributor := providers.NewKeyDistributor([]string{"key-a"}, 1)
+
+	_, release, err := distributor.Acquire(context.Background(), "key-a")
+	require.NoError(t, err)
+	assert.Equal(t, 1, distributor.InFlight("key-a"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = distributor.Acquire(ctx, "key-a")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release()
+	assert.Equal(t, 0, distributor.InFlight("key-a"))
+}
+
+func TestKeyDistributorAcquireMovesToFreeKey(t *testing.T) {
+	t.Parallel()
+
+	d := providers.NewKeyDistributor([]string{"key-a", "key-b", "key-c"}, 1)
+	d.MarkInvalid("key-b")
+
+	key, releaseA, err := d.Acquire(context.Background(), "key-a")
+	require.NoError(t, err)
+	assert.Equal(t, "key-a", key)
+
+	// key-a is saturated and key-b invalid, so key-c takes the request.
+	key, releaseC, err := d.Acquire(context.Background(), "key-a")
+	require.NoError(t, err)
+	assert.Equal(t, "key-c", key)
+	assert.Equal(t, 1, d.InFlight("key-a"))
+	assert.Equal(t, 1, d.InFlight("key-c"))
+
+	// With every valid key saturated, the request queues for its own key.
+	acquired := make(chan string, 1)
+	go func() {
+		key, release, err := d.Acquire(context.Background(), "key-a")
+		if err == nil {
+			release()
+		}
+		acquired <- key
+	}()
+
+	select {
+	case key := <-acquired:
+		t.Fatalf("acquired %q while every key was saturated", key)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	releaseC()
+	select {
+	case key := <-acquired:
+		t.Fatalf("acquired %q instead of waiting for key-a", key)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	releaseA()
+	select {
+	case key := <-acquired:
+		assert.Equal(t, "key-a", key)
+	case <-time.After(time.Second):
+		t.Fatal("queued request did not get key-a once it was released")
+	}
+}
+
+func TestRemainingQuota(t *testing.T) {
+	t.Parallel()
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			res := sseResponse(
+				`{"choices":[{"delta":{"content":"hi"}}]}`,
+				"[DONE]",
+			)
+			res.Header.Set("x-ratelimit-remaining-requests", "42")
+			res.Header.Set("x-ratelimit-reset-requests", "1m0s")
+			return res, nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	requests, _ := openai.RemainingQuota()
+	assert.Equal(t, uint32(math.MaxUint32), requests, "no limit observed yet")
+
+	_, err := openai.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.GPT4OMini{},
+			UserMessage: "Say hello in one sentence.",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	requests, resetAt := openai.RemainingQuota()
+	assert.Equal(t, uint32(42), requests)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), resetAt, 5*time.Second)
+
+}
+
+func TestKeyDistributorRemainingQuotaCountsDispatchedRequests(t *testing.T) {
+	t.Parallel()
+
+	d := providers.NewKeyDistributor([]string{"a", "b"}, 0)
+	reset := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	header := http.Header{}
+	header.Set("anthropic-ratelimit-requests-remaining", "10")
+	header.Set("anthropic-ratelimit-requests-reset", reset.Format(time.RFC3339))
+	d.Observe("a", header)
+	d.Observe("b", header)
+
+	_, release, err := d.Acquire(context.Background(), "a")
+	require.NoError(t, err)
+	release()
+
+	requests, resetAt := d.RemainingQuota()
+	assert.Equal(t, uint32(19), requests)
+	assert.True(t, reset.Equal(resetAt))
+}
+
+func TestKeyDistributorMarkInvalid(t *testing.T) {
+	t.Parallel()
+
+	d := providers.NewKeyDistributor([]string{"bad", "good"}, 1)
+	d.MarkInvalid("bad")
+
+	// Providers back off on their first key, so a retired first key must
+	// hand over to the next valid one.
+	key, release, err := d.Acquire(context.Background(), "bad")
+	require.NoError(t, err)
+	assert.Equal(t, "good", key)
+	assert.Equal(t, 1, d.InFlight("good"))
+	assert.Zero(t, d.InFlight("bad"))
+	release()
+
+	d.MarkInvalid("good")
+	_, _, err = d.Acquire(context.Background(), "bad")
+	require.ErrorIs(t, err, providers.ErrInvalidKey)
+}
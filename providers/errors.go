@@ -0,0 +1,116 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrNoAPIKeys is returned when a provider was constructed without any
+	// API keys to send requests with.
+	ErrNoAPIKeys = errors.New("no API keys available")
+	// ErrUnsupportedModel is returned when a provider is asked to serve a
+	// model it does not support.
+	ErrUnsupportedModel = errors.New("unsupported model")
+	// ErrInvalidKey is returned for requests when every key of the provider
+	// was found by Validate to be rejected by the API.
+	ErrInvalidKey = errors.New("API key is invalid")
+	// ErrContextTooLong is returned by Anthropic.FitContext when a request
+	// exceeds the context window even with its whole history dropped.
+	ErrContextTooLong = errors.New("request does not fit the context window")
+	// ErrRequestTooLarge is returned before sending a request whose body
+	// exceeds the provider's limit, see WithMaxRequestBytes.
+	ErrRequestTooLarge = errors.New("request body too large")
+	// ErrResponseTooLarge is returned once a response body grows past the
+	// provider's limit, see WithMaxResponseBytes.
+	ErrResponseTooLarge = errors.New("response body too large")
+	// ErrModelDeprecated is returned, wrapped in a *ModelDeprecatedError,
+	// when the provider no longer serves the requested model, typically a
+	// dated snapshot it has retired.
+	ErrModelDeprecated = errors.New("model deprecated or not found")
+)
+
+// RetryExhaustedError is returned when a provider's backoff loop gives up
+// after every attempt failed with a retryable error. It unwraps to the last
+// attempt's error.
+type RetryExhaustedError struct {
+	Attempts       int
+	Elapsed        time.Duration
+	LastStatusCode int
+	Err            error
+}
+
+func (e *RetryExhaustedError) Error() string {
+	return fmt.Sprintf(
+		"max retries exceeded after %d attempts in %s (last status %d): %v",
+		e.Attempts, e.Elapsed.Round(time.Millisecond), e.LastStatusCode, e.Err,
+	)
+}
+
+func (e *RetryExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode returns the status of the last failed attempt, letting callers
+// that do not import this package classify the failure.
+func (e *RetryExhaustedError) StatusCode() int {
+	return e.LastStatusCode
+}
+
+// ModelDeprecatedError names the model the provider rejected as retired or
+// unknown. It matches ErrModelDeprecated with errors.Is and unwraps to the
+// provider's error.
+type ModelDeprecatedError struct {
+	Model string
+	Err   error
+}
+
+func (e *ModelDeprecatedError) Error() string {
+	return fmt.Sprintf("%v: %s: %v", ErrModelDeprecated, e.Model, e.Err)
+}
+
+func (e *ModelDeprecatedError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ModelDeprecatedError) Is(target error) bool {
+	return target == ErrModelDeprecated
+}
+
+// statusError is a non-200 response from the API. It keeps the message the
+// provider reported and carries the response body for retry classifiers.
+type statusError struct {
+	err  error
+	body string
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// withBody attaches the body of a failed response to err.
+func withBody(err error, body []byte) error {
+	return &statusError{err: err, body: string(body)}
+}
+
+// responseBody returns the response body attached to err, or "" when the
+// failure carried none.
+func responseBody(err error) string {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.body
+	}
+
+	return ""
+}
+
+// exceedsSizeLimit reports whether err is a request or response size limit
+// being hit, which retrying on another key cannot fix.
+func exceedsSizeLimit(err error) bool {
+	return errors.Is(err, ErrRequestTooLarge) || errors.Is(err, ErrResponseTooLarge)
+}
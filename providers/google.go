@@ -4,14 +4,14 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/rand"
-	"encoding/binary"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"maps"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -20,10 +20,15 @@ import (
 	"github.com/flyx-ai/heimdall/response"
 )
 
-const googleBaseURL = "https://generativelanguage.googleapis.com/v1beta/models/%s:streamGenerateContent?alt=sse&key=%s"
+const (
+	googleBaseURL   = "https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent?key=%s"
+	googleStreamURL = "https://generativelanguage.googleapis.com/v1beta/models/%s:streamGenerateContent?alt=sse&key=%s"
+)
 
 type Google struct {
 	apiKeys []string
+	keys    *KeyDistributor
+	opts    options
 }
 
 type cacheContentRequest struct {
@@ -38,26 +43,22 @@ type systemContent struct {
 	Role  string `json:"role"`
 }
 
-type cacheContentResponse struct {
-	Name       string    `json:"name"`
-	Model      string    `json:"model"`
-	CreateTime time.Time `json:"createTime"`
-	UpdateTime time.Time `json:"updateTime"`
-	ExpireTime time.Time `json:"expireTime"`
-}
-
 // NewGoogle register google as a provider on the router.
-func NewGoogle(apiKeys []string) Google {
+func NewGoogle(apiKeys []string, opts ...Option) Google {
+	o := newOptions(opts)
+
 	return Google{
 		apiKeys: apiKeys,
+		keys:    o.keyDistributor(apiKeys),
+		opts:    o,
 	}
 }
 
 type geminiRequest struct {
-	SystemInstruction systemInstruction `json:"system_instruction"`
-	Contents          []content         `json:"contents"`
-	Tools             models.GoogleTool `json:"tools"`
-	Config            map[string]any    `json:"generationConfig"`
+	SystemInstruction *systemInstruction `json:"system_instruction,omitempty"`
+	Contents          []content          `json:"contents"`
+	Tools             models.GoogleTool  `json:"tools"`
+	Config            map[string]any     `json:"generationConfig"`
 }
 
 type content struct {
@@ -69,6 +70,18 @@ type systemInstruction struct {
 	Parts any `json:"parts"`
 }
 
+// newSystemInstruction returns nil for an empty instruction so it is left
+// out of the request; Gemini does not require one.
+func newSystemInstruction(text string) *systemInstruction {
+	if text == "" {
+		return nil
+	}
+
+	return &systemInstruction{
+		Parts: part{Text: text},
+	}
+}
+
 type fileData struct {
 	MimeType string `json:"mime_type,omitzero"`
 	FileURI  string `json:"file_uri,omitzero"`
@@ -92,14 +105,61 @@ type part struct {
 }
 
 type geminiResponse struct {
-	Candidates    []geminiCandidate `json:"candidates"`
-	UsageMetadata usageMetadata     `json:"usageMetadata"`
-	ModelVersion  string            `json:"modelVersion"`
+	Candidates     []geminiCandidate    `json:"candidates"`
+	UsageMetadata  usageMetadata        `json:"usageMetadata"`
+	ModelVersion   string               `json:"modelVersion"`
+	PromptFeedback geminiPromptFeedback `json:"promptFeedback"`
+}
+
+type geminiPromptFeedback struct {
+	BlockReason string `json:"blockReason"`
+}
+
+// geminiFilteredReasons are the finish reasons Gemini uses when it stops a
+// candidate for safety or policy reasons, including RECITATION for output
+// that reproduced training data too closely.
+var geminiFilteredReasons = map[string]bool{
+	"SAFETY":             true,
+	"RECITATION":         true,
+	"PROHIBITED_CONTENT": true,
+	"BLOCKLIST":          true,
+	"SPII":               true,
+	"IMAGE_SAFETY":       true,
 }
 
 type geminiCandidate struct {
 	Content      geminiContent `json:"content"`
 	FinishReason string        `json:"finishReason"`
+	Index        int           `json:"index"`
+}
+
+// mapGeminiFinish normalises a Gemini finish reason. It is shared with
+// VertexAI, which reports the same values.
+func mapGeminiFinish(reason string) response.FinishReason {
+	switch {
+	case reason == "", reason == "FINISH_REASON_UNSPECIFIED":
+		return ""
+	case reason == "STOP":
+		return response.FinishStop
+	case reason == "MAX_TOKENS":
+		return response.FinishLength
+	case geminiFilteredReasons[reason]:
+		return response.FinishContentFilter
+	default:
+		return response.FinishError
+	}
+}
+
+// primaryCandidate returns the first candidate, which is the one streamed to
+// the chunk handler, or nil if the chunk carries no candidate for it.
+func primaryCandidate(candidates []geminiCandidate) *geminiCandidate {
+	for i := range candidates {
+		if candidates[i].Index == 0 {
+			return &candidates[i]
+		}
+	}
+
+	return nil
 }
 
 type geminiContent struct {
@@ -117,6 +177,7 @@ type usageMetadata struct {
 	CandidatesTokenCount    int             `json:"candidatesTokenCount"`
 	TotalTokenCount         int             `json:"totalTokenCount"`
 	ThoughtsTokenCount      int             `json:"thoughtsTokenCount,omitempty"`
+	CachedContentTokenCount int             `json:"cachedContentTokenCount,omitempty"`
 	PromptTokensDetails     []tokensDetails `json:"promptTokensDetails"`
 	CandidatesTokensDetails []tokensDetails `json:"candidatesTokensDetails"`
 }
@@ -133,7 +194,7 @@ func (g Google) CompleteResponse(
 	requestLog *response.Logging,
 ) (response.Completion, error) {
 	if len(g.apiKeys) == 0 {
-		return response.Completion{}, errors.New("no API keys available")
+		return response.Completion{}, ErrNoAPIKeys
 	}
 	reqLog := &response.Logging{}
 	if requestLog == nil {
@@ -156,6 +217,12 @@ func (g Google) CompleteResponse(
 	}
 
 	for i, key := range g.apiKeys {
+		select {
+		case <-ctx.Done():
+			return response.Completion{}, ctx.Err()
+		default:
+		}
+
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
 			Description: fmt.Sprintf(
@@ -167,6 +234,12 @@ func (g Google) CompleteResponse(
 		if err == nil {
 			return res, nil
 		}
+		if exceedsSizeLimit(err) {
+			return res, err
+		}
+		if errors.Is(err, response.ErrContentFiltered) {
+			return response.Completion{}, err
+		}
 
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
@@ -189,7 +262,7 @@ func (g Google) tryWithBackup(
 	requestLog *response.Logging,
 ) (response.Completion, error) {
 	if len(g.apiKeys) == 0 {
-		return response.Completion{}, errors.New("no API keys available")
+		return response.Completion{}, ErrNoAPIKeys
 	}
 	key := g.apiKeys[0]
 
@@ -198,6 +271,9 @@ func (g Google) tryWithBackup(
 	maxBackoff := 10 * time.Second
 
 	var lastErr error
+	var lastStatusCode int
+	start := g.opts.now()
+	backoffStep := 0
 	for attempt := range maxRetries {
 		requestLog.Events = append(requestLog.Events, response.Event{
 			Timestamp: time.Now(),
@@ -218,16 +294,24 @@ func (g Google) tryWithBackup(
 			})
 			return response.Completion{}, ctx.Err()
 		default:
+			var progress streamProgress
 			res, resCode, err := g.doRequest(
 				ctx,
 				req,
-				client,
-				chunkHandler,
+				progress.client(client),
+				progress.wrap(chunkHandler),
 				key,
 			)
 			if err == nil {
 				return res, nil
 			}
+			dropped, delivered := progress.failed(ctx, err, requestLog)
+			if delivered {
+				return response.Completion{}, err
+			}
+			if dropped {
+				backoffStep = 0
+			}
 			requestLog.Events = append(requestLog.Events, response.Event{
 				Timestamp: time.Now(),
 				Description: fmt.Sprintf(
@@ -236,7 +320,7 @@ func (g Google) tryWithBackup(
 				),
 			})
 
-			if !isRetryableError(resCode) {
+			if !dropped && !g.opts.retryable(resCode, err) {
 				requestLog.Events = append(requestLog.Events, response.Event{
 					Timestamp: time.Now(),
 					Description: fmt.Sprintf(
@@ -244,62 +328,143 @@ func (g Google) tryWithBackup(
 						err,
 					),
 				})
-				return response.Completion{}, err
+				return res, err
 			}
 
 			lastErr = err
+			lastStatusCode = resCode
 
 			backoff := min(initialBackoff*time.Duration(
-				1<<attempt,
+				1<<backoffStep,
 			), maxBackoff)
+			backoffStep++
 
-			var randomBytes [8]byte
-			var jitter time.Duration
-			if _, err := rand.Read(randomBytes[:]); err != nil {
-				jitter = backoff
-			} else {
-				randFloat := float64(binary.LittleEndian.Uint64(randomBytes[:])) / (1 << 64)
-				jitter = time.Duration(float64(backoff) * (0.8 + 0.4*randFloat))
-			}
-
-			timer := time.NewTimer(jitter)
+			timer := g.opts.newTimer(g.opts.jitter(backoff))
 			select {
 			case <-ctx.Done():
 				timer.Stop()
 				return response.Completion{}, ctx.Err()
-			case <-timer.C:
+			case <-timer.C():
 				continue
 			}
 		}
 	}
 
-	return response.Completion{}, fmt.Errorf(
-		"max retries exceeded: %w",
-		lastErr,
-	)
+	return response.Completion{}, &RetryExhaustedError{
+		Attempts:       maxRetries,
+		Elapsed:        g.opts.now().Sub(start),
+		LastStatusCode: lastStatusCode,
+		Err:            lastErr,
+	}
 }
 
 func (g Google) Name() string {
 	return models.GoogleProvider
 }
 
-// CacheContentPayload represents the data to be cached. Must be either text or fileData but not both.
+// Supports implements LLMProvider for the Gemini models doRequest has a
+// request builder for.
+func (g Google) Supports(m models.Model) bool {
+	if m == nil || m.GetProvider() != g.Name() {
+		return false
+	}
+
+	switch m.(type) {
+	case *models.Gemini25FlashImage, *models.Gemini3ProImagePreview:
+		return true
+	}
+
+	switch m.GetName() {
+	case models.Gemini20FlashModel,
+		models.Gemini20FlashLiteModel,
+		models.Gemini25ProModel,
+		models.Gemini25FlashModel,
+		models.Gemini25FlashLiteModel,
+		models.Gemini3ProModel,
+		models.Gemini3FlashModel:
+		return true
+	default:
+		return false
+	}
+}
+
+// SupportsPrefill implements LLMProvider. Gemini does not continue a
+// trailing model turn.
+func (g Google) SupportsPrefill() bool {
+	return false
+}
+
+// Validate checks every API key against the API concurrently and returns the
+// outcome per key, nil meaning the key works. Keys the API rejects are
+// excluded from later requests.
+func (g Google) Validate(ctx context.Context) map[string]error {
+	return validateKeys(ctx, g.apiKeys, g.keys, func(ctx context.Context, key string) (int, error) {
+		return pingURL(ctx, g.opts, "https://generativelanguage.googleapis.com/v1beta/models", func(req *http.Request) {
+			req.Header.Set("x-goog-api-key", key)
+		})
+	})
+}
+
+// CacheContentPayload represents the data to be cached. Text, FileData and
+// Parts may be combined; they are cached as one user turn in that order.
 type CacheContentPayload struct {
-	Text     string
+	Text string
+	// FileData maps a mime type to a file URI. Use Parts to cache several
+	// files of the same type.
 	FileData map[string]string
+	// Parts lists any number of text and file parts, for caching a corpus
+	// of documents together.
+	Parts []CachePart
 }
 
-// CacheContent caches the provided content with the specified TTL and returns a content ID
-// that can be used to reference this content in subsequent requests.
+// CachePart is one part of cached content: either Text, or a file uploaded
+// through the Files API given by its FileURI and MimeType.
+type CachePart struct {
+	Text     string
+	MimeType string
+	FileURI  string
+}
+
+// parts returns the payload as Gemini content parts. FileData is sorted by
+// mime type so the request is the same from call to call.
+func (p CacheContentPayload) parts() []any {
+	var parts []any
+	if p.Text != "" {
+		parts = append(parts, part{Text: p.Text})
+	}
+	for _, mimeType := range slices.Sorted(maps.Keys(p.FileData)) {
+		parts = append(parts, part{FileData: fileData{
+			MimeType: mimeType,
+			FileURI:  p.FileData[mimeType],
+		}})
+	}
+	for _, cp := range p.Parts {
+		if cp.FileURI != "" {
+			parts = append(parts, part{FileData: fileData{
+				MimeType: cp.MimeType,
+				FileURI:  cp.FileURI,
+			}})
+			continue
+		}
+		parts = append(parts, part{Text: cp.Text})
+	}
+
+	return parts
+}
+
+// CacheContent caches the provided content with the specified TTL and returns
+// the cached content, whose Name references it in subsequent requests and
+// whose ExpireTime tells when it lapses unless extended with
+// UpdateCachedContentTTL.
 func (g Google) CacheContent(
 	ctx context.Context,
 	model string,
 	payload CacheContentPayload,
 	systemInstruction string,
 	ttl time.Duration,
-) (string, error) {
+) (CachedContent, error) {
 	if len(g.apiKeys) == 0 {
-		return "", errors.New("no API keys available")
+		return CachedContent{}, ErrNoAPIKeys
 	}
 
 	key := g.apiKeys[0]
@@ -308,18 +473,16 @@ func (g Google) CacheContent(
 		key,
 	)
 
-	if payload.Text != "" && payload.FileData != nil {
-		return "", errors.New("only one of text or fileData can be provided")
-	}
-
-	if len(payload.FileData) > 1 {
-		return "", errors.New("you can only provide one file")
+	parts := payload.parts()
+	if len(parts) == 0 {
+		return CachedContent{}, errors.New("no content to cache")
 	}
 
 	reqBody := cacheContentRequest{
 		Model: "models/" + model,
 		Contents: []content{{
-			Role: "user",
+			Role:  "user",
+			Parts: parts,
 		}},
 		SystemInstruction: systemContent{
 			Role: "system",
@@ -330,37 +493,9 @@ func (g Google) CacheContent(
 		TTL: fmt.Sprintf("%ds", int(ttl.Seconds())),
 	}
 
-	if payload.Text != "" {
-		reqBody.Contents[0].Parts = append(
-			reqBody.Contents[0].Parts,
-			part{
-				Text: payload.Text,
-			},
-		)
-	}
-	if payload.FileData != nil {
-		var mimeType string
-		var fileURI string
-
-		for k, v := range payload.FileData {
-			mimeType = k
-			fileURI = v
-		}
-
-		reqBody.Contents[0].Parts = append(
-			reqBody.Contents[0].Parts,
-			part{
-				FileData: fileData{
-					MimeType: mimeType,
-					FileURI:  fileURI,
-				},
-			},
-		)
-	}
-
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request body: %w", err)
+		return CachedContent{}, fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(
@@ -370,9 +505,11 @@ func (g Google) CacheContent(
 		bytes.NewBuffer(jsonBody),
 	)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return CachedContent{}, fmt.Errorf("failed to create request: %w", err)
 	}
 
+	g.opts.setHeaders(req)
+
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{
@@ -381,25 +518,25 @@ func (g Google) CacheContent(
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
+		return CachedContent{}, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf(
+		return CachedContent{}, fmt.Errorf(
 			"unexpected status code %d: %s",
 			resp.StatusCode,
 			string(body),
 		)
 	}
 
-	var cacheResp cacheContentResponse
-	if err := json.NewDecoder(resp.Body).Decode(&cacheResp); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+	var cached CachedContent
+	if err := json.NewDecoder(resp.Body).Decode(&cached); err != nil {
+		return CachedContent{}, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	return cacheResp.Name, nil
+	return cached, nil
 }
 
 func (g Google) UpdateCachedContentTTL(
@@ -408,7 +545,7 @@ func (g Google) UpdateCachedContentTTL(
 	ttl time.Duration,
 ) error {
 	if len(g.apiKeys) == 0 {
-		return errors.New("no API keys available")
+		return ErrNoAPIKeys
 	}
 
 	key := g.apiKeys[0]
@@ -439,6 +576,8 @@ func (g Google) UpdateCachedContentTTL(
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
+	g.opts.setHeaders(req)
+
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{
@@ -460,8 +599,8 @@ func (g Google) UpdateCachedContentTTL(
 		)
 	}
 
-	var cacheResp cacheContentResponse
-	if err := json.NewDecoder(resp.Body).Decode(&cacheResp); err != nil {
+	var cached CachedContent
+	if err := json.NewDecoder(resp.Body).Decode(&cached); err != nil {
 		return fmt.Errorf("failed to decode response: %w", err)
 	}
 
@@ -488,7 +627,7 @@ func (g Google) ListCachedContents(
 	ctx context.Context,
 ) (*CachedContentsList, error) {
 	if len(g.apiKeys) == 0 {
-		return nil, errors.New("no API keys available")
+		return nil, ErrNoAPIKeys
 	}
 
 	key := g.apiKeys[0]
@@ -504,6 +643,8 @@ func (g Google) ListCachedContents(
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
+	g.opts.setHeaders(req)
+
 	client := &http.Client{
 		Timeout: 30 * time.Second,
 	}
@@ -537,7 +678,7 @@ func (g Google) DeleteCachedContent(
 	cacheName string,
 ) error {
 	if len(g.apiKeys) == 0 {
-		return errors.New("no API keys available")
+		return ErrNoAPIKeys
 	}
 
 	key := g.apiKeys[0]
@@ -557,6 +698,8 @@ func (g Google) DeleteCachedContent(
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
+	g.opts.setHeaders(req)
+
 	client := &http.Client{
 		Timeout: 30 * time.Second,
 	}
@@ -586,8 +729,12 @@ func (g Google) StreamResponse(
 	chunkHandler func(chunk string) error,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if !streams(req.Model) {
+		return completeAsStream(ctx, g, client, req, chunkHandler, requestLog)
+	}
+
 	if len(g.apiKeys) == 0 {
-		return response.Completion{}, errors.New("no API keys available")
+		return response.Completion{}, ErrNoAPIKeys
 	}
 	reqLog := &response.Logging{}
 	if requestLog == nil {
@@ -609,7 +756,15 @@ func (g Google) StreamResponse(
 		reqLog = requestLog
 	}
 
+	chunkHandler, delivered := trackDelivery(chunkHandler)
+
 	for i, key := range g.apiKeys {
+		select {
+		case <-ctx.Done():
+			return response.Completion{}, ctx.Err()
+		default:
+		}
+
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
 			Description: fmt.Sprintf(
@@ -621,6 +776,15 @@ func (g Google) StreamResponse(
 		if err == nil {
 			return res, nil
 		}
+		if exceedsSizeLimit(err) {
+			return res, err
+		}
+		if delivered() {
+			return response.Completion{}, err
+		}
+		if errors.Is(err, response.ErrContentFiltered) {
+			return response.Completion{}, err
+		}
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
 			Description: fmt.Sprintf(
@@ -633,6 +797,33 @@ func (g Google) StreamResponse(
 	return g.tryWithBackup(ctx, req, client, chunkHandler, reqLog)
 }
 
+// historyParts renders a history turn as Gemini parts, in the turn's order.
+func historyParts(his request.Message) []any {
+	var parts []any
+	for _, p := range his.ContentParts() {
+		switch {
+		case p.Image == nil:
+			parts = append(parts, part{Text: p.Text})
+		case p.Image.URL != "":
+			parts = append(parts, fileURI{
+				FileData: fileData{
+					MimeType: string(p.Image.MimeType),
+					FileURI:  p.Image.URL,
+				},
+			})
+		default:
+			parts = append(parts, filePart{
+				InlineData: imageData{
+					MimeType: string(p.Image.MimeType),
+					Data:     p.Image.Data,
+				},
+			})
+		}
+	}
+
+	return parts
+}
+
 func isRetryableError(resCode int) bool {
 	return resCode == 429 || resCode >= 500
 }
@@ -644,6 +835,12 @@ func (g Google) doRequest(
 	chunkHandler func(chunk string) error,
 	key string,
 ) (response.Completion, int, error) {
+	key, release, err := g.keys.Acquire(ctx, key)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	defer release()
+
 	// Handle image generation models separately
 	if _, ok := req.Model.(*models.Gemini25FlashImage); ok {
 		return g.doGemini25FlashImageRequest(ctx, req, client, key)
@@ -652,9 +849,10 @@ func (g Google) doRequest(
 		return g.doGemini3ProImageRequest(ctx, req, client, key)
 	}
 
-	if req.SystemMessage == "" || req.UserMessage == "" {
+	if req.UserMessage == "" && len(req.RawMessages) == 0 &&
+		!models.HasMediaInputs(req.Model) {
 		return response.Completion{}, 400, errors.New(
-			"gemini models require both system message and user message",
+			"gemini models require a user message or media input",
 		)
 	}
 
@@ -672,10 +870,8 @@ func (g Google) doRequest(
 			role = "model"
 		}
 		geminiReq.Contents[i] = content{
-			Role: role,
-			Parts: []any{
-				part{Text: his.Content},
-			},
+			Role:  role,
+			Parts: historyParts(his),
 		}
 	}
 
@@ -693,7 +889,7 @@ func (g Google) doRequest(
 			return response.Completion{}, 0, err
 		}
 
-		body, err := json.Marshal(preparedReq)
+		body, err := json.Marshal(applyGenerationConfig(preparedReq, req))
 		if err != nil {
 			return response.Completion{}, 0, err
 		}
@@ -710,14 +906,14 @@ func (g Google) doRequest(
 			return response.Completion{}, 0, err
 		}
 
-		body, err := json.Marshal(preparedReq)
+		body, err := json.Marshal(applyGenerationConfig(preparedReq, req))
 		if err != nil {
 			return response.Completion{}, 0, err
 		}
 
 		requestBody = body
 	case models.Gemini25ProModel:
-		preparedReq, err := prepareGemini25ProPreviewRequest(
+		preparedReq, err := prepareGemini25ProRequest(
 			geminiReq,
 			model,
 			systemMessage,
@@ -727,14 +923,14 @@ func (g Google) doRequest(
 			return response.Completion{}, 0, err
 		}
 
-		body, err := json.Marshal(preparedReq)
+		body, err := json.Marshal(applyGenerationConfig(preparedReq, req))
 		if err != nil {
 			return response.Completion{}, 0, err
 		}
 
 		requestBody = body
 	case models.Gemini25FlashModel:
-		preparedReq, err := prepareGemini25FlashPreviewRequest(
+		preparedReq, err := prepareGemini25FlashRequest(
 			geminiReq,
 			model,
 			systemMessage,
@@ -744,7 +940,7 @@ func (g Google) doRequest(
 			return response.Completion{}, 0, err
 		}
 
-		body, err := json.Marshal(preparedReq)
+		body, err := json.Marshal(applyGenerationConfig(preparedReq, req))
 		if err != nil {
 			return response.Completion{}, 0, err
 		}
@@ -761,7 +957,7 @@ func (g Google) doRequest(
 			return response.Completion{}, 0, err
 		}
 
-		body, err := json.Marshal(preparedReq)
+		body, err := json.Marshal(applyGenerationConfig(preparedReq, req))
 		if err != nil {
 			return response.Completion{}, 0, err
 		}
@@ -778,7 +974,7 @@ func (g Google) doRequest(
 			return response.Completion{}, 0, err
 		}
 
-		body, err := json.Marshal(preparedReq)
+		body, err := json.Marshal(applyGenerationConfig(preparedReq, req))
 		if err != nil {
 			return response.Completion{}, 0, err
 		}
@@ -795,7 +991,7 @@ func (g Google) doRequest(
 			return response.Completion{}, 0, err
 		}
 
-		body, err := json.Marshal(preparedReq)
+		body, err := json.Marshal(applyGenerationConfig(preparedReq, req))
 		if err != nil {
 			return response.Completion{}, 0, err
 		}
@@ -813,10 +1009,34 @@ func (g Google) doRequest(
 			"request body is nil - model may not be supported",
 		)
 	}
+	requestBody, err = withRawMessages(requestBody, "contents", req.RawMessages)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	requestBody, err = withExtraBody(requestBody, req.ExtraBody)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	if err := g.opts.checkRequestSize(requestBody); err != nil {
+		return response.Completion{}, 0, err
+	}
 
+	// Only streamed requests need the SSE endpoint; a completion is fetched
+	// whole.
+	streaming := chunkHandler != nil
 	apiURL := fmt.Sprintf(googleBaseURL, req.Model.GetName(), key)
-	log.Printf("[Heimdall] Making request to Google API: model=%s url=%s", req.Model.GetName(), strings.Split(apiURL, "?")[0])
-	log.Printf("[Heimdall] Request body size: %d bytes", len(requestBody))
+	if streaming {
+		apiURL = fmt.Sprintf(googleStreamURL, req.Model.GetName(), key)
+	}
+	g.opts.log().DebugContext(ctx, "sending google request",
+		"model", req.Model.GetName(),
+		"url", strings.Split(apiURL, "?")[0],
+		"body_bytes", len(requestBody),
+	)
+
+	// Stop upstream generation if we return before the stream is drained.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
 		apiURL,
@@ -825,92 +1045,189 @@ func (g Google) doRequest(
 		return response.Completion{}, 0, err
 	}
 
-	log.Printf("[Heimdall] Sending HTTP request...")
+	g.opts.setHeaders(httpReq)
+
 	resp, err := client.Do(httpReq)
 	if err != nil {
-		log.Printf("[Heimdall] HTTP request failed: %v", err)
+		g.opts.log().DebugContext(ctx, "google request failed", "error", err)
 		return response.Completion{}, 0, err
 	}
 	defer resp.Body.Close()
-	log.Printf("[Heimdall] Got response: status=%d", resp.StatusCode)
+	g.opts.log().DebugContext(ctx, "google response received", "status", resp.StatusCode)
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		if readErr != nil {
-			log.Printf("[Heimdall] Error response (status %d), failed to read body: %v", resp.StatusCode, readErr)
+			g.opts.log().DebugContext(ctx, "reading google error response failed",
+				"status", resp.StatusCode,
+				"error", readErr,
+			)
 			return response.Completion{}, resp.StatusCode, fmt.Errorf(
 				"received non-200 status code (%d), failed to read error body: %w",
 				resp.StatusCode, readErr,
 			)
 		}
-		log.Printf("[Heimdall] Error response (status %d): %s", resp.StatusCode, string(bodyBytes))
-		return response.Completion{}, resp.StatusCode, fmt.Errorf(
+		g.opts.log().DebugContext(ctx, "google returned an error response",
+			"status", resp.StatusCode,
+			"body", string(bodyBytes),
+		)
+		return response.Completion{}, resp.StatusCode, withBody(fmt.Errorf(
 			"received non-200 status code (%d): %s",
 			resp.StatusCode, string(bodyBytes),
-		)
+		), bodyBytes)
 	}
 
-	reader := bufio.NewReader(resp.Body)
-	var fullContent strings.Builder
+	fullContent := g.opts.newContent()
 	var thoughts strings.Builder
 	var usage response.Usage
 	var rawEvents []json.RawMessage
-	chunks := 0
-	now := time.Now()
+	var finishReason response.FinishReason
+	var choices []strings.Builder
+	if req.CandidateCount > 1 {
+		choices = make([]strings.Builder, req.CandidateCount)
+	}
+
+	// handle folds one response into the completion. A streamed request
+	// receives many, each carrying the next delta of every candidate; a
+	// completion receives one holding everything.
+	handle := func(res geminiResponse) error {
+		if reason := res.PromptFeedback.BlockReason; reason != "" {
+			return &response.ContentFilteredError{
+				Provider: g.Name(),
+				Reason:   reason,
+			}
+		}
 
-	for {
-		if chunks == 0 && time.Since(now).Seconds() > 3.0 {
-			return response.Completion{}, 0, context.Canceled
+		primary := primaryCandidate(res.Candidates)
+		if primary != nil {
+			for _, part := range primary.Content.Parts {
+				if part.Thought {
+					thoughts.WriteString(part.Text)
+					if req.ThoughtHandler != nil {
+						if err := req.ThoughtHandler(part.Text); err != nil {
+							return err
+						}
+					}
+					continue
+				}
+
+				text, limitErr := fullContent.add(part.Text)
+				if chunkHandler != nil {
+					if err := chunkHandler(text); err != nil {
+						return err
+					}
+				}
+				if limitErr != nil {
+					return limitErr
+				}
+			}
 		}
-		line, err := reader.ReadString('\n')
-		if err == io.EOF {
-			break
+
+		// Candidates stream interleaved, each chunk carrying deltas for
+		// some of them, so every candidate is accumulated by its index.
+		if req.CandidateCount > 1 {
+			for _, candidate := range res.Candidates {
+				if candidate.Index < 0 || candidate.Index >= len(choices) {
+					continue
+				}
+				for _, part := range candidate.Content.Parts {
+					if !part.Thought {
+						choices[candidate.Index].WriteString(part.Text)
+					}
+				}
+			}
 		}
-		if err != nil {
-			return response.Completion{}, 0, err
+
+		if primary != nil && primary.FinishReason != "" {
+			finishReason = mapGeminiFinish(primary.FinishReason)
 		}
 
-		line = strings.TrimPrefix(line, "data: ")
-		line = strings.TrimSpace(line)
-		if line == "" || line == "[DONE]" {
-			continue
+		if primary != nil && geminiFilteredReasons[primary.FinishReason] {
+			return &response.ContentFilteredError{
+				Provider: g.Name(),
+				Reason:   primary.FinishReason,
+				Content:  fullContent.String(),
+			}
 		}
 
-		var responseChunk geminiResponse
-		if err := json.Unmarshal([]byte(line), &responseChunk); err != nil {
-			return response.Completion{}, 0, err
+		// Usage is cumulative, so the latest reported counts hold whichever
+		// reason generation stopped for, including a cut-off stream.
+		if !streaming || res.UsageMetadata.TotalTokenCount > 0 {
+			usage = response.Usage{
+				PromptTokens:     res.UsageMetadata.PromptTokenCount,
+				CompletionTokens: res.UsageMetadata.CandidatesTokenCount,
+				TotalTokens:      res.UsageMetadata.TotalTokenCount,
+				CachedTokens:     res.UsageMetadata.CachedContentTokenCount,
+			}
 		}
 
-		rawEvents = append(rawEvents, json.RawMessage(line))
+		return nil
+	}
 
-		if len(responseChunk.Candidates) > 0 {
-			if len(responseChunk.Candidates[0].Content.Parts) > 0 {
-				part := responseChunk.Candidates[0].Content.Parts[0]
-				if part.Thought {
-					thoughts.WriteString(part.Text)
-				} else {
-					fullContent.WriteString(part.Text)
-				}
+	stream := newStreamBody(ctx, g.opts.limitResponse(resp.Body))
+	defer stream.Close()
 
-				if chunkHandler != nil {
-					if !part.Thought {
-						if err := chunkHandler(part.Text); err != nil {
-							return response.Completion{}, 0, err
-						}
-					}
+	if streaming {
+		reader := bufio.NewReader(stream)
+		replays := replayFilter{enabled: req.DedupeChunks}
+		chunks := 0
+		now := time.Now()
+
+		for {
+			if chunks == 0 && g.opts.firstChunkTimedOut(now) {
+				return response.Completion{}, 0, context.Canceled
+			}
+			line, err := reader.ReadString('\n')
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return response.Completion{}, 0, err
+			}
+
+			if replays.replayed(line) {
+				continue
+			}
+			line = strings.TrimPrefix(line, "data: ")
+			line = strings.TrimSpace(line)
+			if line == "" || line == "[DONE]" {
+				continue
+			}
+
+			var responseChunk geminiResponse
+			if err := json.Unmarshal([]byte(line), &responseChunk); err != nil {
+				return response.Completion{}, 0, err
+			}
+
+			rawEvents = append(rawEvents, json.RawMessage(line))
+
+			if err := handle(responseChunk); err != nil {
+				if errors.Is(err, ErrResponseTooLarge) {
+					return fullContent.partial(req.Model.GetName(), g.Name()), 0, err
 				}
+				return response.Completion{}, 0, err
 			}
+
+			chunks++
+		}
+	} else {
+		raw, err := io.ReadAll(stream)
+		if err != nil {
+			return response.Completion{}, 0, err
 		}
 
-		chunks++
+		var res geminiResponse
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return response.Completion{}, 0, err
+		}
 
-		if len(responseChunk.Candidates) > 0 &&
-			responseChunk.Candidates[0].FinishReason == "STOP" {
-			usage = response.Usage{
-				PromptTokens:     responseChunk.UsageMetadata.PromptTokenCount,
-				CompletionTokens: responseChunk.UsageMetadata.CandidatesTokenCount,
-				TotalTokens:      responseChunk.UsageMetadata.TotalTokenCount,
+		rawEvents = append(rawEvents, json.RawMessage(raw))
+
+		if err := handle(res); err != nil {
+			if errors.Is(err, ErrResponseTooLarge) {
+				return fullContent.partial(req.Model.GetName(), g.Name()), 0, err
 			}
+			return response.Completion{}, 0, err
 		}
 	}
 
@@ -919,14 +1236,22 @@ func (g Google) doRequest(
 		return response.Completion{}, 0, fmt.Errorf("marshal raw response events: %w", err)
 	}
 
-	return response.Completion{
-		Content:     fullContent.String(),
-		Thoughts:    thoughts.String(),
-		Model:       req.Model.GetName(),
-		Usage:       usage,
-		RawRequest:  requestBody,
-		RawResponse: rawResp,
-	}, 0, nil
+	var texts []string
+	for i := range choices {
+		texts = append(texts, choices[i].String())
+	}
+
+	return finishStream(req, chunkHandler, g.opts.sampleRaw(ctx, response.Completion{
+		Content:      fullContent.String(),
+		Choices:      texts,
+		Thoughts:     thoughts.String(),
+		Model:        req.Model.GetName(),
+		Provider:     g.Name(),
+		FinishReason: finishReason,
+		Usage:        usage,
+		RawRequest:   requestBody,
+		RawResponse:  rawResp,
+	}))
 }
 
 var _ LLMProvider = new(Google)
@@ -944,9 +1269,7 @@ func prepareGemini20FlashRequest(
 		)
 	}
 
-	request.SystemInstruction.Parts = part{
-		Text: systemInst,
-	}
+	request.SystemInstruction = newSystemInstruction(systemInst)
 
 	lastIndex := 0
 	if len(request.Contents) >= 1 {
@@ -954,10 +1277,12 @@ func prepareGemini20FlashRequest(
 	}
 
 	if len(request.Contents) > 0 {
-		request.Contents[lastIndex].Parts = append(
-			request.Contents[lastIndex].Parts,
-			part{Text: userMsg},
-		)
+		if userMsg != "" {
+			request.Contents[lastIndex].Parts = append(
+				request.Contents[lastIndex].Parts,
+				part{Text: userMsg},
+			)
+		}
 		request.Contents[lastIndex].Role = "user"
 	}
 
@@ -968,7 +1293,7 @@ func prepareGemini20FlashRequest(
 	}
 
 	if len(model.ImageFile) > 0 {
-		request = handleVisionData(request, model.ImageFile)
+		request = handleVisionData(request, model.ImageFile, lastIndex)
 	}
 
 	if len(model.PdfFiles) > 0 {
@@ -979,12 +1304,11 @@ func prepareGemini20FlashRequest(
 		request = handleGenericFiles(request, model.Files, lastIndex)
 	}
 
-	if len(model.StructuredOutput) > 0 {
-		request.Config = map[string]any{
-			"response_mime_type": "application/json",
-			"response_schema":    model.StructuredOutput,
-		}
-	}
+	request = applyResponseFormat(
+		request,
+		model.ResponseMimeType,
+		model.StructuredOutput,
+	)
 
 	if len(model.Tools) > 1 {
 		request.Tools = model.Tools
@@ -1010,9 +1334,7 @@ func prepareGemini20FlashLiteRequest(
 		)
 	}
 
-	request.SystemInstruction.Parts = part{
-		Text: systemInst,
-	}
+	request.SystemInstruction = newSystemInstruction(systemInst)
 
 	lastIndex := 0
 	if len(request.Contents) > 1 {
@@ -1020,10 +1342,12 @@ func prepareGemini20FlashLiteRequest(
 	}
 
 	if len(request.Contents) > 0 {
-		request.Contents[lastIndex].Parts = append(
-			request.Contents[lastIndex].Parts,
-			part{Text: userMsg},
-		)
+		if userMsg != "" {
+			request.Contents[lastIndex].Parts = append(
+				request.Contents[lastIndex].Parts,
+				part{Text: userMsg},
+			)
+		}
 		request.Contents[lastIndex].Role = "user"
 	}
 
@@ -1034,7 +1358,7 @@ func prepareGemini20FlashLiteRequest(
 	}
 
 	if len(model.ImageFile) > 0 {
-		request = handleVisionData(request, model.ImageFile)
+		request = handleVisionData(request, model.ImageFile, lastIndex)
 	}
 
 	if len(model.PdfFiles) > 0 {
@@ -1045,12 +1369,11 @@ func prepareGemini20FlashLiteRequest(
 		request = handleGenericFiles(request, model.Files, lastIndex)
 	}
 
-	if len(model.StructuredOutput) > 0 {
-		request.Config = map[string]any{
-			"response_mime_type": "application/json",
-			"response_schema":    model.StructuredOutput,
-		}
-	}
+	request = applyResponseFormat(
+		request,
+		model.ResponseMimeType,
+		model.StructuredOutput,
+	)
 
 	if len(model.Tools) > 1 {
 		request.Tools = model.Tools
@@ -1076,9 +1399,7 @@ func prepareGemini25FlashLiteRequest(
 		)
 	}
 
-	request.SystemInstruction.Parts = part{
-		Text: systemInst,
-	}
+	request.SystemInstruction = newSystemInstruction(systemInst)
 
 	lastIndex := 0
 	if len(request.Contents) > 1 {
@@ -1086,10 +1407,12 @@ func prepareGemini25FlashLiteRequest(
 	}
 
 	if len(request.Contents) > 0 {
-		request.Contents[lastIndex].Parts = append(
-			request.Contents[lastIndex].Parts,
-			part{Text: userMsg},
-		)
+		if userMsg != "" {
+			request.Contents[lastIndex].Parts = append(
+				request.Contents[lastIndex].Parts,
+				part{Text: userMsg},
+			)
+		}
 		request.Contents[lastIndex].Role = "user"
 	}
 
@@ -1100,7 +1423,7 @@ func prepareGemini25FlashLiteRequest(
 	}
 
 	if len(model.ImageFile) > 0 {
-		request = handleVisionData(request, model.ImageFile)
+		request = handleVisionData(request, model.ImageFile, lastIndex)
 	}
 
 	if len(model.PdfFiles) > 0 {
@@ -1111,12 +1434,11 @@ func prepareGemini25FlashLiteRequest(
 		request = handleGenericFiles(request, model.Files, lastIndex)
 	}
 
-	if len(model.StructuredOutput) > 0 {
-		request.Config = map[string]any{
-			"response_mime_type": "application/json",
-			"response_schema":    model.StructuredOutput,
-		}
-	}
+	request = applyResponseFormat(
+		request,
+		model.ResponseMimeType,
+		model.StructuredOutput,
+	)
 
 	if len(model.Tools) > 1 {
 		request.Tools = model.Tools
@@ -1129,22 +1451,20 @@ func prepareGemini25FlashLiteRequest(
 	return request, nil
 }
 
-func prepareGemini25FlashPreviewRequest(
+func prepareGemini25FlashRequest(
 	request geminiRequest,
 	requestedModel models.Model,
 	systemInst string,
 	userMsg string,
 ) (geminiRequest, error) {
-	model, ok := requestedModel.(models.Gemini25FlashPreview)
+	model, ok := requestedModel.(models.Gemini25Flash)
 	if !ok {
 		return request, errors.New(
-			"internal error; model type assertion to models.Gemini25FlashPreview failed",
+			"internal error; model type assertion to models.Gemini25Flash failed",
 		)
 	}
 
-	request.SystemInstruction.Parts = part{
-		Text: systemInst,
-	}
+	request.SystemInstruction = newSystemInstruction(systemInst)
 
 	lastIndex := 0
 	if len(request.Contents) > 1 {
@@ -1152,10 +1472,12 @@ func prepareGemini25FlashPreviewRequest(
 	}
 
 	if len(request.Contents) > 0 {
-		request.Contents[lastIndex].Parts = append(
-			request.Contents[lastIndex].Parts,
-			part{Text: userMsg},
-		)
+		if userMsg != "" {
+			request.Contents[lastIndex].Parts = append(
+				request.Contents[lastIndex].Parts,
+				part{Text: userMsg},
+			)
+		}
 		request.Contents[lastIndex].Role = "user"
 	}
 
@@ -1166,7 +1488,7 @@ func prepareGemini25FlashPreviewRequest(
 	}
 
 	if len(model.ImageFile) > 0 {
-		request = handleVisionData(request, model.ImageFile)
+		request = handleVisionData(request, model.ImageFile, lastIndex)
 	}
 
 	if len(model.PdfFiles) > 0 {
@@ -1177,12 +1499,11 @@ func prepareGemini25FlashPreviewRequest(
 		request = handleGenericFiles(request, model.Files, lastIndex)
 	}
 
-	if len(model.StructuredOutput) > 0 {
-		request.Config = map[string]any{
-			"response_mime_type": "application/json",
-			"response_schema":    model.StructuredOutput,
-		}
-	}
+	request = applyResponseFormat(
+		request,
+		model.ResponseMimeType,
+		model.StructuredOutput,
+	)
 
 	if len(model.Tools) > 1 {
 		request.Tools = model.Tools
@@ -1195,22 +1516,20 @@ func prepareGemini25FlashPreviewRequest(
 	return request, nil
 }
 
-func prepareGemini25ProPreviewRequest(
+func prepareGemini25ProRequest(
 	request geminiRequest,
 	requestedModel models.Model,
 	systemInst string,
 	userMsg string,
 ) (geminiRequest, error) {
-	model, ok := requestedModel.(models.Gemini25ProPreview)
+	model, ok := requestedModel.(models.Gemini25Pro)
 	if !ok {
 		return request, errors.New(
-			"internal error; model type assertion to models.Gemini25ProPreview failed",
+			"internal error; model type assertion to models.Gemini25Pro failed",
 		)
 	}
 
-	request.SystemInstruction.Parts = part{
-		Text: systemInst,
-	}
+	request.SystemInstruction = newSystemInstruction(systemInst)
 
 	lastIndex := 0
 	if len(request.Contents) > 1 {
@@ -1218,10 +1537,12 @@ func prepareGemini25ProPreviewRequest(
 	}
 
 	if len(request.Contents) > 0 {
-		request.Contents[lastIndex].Parts = append(
-			request.Contents[lastIndex].Parts,
-			part{Text: userMsg},
-		)
+		if userMsg != "" {
+			request.Contents[lastIndex].Parts = append(
+				request.Contents[lastIndex].Parts,
+				part{Text: userMsg},
+			)
+		}
 		request.Contents[lastIndex].Role = "user"
 	}
 
@@ -1232,7 +1553,7 @@ func prepareGemini25ProPreviewRequest(
 	}
 
 	if len(model.ImageFile) > 0 {
-		request = handleVisionData(request, model.ImageFile)
+		request = handleVisionData(request, model.ImageFile, lastIndex)
 	}
 
 	if len(model.PdfFiles) > 0 {
@@ -1243,12 +1564,11 @@ func prepareGemini25ProPreviewRequest(
 		request = handleGenericFiles(request, model.Files, lastIndex)
 	}
 
-	if len(model.StructuredOutput) > 0 {
-		request.Config = map[string]any{
-			"response_mime_type": "application/json",
-			"response_schema":    model.StructuredOutput,
-		}
-	}
+	request = applyResponseFormat(
+		request,
+		model.ResponseMimeType,
+		model.StructuredOutput,
+	)
 
 	if len(model.Tools) > 1 {
 		request.Tools = model.Tools
@@ -1264,11 +1584,12 @@ func prepareGemini25ProPreviewRequest(
 func handleVisionData(
 	request geminiRequest,
 	imageFiles []models.GoogleImagePayload,
+	contentIdx int,
 ) geminiRequest {
 	for _, imgFile := range imageFiles {
 		if strings.HasPrefix(imgFile.Data, "https://") {
-			request.Contents[0].Parts = append(
-				request.Contents[0].Parts,
+			request.Contents[contentIdx].Parts = append(
+				request.Contents[contentIdx].Parts,
 				filePart{
 					InlineData: fileData{
 						MimeType: imgFile.MimeType,
@@ -1291,8 +1612,8 @@ func handleVisionData(
 				}
 			}
 
-			request.Contents[0].Parts = append(
-				request.Contents[0].Parts,
+			request.Contents[contentIdx].Parts = append(
+				request.Contents[contentIdx].Parts,
 				filePart{
 					InlineData: imageData{
 						MimeType: imgFile.MimeType,
@@ -1382,33 +1703,80 @@ func handleGenericFiles(
 	return request
 }
 
+// applyGenerationConfig merges the sampling parameters from the completion
+// request into generationConfig, leaving keys already set for thinking or
+// structured output untouched.
+func applyGenerationConfig(
+	request geminiRequest,
+	req request.Completion,
+) geminiRequest {
+	params := map[string]any{}
+	if req.Temperature != 0 {
+		params["temperature"] = req.Temperature
+	}
+	if req.TopP != 0 {
+		params["topP"] = req.TopP
+	}
+	if req.TopK != 0 {
+		params["topK"] = req.TopK
+	}
+	if req.CandidateCount != 0 {
+		params["candidateCount"] = req.CandidateCount
+	}
+	if req.MaxTokens != 0 {
+		params["maxOutputTokens"] = req.MaxTokens
+	}
+	if len(req.StopSequences) > 0 {
+		params["stopSequences"] = req.StopSequences
+	}
+	if req.PresencePenalty != 0 {
+		params["presencePenalty"] = req.PresencePenalty
+	}
+
+	if len(params) == 0 {
+		return request
+	}
+
+	if request.Config == nil {
+		request.Config = map[string]any{}
+	}
+	for k, v := range params {
+		request.Config[k] = v
+	}
+
+	return request
+}
+
 func handleThinkingBudget(
 	request geminiRequest,
 	budget models.ThinkBudget,
 ) geminiRequest {
+	var thinkingConfig map[string]any
 	switch budget {
 	case models.HighThinkBudget:
-		request.Config = map[string]any{
-			"thinkingConfig": map[string]any{
-				"thinkingBudget":  int64(24576),
-				"includeThoughts": true,
-			},
+		thinkingConfig = map[string]any{
+			"thinkingBudget":  int64(24576),
+			"includeThoughts": true,
 		}
 	case models.MediumThinkBudget:
-		request.Config = map[string]any{
-			"thinkingConfig": map[string]any{
-				"thinkingBudget":  int64(12288),
-				"includeThoughts": true,
-			},
+		thinkingConfig = map[string]any{
+			"thinkingBudget":  int64(12288),
+			"includeThoughts": true,
 		}
 	case models.LowThinkBudget:
-		request.Config = map[string]any{
-			"thinkingConfig": map[string]any{
-				"thinkingBudget":  int64(0),
-				"includeThoughts": false,
-			},
+		thinkingConfig = map[string]any{
+			"thinkingBudget":  int64(0),
+			"includeThoughts": false,
 		}
 	}
+	if thinkingConfig == nil {
+		return request
+	}
+
+	if request.Config == nil {
+		request.Config = map[string]any{}
+	}
+	request.Config["thinkingConfig"] = thinkingConfig
 
 	return request
 }
@@ -1457,6 +1825,32 @@ func handleMediaResolution(
 	return request
 }
 
+// applyResponseFormat sets the response MIME type, and the schema when
+// given, in generationConfig without touching its other keys. A schema
+// without a MIME type asks for JSON.
+func applyResponseFormat(
+	request geminiRequest,
+	mimeType string,
+	schema map[string]any,
+) geminiRequest {
+	if mimeType == "" && len(schema) > 0 {
+		mimeType = models.GoogleMimeJSON
+	}
+	if mimeType == "" {
+		return request
+	}
+
+	if request.Config == nil {
+		request.Config = map[string]any{}
+	}
+	request.Config["response_mime_type"] = mimeType
+	if len(schema) > 0 {
+		request.Config["response_schema"] = schema
+	}
+
+	return request
+}
+
 func prepareGemini3ProPreviewRequest(
 	request geminiRequest,
 	requestedModel models.Model,
@@ -1470,9 +1864,7 @@ func prepareGemini3ProPreviewRequest(
 		)
 	}
 
-	request.SystemInstruction.Parts = part{
-		Text: systemInst,
-	}
+	request.SystemInstruction = newSystemInstruction(systemInst)
 
 	lastIndex := 0
 	if len(request.Contents) > 1 {
@@ -1480,10 +1872,12 @@ func prepareGemini3ProPreviewRequest(
 	}
 
 	if len(request.Contents) > 0 {
-		request.Contents[lastIndex].Parts = append(
-			request.Contents[lastIndex].Parts,
-			part{Text: userMsg},
-		)
+		if userMsg != "" {
+			request.Contents[lastIndex].Parts = append(
+				request.Contents[lastIndex].Parts,
+				part{Text: userMsg},
+			)
+		}
 		request.Contents[lastIndex].Role = "user"
 	}
 
@@ -1494,7 +1888,7 @@ func prepareGemini3ProPreviewRequest(
 	}
 
 	if len(model.ImageFile) > 0 {
-		request = handleVisionData(request, model.ImageFile)
+		request = handleVisionData(request, model.ImageFile, lastIndex)
 	}
 
 	if len(model.PdfFiles) > 0 {
@@ -1505,13 +1899,11 @@ func prepareGemini3ProPreviewRequest(
 		request = handleGenericFiles(request, model.Files, lastIndex)
 	}
 
-	if len(model.StructuredOutput) > 0 {
-		if request.Config == nil {
-			request.Config = map[string]any{}
-		}
-		request.Config["response_mime_type"] = "application/json"
-		request.Config["response_schema"] = model.StructuredOutput
-	}
+	request = applyResponseFormat(
+		request,
+		model.ResponseMimeType,
+		model.StructuredOutput,
+	)
 
 	if len(model.Tools) > 0 {
 		request.Tools = model.Tools
@@ -1541,9 +1933,7 @@ func prepareGemini3FlashPreviewRequest(
 		)
 	}
 
-	request.SystemInstruction.Parts = part{
-		Text: systemInst,
-	}
+	request.SystemInstruction = newSystemInstruction(systemInst)
 
 	lastIndex := 0
 	if len(request.Contents) > 1 {
@@ -1551,10 +1941,12 @@ func prepareGemini3FlashPreviewRequest(
 	}
 
 	if len(request.Contents) > 0 {
-		request.Contents[lastIndex].Parts = append(
-			request.Contents[lastIndex].Parts,
-			part{Text: userMsg},
-		)
+		if userMsg != "" {
+			request.Contents[lastIndex].Parts = append(
+				request.Contents[lastIndex].Parts,
+				part{Text: userMsg},
+			)
+		}
 		request.Contents[lastIndex].Role = "user"
 	}
 
@@ -1565,7 +1957,7 @@ func prepareGemini3FlashPreviewRequest(
 	}
 
 	if len(model.ImageFile) > 0 {
-		request = handleVisionData(request, model.ImageFile)
+		request = handleVisionData(request, model.ImageFile, lastIndex)
 	}
 
 	if len(model.PdfFiles) > 0 {
@@ -1576,13 +1968,11 @@ func prepareGemini3FlashPreviewRequest(
 		request = handleGenericFiles(request, model.Files, lastIndex)
 	}
 
-	if len(model.StructuredOutput) > 0 {
-		if request.Config == nil {
-			request.Config = map[string]any{}
-		}
-		request.Config["response_mime_type"] = "application/json"
-		request.Config["response_schema"] = model.StructuredOutput
-	}
+	request = applyResponseFormat(
+		request,
+		model.ResponseMimeType,
+		model.StructuredOutput,
+	)
 
 	if len(model.Tools) > 0 {
 		request.Tools = model.Tools
@@ -1711,10 +2101,17 @@ func (g Google) doGemini3ProImageRequest(
 		key,
 	)
 
+	// Abandon the upstream request if we return before its body is read.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(bodyBytes))
 	if err != nil {
 		return response.Completion{}, 0, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
+
+	g.opts.setHeaders(httpReq)
+
 	httpReq.Header.Set("Content-Type", "application/json")
 
 	resp, err := client.Do(httpReq)
@@ -1742,29 +2139,28 @@ func (g Google) doGemini3ProImageRequest(
 		return response.Completion{}, 0, errors.New("no image data in response")
 	}
 
-	var imgData string
-	for _, p := range imageResp.Candidates[0].Content.Parts {
-		if p.InlineData != nil && p.InlineData.Data != "" {
-			imgData = p.InlineData.Data
-			break
-		}
+	images, err := imageResp.images()
+	if err != nil {
+		return response.Completion{}, 0, err
 	}
-
-	if imgData == "" {
+	if len(images) == 0 {
 		return response.Completion{}, 0, errors.New("no image data found in response parts")
 	}
 
-	return response.Completion{
-		Content: imgData,
-		Model:   models.Gemini3ProImageModel,
+	return g.opts.sampleRaw(ctx, response.Completion{
+		Content:  base64.StdEncoding.EncodeToString(images[0].Data),
+		Images:   images,
+		Model:    models.Gemini3ProImageModel,
+		Provider: g.Name(),
 		Usage: response.Usage{
 			PromptTokens:     imageResp.UsageMetadata.PromptTokenCount,
 			CompletionTokens: imageResp.UsageMetadata.CandidatesTokenCount,
 			TotalTokens:      imageResp.UsageMetadata.TotalTokenCount,
+			CachedTokens:     imageResp.UsageMetadata.CachedContentTokenCount,
 		},
 		RawRequest:  bodyBytes,
 		RawResponse: rawResponse.Bytes(),
-	}, http.StatusOK, nil
+	}), http.StatusOK, nil
 }
 
 // Image generation response structures
@@ -1781,12 +2177,37 @@ type gemini25FlashImageResponse struct {
 		} `json:"content"`
 	} `json:"candidates"`
 	UsageMetadata struct {
-		PromptTokenCount     int `json:"promptTokenCount"`
-		CandidatesTokenCount int `json:"candidatesTokenCount"`
-		TotalTokenCount      int `json:"totalTokenCount"`
+		PromptTokenCount        int `json:"promptTokenCount"`
+		CandidatesTokenCount    int `json:"candidatesTokenCount"`
+		TotalTokenCount         int `json:"totalTokenCount"`
+		CachedContentTokenCount int `json:"cachedContentTokenCount"`
 	} `json:"usageMetadata"`
 }
 
+// images decodes the inline image parts of the first candidate.
+func (r gemini25FlashImageResponse) images() ([]response.Image, error) {
+	if len(r.Candidates) == 0 {
+		return nil, nil
+	}
+
+	var images []response.Image
+	for _, part := range r.Candidates[0].Content.Parts {
+		if part.InlineData == nil || part.InlineData.Data == "" {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(part.InlineData.Data)
+		if err != nil {
+			return nil, fmt.Errorf("decode image data: %w", err)
+		}
+		images = append(images, response.Image{
+			MimeType: part.InlineData.MimeType,
+			Data:     data,
+		})
+	}
+
+	return images, nil
+}
+
 // doGemini25FlashImageRequest handles image generation via Gemini 2.5 Flash Image model
 func (g Google) doGemini25FlashImageRequest(
 	ctx context.Context,
@@ -1903,11 +2324,17 @@ func (g Google) doGemini25FlashImageRequest(
 		key,
 	)
 
+	// Abandon the upstream request if we return before its body is read.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(bodyBytes))
 	if err != nil {
 		return response.Completion{}, 0, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
+	g.opts.setHeaders(httpReq)
+
 	httpReq.Header.Set("Content-Type", "application/json")
 
 	resp, err := client.Do(httpReq)
@@ -1935,28 +2362,26 @@ func (g Google) doGemini25FlashImageRequest(
 		return response.Completion{}, 0, errors.New("no image data in response")
 	}
 
-	// Extract base64 image data from parts
-	var imageData string
-	for _, part := range imageResp.Candidates[0].Content.Parts {
-		if part.InlineData != nil && part.InlineData.Data != "" {
-			imageData = part.InlineData.Data
-			break
-		}
+	images, err := imageResp.images()
+	if err != nil {
+		return response.Completion{}, 0, err
 	}
-
-	if imageData == "" {
+	if len(images) == 0 {
 		return response.Completion{}, 0, errors.New("no image data found in response parts")
 	}
 
-	return response.Completion{
-		Content: imageData,
-		Model:   models.Gemini25FlashImageModel,
+	return g.opts.sampleRaw(ctx, response.Completion{
+		Content:  base64.StdEncoding.EncodeToString(images[0].Data),
+		Images:   images,
+		Model:    models.Gemini25FlashImageModel,
+		Provider: g.Name(),
 		Usage: response.Usage{
 			PromptTokens:     imageResp.UsageMetadata.PromptTokenCount,
 			CompletionTokens: imageResp.UsageMetadata.CandidatesTokenCount,
 			TotalTokens:      imageResp.UsageMetadata.TotalTokenCount,
+			CachedTokens:     imageResp.UsageMetadata.CachedContentTokenCount,
 		},
 		RawRequest:  bodyBytes,
 		RawResponse: rawResponse.Bytes(),
-	}, http.StatusOK, nil
+	}), http.StatusOK, nil
 }
@@ -1,15 +1,22 @@
 package providers_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/flyx-ai/heimdall/models"
 	"github.com/flyx-ai/heimdall/providers"
 	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -71,19 +78,882 @@ func TestGoogleModelsWithStreaming(t *testing.T) {
 		},
 	}
 
-	var chunkHandlerCollection string
+	var collected providers.Collector
 	res, err := google.StreamResponse(
 		context.Background(),
 		client,
 		req,
-		func(chunk string) error {
-			chunkHandlerCollection = chunkHandlerCollection + chunk
-			return nil
-		},
+		collected.Handle,
 		nil,
 	)
 	require.NoError(t, err, "StreamResponse returned an unexpected error")
-	assert.NotEmpty(t, chunkHandlerCollection, "chunkHandlerCollection should not be empty")
+	assert.NotEmpty(t, collected.String(), "collected chunks should not be empty")
 	assert.NotEmpty(t, res.Content, "content should not be empty")
 	assert.NotEmpty(t, res.Model, "model should not be empty")
 }
+
+func TestGoogleCachedTokenUsage(t *testing.T) {
+	t.Parallel()
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return jsonResponse(
+				`{"candidates":[{"content":{"parts":[{"text":"hello"}]},"finishReason":"STOP"}],` +
+					`"usageMetadata":{"promptTokenCount":1200,"candidatesTokenCount":5,` +
+					`"totalTokenCount":1205,"cachedContentTokenCount":1024},"responseId":"resp-1"}`,
+			), nil
+		}),
+	}
+	google := providers.NewGoogle([]string{"test-key"})
+
+	res, err := google.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:         models.Gemini25FlashLite{},
+			SystemMessage: "you are a helpful assistant.",
+			UserMessage:   "Say hello in one sentence.",
+			Tags: map[string]string{
+				"type": "testing",
+			},
+		},
+		client,
+		nil,
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1200, res.Usage.PromptTokens)
+	assert.Equal(t, 1024, res.Usage.CachedTokens)
+
+	var events []map[string]any
+	require.NoError(t, json.Unmarshal(res.RawResponse, &events))
+	require.Len(t, events, 1)
+	assert.Equal(t, "resp-1", events[0]["responseId"], "raw events keep provider-native fields")
+}
+
+func TestGoogleWithoutSystemMessage(t *testing.T) {
+	t.Parallel()
+
+	var body map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return jsonResponse(
+				`{"candidates":[{"content":{"parts":[{"text":"hello"}]},"finishReason":"STOP"}]}`,
+			), nil
+		}),
+	}
+	google := providers.NewGoogle([]string{"test-key"})
+
+	res, err := google.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.Gemini25Flash{},
+			UserMessage: "Say hello in one sentence.",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello", res.Content)
+	assert.NotContains(t, body, "system_instruction")
+}
+
+func TestGoogleThoughtHandler(t *testing.T) {
+	t.Parallel()
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return sseResponse(
+				`{"candidates":[{"content":{"parts":[{"text":"pondering","thought":true}]}}]}`,
+				`{"candidates":[{"content":{"parts":[{"text":"hello"}]},"finishReason":"STOP"}]}`,
+			), nil
+		}),
+	}
+	google := providers.NewGoogle([]string{"test-key"})
+
+	var (
+		thoughts  []string
+		collected providers.Collector
+	)
+	res, err := google.StreamResponse(
+		context.Background(),
+		client,
+		request.Completion{
+			Model:         models.Gemini25Flash{},
+			SystemMessage: "you are a helpful assistant.",
+			UserMessage:   "Say hello in one sentence.",
+			ThoughtHandler: func(thought string) error {
+				thoughts = append(thoughts, thought)
+				return nil
+			},
+			Tags: map[string]string{
+				"type": "testing",
+			},
+		},
+		collected.Handle,
+		nil,
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"pondering"}, thoughts)
+	assert.Equal(t, []string{"hello"}, collected.Chunks())
+	assert.Equal(t, "pondering", res.Thoughts)
+	assert.Equal(t, "hello", res.Content)
+}
+
+func TestGoogleHistoryImages(t *testing.T) {
+	t.Parallel()
+
+	var body struct {
+		Contents []struct {
+			Role  string          `json:"role"`
+			Parts json.RawMessage `json:"parts"`
+		} `json:"contents"`
+	}
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return jsonResponse(
+				`{"candidates":[{"content":{"parts":[{"text":"the cat"}]},"finishReason":"STOP"}]}`,
+			), nil
+		}),
+	}
+	google := providers.NewGoogle([]string{"test-key"})
+
+	_, err := google.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:         models.Gemini25Flash{},
+			SystemMessage: "you are a helpful assistant.",
+			History: []request.Message{
+				{
+					Role:    "user",
+					Content: "What is in these pictures?",
+					Images: []request.Image{
+						{MimeType: request.MimeTypePNG, Data: "aGVsbG8="},
+						{MimeType: request.MimeTypeJPEG, URL: "gs://bucket/cat.jpg"},
+					},
+				},
+				{Role: "assistant", Content: "A dog and a cat."},
+			},
+			UserMessage: "Which one is bigger?",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	require.GreaterOrEqual(t, len(body.Contents), 2)
+	assert.JSONEq(t, `[
+		{"inline_data":{"mime_type":"image/png","data":"aGVsbG8="}},
+		{"file_data":{"mime_type":"image/jpeg","file_uri":"gs://bucket/cat.jpg"}},
+		{"text":"What is in these pictures?"}
+	]`, string(body.Contents[0].Parts))
+	assert.Equal(t, "model", body.Contents[1].Role)
+}
+
+func TestGoogleModelImagesWithHistory(t *testing.T) {
+	t.Parallel()
+
+	var body struct {
+		Contents []struct {
+			Role  string          `json:"role"`
+			Parts json.RawMessage `json:"parts"`
+		} `json:"contents"`
+	}
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return jsonResponse(
+				`{"candidates":[{"content":{"parts":[{"text":"a cat"}]},"finishReason":"STOP"}]}`,
+			), nil
+		}),
+	}
+	google := providers.NewGoogle([]string{"test-key"})
+
+	_, err := google.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model: models.Gemini25Flash{
+				ImageFile: []models.GoogleImagePayload{
+					{MimeType: "image/png", Data: "aGVsbG8="},
+				},
+			},
+			History: []request.Message{
+				{Role: "user", Content: "Hello"},
+				{Role: "assistant", Content: "Hi, how can I help?"},
+			},
+			UserMessage: "What is in this picture?",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, body.Contents, 3)
+	assert.JSONEq(t, `[{"text":"Hello"}]`, string(body.Contents[0].Parts))
+	assert.Equal(t, "user", body.Contents[2].Role)
+	assert.JSONEq(t, `[
+		{"text":"What is in this picture?"},
+		{"inline_data":{"mime_type":"image/png","data":"aGVsbG8="}}
+	]`, string(body.Contents[2].Parts))
+}
+
+func TestGoogleImageWithoutText(t *testing.T) {
+	t.Parallel()
+
+	var body struct {
+		Contents []struct {
+			Role  string          `json:"role"`
+			Parts json.RawMessage `json:"parts"`
+		} `json:"contents"`
+	}
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return jsonResponse(
+				`{"candidates":[{"content":{"parts":[{"text":"a cat"}]},"finishReason":"STOP"}]}`,
+			), nil
+		}),
+	}
+	google := providers.NewGoogle([]string{"test-key"})
+
+	t.Run("should send only the image", func(t *testing.T) {
+		res, err := google.CompleteResponse(
+			context.Background(),
+			request.Completion{
+				Model: models.Gemini25Flash{
+					ImageFile: []models.GoogleImagePayload{
+						{MimeType: "image/png", Data: "aGVsbG8="},
+					},
+				},
+			},
+			client,
+			&response.Logging{},
+		)
+		require.NoError(t, err)
+
+		require.Len(t, body.Contents, 1)
+		assert.Equal(t, "user", body.Contents[0].Role)
+		assert.JSONEq(t, `[
+			{"inline_data":{"mime_type":"image/png","data":"aGVsbG8="}}
+		]`, string(body.Contents[0].Parts))
+		assert.Equal(t, "a cat", res.Content)
+	})
+
+	t.Run("should still require a user message without media", func(t *testing.T) {
+		_, err := google.CompleteResponse(
+			context.Background(),
+			request.Completion{Model: models.Gemini25Flash{}},
+			client,
+			&response.Logging{},
+		)
+		require.Error(t, err)
+	})
+}
+
+func TestGoogleContentFiltered(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		event   string
+		reason  string
+		content string
+	}{
+		"should report a blocked prompt": {
+			event:  `{"promptFeedback":{"blockReason":"PROHIBITED_CONTENT"}}`,
+			reason: "PROHIBITED_CONTENT",
+		},
+		"should report a candidate stopped for safety": {
+			event:   `{"candidates":[{"content":{"parts":[{"text":"partial"}]},"finishReason":"SAFETY"}]}`,
+			reason:  "SAFETY",
+			content: "partial",
+		},
+		"should report a candidate stopped for recitation": {
+			event:   `{"candidates":[{"content":{"parts":[{"text":"partial"}]},"finishReason":"RECITATION"}]}`,
+			reason:  "RECITATION",
+			content: "partial",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					return jsonResponse(tt.event), nil
+				}),
+			}
+			google := providers.NewGoogle([]string{"test-key"})
+
+			_, err := google.CompleteResponse(
+				context.Background(),
+				request.Completion{
+					Model:         models.Gemini25FlashLite{},
+					SystemMessage: "you are a helpful assistant.",
+					UserMessage:   "Say hello in one sentence.",
+					Tags: map[string]string{
+						"type": "testing",
+					},
+				},
+				client,
+				nil,
+			)
+
+			var filtered *response.ContentFilteredError
+			require.ErrorAs(t, err, &filtered)
+			assert.Equal(t, tt.reason, filtered.Reason)
+			assert.Equal(t, tt.content, filtered.Content)
+		})
+	}
+}
+
+func TestGoogleMultipleCandidates(t *testing.T) {
+	t.Parallel()
+
+	var body map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"candidates":[{"content":{"parts":[{"text":"Hel"}]},"index":0},`+
+					`{"content":{"parts":[{"text":"Hi"}]},"index":1}]}`,
+				`{"candidates":[{"content":{"parts":[{"text":" there"}]},"index":1}]}`,
+				`{"candidates":[{"content":{"parts":[{"text":"lo"}]},"finishReason":"STOP","index":0},`+
+					`{"content":{"parts":[{"text":"!"}]},"finishReason":"STOP","index":1}]}`,
+			), nil
+		}),
+	}
+	google := providers.NewGoogle([]string{"test-key"})
+
+	res, err := google.StreamResponse(
+		context.Background(),
+		client,
+		request.Completion{
+			Model:          models.Gemini25FlashLite{},
+			SystemMessage:  "you are a helpful assistant.",
+			UserMessage:    "Say hello in one sentence.",
+			CandidateCount: 2,
+			Tags: map[string]string{
+				"type": "testing",
+			},
+		},
+		func(chunk string) error { return nil },
+		nil,
+	)
+	require.NoError(t, err)
+
+	generationConfig := body["generationConfig"].(map[string]any)
+	assert.Equal(t, float64(2), generationConfig["candidateCount"])
+	assert.Equal(t, []string{"Hello", "Hi there!"}, res.Choices)
+	assert.Equal(t, "Hello", res.Content)
+}
+
+func TestGoogleEndpoints(t *testing.T) {
+	t.Parallel()
+
+	req := request.Completion{
+		Model:         models.Gemini25Flash{},
+		SystemMessage: "you are a helpful assistant.",
+		UserMessage:   "Say hello in one sentence.",
+	}
+
+	t.Run("should fetch a completion whole from generateContent", func(t *testing.T) {
+		t.Parallel()
+
+		var url string
+		client := http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				url = r.URL.String()
+				return jsonResponse(
+					`{"candidates":[{"content":{"parts":[{"text":"pondering","thought":true},` +
+						`{"text":"hello"}]},"finishReason":"STOP"}],` +
+						`"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":2,"totalTokenCount":12}}`,
+				), nil
+			}),
+		}
+		google := providers.NewGoogle([]string{"test-key"})
+
+		res, err := google.CompleteResponse(context.Background(), req, client, &response.Logging{})
+		require.NoError(t, err)
+
+		assert.Contains(t, url, "/models/gemini-2.5-flash:generateContent")
+		assert.NotContains(t, url, "alt=sse")
+		assert.Equal(t, "hello", res.Content)
+		assert.Equal(t, "pondering", res.Thoughts)
+		assert.Equal(t, 12, res.Usage.TotalTokens)
+	})
+
+	t.Run("should report usage for a completion cut off at the token limit", func(t *testing.T) {
+		t.Parallel()
+
+		client := http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				return jsonResponse(
+					`{"candidates":[{"content":{"parts":[{"text":"hel"}]},"finishReason":"MAX_TOKENS"}],` +
+						`"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":1,"totalTokenCount":11,` +
+						`"cachedContentTokenCount":8}}`,
+				), nil
+			}),
+		}
+		google := providers.NewGoogle([]string{"test-key"})
+
+		res, err := google.CompleteResponse(context.Background(), req, client, &response.Logging{})
+		require.NoError(t, err)
+
+		assert.Equal(t, "hel", res.Content)
+		assert.Equal(t, 11, res.Usage.TotalTokens)
+		assert.Equal(t, 8, res.Usage.CachedTokens)
+		assert.Equal(t, response.FinishLength, res.FinishReason)
+		assert.True(t, res.Truncated())
+	})
+
+	t.Run("should report usage for a stream cut off at the token limit", func(t *testing.T) {
+		t.Parallel()
+
+		client := http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				return sseResponse(
+					`{"candidates":[{"content":{"parts":[{"text":"hel"}]}}],`+
+						`"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":1,"totalTokenCount":11}}`,
+					`{"candidates":[{"content":{"parts":[{"text":"lo"}]},"finishReason":"MAX_TOKENS"}],`+
+						`"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":2,"totalTokenCount":12}}`,
+				), nil
+			}),
+		}
+		google := providers.NewGoogle([]string{"test-key"})
+
+		res, err := google.StreamResponse(
+			context.Background(),
+			client,
+			req,
+			func(chunk string) error { return nil },
+			&response.Logging{},
+		)
+		require.NoError(t, err)
+
+		assert.Equal(t, "hello", res.Content)
+		assert.Equal(t, 12, res.Usage.TotalTokens)
+		assert.Equal(t, 2, res.Usage.CompletionTokens)
+		assert.Equal(t, response.FinishLength, res.FinishReason)
+	})
+
+	t.Run("should stream from streamGenerateContent", func(t *testing.T) {
+		t.Parallel()
+
+		var url string
+		client := http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				url = r.URL.String()
+				return sseResponse(
+					`{"candidates":[{"content":{"parts":[{"text":"hel"}]}}]}`,
+					`{"candidates":[{"content":{"parts":[{"text":"lo"}]},"finishReason":"STOP"}]}`,
+				), nil
+			}),
+		}
+		google := providers.NewGoogle([]string{"test-key"})
+
+		var collected providers.Collector
+		res, err := google.StreamResponse(
+			context.Background(),
+			client,
+			req,
+			collected.Handle,
+			&response.Logging{},
+		)
+		require.NoError(t, err)
+
+		assert.Contains(t, url, "/models/gemini-2.5-flash:streamGenerateContent")
+		assert.Contains(t, url, "alt=sse")
+		assert.Equal(t, []string{"hel", "lo"}, collected.Chunks())
+		assert.Equal(t, "hello", res.Content)
+	})
+}
+
+func TestGoogleRawMessages(t *testing.T) {
+	t.Parallel()
+
+	raw := `[{"role":"user","parts":[{"text":"Say hello in one sentence."}]}]`
+
+	var body struct {
+		Contents json.RawMessage `json:"contents"`
+	}
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return jsonResponse(
+				`{"candidates":[{"content":{"parts":[{"text":"hello"}]},"finishReason":"STOP"}]}`,
+			), nil
+		}),
+	}
+	google := providers.NewGoogle([]string{"test-key"})
+
+	res, err := google.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.Gemini25Flash{},
+			RawMessages: json.RawMessage(raw),
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.JSONEq(t, raw, string(body.Contents))
+	assert.Equal(t, "hello", res.Content)
+}
+
+func TestGoogleRetriesServerErrors(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if calls.Add(1) <= 2 {
+				return &http.Response{
+					StatusCode: http.StatusServiceUnavailable,
+					Body:       io.NopCloser(strings.NewReader(`{"error":{"code":503}}`)),
+				}, nil
+			}
+			return jsonResponse(
+				`{"candidates":[{"content":{"parts":[{"text":"hello"}]},"finishReason":"STOP"}]}`,
+			), nil
+		}),
+	}
+	google := providers.NewGoogle([]string{"test-key"})
+
+	res, err := google.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:         models.Gemini25Flash{},
+			SystemMessage: "you are a helpful assistant.",
+			UserMessage:   "Say hello in one sentence.",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello", res.Content)
+	assert.EqualValues(t, 3, calls.Load())
+}
+
+func TestGoogleWithoutAPIKeys(t *testing.T) {
+	t.Parallel()
+
+	provider := providers.NewGoogle(nil)
+	req := request.Completion{
+		Model:       models.Gemini25FlashLite{},
+		UserMessage: "Say hello in one sentence.",
+	}
+
+	_, err := provider.CompleteResponse(context.Background(), req, http.Client{}, nil)
+	require.ErrorIs(t, err, providers.ErrNoAPIKeys)
+
+	_, err = provider.StreamResponse(
+		context.Background(),
+		http.Client{},
+		req,
+		func(chunk string) error { return nil },
+		nil,
+	)
+	require.ErrorIs(t, err, providers.ErrNoAPIKeys)
+}
+
+func TestGoogleGemini25StableModels(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		model models.Model
+		want  string
+	}{
+		{
+			name:  "should request gemini-2.5-flash",
+			model: models.Gemini25Flash{},
+			want:  "/models/gemini-2.5-flash:",
+		},
+		{
+			name:  "should request gemini-2.5-pro",
+			model: models.Gemini25Pro{},
+			want:  "/models/gemini-2.5-pro:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path string
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					path = req.URL.Path
+					return jsonResponse(
+						`{"candidates":[{"content":{"parts":[{"text":"hello"}]},"finishReason":"STOP"}]}`,
+					), nil
+				}),
+			}
+			google := providers.NewGoogle([]string{"test-key"})
+
+			res, err := google.CompleteResponse(
+				context.Background(),
+				request.Completion{
+					Model:         tt.model,
+					SystemMessage: "you are a helpful assistant.",
+					UserMessage:   "Say hello in one sentence.",
+					Tags: map[string]string{
+						"type": "testing",
+					},
+				},
+				client,
+				nil,
+			)
+			require.NoError(t, err)
+
+			assert.Contains(t, path, tt.want)
+			assert.Equal(t, "hello", res.Content)
+			assert.Equal(t, models.GoogleProvider, res.Provider)
+		})
+	}
+}
+
+func TestGoogleGenerationConfig(t *testing.T) {
+	t.Parallel()
+
+	var sent struct {
+		GenerationConfig map[string]any `json:"generationConfig"`
+	}
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&sent); err != nil {
+				return nil, err
+			}
+			return jsonResponse(
+				`{"candidates":[{"content":{"parts":[{"text":"hello"}]},"finishReason":"STOP"}]}`,
+			), nil
+		}),
+	}
+	google := providers.NewGoogle([]string{"test-key"})
+
+	_, err := google.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model: models.Gemini25Flash{
+				StructuredOutput: map[string]any{"type": "object"},
+			},
+			SystemMessage: "you are a helpful assistant.",
+			UserMessage:   "Say hello in one sentence.",
+			Temperature:   0.5,
+			TopK:          40,
+			MaxTokens:     256,
+			StopSequences: []string{"END"},
+			Tags: map[string]string{
+				"type": "testing",
+			},
+		},
+		client,
+		nil,
+	)
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 40, sent.GenerationConfig["topK"])
+	assert.EqualValues(t, 256, sent.GenerationConfig["maxOutputTokens"])
+	assert.EqualValues(t, 0.5, sent.GenerationConfig["temperature"])
+	assert.Equal(t, []any{"END"}, sent.GenerationConfig["stopSequences"])
+	assert.Equal(t, "application/json", sent.GenerationConfig["response_mime_type"], "schema keys should be preserved")
+}
+
+func TestGoogleResponseMimeType(t *testing.T) {
+	t.Parallel()
+
+	labels := []string{"positive", "negative", "neutral"}
+
+	tests := map[string]struct {
+		model      models.Model
+		wantMime   string
+		wantSchema any
+	}{
+		"should classify into an enum": {
+			model: models.Gemini25Flash{
+				StructuredOutput: models.GoogleEnumSchema(labels...),
+				ResponseMimeType: models.GoogleMimeEnum,
+				Thinking:         models.LowThinkBudget,
+			},
+			wantMime:   "text/x.enum",
+			wantSchema: map[string]any{"type": "STRING", "enum": []any{"positive", "negative", "neutral"}},
+		},
+		"should default to json for a schema": {
+			model: models.Gemini3FlashPreview{
+				StructuredOutput: map[string]any{"type": "STRING"},
+			},
+			wantMime:   "application/json",
+			wantSchema: map[string]any{"type": "STRING"},
+		},
+		"should send a mime type without a schema": {
+			model: models.Gemini20Flash{
+				ResponseMimeType: models.GoogleMimeText,
+			},
+			wantMime: "text/plain",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var sent struct {
+				GenerationConfig map[string]any `json:"generationConfig"`
+			}
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					if err := json.NewDecoder(req.Body).Decode(&sent); err != nil {
+						return nil, err
+					}
+					return jsonResponse(
+						`{"candidates":[{"content":{"parts":[{"text":"positive"}]},"finishReason":"STOP"}]}`,
+					), nil
+				}),
+			}
+			google := providers.NewGoogle([]string{"test-key"})
+
+			res, err := google.CompleteResponse(
+				context.Background(),
+				request.Completion{
+					Model:       tt.model,
+					UserMessage: "Classify the sentiment: I love this library.",
+					Temperature: 0.5,
+					Tags:        map[string]string{},
+				},
+				client,
+				nil,
+			)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantMime, sent.GenerationConfig["response_mime_type"])
+			assert.Equal(t, tt.wantSchema, sent.GenerationConfig["response_schema"])
+			assert.EqualValues(t, 0.5, sent.GenerationConfig["temperature"], "other keys should be preserved")
+			assert.Contains(t, labels, res.Content)
+		})
+	}
+}
+
+func TestGoogleThinkingKeepsResponseSchema(t *testing.T) {
+	t.Parallel()
+
+	var sent struct {
+		GenerationConfig map[string]any `json:"generationConfig"`
+	}
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&sent); err != nil {
+				return nil, err
+			}
+			return jsonResponse(
+				`{"candidates":[{"content":{"parts":[{"text":"{}"}]},"finishReason":"STOP"}]}`,
+			), nil
+		}),
+	}
+	google := providers.NewGoogle([]string{"test-key"})
+
+	_, err := google.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model: models.Gemini25Pro{
+				StructuredOutput: map[string]any{"type": "object"},
+				Thinking:         models.MediumThinkBudget,
+			},
+			UserMessage: "Say hello in one sentence.",
+			Tags:        map[string]string{},
+		},
+		client,
+		nil,
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "application/json", sent.GenerationConfig["response_mime_type"])
+	assert.Equal(t, map[string]any{"type": "object"}, sent.GenerationConfig["response_schema"])
+	assert.Contains(t, sent.GenerationConfig, "thinkingConfig")
+}
+
+func TestGoogleWithLogger(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return jsonResponse(
+				`{"candidates":[{"content":{"parts":[{"text":"hello"}]},"finishReason":"STOP"}]}`,
+			), nil
+		}),
+	}
+	google := providers.NewGoogle([]string{"test-key"}, providers.WithLogger(logger))
+
+	_, err := google.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:         models.Gemini25Flash{},
+			SystemMessage: "you are a helpful assistant.",
+			UserMessage:   "Say hello in one sentence.",
+			Tags: map[string]string{
+				"type": "testing",
+			},
+		},
+		client,
+		nil,
+	)
+	require.NoError(t, err)
+
+	assert.Contains(t, logs.String(), "google response received")
+	assert.NotContains(t, logs.String(), "test-key", "api keys should never be logged")
+}
+
+func TestGoogleImageGeneration(t *testing.T) {
+	t.Parallel()
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return jsonResponse(`{
+				"candidates":[{"content":{"parts":[
+					{"text":"Here is your lighthouse."},
+					{"inlineData":{"mimeType":"image/png","data":"cG5nLWJ5dGVz"}},
+					{"inlineData":{"mimeType":"image/jpeg","data":"anBlZy1ieXRlcw=="}}
+				]}}],
+				"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":1290,"totalTokenCount":1295}
+			}`), nil
+		}),
+	}
+	google := providers.NewGoogle([]string{"test-key"})
+
+	res, err := google.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       &models.Gemini25FlashImage{},
+			UserMessage: "A lighthouse at dusk.",
+			Tags:        map[string]string{},
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, []response.Image{
+		{MimeType: "image/png", Data: []byte("png-bytes")},
+		{MimeType: "image/jpeg", Data: []byte("jpeg-bytes")},
+	}, res.Images)
+	assert.Equal(t, "cG5nLWJ5dGVz", res.Content)
+	assert.Equal(t, 1295, res.Usage.TotalTokens)
+}
@@ -4,14 +4,11 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/rand"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/flyx-ai/heimdall/models"
@@ -21,13 +18,72 @@ import (
 
 const grokBaseURL = "https://api.x.ai/v1"
 
+type grokChatRequest struct {
+	openAIRequest
+	SearchParameters *grokSearchParameters `json:"search_parameters,omitempty"`
+}
+
+type grokSearchParameters struct {
+	Mode             string             `json:"mode,omitempty"`
+	Sources          []grokSearchSource `json:"sources,omitempty"`
+	MaxSearchResults int                `json:"max_search_results,omitempty"`
+	FromDate         string             `json:"from_date,omitempty"`
+	ToDate           string             `json:"to_date,omitempty"`
+	ReturnCitations  bool               `json:"return_citations"`
+}
+
+type grokSearchSource struct {
+	Type             string   `json:"type"`
+	Country          string   `json:"country,omitempty"`
+	AllowedWebsites  []string `json:"allowed_websites,omitempty"`
+	ExcludedWebsites []string `json:"excluded_websites,omitempty"`
+	XHandles         []string `json:"included_x_handles,omitempty"`
+	Links            []string `json:"links,omitempty"`
+}
+
+type grokChunk struct {
+	openAIChunk
+	Citations []string `json:"citations"`
+}
+
+// newGrokSearchParameters converts the model's Live Search settings, or
+// returns nil when search is not configured.
+func newGrokSearchParameters(search models.GrokSearch) *grokSearchParameters {
+	if search.Mode == "" {
+		return nil
+	}
+
+	params := &grokSearchParameters{
+		Mode:             search.Mode,
+		MaxSearchResults: search.MaxResults,
+		ReturnCitations:  true,
+	}
+	for _, source := range search.Sources {
+		params.Sources = append(params.Sources, grokSearchSource(source))
+	}
+	if !search.FromDate.IsZero() {
+		params.FromDate = search.FromDate.Format(time.DateOnly)
+	}
+	if !search.ToDate.IsZero() {
+		params.ToDate = search.ToDate.Format(time.DateOnly)
+	}
+
+	return params
+}
+
 type Grok struct {
 	apiKeys []string
+	keys    *KeyDistributor
+	opts    options
 }
 
-func NewGrok(apiKeys []string) Grok {
+func NewGrok(apiKeys []string, opts ...Option) Grok {
+	o := newOptions(opts)
+
 	return Grok{
 		apiKeys: apiKeys,
+		keys:    o.keyDistributor(apiKeys),
+		opts:    o,
 	}
 }
 
@@ -35,6 +91,36 @@ func (g Grok) Name() string {
 	return models.GrokProvider
 }
 
+// Supports implements LLMProvider. Any Grok model is served, including ones
+// without a dedicated type, which are sent as plain chat messages.
+func (g Grok) Supports(m models.Model) bool {
+	return m != nil && m.GetProvider() == g.Name()
+}
+
+// SupportsPrefill implements LLMProvider. The chat API answers a trailing
+// assistant turn afresh rather than continuing it.
+func (g Grok) SupportsPrefill() bool {
+	return false
+}
+
+// Validate checks every API key against the API concurrently and returns the
+// outcome per key, nil meaning the key works. Keys the API rejects are
+// excluded from later requests.
+func (g Grok) Validate(ctx context.Context) map[string]error {
+	return validateKeys(ctx, g.apiKeys, g.keys, func(ctx context.Context, key string) (int, error) {
+		return pingURL(ctx, g.opts, grokBaseURL+"/models", func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer "+key)
+		})
+	})
+}
+
+// RemainingQuota returns the number of requests the provider's keys can still
+// make and when the earliest rate-limit window resets, as last reported by
+// the API and reduced by requests dispatched since.
+func (g Grok) RemainingQuota() (requests uint32, resetAt time.Time) {
+	return g.keys.RemainingQuota()
+}
+
 func (g Grok) doRequest(
 	ctx context.Context,
 	req request.Completion,
@@ -42,6 +128,12 @@ func (g Grok) doRequest(
 	chunkHandler func(chunk string) error,
 	key string,
 ) (response.Completion, int, error) {
+	key, release, err := g.keys.Acquire(ctx, key)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	defer release()
+
 	model := req.Model.GetName()
 
 	grokRequest := openAIRequest{
@@ -49,24 +141,31 @@ func (g Grok) doRequest(
 		Stream:        true,
 		StreamOptions: streamOptions{IncludeUsage: true},
 		Temperature:   1.0,
-	}
+	}.withSampling(req)
 
 	var structuredOutput map[string]any
+	var search models.GrokSearch
 	switch m := req.Model.(type) {
 	case models.Grok2Vision:
 		structuredOutput = m.StructuredOutput
 	case models.Grok3:
 		structuredOutput = m.StructuredOutput
+		search = m.Search
 	case models.Grok3Mini:
 		structuredOutput = m.StructuredOutput
+		search = m.Search
 	case models.Grok3Fast:
 		structuredOutput = m.StructuredOutput
+		search = m.Search
 	case models.Grok3MiniFast:
 		structuredOutput = m.StructuredOutput
+		search = m.Search
 	case models.Grok4:
 		structuredOutput = m.StructuredOutput
+		search = m.Search
 	case models.Grok4Fast:
 		structuredOutput = m.StructuredOutput
+		search = m.Search
 	}
 
 	if len(structuredOutput) > 0 {
@@ -87,10 +186,28 @@ func (g Grok) doRequest(
 		return response.Completion{}, 0, err
 	}
 
-	body, err := json.Marshal(request)
+	body, err := json.Marshal(grokChatRequest{
+		openAIRequest:    request,
+		SearchParameters: newGrokSearchParameters(search),
+	})
 	if err != nil {
 		return response.Completion{}, 0, err
 	}
+	body, err = withRawMessages(body, "messages", req.RawMessages)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	body, err = withExtraBody(body, req.ExtraBody)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	if err := g.opts.checkRequestSize(body); err != nil {
+		return response.Completion{}, 0, err
+	}
+
+	// Stop upstream generation if we return before the stream is drained.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	httpReq, err := http.NewRequestWithContext(ctx, "POST",
 		fmt.Sprintf("%s/chat/completions", grokBaseURL),
@@ -102,6 +219,8 @@ func (g Grok) doRequest(
 		)
 	}
 
+	g.opts.setHeaders(httpReq)
+
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Authorization", "Bearer "+key)
 
@@ -111,21 +230,29 @@ func (g Grok) doRequest(
 	}
 	defer resp.Body.Close()
 
+	g.keys.Observe(key, resp.Header)
+
 	if resp.StatusCode != http.StatusOK {
-		return response.Completion{}, resp.StatusCode, errors.New(
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return response.Completion{}, resp.StatusCode, withBody(errors.New(
 			"received non-200 status code",
-		)
+		), bodyBytes)
 	}
 
-	reader := bufio.NewReader(resp.Body)
-	var fullContent strings.Builder
+	stream := newStreamBody(ctx, g.opts.limitResponse(resp.Body))
+	defer stream.Close()
+
+	reader := bufio.NewReader(stream)
+	replays := replayFilter{enabled: req.DedupeChunks}
+	fullContent := g.opts.newContent()
 	var usage response.Usage
+	var citations []string
 	var rawEvents []json.RawMessage
 	chunks := 0
 	now := time.Now()
 
 	for {
-		if chunks == 0 && time.Since(now).Seconds() > 3.0 {
+		if chunks == 0 && g.opts.firstChunkTimedOut(now) {
 			return response.Completion{}, 0, context.Canceled
 		}
 		line, err := reader.ReadString('\n')
@@ -139,13 +266,15 @@ func (g Grok) doRequest(
 			)
 		}
 
-		line = strings.TrimPrefix(line, "data: ")
-		line = strings.TrimSpace(line)
-		if line == "" || line == "[DONE]" {
+		if replays.replayed(line) {
+			continue
+		}
+		line, ok := sseData(line)
+		if !ok {
 			continue
 		}
 
-		var chunk openAIChunk
+		var chunk grokChunk
 		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
 			return response.Completion{}, 0, fmt.Errorf(
 				"unmarshal chunk: %w",
@@ -155,14 +284,22 @@ func (g Grok) doRequest(
 
 		rawEvents = append(rawEvents, json.RawMessage(line))
 
+		// Citations arrive once the answer is complete, on the last chunk.
+		if len(chunk.Citations) > 0 {
+			citations = chunk.Citations
+		}
+
 		if len(chunk.Choices) > 0 {
-			fullContent.WriteString(chunk.Choices[0].Delta.Content)
+			text, limitErr := fullContent.add(chunk.Choices[0].Delta.Content)
 
 			if chunkHandler != nil {
-				if err := chunkHandler(chunk.Choices[0].Delta.Content); err != nil {
+				if err := chunkHandler(text); err != nil {
 					return response.Completion{}, 0, err
 				}
 			}
+			if limitErr != nil {
+				return fullContent.partial(req.Model.GetName(), g.Name()), 0, limitErr
+			}
 		}
 
 		chunks++
@@ -171,6 +308,8 @@ func (g Grok) doRequest(
 				PromptTokens:     chunk.Usage.PromptTokens,
 				CompletionTokens: chunk.Usage.CompletionTokens,
 				TotalTokens:      chunk.Usage.TotalTokens,
+				CachedTokens:     chunk.Usage.PromptTokensDetails.CachedTokens,
+				ReasoningTokens:  chunk.Usage.CompletionTokensDetails.ReasoningTokens,
 			}
 		}
 	}
@@ -180,13 +319,15 @@ func (g Grok) doRequest(
 		return response.Completion{}, 0, fmt.Errorf("marshal raw response events: %w", err)
 	}
 
-	return response.Completion{
+	return finishStream(req, chunkHandler, g.opts.sampleRaw(ctx, response.Completion{
 		Content:     fullContent.String(),
 		Model:       req.Model.GetName(),
+		Provider:    g.Name(),
+		Citations:   citations,
 		Usage:       usage,
 		RawRequest:  body,
 		RawResponse: rawResp,
-	}, 0, nil
+	}))
 }
 
 func (g Grok) tryWithBackup(
@@ -196,6 +337,10 @@ func (g Grok) tryWithBackup(
 	chunkHandler func(chunk string) error,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if len(g.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
 	key := g.apiKeys[0]
 
 	maxRetries := 5
@@ -203,6 +348,9 @@ func (g Grok) tryWithBackup(
 	maxBackoff := 10 * time.Second
 
 	var lastErr error
+	var lastStatusCode int
+	start := g.opts.now()
+	backoffStep := 0
 	for attempt := range maxRetries {
 		requestLog.Events = append(requestLog.Events, response.Event{
 			Timestamp: time.Now(),
@@ -223,16 +371,24 @@ func (g Grok) tryWithBackup(
 			})
 			return response.Completion{}, ctx.Err()
 		default:
+			var progress streamProgress
 			res, resCode, err := g.doRequest(
 				ctx,
 				req,
-				client,
-				chunkHandler,
+				progress.client(client),
+				progress.wrap(chunkHandler),
 				key,
 			)
 			if err == nil {
 				return res, nil
 			}
+			dropped, delivered := progress.failed(ctx, err, requestLog)
+			if delivered {
+				return response.Completion{}, err
+			}
+			if dropped {
+				backoffStep = 0
+			}
 			requestLog.Events = append(requestLog.Events, response.Event{
 				Timestamp: time.Now(),
 				Description: fmt.Sprintf(
@@ -241,7 +397,7 @@ func (g Grok) tryWithBackup(
 				),
 			})
 
-			if !isRetryableError(resCode) {
+			if !dropped && !g.opts.retryable(resCode, err) {
 				requestLog.Events = append(requestLog.Events, response.Event{
 					Timestamp: time.Now(),
 					Description: fmt.Sprintf(
@@ -249,39 +405,34 @@ func (g Grok) tryWithBackup(
 						err,
 					),
 				})
-				return response.Completion{}, err
+				return res, err
 			}
 
 			lastErr = err
+			lastStatusCode = resCode
 
 			backoff := min(initialBackoff*time.Duration(
-				1<<attempt,
+				1<<backoffStep,
 			), maxBackoff)
+			backoffStep++
 
-			var randomBytes [8]byte
-			var jitter time.Duration
-			if _, err := rand.Read(randomBytes[:]); err != nil {
-				jitter = backoff
-			} else {
-				randFloat := float64(binary.LittleEndian.Uint64(randomBytes[:])) / (1 << 64)
-				jitter = time.Duration(float64(backoff) * (0.8 + 0.4*randFloat))
-			}
-
-			timer := time.NewTimer(jitter)
+			timer := g.opts.newTimer(g.opts.jitter(backoff))
 			select {
 			case <-ctx.Done():
 				timer.Stop()
 				return response.Completion{}, ctx.Err()
-			case <-timer.C:
+			case <-timer.C():
 				continue
 			}
 		}
 	}
 
-	return response.Completion{}, fmt.Errorf(
-		"max retries exceeded: %w",
-		lastErr,
-	)
+	return response.Completion{}, &RetryExhaustedError{
+		Attempts:       maxRetries,
+		Elapsed:        g.opts.now().Sub(start),
+		LastStatusCode: lastStatusCode,
+		Err:            lastErr,
+	}
 }
 
 func (g Grok) CompleteResponse(
@@ -290,6 +441,10 @@ func (g Grok) CompleteResponse(
 	client http.Client,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if len(g.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
 	reqLog := &response.Logging{}
 	if requestLog == nil {
 		req.Tags["request_type"] = "completion"
@@ -311,6 +466,12 @@ func (g Grok) CompleteResponse(
 	}
 
 	for i, key := range g.apiKeys {
+		select {
+		case <-ctx.Done():
+			return response.Completion{}, ctx.Err()
+		default:
+		}
+
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
 			Description: fmt.Sprintf(
@@ -322,6 +483,9 @@ func (g Grok) CompleteResponse(
 		if err == nil {
 			return res, nil
 		}
+		if exceedsSizeLimit(err) {
+			return res, err
+		}
 
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
@@ -342,6 +506,14 @@ func (g Grok) StreamResponse(
 	chunkHandler func(chunk string) error,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if !streams(req.Model) {
+		return completeAsStream(ctx, g, client, req, chunkHandler, requestLog)
+	}
+
+	if len(g.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
 	reqLog := &response.Logging{}
 	if requestLog == nil {
 		req.Tags["request_type"] = "streaming"
@@ -362,7 +534,15 @@ func (g Grok) StreamResponse(
 		reqLog = requestLog
 	}
 
+	chunkHandler, delivered := trackDelivery(chunkHandler)
+
 	for i, key := range g.apiKeys {
+		select {
+		case <-ctx.Done():
+			return response.Completion{}, ctx.Err()
+		default:
+		}
+
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
 			Description: fmt.Sprintf(
@@ -374,6 +554,12 @@ func (g Grok) StreamResponse(
 		if err == nil {
 			return res, nil
 		}
+		if exceedsSizeLimit(err) {
+			return res, err
+		}
+		if delivered() {
+			return response.Completion{}, err
+		}
 
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
@@ -468,13 +654,15 @@ func prepareGrokVisionRequest(
 		)
 	}
 
-	reqMsgWithImage[lastIndex].Content = append(
-		reqMsgWithImage[lastIndex].Content,
-		fileInputMessage{
-			Type: "text",
-			Text: userMsg,
-		},
-	)
+	if userMsg != "" {
+		reqMsgWithImage[lastIndex].Content = append(
+			reqMsgWithImage[lastIndex].Content,
+			fileInputMessage{
+				Type: "text",
+				Text: userMsg,
+			},
+		)
+	}
 
 	request.Messages = reqMsgWithImage
 	return request, nil
@@ -2,6 +2,7 @@ package providers_test
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"os"
 	"testing"
@@ -10,6 +11,7 @@ import (
 	"github.com/flyx-ai/heimdall/models"
 	"github.com/flyx-ai/heimdall/providers"
 	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -71,20 +73,17 @@ func TestGrokModelsWithStreaming(t *testing.T) {
 		},
 	}
 
-	var chunks []string
+	var collected providers.Collector
 	res, err := grokProvider.StreamResponse(
 		context.Background(),
 		client,
 		req,
-		func(chunk string) error {
-			chunks = append(chunks, chunk)
-			return nil
-		},
+		collected.Handle,
 		nil,
 	)
 	require.NoError(t, err, "StreamResponse returned an unexpected error")
 	assert.NotEmpty(t, res.Content, "Expected non-empty content")
-	assert.NotEmpty(t, chunks, "Expected streaming chunks")
+	assert.NotEmpty(t, collected.Chunks(), "Expected streaming chunks")
 }
 
 func TestGrokErrorHandling(t *testing.T) {
@@ -113,3 +112,100 @@ func TestGrokErrorHandling(t *testing.T) {
 	)
 	require.Error(t, err, "Expected error with invalid API key")
 }
+
+func TestGrokWithoutAPIKeys(t *testing.T) {
+	t.Parallel()
+
+	provider := providers.NewGrok(nil)
+	req := request.Completion{
+		Model:       models.Grok3Mini{},
+		UserMessage: "Say hello in one sentence.",
+	}
+
+	_, err := provider.CompleteResponse(context.Background(), req, http.Client{}, nil)
+	require.ErrorIs(t, err, providers.ErrNoAPIKeys)
+
+	_, err = provider.StreamResponse(
+		context.Background(),
+		http.Client{},
+		req,
+		func(chunk string) error { return nil },
+		nil,
+	)
+	require.ErrorIs(t, err, providers.ErrNoAPIKeys)
+}
+
+func TestGrokLiveSearch(t *testing.T) {
+	t.Parallel()
+
+	var body map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"It rained."}}]}`,
+				`{"choices":[],"citations":["https://x.com/weather/status/1","https://example.com/forecast"]}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	grok := providers.NewGrok([]string{"test-key"})
+
+	res, err := grok.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model: models.Grok4{
+				Search: models.GrokSearch{
+					Mode:       "on",
+					Sources:    []models.GrokSearchSource{{Type: "x", XHandles: []string{"weather"}}},
+					MaxResults: 5,
+					FromDate:   time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+			UserMessage: "What was the weather yesterday?",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]any{
+		"mode":               "on",
+		"sources":            []any{map[string]any{"type": "x", "included_x_handles": []any{"weather"}}},
+		"max_search_results": float64(5),
+		"from_date":          "2025-07-01",
+		"return_citations":   true,
+	}, body["search_parameters"])
+	assert.Equal(t, "It rained.", res.Content)
+	assert.Equal(t, []string{"https://x.com/weather/status/1", "https://example.com/forecast"}, res.Citations)
+}
+
+func TestGrokWithoutLiveSearch(t *testing.T) {
+	t.Parallel()
+
+	var body map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(`{"choices":[{"delta":{"content":"hi"}}]}`, "[DONE]"), nil
+		}),
+	}
+	grok := providers.NewGrok([]string{"test-key"})
+
+	_, err := grok.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.Grok4{},
+			UserMessage: "Say hello in one sentence.",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.NotContains(t, body, "search_parameters")
+}
@@ -0,0 +1,204 @@
+package providers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+)
+
+// TeeChunkHandler returns a chunk handler that forwards every chunk to each of
+// the given handlers in order. It stops at the first handler that returns an
+// error and returns that error, so later handlers do not see the chunk.
+//
+// Handlers are called synchronously on the streaming goroutine: a slow handler
+// blocks the stream (and every handler after it) until it returns.
+func TeeChunkHandler(handlers ...func(chunk string) error) func(chunk string) error {
+	return func(chunk string) error {
+		for _, handler := range handlers {
+			if handler == nil {
+				continue
+			}
+			if err := handler(chunk); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// WriterHandler returns a chunk handler that writes every chunk to w. A write
+// that stores fewer bytes than the chunk fails with io.ErrShortWrite, and w is
+// flushed after each chunk when it implements http.Flusher, so streamed HTTP
+// responses reach the client as they are generated.
+func WriterHandler(w io.Writer) func(chunk string) error {
+	flusher, _ := w.(http.Flusher)
+
+	return func(chunk string) error {
+		n, err := io.WriteString(w, chunk)
+		if err != nil {
+			return err
+		}
+		if n < len(chunk) {
+			return io.ErrShortWrite
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		return nil
+	}
+}
+
+// Collector gathers the chunks of a stream, for tests and callers that need
+// the assembled text once the stream ends. Pass its Handle method as the
+// chunk handler. The zero value is ready to use and it is safe for
+// concurrent use.
+type Collector struct {
+	mu     sync.Mutex
+	chunks []string
+}
+
+// Handle records chunk. It never fails.
+func (c *Collector) Handle(chunk string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.chunks = append(c.chunks, chunk)
+
+	return nil
+}
+
+// Chunks returns a copy of the chunks received so far, in order.
+func (c *Collector) Chunks() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.chunks)
+}
+
+// String returns the chunks received so far joined into one string.
+func (c *Collector) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return strings.Join(c.chunks, "")
+}
+
+// Boundary selects where BoundaryHandler splits buffered text.
+type Boundary int
+
+const (
+	// BoundaryWord flushes up to and including the last whitespace, so no
+	// word is split across chunks.
+	BoundaryWord Boundary = iota
+	// BoundarySentence flushes up to the last whitespace following a '.',
+	// '!' or '?' (optionally followed by closing quotes or brackets), or up
+	// to the last newline.
+	BoundarySentence
+)
+
+// BoundaryHandler returns a chunk handler that buffers deltas and passes them
+// to inner only once they end on the given boundary, for consumers such as
+// text-to-speech that must not receive partial words or sentences. The
+// returned flush passes on whatever is left in the buffer and must be called
+// when the stream ends, typically from the request's OnComplete callback.
+// Neither function is safe for concurrent use.
+func BoundaryHandler(
+	inner func(chunk string) error,
+	boundary Boundary,
+) (handler func(chunk string) error, flush func() error) {
+	var buf strings.Builder
+
+	handler = func(chunk string) error {
+		buf.WriteString(chunk)
+
+		pending := buf.String()
+		end := boundaryEnd(pending, boundary)
+		if end == 0 {
+			return nil
+		}
+
+		buf.Reset()
+		buf.WriteString(pending[end:])
+
+		return inner(pending[:end])
+	}
+
+	flush = func() error {
+		if buf.Len() == 0 {
+			return nil
+		}
+
+		rest := buf.String()
+		buf.Reset()
+
+		return inner(rest)
+	}
+
+	return handler, flush
+}
+
+// boundaryEnd returns the length of the longest prefix of s that ends on the
+// boundary, or 0 when there is none yet.
+func boundaryEnd(s string, boundary Boundary) int {
+	end := 0
+	var prev rune
+	for i, r := range s {
+		if !unicode.IsSpace(r) {
+			if !strings.ContainsRune(`"')]”’`, r) {
+				prev = r
+			}
+			continue
+		}
+
+		switch {
+		case boundary == BoundaryWord, r == '\n':
+			end = i + utf8.RuneLen(r)
+		case prev == '.' || prev == '!' || prev == '?':
+			end = i + utf8.RuneLen(r)
+		}
+		prev = r
+	}
+
+	return end
+}
+
+// StreamResponseTo streams the response from provider into w and returns the
+// final completion.
+func StreamResponseTo(
+	ctx context.Context,
+	provider LLMProvider,
+	client http.Client,
+	req request.Completion,
+	w io.Writer,
+	requestLog *response.Logging,
+) (response.Completion, error) {
+	return provider.StreamResponse(ctx, client, req, WriterHandler(w), requestLog)
+}
+
+// finishStream passes a response whose stream finished cleanly to the
+// request's OnComplete callback. Non-streaming calls, which have no chunk
+// handler, skip the callback.
+func finishStream(
+	req request.Completion,
+	chunkHandler func(chunk string) error,
+	res response.Completion,
+) (response.Completion, int, error) {
+	if chunkHandler != nil && req.OnComplete != nil {
+		if err := req.OnComplete(res); err != nil {
+			return response.Completion{}, 0, err
+		}
+	}
+
+	return res, 0, nil
+}
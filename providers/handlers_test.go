@@ -0,0 +1,235 @@
+package providers_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/providers"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTeeChunkHandler(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should forward every chunk to all handlers", func(t *testing.T) {
+		var first, second []string
+		handler := providers.TeeChunkHandler(
+			func(chunk string) error {
+				first = append(first, chunk)
+				return nil
+			},
+			nil,
+			func(chunk string) error {
+				second = append(second, chunk)
+				return nil
+			},
+		)
+
+		require.NoError(t, handler("hello"))
+		require.NoError(t, handler(" world"))
+
+		assert.Equal(t, []string{"hello", " world"}, first)
+		assert.Equal(t, []string{"hello", " world"}, second)
+	})
+
+	t.Run("should stop on the first error", func(t *testing.T) {
+		errStop := errors.New("stop")
+		var reached bool
+		handler := providers.TeeChunkHandler(
+			func(chunk string) error {
+				return errStop
+			},
+			func(chunk string) error {
+				reached = true
+				return nil
+			},
+		)
+
+		require.ErrorIs(t, handler("hello"), errStop)
+		assert.False(t, reached, "handlers after a failing one should not be called")
+	})
+}
+
+func TestCollector(t *testing.T) {
+	t.Parallel()
+
+	var collected providers.Collector
+	assert.Empty(t, collected.String())
+
+	require.NoError(t, collected.Handle("hel"))
+	require.NoError(t, collected.Handle("lo"))
+
+	assert.Equal(t, "hello", collected.String())
+	chunks := collected.Chunks()
+	assert.Equal(t, []string{"hel", "lo"}, chunks)
+
+	chunks[0] = "changed"
+	assert.Equal(t, []string{"hel", "lo"}, collected.Chunks(), "Chunks should return a copy")
+}
+
+func TestBoundaryHandler(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		boundary providers.Boundary
+		deltas   []string
+		want     []string
+	}{
+		"should flush whole words": {
+			boundary: providers.BoundaryWord,
+			deltas:   []string{"Hel", "lo wo", "rld, how", " are", " you"},
+			want:     []string{"Hello ", "world, ", "how ", "are ", "you"},
+		},
+		"should flush whole sentences": {
+			boundary: providers.BoundarySentence,
+			deltas:   []string{"It costs 3.", "50 today. Bu", "y now! \"Really?\" ", "Yes"},
+			want:     []string{"It costs 3.50 today. ", "Buy now! \"Really?\" ", "Yes"},
+		},
+		"should flush on newlines": {
+			boundary: providers.BoundarySentence,
+			deltas:   []string{"- one\n- tw", "o"},
+			want:     []string{"- one\n", "- two"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var got []string
+			handler, flush := providers.BoundaryHandler(func(chunk string) error {
+				got = append(got, chunk)
+				return nil
+			}, tt.boundary)
+
+			for _, delta := range tt.deltas {
+				require.NoError(t, handler(delta))
+			}
+			require.NoError(t, flush())
+			require.NoError(t, flush())
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("should return the inner handler's error", func(t *testing.T) {
+		t.Parallel()
+
+		errStop := errors.New("stop")
+		handler, _ := providers.BoundaryHandler(func(chunk string) error {
+			return errStop
+		}, providers.BoundaryWord)
+
+		require.NoError(t, handler("hel"))
+		require.ErrorIs(t, handler("lo "), errStop)
+	})
+}
+
+type shortWriter struct{}
+
+func (shortWriter) Write(p []byte) (int, error) {
+	return len(p) / 2, nil
+}
+
+func TestWriterHandler(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should write and flush every chunk", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler := providers.WriterHandler(rec)
+
+		require.NoError(t, handler("hello"))
+		require.NoError(t, handler(" world"))
+
+		assert.Equal(t, "hello world", rec.Body.String())
+		assert.True(t, rec.Flushed)
+	})
+
+	t.Run("should report short writes", func(t *testing.T) {
+		handler := providers.WriterHandler(shortWriter{})
+
+		require.ErrorIs(t, handler("hello"), io.ErrShortWrite)
+	})
+}
+
+func TestStreamResponseTo(t *testing.T) {
+	t.Parallel()
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"hello"}}]}`,
+				`{"choices":[{"delta":{"content":" world"}}]}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+
+	var buf bytes.Buffer
+	res, err := providers.StreamResponseTo(
+		context.Background(),
+		providers.NewOpenAI([]string{"test-key"}),
+		client,
+		request.Completion{
+			Model:       models.GPT4OMini{},
+			UserMessage: "Say hello in one sentence.",
+		},
+		&buf,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello world", buf.String())
+	assert.Equal(t, "hello world", res.Content)
+}
+
+func TestOnComplete(t *testing.T) {
+	t.Parallel()
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"hello"}}]}`,
+				`{"choices":[{"delta":{"content":" world"}}]}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	var events []string
+	res, err := openai.StreamResponse(
+		context.Background(),
+		client,
+		request.Completion{
+			Model:       models.GPT4OMini{},
+			UserMessage: "Say hello in one sentence.",
+			OnComplete: func(res response.Completion) error {
+				events = append(events, "complete: "+res.Content)
+				return nil
+			},
+		},
+		func(chunk string) error {
+			events = append(events, "chunk: "+chunk)
+			return nil
+		},
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"chunk: hello",
+		"chunk:  world",
+		"complete: hello world",
+	}, events)
+	assert.Equal(t, "hello world", res.Content)
+}
@@ -0,0 +1,30 @@
+package providers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/flyx-ai/heimdall/request"
+)
+
+// withIdempotencyKey gives req an idempotency key if the caller did not set
+// one. It runs once per logical request, before the first attempt, so every
+// retry of that request reuses the same key.
+//
+// A key set by the caller is suffixed with the request's hash. The router
+// sends one request several times with a different body, such as an
+// AutoContinue follow-up, a downgraded model or the next RunAgent step, and
+// a provider honouring the key would otherwise replay the first response to
+// all of them.
+func withIdempotencyKey(req request.Completion) request.Completion {
+	if req.IdempotencyKey != "" {
+		req.IdempotencyKey += "-" + req.Hash()[:16]
+		return req
+	}
+
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	req.IdempotencyKey = hex.EncodeToString(b[:])
+
+	return req
+}
@@ -0,0 +1,208 @@
+package providers
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+)
+
+// RateLimited caps the rate of upstream calls made by the provider it wraps,
+// across all of that provider's keys. Every HTTP request, including retries
+// on another key, waits for the limiter first, so it also bounds traffic
+// that per-key quotas do not. Providers with a client of their own, such as
+// VertexAI, find the limiter in the request's context.
+type RateLimited struct {
+	provider LLMProvider
+	limiter  *rate.Limiter
+}
+
+// WithRateLimit wraps provider so it makes at most rps upstream calls per
+// second on average, with bursts of up to burst calls. Calls block until the
+// limiter allows them, or fail with the context's error once it is done. A
+// non-positive rps leaves the rate unlimited, and burst is at least one.
+func WithRateLimit(provider LLMProvider, rps float64, burst int) RateLimited {
+	limit := rate.Limit(rps)
+	if rps <= 0 {
+		limit = rate.Inf
+	}
+
+	return RateLimited{
+		provider: provider,
+		limiter:  rate.NewLimiter(limit, max(burst, 1)),
+	}
+}
+
+// RateLimitState is a snapshot of a RateLimited limiter, for metrics.
+type RateLimitState struct {
+	// Limit is the configured number of calls per second, or +Inf when
+	// unlimited.
+	Limit float64
+	Burst int
+	// Tokens is the number of calls that can start right away. It is
+	// negative while callers are queued for the limiter.
+	Tokens float64
+}
+
+// State returns the current state of the limiter.
+func (r RateLimited) State() RateLimitState {
+	return RateLimitState{
+		Limit:  float64(r.limiter.Limit()),
+		Burst:  r.limiter.Burst(),
+		Tokens: r.limiter.Tokens(),
+	}
+}
+
+// Name returns the name of the wrapped provider.
+func (r RateLimited) Name() string {
+	return r.provider.Name()
+}
+
+// Supports implements LLMProvider by deferring to the wrapped provider.
+func (r RateLimited) Supports(m models.Model) bool {
+	return r.provider.Supports(m)
+}
+
+// SupportsPrefill implements LLMProvider by deferring to the wrapped
+// provider.
+func (r RateLimited) SupportsPrefill() bool {
+	return r.provider.SupportsPrefill()
+}
+
+// Close closes the wrapped provider when it holds resources, as VertexAI
+// does, so Router.Close reaches it through the wrapper.
+func (r RateLimited) Close() error {
+	if closer, ok := r.provider.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// Validate checks the wrapped provider's keys, see Openai.Validate. It
+// returns nil for a provider that cannot validate its keys.
+func (r RateLimited) Validate(ctx context.Context) map[string]error {
+	if validator, ok := r.provider.(interface {
+		Validate(ctx context.Context) map[string]error
+	}); ok {
+		return validator.Validate(ctx)
+	}
+
+	return nil
+}
+
+// RemainingQuota returns the wrapped provider's remaining quota, see
+// Openai.RemainingQuota. A provider that does not track one is reported as
+// unlimited, with math.MaxUint32 requests.
+func (r RateLimited) RemainingQuota() (requests uint32, resetAt time.Time) {
+	if quota, ok := r.provider.(interface {
+		RemainingQuota() (uint32, time.Time)
+	}); ok {
+		return quota.RemainingQuota()
+	}
+
+	return math.MaxUint32, time.Time{}
+}
+
+// CompleteResponse implements LLMProvider.
+func (r RateLimited) CompleteResponse(
+	ctx context.Context,
+	req request.Completion,
+	client http.Client,
+	requestLog *response.Logging,
+) (response.Completion, error) {
+	return r.provider.CompleteResponse(r.withLimiter(ctx), req, r.limit(client), requestLog)
+}
+
+// StreamResponse implements LLMProvider.
+func (r RateLimited) StreamResponse(
+	ctx context.Context,
+	client http.Client,
+	req request.Completion,
+	chunkHandler func(chunk string) error,
+	requestLog *response.Logging,
+) (response.Completion, error) {
+	return r.provider.StreamResponse(r.withLimiter(ctx), r.limit(client), req, chunkHandler, requestLog)
+}
+
+// doRequest implements LLMProvider.
+func (r RateLimited) doRequest(
+	ctx context.Context,
+	req request.Completion,
+	client http.Client,
+	chunkHandler func(chunk string) error,
+	key string,
+) (response.Completion, int, error) {
+	return r.provider.doRequest(r.withLimiter(ctx), req, r.limit(client), chunkHandler, key)
+}
+
+// tryWithBackup implements LLMProvider.
+func (r RateLimited) tryWithBackup(
+	ctx context.Context,
+	req request.Completion,
+	client http.Client,
+	chunkHandler func(chunk string) error,
+	requestLog *response.Logging,
+) (response.Completion, error) {
+	return r.provider.tryWithBackup(r.withLimiter(ctx), req, r.limit(client), chunkHandler, requestLog)
+}
+
+// limit returns a copy of client whose requests wait for the limiter.
+func (r RateLimited) limit(client http.Client) http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = limitedTransport{limiter: r.limiter, next: next}
+
+	return client
+}
+
+type limiterKey struct{}
+
+// withLimiter returns ctx carrying the limiter, for providers that send
+// requests through a client of their own rather than the one handed to
+// them.
+func (r RateLimited) withLimiter(ctx context.Context) context.Context {
+	return context.WithValue(ctx, limiterKey{}, r.limiter)
+}
+
+// contextLimited wraps next so requests wait for the limiter a RateLimited
+// wrapper placed in their context, if any.
+func contextLimited(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return limitedTransport{next: next}
+}
+
+// limitedTransport waits for limiter before each request, or for the
+// limiter in the request's context when limiter is nil.
+type limitedTransport struct {
+	limiter *rate.Limiter
+	next    http.RoundTripper
+}
+
+func (t limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	limiter := t.limiter
+	if limiter == nil {
+		limiter, _ = req.Context().Value(limiterKey{}).(*rate.Limiter)
+	}
+	if limiter != nil {
+		if err := limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+var _ LLMProvider = new(RateLimited)
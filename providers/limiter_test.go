@@ -0,0 +1,141 @@
+package providers_test
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/providers"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithRateLimit(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			calls.Add(1)
+			return sseResponse(`{"choices":[{"delta":{"content":"hi"}}]}`, "[DONE]"), nil
+		}),
+	}
+	limited := providers.WithRateLimit(
+		providers.NewOpenRouter([]string{"test-key"}),
+		0.01,
+		1,
+	)
+	req := request.Completion{
+		Model:       models.OpenRouterModel{ModelName: "openai/gpt-4o-mini"},
+		UserMessage: "Say hello.",
+	}
+
+	assert.Equal(t, models.OpenRouterProvider, limited.Name())
+	assert.Equal(t, providers.RateLimitState{Limit: 0.01, Burst: 1, Tokens: 1}, limited.State())
+
+	res, err := limited.CompleteResponse(context.Background(), req, client, &response.Logging{})
+	require.NoError(t, err)
+	assert.Equal(t, "hi", res.Content)
+	assert.EqualValues(t, 1, calls.Load())
+	assert.Less(t, limited.State().Tokens, 1.0)
+
+	t.Run("should fail once the context cannot wait for the limiter", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := limited.StreamResponse(ctx, client, req, func(string) error { return nil }, &response.Logging{})
+		require.Error(t, err)
+		assert.EqualValues(t, 1, calls.Load())
+	})
+
+	t.Run("should not limit a non-positive rate", func(t *testing.T) {
+		unlimited := providers.WithRateLimit(providers.NewOpenRouter([]string{"test-key"}), 0, 0)
+		for range 5 {
+			_, err := unlimited.CompleteResponse(context.Background(), req, client, &response.Logging{})
+			require.NoError(t, err)
+		}
+	})
+}
+
+func TestWithRateLimitVertexAI(t *testing.T) {
+	t.Parallel()
+
+	vertex, err := providers.NewVertexAI(context.Background(), "test-project", "us-central1", nil)
+	require.NoError(t, err)
+	limited := providers.WithRateLimit(&vertex, 0.01, 1)
+	req := request.Completion{
+		Model:       models.VertexGemini25Flash{},
+		UserMessage: "Say hello.",
+		Tags:        map[string]string{},
+	}
+
+	// VertexAI sends through its own client, not the one handed to it. The
+	// short deadline ends the call once its request is on its way.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err = limited.CompleteResponse(ctx, req, http.Client{}, &response.Logging{})
+	require.Error(t, err)
+	assert.Less(t, limited.State().Tokens, 1.0, "the request should have waited for the limiter")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = limited.CompleteResponse(ctx, req, http.Client{}, &response.Logging{})
+	require.ErrorContains(t, err, "rate: Wait")
+}
+
+func TestRateLimitedForwardsProviderMethods(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should report the wrapped provider's quota", func(t *testing.T) {
+		t.Parallel()
+
+		client := http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				res := sseResponse(`{"choices":[{"delta":{"content":"hi"}}]}`, "[DONE]")
+				res.Header.Set("x-ratelimit-remaining-requests", "42")
+				res.Header.Set("x-ratelimit-reset-requests", "1m0s")
+				return res, nil
+			}),
+		}
+		limited := providers.WithRateLimit(providers.NewOpenAI([]string{"test-key"}), 0, 1)
+
+		_, err := limited.CompleteResponse(
+			context.Background(),
+			request.Completion{Model: models.GPT4OMini{}, UserMessage: "Say hello."},
+			client,
+			&response.Logging{},
+		)
+		require.NoError(t, err)
+
+		requests, _ := limited.RemainingQuota()
+		assert.Equal(t, uint32(42), requests)
+	})
+
+	t.Run("should report an untracked quota as unlimited", func(t *testing.T) {
+		t.Parallel()
+
+		limited := providers.WithRateLimit(providers.NewOpenRouter([]string{"test-key"}), 0, 1)
+
+		requests, resetAt := limited.RemainingQuota()
+		assert.Equal(t, uint32(math.MaxUint32), requests)
+		assert.True(t, resetAt.IsZero())
+	})
+
+	t.Run("should close the wrapped provider", func(t *testing.T) {
+		t.Parallel()
+
+		vertex, err := providers.NewVertexAI(context.Background(), "test-project", "us-central1", nil)
+		require.NoError(t, err)
+		limited := providers.WithRateLimit(&vertex, 0, 1)
+
+		assert.Implements(t, (*io.Closer)(nil), limited)
+		assert.NoError(t, limited.Close())
+	})
+}
@@ -3,9 +3,9 @@ package providers
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"context"
-	"crypto/rand"
-	"encoding/binary"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -32,8 +32,10 @@ func SetOpenAIBaseURL(url string) {
 }
 
 type requestMessage struct {
-	Role    string `json:"role"`
-	Content any    `json:"content"`
+	Role       string           `json:"role"`
+	Content    any              `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
 }
 
 type file struct {
@@ -70,40 +72,261 @@ type requestMessageWithImage struct {
 	Content []any  `json:"content"`
 }
 
+// mapOpenAIFinish normalises an OpenAI finish_reason. Unrecognised reasons
+// from OpenAI-compatible backends are reported as a natural stop.
+func mapOpenAIFinish(reason string) response.FinishReason {
+	switch reason {
+	case "":
+		return ""
+	case "length":
+		return response.FinishLength
+	case "content_filter":
+		return response.FinishContentFilter
+	case "tool_calls", "function_call":
+		return response.FinishToolCalls
+	default:
+		return response.FinishStop
+	}
+}
+
 type openAIChunk struct {
 	Choices []struct {
 		Delta struct {
-			Content string `json:"content"`
+			Content   string                `json:"content"`
+			Audio     *openAIAudioDelta     `json:"audio"`
+			ToolCalls []openAIToolCallDelta `json:"tool_calls"`
 		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
+	ServiceTier string `json:"service_tier"`
+	Usage       struct {
+		PromptTokens        int `json:"prompt_tokens"`
+		CompletionTokens    int `json:"completion_tokens"`
+		TotalTokens         int `json:"total_tokens"`
+		PromptTokensDetails struct {
+			CachedTokens int `json:"cached_tokens"`
+		} `json:"prompt_tokens_details"`
+		CompletionTokensDetails struct {
+			ReasoningTokens          int `json:"reasoning_tokens"`
+			AcceptedPredictionTokens int `json:"accepted_prediction_tokens"`
+			RejectedPredictionTokens int `json:"rejected_prediction_tokens"`
+		} `json:"completion_tokens_details"`
 	} `json:"usage"`
 }
 
+// openAIAudioDelta is a piece of a streamed audio response: a chunk of
+// base64 audio, a chunk of its transcript, or both.
+type openAIAudioDelta struct {
+	ID         string `json:"id"`
+	Data       string `json:"data"`
+	Transcript string `json:"transcript"`
+}
+
+const defaultOpenAIAudioFormat = "pcm16"
+
+// audioFormat returns the encoding of the audio the model is asked to
+// return, or an empty string when it is not asked for audio.
+func audioFormat(m models.Model) string {
+	if m, ok := m.(models.GPT4OAudio); ok && m.AudioOutput != nil {
+		return cmp.Or(m.AudioOutput.Format, defaultOpenAIAudioFormat)
+	}
+
+	return ""
+}
+
+type openAIAudioConfig struct {
+	Voice  string `json:"voice"`
+	Format string `json:"format"`
+}
+
+// openAIAudio collects the audio deltas of a stream.
+type openAIAudio struct {
+	audio      *response.Audio
+	transcript strings.Builder
+}
+
+func (a *openAIAudio) add(delta *openAIAudioDelta, format string) error {
+	if delta == nil {
+		return nil
+	}
+	if a.audio == nil {
+		a.audio = &response.Audio{Format: format}
+	}
+	if delta.ID != "" {
+		a.audio.ID = delta.ID
+	}
+	if delta.Data != "" {
+		data, err := base64.StdEncoding.DecodeString(delta.Data)
+		if err != nil {
+			return fmt.Errorf("decode audio chunk: %w", err)
+		}
+		a.audio.Data = append(a.audio.Data, data...)
+	}
+	a.transcript.WriteString(delta.Transcript)
+
+	return nil
+}
+
+// result returns the collected audio, or nil when the stream carried none.
+func (a *openAIAudio) result() *response.Audio {
+	if a.audio == nil {
+		return nil
+	}
+	a.audio.Transcript = a.transcript.String()
+
+	return a.audio
+}
+
+type openAITool struct {
+	Type     string             `json:"type"`
+	Function openAIToolFunction `json:"function"`
+}
+
+type openAIToolFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+// openAITools converts the request's tools to OpenAI function tools.
+func openAITools(tools []request.Tool) []openAITool {
+	converted := make([]openAITool, 0, len(tools))
+	for _, tool := range tools {
+		converted = append(converted, openAITool{
+			Type: "function",
+			Function: openAIToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+
+	return converted
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// openAIToolCalls converts an assistant turn's tool calls back to the wire
+// format.
+func openAIToolCalls(calls []response.ToolCall) []openAIToolCall {
+	converted := make([]openAIToolCall, 0, len(calls))
+	for _, call := range calls {
+		c := openAIToolCall{ID: call.ID, Type: "function"}
+		c.Function.Name = call.Name
+		c.Function.Arguments = string(call.Arguments)
+		converted = append(converted, c)
+	}
+
+	return converted
+}
+
+// openAIToolCallDelta is a piece of a streamed tool call. The first piece of
+// each call carries its ID and name; the arguments arrive in fragments.
+type openAIToolCallDelta struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// openAIToolCallCollector assembles the tool call deltas of a stream.
+type openAIToolCallCollector struct {
+	calls []response.ToolCall
+	args  []strings.Builder
+}
+
+func (c *openAIToolCallCollector) add(deltas []openAIToolCallDelta) {
+	for _, delta := range deltas {
+		for len(c.calls) <= delta.Index {
+			c.calls = append(c.calls, response.ToolCall{})
+			c.args = append(c.args, strings.Builder{})
+		}
+		if delta.ID != "" {
+			c.calls[delta.Index].ID = delta.ID
+		}
+		if delta.Function.Name != "" {
+			c.calls[delta.Index].Name = delta.Function.Name
+		}
+		c.args[delta.Index].WriteString(delta.Function.Arguments)
+	}
+}
+
+// result returns the collected calls, or nil when the stream carried none.
+func (c *openAIToolCallCollector) result() []response.ToolCall {
+	for i := range c.calls {
+		c.calls[i].Arguments = json.RawMessage(c.args[i].String())
+	}
+
+	return c.calls
+}
+
 type streamOptions struct {
 	IncludeUsage bool `json:"include_usage"`
 }
 
 type openAIRequest struct {
-	Model          string         `json:"model"`
-	Messages       any            `json:"messages"`
-	Stream         bool           `json:"stream"`
-	StreamOptions  streamOptions  `json:"stream_options"`
-	Temperature    float32        `json:"temperature,omitempty"`
-	TopP           float32        `json:"top_p,omitempty"`
-	ResponseFormat map[string]any `json:"response_format,omitempty"`
+	Model               string             `json:"model"`
+	Messages            any                `json:"messages"`
+	Stream              bool               `json:"stream"`
+	StreamOptions       streamOptions      `json:"stream_options"`
+	Temperature         float32            `json:"temperature,omitempty"`
+	TopP                float32            `json:"top_p,omitempty"`
+	MaxTokens           int                `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int                `json:"max_completion_tokens,omitempty"`
+	Stop                []string           `json:"stop,omitempty"`
+	PresencePenalty     float32            `json:"presence_penalty,omitempty"`
+	ResponseFormat      map[string]any     `json:"response_format,omitempty"`
+	ServiceTier         string             `json:"service_tier,omitempty"`
+	Store               bool               `json:"store,omitempty"`
+	Metadata            map[string]string  `json:"metadata,omitempty"`
+	Modalities          []string           `json:"modalities,omitempty"`
+	Audio               *openAIAudioConfig `json:"audio,omitempty"`
+	Tools               []openAITool       `json:"tools,omitempty"`
+	Prediction          *openAIPrediction  `json:"prediction,omitempty"`
+}
+
+// withSampling returns r with the request's sampling parameters. The
+// temperature stays at 1.0 unless the request sets one.
+func (r openAIRequest) withSampling(req request.Completion) openAIRequest {
+	if req.Temperature != 0 {
+		r.Temperature = req.Temperature
+	}
+	r.TopP = req.TopP
+	r.Stop = req.StopSequences
+	r.PresencePenalty = req.PresencePenalty
+
+	return r
+}
+
+// openAIPrediction is the known content of a predicted output.
+type openAIPrediction struct {
+	Type    string `json:"type"`
+	Content string `json:"content"`
 }
 
 type Openai struct {
 	apiKeys []string
+	keys    *KeyDistributor
+	opts    options
 }
 
-func NewOpenAI(apiKeys []string) Openai {
+func NewOpenAI(apiKeys []string, opts ...Option) Openai {
+	o := newOptions(opts)
+
 	return Openai{
 		apiKeys: apiKeys,
+		keys:    o.keyDistributor(apiKeys),
+		opts:    o,
 	}
 }
 
@@ -114,18 +337,57 @@ func (oa Openai) doRequest(
 	chunkHandler func(chunk string) error,
 	key string,
 ) (response.Completion, int, error) {
-	model := req.Model.GetName()
+	key, release, err := oa.keys.Acquire(ctx, key)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	defer release()
+
+	res, resCode, err := oa.requestModel(ctx, req, client, chunkHandler, key, req.Model.GetName())
+	var deprecated *ModelDeprecatedError
+	if errors.As(err, &deprecated) && oa.opts.floatingAliases {
+		if alias, ok := models.FloatingAlias(deprecated.Model); ok {
+			oa.opts.log().WarnContext(ctx, "model snapshot retired, retrying with its floating alias",
+				"model", deprecated.Model,
+				"alias", alias,
+			)
+			return oa.requestModel(ctx, req, client, chunkHandler, key, alias)
+		}
+	}
 
+	return res, resCode, err
+}
+
+// requestModel streams a chat completion for req from model, which is the
+// request's model name or the floating alias standing in for it.
+func (oa Openai) requestModel(
+	ctx context.Context,
+	req request.Completion,
+	client http.Client,
+	chunkHandler func(chunk string) error,
+	key string,
+	model string,
+) (response.Completion, int, error) {
 	openaiRequest := openAIRequest{
 		Model:         model,
 		Stream:        true,
 		StreamOptions: streamOptions{IncludeUsage: true},
 		Temperature:   1.0,
+		ServiceTier:   req.ServiceTier,
+		Store:         req.Store,
+		Metadata:      req.Metadata(),
+	}.withSampling(req)
+	if len(req.Tools) > 0 {
+		openaiRequest.Tools = openAITools(req.Tools)
+	}
+	if req.Prediction != "" {
+		openaiRequest.Prediction = &openAIPrediction{Type: "content", Content: req.Prediction}
 	}
 
 	request, err := prepareModelRequest(
 		openaiRequest,
 		req.Model,
+		req.MaxTokens,
 		req.SystemMessage,
 		req.UserMessage,
 		req.History,
@@ -138,6 +400,21 @@ func (oa Openai) doRequest(
 	if err != nil {
 		return response.Completion{}, 0, err
 	}
+	body, err = withRawMessages(body, "messages", req.RawMessages)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	body, err = withExtraBody(body, req.ExtraBody)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	if err := oa.opts.checkRequestSize(body); err != nil {
+		return response.Completion{}, 0, err
+	}
+
+	// Stop upstream generation if we return before the stream is drained.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	httpReq, err := http.NewRequestWithContext(ctx, "POST",
 		fmt.Sprintf("%s/chat/completions", openAIBaseURL),
@@ -149,7 +426,10 @@ func (oa Openai) doRequest(
 		)
 	}
 
+	oa.opts.setHeaders(httpReq)
+
 	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Idempotency-Key", req.IdempotencyKey)
 	httpReq.Header.Set("Authorization", "Bearer "+key)
 
 	resp, err := client.Do(httpReq)
@@ -158,21 +438,36 @@ func (oa Openai) doRequest(
 	}
 	defer resp.Body.Close()
 
+	oa.keys.Observe(key, resp.Header)
+
 	if resp.StatusCode != http.StatusOK {
-		return response.Completion{}, resp.StatusCode, errors.New(
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		err := withBody(errors.New(
 			"received non-200 status code",
-		)
+		), bodyBytes)
+		if openAIModelRetired(resp.StatusCode, model, bodyBytes) {
+			err = &ModelDeprecatedError{Model: model, Err: err}
+		}
+		return response.Completion{}, resp.StatusCode, err
 	}
 
-	reader := bufio.NewReader(resp.Body)
-	var fullContent strings.Builder
+	stream := newStreamBody(ctx, oa.opts.limitResponse(resp.Body))
+	defer stream.Close()
+
+	reader := bufio.NewReader(stream)
+	replays := replayFilter{enabled: req.DedupeChunks}
+	fullContent := oa.opts.newContent()
 	var usage response.Usage
+	var finishReason string
+	var serviceTier string
+	var audio openAIAudio
+	var toolCalls openAIToolCallCollector
 	var rawEvents []json.RawMessage
 	chunks := 0
 	now := time.Now()
 
 	for {
-		if chunks == 0 && time.Since(now).Seconds() > 3.0 {
+		if chunks == 0 && oa.opts.firstChunkTimedOut(now) {
 			return response.Completion{}, 0, context.Canceled
 		}
 		line, err := reader.ReadString('\n')
@@ -186,9 +481,11 @@ func (oa Openai) doRequest(
 			)
 		}
 
-		line = strings.TrimPrefix(line, "data: ")
-		line = strings.TrimSpace(line)
-		if line == "" || line == "[DONE]" {
+		if replays.replayed(line) {
+			continue
+		}
+		line, ok := sseData(line)
+		if !ok {
 			continue
 		}
 
@@ -202,44 +499,105 @@ func (oa Openai) doRequest(
 
 		rawEvents = append(rawEvents, json.RawMessage(line))
 
+		if chunk.ServiceTier != "" {
+			serviceTier = chunk.ServiceTier
+		}
+
 		if len(chunk.Choices) > 0 {
-			fullContent.WriteString(chunk.Choices[0].Delta.Content)
+			text, limitErr := fullContent.add(chunk.Choices[0].Delta.Content)
+			if chunk.Choices[0].FinishReason != "" {
+				finishReason = chunk.Choices[0].FinishReason
+			}
+			if err := audio.add(chunk.Choices[0].Delta.Audio, audioFormat(req.Model)); err != nil {
+				return response.Completion{}, 0, err
+			}
+			toolCalls.add(chunk.Choices[0].Delta.ToolCalls)
 
 			if chunkHandler != nil {
-				if err := chunkHandler(chunk.Choices[0].Delta.Content); err != nil {
+				if err := chunkHandler(text); err != nil {
 					return response.Completion{}, 0, err
 				}
 			}
+			if limitErr != nil {
+				return fullContent.partial(model, oa.Name()), 0, limitErr
+			}
 		}
 
 		chunks++
 		if chunk.Usage.TotalTokens != 0 {
 			usage = response.Usage{
-				PromptTokens:     chunk.Usage.PromptTokens,
-				CompletionTokens: chunk.Usage.CompletionTokens,
-				TotalTokens:      chunk.Usage.TotalTokens,
+				PromptTokens:             chunk.Usage.PromptTokens,
+				CompletionTokens:         chunk.Usage.CompletionTokens,
+				TotalTokens:              chunk.Usage.TotalTokens,
+				CachedTokens:             chunk.Usage.PromptTokensDetails.CachedTokens,
+				ReasoningTokens:          chunk.Usage.CompletionTokensDetails.ReasoningTokens,
+				AcceptedPredictionTokens: chunk.Usage.CompletionTokensDetails.AcceptedPredictionTokens,
+				RejectedPredictionTokens: chunk.Usage.CompletionTokensDetails.RejectedPredictionTokens,
 			}
 		}
 	}
 
+	if finishReason == "content_filter" {
+		return response.Completion{}, 0, &response.ContentFilteredError{
+			Provider: oa.Name(),
+			Reason:   finishReason,
+			Content:  fullContent.String(),
+		}
+	}
+
 	rawResp, err := json.Marshal(rawEvents)
 	if err != nil {
 		return response.Completion{}, 0, fmt.Errorf("marshal raw response events: %w", err)
 	}
 
-	return response.Completion{
-		Content:     fullContent.String(),
-		Model:       req.Model.GetName(),
-		Usage:       usage,
-		RawRequest:  body,
-		RawResponse: rawResp,
-	}, 0, nil
+	return finishStream(req, chunkHandler, oa.opts.sampleRaw(ctx, response.Completion{
+		Content:      fullContent.String(),
+		Model:        model,
+		Provider:     oa.Name(),
+		FinishReason: mapOpenAIFinish(finishReason),
+		ServiceTier:  serviceTier,
+		Audio:        audio.result(),
+		ToolCalls:    toolCalls.result(),
+		Usage:        usage,
+		RawRequest:   body,
+		RawResponse:  rawResp,
+	}))
 }
 
 func (oa Openai) Name() string {
 	return models.OpenaiProvider
 }
 
+// Supports implements LLMProvider. Any OpenAI model is served, including
+// ones without a dedicated type, which are sent as plain chat messages.
+func (oa Openai) Supports(m models.Model) bool {
+	return m != nil && m.GetProvider() == oa.Name()
+}
+
+// SupportsPrefill implements LLMProvider. The chat API answers a trailing
+// assistant turn afresh rather than continuing it.
+func (oa Openai) SupportsPrefill() bool {
+	return false
+}
+
+// Validate checks every API key against the API concurrently and returns the
+// outcome per key, nil meaning the key works. Keys the API rejects are
+// excluded from later requests.
+func (oa Openai) Validate(ctx context.Context) map[string]error {
+	return validateKeys(ctx, oa.apiKeys, oa.keys, func(ctx context.Context, key string) (int, error) {
+		return pingURL(ctx, oa.opts, openAIBaseURL+"/models", func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer "+key)
+		})
+	})
+}
+
+// RemainingQuota returns the number of requests the provider's keys can still
+// make and when the earliest rate-limit window resets, as last reported by
+// the API and reduced by requests dispatched since.
+func (oa Openai) RemainingQuota() (requests uint32, resetAt time.Time) {
+	return oa.keys.RemainingQuota()
+}
+
 // Check if an error is retryable based on the status code
 // func isRetryableError(statusCode int) bool {
 // 	switch statusCode {
@@ -262,6 +620,10 @@ func (oa Openai) tryWithBackup(
 	chunkHandler func(chunk string) error,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if len(oa.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
 	key := oa.apiKeys[0]
 
 	maxRetries := 5
@@ -269,6 +631,9 @@ func (oa Openai) tryWithBackup(
 	maxBackoff := 10 * time.Second
 
 	var lastErr error
+	var lastStatusCode int
+	start := oa.opts.now()
+	backoffStep := 0
 	for attempt := range maxRetries {
 		requestLog.Events = append(requestLog.Events, response.Event{
 			Timestamp: time.Now(),
@@ -289,16 +654,24 @@ func (oa Openai) tryWithBackup(
 			})
 			return response.Completion{}, ctx.Err()
 		default:
+			var progress streamProgress
 			res, resCode, err := oa.doRequest(
 				ctx,
 				req,
-				client,
-				chunkHandler,
+				progress.client(client),
+				progress.wrap(chunkHandler),
 				key,
 			)
 			if err == nil {
 				return res, nil
 			}
+			dropped, delivered := progress.failed(ctx, err, requestLog)
+			if delivered {
+				return response.Completion{}, err
+			}
+			if dropped {
+				backoffStep = 0
+			}
 			requestLog.Events = append(requestLog.Events, response.Event{
 				Timestamp: time.Now(),
 				Description: fmt.Sprintf(
@@ -307,7 +680,7 @@ func (oa Openai) tryWithBackup(
 				),
 			})
 
-			if !isRetryableError(resCode) {
+			if !dropped && !oa.opts.retryable(resCode, err) {
 				requestLog.Events = append(requestLog.Events, response.Event{
 					Timestamp: time.Now(),
 					Description: fmt.Sprintf(
@@ -315,39 +688,34 @@ func (oa Openai) tryWithBackup(
 						err,
 					),
 				})
-				return response.Completion{}, err
+				return res, err
 			}
 
 			lastErr = err
+			lastStatusCode = resCode
 
 			backoff := min(initialBackoff*time.Duration(
-				1<<attempt,
+				1<<backoffStep,
 			), maxBackoff)
+			backoffStep++
 
-			var randomBytes [8]byte
-			var jitter time.Duration
-			if _, err := rand.Read(randomBytes[:]); err != nil {
-				jitter = backoff
-			} else {
-				randFloat := float64(binary.LittleEndian.Uint64(randomBytes[:])) / (1 << 64)
-				jitter = time.Duration(float64(backoff) * (0.8 + 0.4*randFloat))
-			}
-
-			timer := time.NewTimer(jitter)
+			timer := oa.opts.newTimer(oa.opts.jitter(backoff))
 			select {
 			case <-ctx.Done():
 				timer.Stop()
 				return response.Completion{}, ctx.Err()
-			case <-timer.C:
+			case <-timer.C():
 				continue
 			}
 		}
 	}
 
-	return response.Completion{}, fmt.Errorf(
-		"max retries exceeded: %w",
-		lastErr,
-	)
+	return response.Completion{}, &RetryExhaustedError{
+		Attempts:       maxRetries,
+		Elapsed:        oa.opts.now().Sub(start),
+		LastStatusCode: lastStatusCode,
+		Err:            lastErr,
+	}
 }
 
 func (oa Openai) CompleteResponse(
@@ -356,6 +724,12 @@ func (oa Openai) CompleteResponse(
 	client http.Client,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if len(oa.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
+	req = withIdempotencyKey(req)
+
 	if _, ok := req.Model.(*models.GPTImage); ok {
 		reqLog := requestLog
 		if reqLog == nil {
@@ -389,6 +763,12 @@ func (oa Openai) CompleteResponse(
 		var lastErr error
 		var lastStatusCode int
 		for i, key := range oa.apiKeys {
+			select {
+			case <-ctx.Done():
+				return response.Completion{}, ctx.Err()
+			default:
+			}
+
 			reqLog.Events = append(reqLog.Events, response.Event{
 				Timestamp: time.Now(),
 				Description: fmt.Sprintf(
@@ -436,10 +816,12 @@ func (oa Openai) CompleteResponse(
 						),
 					})
 					backoff := time.Duration(1<<attempt) * time.Second
+					timer := oa.opts.newTimer(backoff)
 					select {
 					case <-ctx.Done():
+						timer.Stop()
 						return response.Completion{}, ctx.Err()
-					case <-time.After(backoff):
+					case <-timer.C():
 						continue
 					}
 				}
@@ -496,6 +878,12 @@ func (oa Openai) CompleteResponse(
 	}
 
 	for i, key := range oa.apiKeys {
+		select {
+		case <-ctx.Done():
+			return response.Completion{}, ctx.Err()
+		default:
+		}
+
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
 			Description: fmt.Sprintf(
@@ -507,6 +895,12 @@ func (oa Openai) CompleteResponse(
 		if err == nil {
 			return res, nil
 		}
+		if exceedsSizeLimit(err) {
+			return res, err
+		}
+		if errors.Is(err, response.ErrContentFiltered) {
+			return response.Completion{}, err
+		}
 
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
@@ -527,38 +921,16 @@ func (oa Openai) StreamResponse(
 	chunkHandler func(chunk string) error,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
-	if _, ok := req.Model.(*models.GPTImage); ok {
-		logCtx := requestLog
-		if logCtx == nil {
-			logCtx = &response.Logging{
-				Start: time.Now(),
-			}
-			logCtx.Events = append(
-				logCtx.Events,
-				response.Event{
-					Timestamp:   time.Now(),
-					Description: "Initiating non-streaming call for GPTImage from StreamResponse",
-				},
-			)
-		}
-
-		if logCtx != nil {
-			if logCtx.Start.IsZero() {
-				logCtx.Start = time.Now()
-			}
-
-			logCtx.Events = append(
-				logCtx.Events,
-				response.Event{
-					Timestamp:   time.Now(),
-					Description: "Delegating GPTImage request from StreamResponse to CompleteResponse",
-				},
-			)
-		}
+	if !streams(req.Model) {
+		return completeAsStream(ctx, oa, client, req, chunkHandler, requestLog)
+	}
 
-		return oa.CompleteResponse(ctx, req, client, logCtx)
+	if len(oa.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
 	}
 
+	req = withIdempotencyKey(req)
+
 	reqLog := &response.Logging{}
 	if requestLog == nil {
 		req.Tags["request_type"] = "streaming"
@@ -579,7 +951,15 @@ func (oa Openai) StreamResponse(
 		reqLog = requestLog
 	}
 
+	chunkHandler, delivered := trackDelivery(chunkHandler)
+
 	for i, key := range oa.apiKeys {
+		select {
+		case <-ctx.Done():
+			return response.Completion{}, ctx.Err()
+		default:
+		}
+
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
 			Description: fmt.Sprintf(
@@ -591,6 +971,15 @@ func (oa Openai) StreamResponse(
 		if err == nil {
 			return res, nil
 		}
+		if exceedsSizeLimit(err) {
+			return res, err
+		}
+		if delivered() {
+			return response.Completion{}, err
+		}
+		if errors.Is(err, response.ErrContentFiltered) {
+			return response.Completion{}, err
+		}
 
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
@@ -610,6 +999,12 @@ func (oa Openai) callImageGenerationAPI(
 	client http.Client,
 	key string,
 ) (response.Completion, int, error) {
+	key, release, err := oa.keys.Acquire(ctx, key)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	defer release()
+
 	gptImageModel, ok := req.Model.(*models.GPTImage)
 	if !ok {
 		return response.Completion{}, 0, errors.New(
@@ -656,6 +1051,10 @@ func (oa Openai) callImageGenerationAPI(
 		)
 	}
 
+	// Abandon the upstream request if we return before its body is read.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	httpReq, err := http.NewRequestWithContext(ctx, "POST",
 		fmt.Sprintf("%s/images/generations", openAIBaseURL),
 		bytes.NewReader(bodyBytes))
@@ -666,7 +1065,10 @@ func (oa Openai) callImageGenerationAPI(
 		)
 	}
 
+	oa.opts.setHeaders(httpReq)
+
 	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Idempotency-Key", req.IdempotencyKey)
 	httpReq.Header.Set("Authorization", "Bearer "+key)
 
 	resp, err := client.Do(httpReq)
@@ -678,6 +1080,8 @@ func (oa Openai) callImageGenerationAPI(
 	}
 	defer resp.Body.Close()
 
+	oa.keys.Observe(key, resp.Header)
+
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		return response.Completion{}, resp.StatusCode, fmt.Errorf(
@@ -718,24 +1122,75 @@ func (oa Openai) callImageGenerationAPI(
 		TotalTokens:      0,
 	}
 
-	return response.Completion{
+	return oa.opts.sampleRaw(ctx, response.Completion{
 		Content:     contentBuilder.String(),
 		Model:       req.Model.GetName(),
+		Provider:    oa.Name(),
 		Usage:       usage,
 		RawRequest:  bodyBytes,
 		RawResponse: rawResponse.Bytes(),
-	}, resp.StatusCode, nil
+	}), resp.StatusCode, nil
 }
 
 var _ LLMProvider = new(Openai)
 
+// openAIModelRetired reports whether an error response says the requested
+// model was deprecated, or that it does not exist when it is a dated
+// snapshot, which is how OpenAI answers for a retired one. OpenAI also
+// answers model_not_found when the key has no access to a model, so that
+// alone is not taken as retirement.
+func openAIModelRetired(statusCode int, model string, body []byte) bool {
+	if statusCode != http.StatusNotFound && statusCode != http.StatusBadRequest {
+		return false
+	}
+
+	var errResp struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errResp); err != nil {
+		return false
+	}
+
+	if strings.Contains(errResp.Error.Message, "has been deprecated") {
+		return true
+	}
+	_, snapshot := models.FloatingAlias(model)
+
+	return snapshot && errResp.Error.Code == "model_not_found"
+}
+
+// usesMaxCompletionTokens reports whether the model only accepts
+// max_completion_tokens. The o-series and GPT-5 family reject max_tokens,
+// while older models only understand max_tokens.
+func usesMaxCompletionTokens(alias string) bool {
+	for _, prefix := range []string{"o1", "o3", "o4", "gpt-5"} {
+		if strings.HasPrefix(alias, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func prepareModelRequest(
 	request openAIRequest,
 	requestedModel models.Model,
+	maxTokens int,
 	systemInst string,
 	userMsg string,
 	history []request.Message,
 ) (openAIRequest, error) {
+	if maxTokens > 0 {
+		if usesMaxCompletionTokens(requestedModel.GetName()) {
+			request.MaxCompletionTokens = maxTokens
+		} else {
+			request.MaxTokens = maxTokens
+		}
+	}
+
 	switch m := requestedModel.(type) {
 	case models.GPT41:
 		return prepareRequest(request, m.StructuredOutput, m.PdfFile, m.ImageFile, systemInst, userMsg, history)
@@ -763,6 +1218,15 @@ func prepareModelRequest(
 		return prepareRequest(request, m.StructuredOutput, m.PdfFile, m.ImageFile, systemInst, userMsg, history)
 	case models.GPT51CodexMini:
 		return prepareRequest(request, m.StructuredOutput, m.PdfFile, m.ImageFile, systemInst, userMsg, history)
+	case models.GPT4OAudio:
+		if m.AudioOutput != nil {
+			request.Modalities = []string{"text", "audio"}
+			request.Audio = &openAIAudioConfig{
+				Voice:  m.AudioOutput.Voice,
+				Format: audioFormat(m),
+			}
+		}
+		return prepareBasicMessages(request, systemInst, userMsg, history)
 	case models.O1:
 		return prepareRequest(request, m.StructuredOutput, m.PdfFile, m.ImageFile, systemInst, userMsg, history)
 	case models.O3Mini:
@@ -821,13 +1285,8 @@ func prepareRequestWithImage(
 
 	for _, his := range history {
 		reqMsgWithImage = append(reqMsgWithImage, requestMessageWithImage{
-			Role: his.Role,
-			Content: []any{
-				fileInputMessage{
-					Type: "text",
-					Text: his.Content,
-				},
-			},
+			Role:    his.Role,
+			Content: historyContentParts(his),
 		})
 	}
 
@@ -863,13 +1322,15 @@ func prepareRequestWithImage(
 		)
 	}
 
-	reqMsgWithImage[lastIndex].Content = append(
-		reqMsgWithImage[lastIndex].Content,
-		fileInputMessage{
-			Type: "text",
-			Text: userMsg,
-		},
-	)
+	if userMsg != "" {
+		reqMsgWithImage[lastIndex].Content = append(
+			reqMsgWithImage[lastIndex].Content,
+			fileInputMessage{
+				Type: "text",
+				Text: userMsg,
+			},
+		)
+	}
 
 	request.Messages = reqMsgWithImage
 	return request, nil
@@ -885,13 +1346,8 @@ func prepareRequestWithPdf(
 
 	for _, his := range history {
 		reqMsgWithFile = append(reqMsgWithFile, requestMessageWithFile{
-			Role: his.Role,
-			Content: []any{
-				fileInputMessage{
-					Type: "text",
-					Text: his.Content,
-				},
-			},
+			Role:    his.Role,
+			Content: historyContentParts(his),
 		})
 	}
 
@@ -927,42 +1383,91 @@ func prepareRequestWithPdf(
 		fi,
 	)
 
-	reqMsgWithFile[lastIndex].Content = append(
-		reqMsgWithFile[lastIndex].Content,
-		fileInputMessage{
-			Type: "text",
-			Text: userMsg,
-		},
-	)
+	if userMsg != "" {
+		reqMsgWithFile[lastIndex].Content = append(
+			reqMsgWithFile[lastIndex].Content,
+			fileInputMessage{
+				Type: "text",
+				Text: userMsg,
+			},
+		)
+	}
 
 	request.Messages = reqMsgWithFile
 	return request, nil
 }
 
+// historyContentParts renders a history turn as content parts, in the
+// turn's order.
+func historyContentParts(his request.Message) []any {
+	var parts []any
+	for _, p := range his.ContentParts() {
+		if p.Image == nil {
+			parts = append(parts, fileInputMessage{
+				Type: "text",
+				Text: p.Text,
+			})
+			continue
+		}
+
+		url := p.Image.URL
+		if url == "" {
+			url = "data:" + string(p.Image.MimeType) + ";base64," + p.Image.Data
+		}
+
+		parts = append(parts, imageInput{
+			Type: "image_url",
+			ImageURL: imageURL{
+				URL:    url,
+				Detail: "auto",
+			},
+		})
+	}
+
+	return parts
+}
+
 func prepareBasicMessages(
 	request openAIRequest,
 	systemInst string,
 	userMsg string,
 	history []request.Message,
 ) (openAIRequest, error) {
-	hisLen := len(history)
-	requestMessages := make([]requestMessage, hisLen+2)
+	requestMessages := make([]requestMessage, 0, len(history)+2)
 
-	for i := range history {
-		requestMessages[i] = requestMessage{
-			Role:    history[i].Role,
-			Content: history[i].Content,
-		}
+	if systemInst != "" {
+		requestMessages = append(requestMessages, requestMessage{
+			Role:    "system",
+			Content: systemInst,
+		})
 	}
 
-	requestMessages[hisLen] = requestMessage{
-		Role:    "system",
-		Content: systemInst,
+	for _, his := range history {
+		msg := requestMessage{
+			Role:       his.Role,
+			Content:    his.Content,
+			ToolCallID: his.ToolCallID,
+		}
+		if len(his.Images) > 0 || len(his.Parts) > 0 {
+			msg.Content = historyContentParts(his)
+		}
+		if len(his.ToolCalls) > 0 {
+			msg.ToolCalls = openAIToolCalls(his.ToolCalls)
+			if his.Content == "" {
+				msg.Content = nil
+			}
+		}
+		requestMessages = append(requestMessages, msg)
 	}
 
-	requestMessages[hisLen+1] = requestMessage{
-		Role:    "user",
-		Content: userMsg,
+	// An empty user message after an assistant turn asks the model to carry
+	// on from that turn, after a tool result to answer with it, and after a
+	// user turn to answer that turn, so none is sent.
+	if userMsg != "" || len(history) == 0 {
+		requestMessages = append(requestMessages, requestMessage{
+			Role:    "user",
+			Content: userMsg,
+		})
 	}
 
 	request.Messages = requestMessages
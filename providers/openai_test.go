@@ -2,14 +2,25 @@ package providers_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/flyx-ai/heimdall/models"
 	"github.com/flyx-ai/heimdall/providers"
 	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -106,15 +117,12 @@ func TestOpenAIModelsWithStreaming(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			var chunkHandlerCollection string
+			var collected providers.Collector
 			res, err := openai.StreamResponse(
 				context.Background(),
 				client,
 				tt.req,
-				func(chunk string) error {
-					chunkHandlerCollection = chunkHandlerCollection + chunk
-					return nil
-				},
+				collected.Handle,
 				nil,
 			)
 			require.NoError(
@@ -127,8 +135,8 @@ func TestOpenAIModelsWithStreaming(t *testing.T) {
 
 			assert.NotEmpty(
 				t,
-				chunkHandlerCollection,
-				"chunkHandlerCollection should not be empty",
+				collected.String(),
+				"collected chunks should not be empty",
 			)
 			assert.NotEmpty(t, res.Content, "content should not be empty")
 			assert.NotEmpty(t, res.Model, "model should not be empty")
@@ -172,3 +180,1623 @@ func TestOpenAIPDFHandling(t *testing.T) {
 	require.NoError(t, err, "PDF handling returned an unexpected error")
 	assert.NotEmpty(t, res.Content, "response content should not be empty")
 }
+
+func TestOpenAIMaxTokensParameter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		model      models.Model
+		wantKey    string
+		missingKey string
+	}{
+		{
+			name:       "should send max_tokens for GPT4O",
+			model:      models.GPT4O{},
+			wantKey:    "max_tokens",
+			missingKey: "max_completion_tokens",
+		},
+		{
+			name:       "should send max_completion_tokens for O3Mini",
+			model:      models.O3Mini{},
+			wantKey:    "max_completion_tokens",
+			missingKey: "max_tokens",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent map[string]any
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					if err := json.NewDecoder(req.Body).Decode(&sent); err != nil {
+						return nil, err
+					}
+					return sseResponse(
+						`{"choices":[{"delta":{"content":"hi"}}]}`,
+						"[DONE]",
+					), nil
+				}),
+			}
+			openai := providers.NewOpenAI([]string{"test-key"})
+
+			_, err := openai.CompleteResponse(
+				context.Background(),
+				request.Completion{
+					Model:       tt.model,
+					UserMessage: "Say hello in one sentence.",
+					MaxTokens:   128,
+				},
+				client,
+				&response.Logging{},
+			)
+			require.NoError(t, err)
+
+			assert.EqualValues(t, 128, sent[tt.wantKey])
+			assert.NotContains(t, sent, tt.missingKey)
+		})
+	}
+}
+
+func TestOpenAIWithoutAPIKeys(t *testing.T) {
+	t.Parallel()
+
+	provider := providers.NewOpenAI(nil)
+	req := request.Completion{
+		Model:       models.GPT4OMini{},
+		UserMessage: "Say hello in one sentence.",
+	}
+
+	_, err := provider.CompleteResponse(context.Background(), req, http.Client{}, nil)
+	require.ErrorIs(t, err, providers.ErrNoAPIKeys)
+
+	_, err = provider.StreamResponse(
+		context.Background(),
+		http.Client{},
+		req,
+		func(chunk string) error { return nil },
+		nil,
+	)
+	require.ErrorIs(t, err, providers.ErrNoAPIKeys)
+}
+
+// unlistedOpenAIModel stands in for an OpenAI model heimdall has no dedicated
+// type for yet.
+type unlistedOpenAIModel struct{}
+
+func (unlistedOpenAIModel) GetProvider() string               { return models.OpenaiProvider }
+func (unlistedOpenAIModel) Family() string                    { return "" }
+func (unlistedOpenAIModel) GetName() string                   { return "gpt-future" }
+func (unlistedOpenAIModel) EstimateCost(text string) float64  { return 0 }
+func (unlistedOpenAIModel) Capabilities() models.Capabilities { return models.Capabilities{} }
+
+func TestOpenAIUnlistedModel(t *testing.T) {
+	t.Parallel()
+
+	var body struct {
+		Model         string `json:"model"`
+		StreamOptions struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"hi"}}]}`,
+				`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":1,"total_tokens":13}}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	res, err := openai.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:         unlistedOpenAIModel{},
+			SystemMessage: "you are a helpful assistant.",
+			History: []request.Message{
+				{Role: "user", Content: "Hello."},
+				{Role: "assistant", Content: "Hi!"},
+			},
+			UserMessage: "Say hello in one sentence.",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "gpt-future", body.Model)
+	assert.True(t, body.StreamOptions.IncludeUsage)
+	require.Len(t, body.Messages, 4)
+	assert.Equal(t, "system", body.Messages[0].Role)
+	assert.Equal(t, "you are a helpful assistant.", body.Messages[0].Content)
+	assert.Equal(t, "Hello.", body.Messages[1].Content)
+	assert.Equal(t, "Hi!", body.Messages[2].Content)
+	assert.Equal(t, "user", body.Messages[3].Role)
+	assert.Equal(t, "Say hello in one sentence.", body.Messages[3].Content)
+	assert.Equal(t, 13, res.Usage.TotalTokens)
+}
+
+func TestOpenAIHistoryParts(t *testing.T) {
+	t.Parallel()
+
+	var body struct {
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(`{"choices":[{"delta":{"content":"B"}}]}`, "[DONE]"), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	_, err := openai.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model: models.GPT4OMini{},
+			History: []request.Message{
+				{
+					Role: "user",
+					Parts: []request.Part{
+						{Text: "Here is image A:"},
+						{Image: &request.Image{URL: "https://example.com/a.png"}},
+						{Text: "and image B:"},
+						{Image: &request.Image{MimeType: request.MimeTypePNG, Data: "aGVsbG8="}},
+					},
+				},
+				{Role: "assistant", Content: "Got them."},
+			},
+			UserMessage: "Which one is brighter?",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, body.Messages, 3)
+	assert.JSONEq(t, `[
+		{"type":"text","text":"Here is image A:"},
+		{"type":"image_url","image_url":{"url":"https://example.com/a.png","detail":"auto"}},
+		{"type":"text","text":"and image B:"},
+		{"type":"image_url","image_url":{"url":"data:image/png;base64,aGVsbG8=","detail":"auto"}}
+	]`, string(body.Messages[0].Content))
+	assert.JSONEq(t, `"Got them."`, string(body.Messages[1].Content))
+}
+
+func TestOpenAIImageWithoutText(t *testing.T) {
+	t.Parallel()
+
+	var body struct {
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(`{"choices":[{"delta":{"content":"A cat."}}]}`, "[DONE]"), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	res, err := openai.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model: models.GPT4O{
+				ImageFile: []models.OpenaiImagePayload{{Url: "https://example.com/cat.png"}},
+			},
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, body.Messages, 1)
+	assert.Equal(t, "user", body.Messages[0].Role)
+	assert.JSONEq(t, `[
+		{"type":"image_url","image_url":{"url":"https://example.com/cat.png","detail":"auto"}}
+	]`, string(body.Messages[0].Content), "no empty text part should be sent")
+	assert.Equal(t, "A cat.", res.Content)
+}
+
+func TestOpenAIAudioOutput(t *testing.T) {
+	t.Parallel()
+
+	var body map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			body = nil
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"choices":[{"delta":{"audio":{"id":"audio_1","transcript":"Hello"}}}]}`,
+				`{"choices":[{"delta":{"audio":{"data":"AAEC","transcript":" there."}}}]}`,
+				`{"choices":[{"delta":{"audio":{"data":"AwQF"}},"finish_reason":"stop"}]}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	t.Run("should request and collect audio", func(t *testing.T) {
+		res, err := openai.CompleteResponse(
+			context.Background(),
+			request.Completion{
+				Model: models.GPT4OAudio{
+					AudioOutput: &models.OpenAIAudioOutput{Voice: "alloy"},
+				},
+				UserMessage: "Say hello.",
+			},
+			client,
+			&response.Logging{},
+		)
+		require.NoError(t, err)
+
+		assert.Equal(t, []any{"text", "audio"}, body["modalities"])
+		assert.Equal(t, map[string]any{"voice": "alloy", "format": "pcm16"}, body["audio"])
+		assert.Empty(t, res.Content, "the transcript should not be part of the content")
+		assert.Equal(t, &response.Audio{
+			ID:         "audio_1",
+			Data:       []byte{0, 1, 2, 3, 4, 5},
+			Format:     "pcm16",
+			Transcript: "Hello there.",
+		}, res.Audio)
+	})
+
+	t.Run("should leave audio off by default", func(t *testing.T) {
+		_, err := openai.CompleteResponse(
+			context.Background(),
+			request.Completion{Model: models.GPT4OAudio{}, UserMessage: "Say hello."},
+			client,
+			&response.Logging{},
+		)
+		require.NoError(t, err)
+
+		assert.NotContains(t, body, "modalities")
+		assert.NotContains(t, body, "audio")
+	})
+}
+
+func TestOpenAIContinuesAssistantTurn(t *testing.T) {
+	t.Parallel()
+
+	var body struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"choices":[{"delta":{"content":" a time"},"finish_reason":"length"}]}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	res, err := openai.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model: models.GPT4OMini{},
+			History: []request.Message{
+				{Role: "user", Content: "Tell me a story."},
+				{Role: "assistant", Content: "Once upon"},
+			},
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, body.Messages, 2)
+	assert.Equal(t, "assistant", body.Messages[1].Role)
+	assert.Equal(t, response.FinishLength, res.FinishReason)
+	assert.True(t, res.Truncated())
+}
+
+func TestOpenAIToolCalls(t *testing.T) {
+	t.Parallel()
+
+	var body map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_2","function":{"name":"weather","arguments":"{\"city\""}}]}}]}`,
+				`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":":\"Bergen\"}"}}]},"finish_reason":"tool_calls"}]}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	res, err := openai.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model: models.GPT4OMini{},
+			Tools: []request.Tool{{
+				Name:        "weather",
+				Description: "Current weather in a city.",
+				Parameters:  map[string]any{"type": "object"},
+			}},
+			History: []request.Message{
+				{Role: "user", Content: "Weather in Oslo?"},
+				{Role: "assistant", ToolCalls: []response.ToolCall{{
+					ID:        "call_1",
+					Name:      "weather",
+					Arguments: json.RawMessage(`{"city":"Oslo"}`),
+				}}},
+				{Role: "tool", Content: "rain", ToolCallID: "call_1"},
+			},
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, []any{map[string]any{
+		"type": "function",
+		"function": map[string]any{
+			"name":        "weather",
+			"description": "Current weather in a city.",
+			"parameters":  map[string]any{"type": "object"},
+		},
+	}}, body["tools"])
+	assert.Equal(t, []any{
+		map[string]any{"role": "user", "content": "Weather in Oslo?"},
+		map[string]any{"role": "assistant", "content": nil, "tool_calls": []any{map[string]any{
+			"id":       "call_1",
+			"type":     "function",
+			"function": map[string]any{"name": "weather", "arguments": `{"city":"Oslo"}`},
+		}}},
+		map[string]any{"role": "tool", "content": "rain", "tool_call_id": "call_1"},
+	}, body["messages"], "no empty user message should follow the tool result")
+
+	assert.Equal(t, response.FinishToolCalls, res.FinishReason)
+	assert.Equal(t, []response.ToolCall{{
+		ID:        "call_2",
+		Name:      "weather",
+		Arguments: json.RawMessage(`{"city":"Bergen"}`),
+	}}, res.ToolCalls)
+}
+
+func TestOpenAIPrediction(t *testing.T) {
+	t.Parallel()
+
+	var body map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			body = nil
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"func add(a, b int) int { return a + b }"}}]}`,
+				`{"choices":[],"usage":{"prompt_tokens":20,"completion_tokens":15,"total_tokens":35,`+
+					`"completion_tokens_details":{"accepted_prediction_tokens":12,"rejected_prediction_tokens":3}}}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	t.Run("should send the prediction and report its tokens", func(t *testing.T) {
+		res, err := openai.CompleteResponse(
+			context.Background(),
+			request.Completion{
+				Model:       models.GPT4O{},
+				UserMessage: "Rename sum to add.",
+				Prediction:  "func sum(a, b int) int { return a + b }",
+			},
+			client,
+			&response.Logging{},
+		)
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]any{
+			"type":    "content",
+			"content": "func sum(a, b int) int { return a + b }",
+		}, body["prediction"])
+		assert.Equal(t, 12, res.Usage.AcceptedPredictionTokens)
+		assert.Equal(t, 3, res.Usage.RejectedPredictionTokens)
+	})
+
+	t.Run("should leave the prediction off by default", func(t *testing.T) {
+		_, err := openai.CompleteResponse(
+			context.Background(),
+			request.Completion{Model: models.GPT4O{}, UserMessage: "Say hello."},
+			client,
+			&response.Logging{},
+		)
+		require.NoError(t, err)
+
+		assert.NotContains(t, body, "prediction")
+	})
+}
+
+func TestOpenAIModelDeprecated(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		model          models.Model
+		opts           []providers.Option
+		wantModels     []string
+		wantErr        bool
+		wantDeprecated bool
+	}{
+		"should name the retired model": {
+			model:          models.GPT4O{},
+			wantModels:     []string{models.GPT4OAlias, models.GPT4OAlias},
+			wantErr:        true,
+			wantDeprecated: true,
+		},
+		"should retry with the floating alias": {
+			model:      models.GPT4O{},
+			opts:       []providers.Option{providers.WithFloatingAliasFallback()},
+			wantModels: []string{models.GPT4OAlias, "gpt-4o"},
+		},
+		"should not take an undated model the key cannot use as retired": {
+			model:      models.GPT4Turbo{},
+			opts:       []providers.Option{providers.WithFloatingAliasFallback()},
+			wantModels: []string{models.GPT4TurboAlias, models.GPT4TurboAlias},
+			wantErr:    true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var requested []string
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					var body struct {
+						Model string `json:"model"`
+					}
+					if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+						return nil, err
+					}
+					requested = append(requested, body.Model)
+					if body.Model != "gpt-4o" {
+						return &http.Response{
+							StatusCode: http.StatusNotFound,
+							Body: io.NopCloser(strings.NewReader(
+								`{"error":{"code":"model_not_found","message":"The model does not exist."}}`,
+							)),
+						}, nil
+					}
+					return sseResponse(`{"choices":[{"delta":{"content":"hi"}}]}`, "[DONE]"), nil
+				}),
+			}
+			openai := providers.NewOpenAI([]string{"test-key"}, tt.opts...)
+
+			res, err := openai.CompleteResponse(
+				context.Background(),
+				request.Completion{Model: tt.model, UserMessage: "Say hello."},
+				client,
+				&response.Logging{},
+			)
+
+			assert.Equal(t, tt.wantModels, requested)
+			if tt.wantErr && !tt.wantDeprecated {
+				require.Error(t, err)
+				assert.NotErrorIs(t, err, providers.ErrModelDeprecated)
+				return
+			}
+			if tt.wantErr {
+				require.ErrorIs(t, err, providers.ErrModelDeprecated)
+				var deprecated *providers.ModelDeprecatedError
+				require.ErrorAs(t, err, &deprecated)
+				assert.Equal(t, models.GPT4OAlias, deprecated.Model)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "gpt-4o", res.Model)
+		})
+	}
+}
+
+func TestOpenAIIdempotencyKeyIsStableAcrossRetries(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			keys = append(keys, req.Header.Get("Idempotency-Key"))
+			if len(keys) == 1 {
+				return &http.Response{
+					StatusCode: http.StatusInternalServerError,
+					Body:       io.NopCloser(strings.NewReader("")),
+				}, nil
+			}
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"hi"}}]}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	_, err := openai.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.GPT4OMini{},
+			UserMessage: "Say hello in one sentence.",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, keys, 2)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1], "retries should reuse the idempotency key")
+}
+
+func TestOpenAIIdempotencyKeyFollowsRequestBody(t *testing.T) {
+	t.Parallel()
+
+	var keys []string
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			keys = append(keys, req.Header.Get("Idempotency-Key"))
+			return sseResponse(`{"choices":[{"delta":{"content":"hi"}}]}`, "[DONE]"), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+	req := request.Completion{
+		Model:          models.GPT4OMini{},
+		UserMessage:    "Say hello in one sentence.",
+		IdempotencyKey: "order-42",
+	}
+
+	for _, req := range []request.Completion{
+		req,
+		req,
+		req.WithAssistantReply(response.Completion{Content: "Hello"}),
+	} {
+		_, err := openai.CompleteResponse(context.Background(), req, client, &response.Logging{})
+		require.NoError(t, err)
+	}
+
+	require.Len(t, keys, 3)
+	assert.True(t, strings.HasPrefix(keys[0], "order-42-"), "the caller's key should be kept as a prefix")
+	assert.Equal(t, keys[0], keys[1], "the same request should reuse the key")
+	assert.NotEqual(t, keys[0], keys[2], "a follow-up request should get a key of its own")
+}
+
+func TestOpenAICancelsRequestOnEarlyReturn(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu       sync.Mutex
+		contexts []context.Context
+	)
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			contexts = append(contexts, req.Context())
+			mu.Unlock()
+
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"hi"}}]}`,
+				`{"choices":[{"delta":{"content":" there"}}]}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	_, err := openai.StreamResponse(
+		context.Background(),
+		client,
+		request.Completion{
+			Model:       models.GPT4OMini{},
+			UserMessage: "Say hello in one sentence.",
+		},
+		func(chunk string) error {
+			return errors.New("stop")
+		},
+		&response.Logging{},
+	)
+	require.Error(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, contexts)
+	for _, ctx := range contexts {
+		assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	}
+}
+
+func TestOpenAIStopsKeyRotationOnCancel(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var keys []string
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			keys = append(keys, req.Header.Get("Authorization"))
+			// The caller goes away while the first key's request fails.
+			cancel()
+
+			return &http.Response{
+				StatusCode: http.StatusUnauthorized,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(`{"error":{"message":"bad key"}}`)),
+			}, nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"first-key", "second-key"})
+
+	_, err := openai.CompleteResponse(
+		ctx,
+		request.Completion{
+			Model:       models.GPT4OMini{},
+			UserMessage: "Say hello in one sentence.",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.ErrorIs(t, err, context.Canceled)
+
+	assert.Equal(t, []string{"Bearer first-key"}, keys)
+}
+
+// failingReader fails every read with err.
+type failingReader struct{ err error }
+
+func (r failingReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestOpenAIPartlyDeliveredStreamSkipsRetries(t *testing.T) {
+	t.Parallel()
+
+	// Neither the other key nor the backoff loop may retry the stream, as
+	// the chunk handler would receive its text twice.
+	tests := map[string]struct {
+		resume    bool
+		wantCalls int
+	}{
+		"should return the drop without ResumeOnDrop": {
+			wantCalls: 1,
+		},
+		"should hand a partly delivered stream back when resuming": {
+			resume:    true,
+			wantCalls: 1,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			calls := 0
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					calls++
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+						Body: io.NopCloser(io.MultiReader(
+							strings.NewReader(`data: {"choices":[{"delta":{"content":"Once upon"}}]}`+"\n\n"),
+							failingReader{err: io.ErrUnexpectedEOF},
+						)),
+					}, nil
+				}),
+			}
+			openai := providers.NewOpenAI(
+				[]string{"first-key", "second-key"},
+				providers.WithClock(newFakeClock()),
+			)
+
+			_, err := openai.StreamResponse(
+				context.Background(),
+				client,
+				request.Completion{
+					Model:        models.GPT4OMini{},
+					UserMessage:  "Tell me a story.",
+					ResumeOnDrop: tt.resume,
+				},
+				func(chunk string) error { return nil },
+				&response.Logging{},
+			)
+			require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+			assert.Equal(t, tt.wantCalls, calls)
+		})
+	}
+}
+
+func TestOpenAICompatibleStreamsSkipKeepalives(t *testing.T) {
+	t.Parallel()
+
+	body := strings.Join([]string{
+		": OPENROUTER PROCESSING",
+		"",
+		`data: {"choices":[{"delta":{"content":"hel"}}]}`,
+		"",
+		"event: ping",
+		"data: keepalive",
+		"",
+		": ping",
+		`data: {"choices":[{"delta":{"content":"lo"}}]}`,
+		"",
+		"data: [DONE]",
+		"",
+	}, "\n")
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		}),
+	}
+
+	tests := map[string]struct {
+		provider providers.LLMProvider
+		model    models.Model
+	}{
+		"openai": {
+			provider: providers.NewOpenAI([]string{"test-key"}),
+			model:    models.GPT4OMini{},
+		},
+		"grok": {
+			provider: providers.NewGrok([]string{"test-key"}),
+			model:    models.Grok3Mini{},
+		},
+		"openrouter": {
+			provider: providers.NewOpenRouter([]string{"test-key"}),
+			model:    models.OpenRouterModel{ModelName: "openai/gpt-4o-mini"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := tt.provider.CompleteResponse(
+				context.Background(),
+				request.Completion{
+					Model:       tt.model,
+					UserMessage: "Say hello in one sentence.",
+				},
+				client,
+				&response.Logging{},
+			)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", res.Content)
+		})
+	}
+}
+
+func TestOpenAIStalledStreamHonorsCancellation(t *testing.T) {
+	t.Parallel()
+
+	pr, pw := io.Pipe()
+	t.Cleanup(func() { pw.Close() })
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			go pw.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n"))
+			return &http.Response{StatusCode: http.StatusOK, Body: pr}, nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := openai.StreamResponse(
+			ctx,
+			client,
+			request.Completion{
+				Model:       models.GPT4OMini{},
+				UserMessage: "Say hello in one sentence.",
+			},
+			func(chunk string) error {
+				// The upstream goes silent after this chunk.
+				cancel()
+				return nil
+			},
+			&response.Logging{},
+		)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("stream read did not return after the context was cancelled")
+	}
+}
+
+func TestOpenAIReusesConnections(t *testing.T) {
+	t.Parallel()
+
+	var newConns atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w,
+				"data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n")
+		},
+	))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConns.Add(1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+
+	target, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	transport := &http.Transport{}
+	t.Cleanup(transport.CloseIdleConnections)
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			return transport.RoundTrip(req)
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	for range 3 {
+		_, err := openai.CompleteResponse(
+			context.Background(),
+			request.Completion{
+				Model:       models.GPT4OMini{},
+				UserMessage: "Say hello in one sentence.",
+			},
+			client,
+			&response.Logging{},
+		)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, int32(1), newConns.Load())
+}
+
+func TestOpenAIContentFiltered(t *testing.T) {
+	t.Parallel()
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"Once upon"}}]}`,
+				`{"choices":[{"delta":{},"finish_reason":"content_filter"}]}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	_, err := openai.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.GPT4OMini{},
+			UserMessage: "Say hello in one sentence.",
+		},
+		client,
+		&response.Logging{},
+	)
+
+	var filtered *response.ContentFilteredError
+	require.ErrorAs(t, err, &filtered)
+	assert.Equal(t, "content_filter", filtered.Reason)
+	assert.Equal(t, "Once upon", filtered.Content)
+}
+
+// slowStream stalls for delay before delivering its first part, as a
+// keep-alive arriving from a model that is slow to start would.
+type slowStream struct {
+	delay   time.Duration
+	parts   []string
+	started bool
+}
+
+func (s *slowStream) Read(p []byte) (int, error) {
+	if len(s.parts) == 0 {
+		return 0, io.EOF
+	}
+	if !s.started {
+		s.started = true
+		time.Sleep(s.delay)
+	}
+
+	n := copy(p, s.parts[0])
+	s.parts = s.parts[1:]
+	return n, nil
+}
+
+func (s *slowStream) Close() error { return nil }
+
+func TestOpenAIFirstChunkTimeout(t *testing.T) {
+	t.Parallel()
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body: &slowStream{
+					delay: 50 * time.Millisecond,
+					parts: []string{
+						"\n",
+						"data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n",
+						"data: [DONE]\n",
+					},
+				},
+			}, nil
+		}),
+	}
+	req := request.Completion{
+		Model:       models.GPT4OMini{},
+		UserMessage: "Say hello in one sentence.",
+	}
+
+	tests := map[string]struct {
+		timeout time.Duration
+		wantErr error
+	}{
+		"should abandon a stream slower than the timeout": {
+			timeout: 10 * time.Millisecond,
+			wantErr: context.Canceled,
+		},
+		"should wait indefinitely when disabled": {
+			timeout: -1,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			openai := providers.NewOpenAI(
+				[]string{"test-key"},
+				providers.WithFirstChunkTimeout(tt.timeout),
+			)
+
+			res, err := openai.CompleteResponse(
+				context.Background(),
+				req,
+				client,
+				&response.Logging{},
+			)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "hi", res.Content)
+		})
+	}
+}
+
+func TestOpenAIRetryExhausted(t *testing.T) {
+	t.Parallel()
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+				Body:       io.NopCloser(strings.NewReader("")),
+			}, nil
+		}),
+	}
+	// The five backoffs add up to 3.1s without jitter.
+	openai := providers.NewOpenAI(
+		[]string{"test-key"},
+		providers.WithClock(newFakeClock()),
+		providers.WithJitter(1, 1),
+	)
+
+	_, err := openai.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.GPT4OMini{},
+			UserMessage: "Say hello in one sentence.",
+		},
+		client,
+		&response.Logging{},
+	)
+
+	var exhausted *providers.RetryExhaustedError
+	require.ErrorAs(t, err, &exhausted)
+	assert.Equal(t, 5, exhausted.Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, exhausted.LastStatusCode)
+	assert.Equal(t, 3100*time.Millisecond, exhausted.Elapsed)
+	assert.ErrorContains(t, err, "max retries exceeded")
+}
+
+func TestOpenAIRetryClassifier(t *testing.T) {
+	t.Parallel()
+
+	warmingUp := func(statusCode int, body string, err error) bool {
+		return statusCode == http.StatusBadRequest && strings.Contains(body, "warming up")
+	}
+
+	tests := map[string]struct {
+		status    int
+		body      string
+		wantCalls int
+		wantErr   bool
+	}{
+		"should retry a 400 the classifier accepts": {
+			status:    http.StatusBadRequest,
+			body:      `{"error":"model warming up"}`,
+			wantCalls: 3,
+		},
+		"should not retry a 503 the classifier rejects": {
+			status:    http.StatusServiceUnavailable,
+			wantCalls: 2,
+			wantErr:   true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			calls := 0
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					calls++
+					if calls <= 2 {
+						return &http.Response{
+							StatusCode: tt.status,
+							Body:       io.NopCloser(strings.NewReader(tt.body)),
+						}, nil
+					}
+					return sseResponse(`{"choices":[{"delta":{"content":"hi"}}]}`, "[DONE]"), nil
+				}),
+			}
+			openai := providers.NewOpenAI(
+				[]string{"test-key"},
+				providers.WithClock(newFakeClock()),
+				providers.WithRetryClassifier(warmingUp),
+			)
+
+			res, err := openai.CompleteResponse(
+				context.Background(),
+				request.Completion{
+					Model:       models.GPT4OMini{},
+					UserMessage: "Say hello in one sentence.",
+				},
+				client,
+				&response.Logging{},
+			)
+
+			assert.Equal(t, tt.wantCalls, calls)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "hi", res.Content)
+		})
+	}
+}
+
+func TestOpenAIJitter(t *testing.T) {
+	t.Parallel()
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+				Body:       io.NopCloser(strings.NewReader("")),
+			}, nil
+		}),
+	}
+	// The five backoffs add up to 3.1s before jitter.
+	openai := providers.NewOpenAI([]string{"test-key"}, providers.WithJitter(0.01, 0.01))
+
+	_, err := openai.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.GPT4OMini{},
+			UserMessage: "Say hello in one sentence.",
+		},
+		client,
+		&response.Logging{},
+	)
+
+	var exhausted *providers.RetryExhaustedError
+	require.ErrorAs(t, err, &exhausted)
+	assert.GreaterOrEqual(t, exhausted.Elapsed, 30*time.Millisecond)
+	assert.Less(t, exhausted.Elapsed, time.Second)
+}
+
+func TestOpenAICachedAndReasoningTokens(t *testing.T) {
+	t.Parallel()
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"hi"}}]}`,
+				`{"choices":[],"usage":{"prompt_tokens":2048,"completion_tokens":300,"total_tokens":2348,`+
+					`"prompt_tokens_details":{"cached_tokens":1024},`+
+					`"completion_tokens_details":{"reasoning_tokens":256}}}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	res, err := openai.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.GPT5Mini{},
+			UserMessage: "Say hello in one sentence.",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1024, res.Usage.CachedTokens)
+	assert.Equal(t, 256, res.Usage.ReasoningTokens)
+}
+
+func TestOpenAIServiceTier(t *testing.T) {
+	t.Parallel()
+
+	var body map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"hi"}}],"service_tier":"flex"}`,
+				`{"choices":[],"service_tier":"flex","usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	res, err := openai.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.GPT5Mini{},
+			UserMessage: "Say hello in one sentence.",
+			ServiceTier: "flex",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "flex", body["service_tier"])
+	assert.Equal(t, "flex", res.ServiceTier)
+}
+
+func TestOpenAISamplingParameters(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		req  request.Completion
+		want map[string]any
+	}{
+		"should send a temperature of 1 by default": {
+			want: map[string]any{"temperature": 1.0},
+		},
+		"should send the request's sampling parameters": {
+			req: request.Completion{
+				Temperature:     0.2,
+				TopP:            0.9,
+				StopSequences:   []string{"\n\n"},
+				PresencePenalty: 0.5,
+			},
+			want: map[string]any{
+				"temperature":      0.2,
+				"top_p":            0.9,
+				"stop":             []any{"\n\n"},
+				"presence_penalty": 0.5,
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var body map[string]any
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+						return nil, err
+					}
+					return sseResponse(`{"choices":[{"delta":{"content":"hi"}}]}`, "[DONE]"), nil
+				}),
+			}
+			openai := providers.NewOpenAI([]string{"test-key"})
+
+			req := tt.req
+			req.Model = models.GPT4OMini{}
+			req.UserMessage = "Say hello in one sentence."
+			_, err := openai.CompleteResponse(context.Background(), req, client, &response.Logging{})
+			require.NoError(t, err)
+
+			for key, want := range tt.want {
+				assert.Equal(t, want, body[key], key)
+			}
+		})
+	}
+}
+
+func TestOpenAIStoreAndMetadata(t *testing.T) {
+	t.Parallel()
+
+	var body map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"hi"}}]}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	_, err := openai.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.GPT5Mini{},
+			UserMessage: "Say hello in one sentence.",
+			Store:       true,
+			Tags: map[string]string{
+				"metadata.experiment": "onboarding-v2",
+				"team":                "growth",
+			},
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, true, body["store"])
+	assert.Equal(t, map[string]any{"experiment": "onboarding-v2"}, body["metadata"])
+}
+
+func TestOpenAIExtraBody(t *testing.T) {
+	t.Parallel()
+
+	var body map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"hi"}}]}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	_, err := openai.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.GPT4O{},
+			UserMessage: "Say hello in one sentence.",
+			Store:       true,
+			ExtraBody: map[string]any{
+				"prediction": map[string]any{"type": "content", "content": "Hello!"},
+				"store":      false,
+			},
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		map[string]any{"type": "content", "content": "Hello!"},
+		body["prediction"],
+	)
+	assert.Equal(t, false, body["store"], "extra body should win over explicit fields")
+	assert.Equal(t, models.GPT4OAlias, body["model"])
+}
+
+func TestOpenAISizeLimits(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("hello ", 50)
+
+	tests := map[string]struct {
+		opts      []providers.Option
+		wantErr   error
+		wantCalls int
+	}{
+		"should reject a request over the limit before sending it": {
+			opts:      []providers.Option{providers.WithMaxRequestBytes(64)},
+			wantErr:   providers.ErrRequestTooLarge,
+			wantCalls: 0,
+		},
+		"should stop reading a response over the limit": {
+			opts:      []providers.Option{providers.WithMaxResponseBytes(128)},
+			wantErr:   providers.ErrResponseTooLarge,
+			wantCalls: 1,
+		},
+		"should allow both within the limits": {
+			opts:      []providers.Option{providers.WithMaxRequestBytes(4096), providers.WithMaxResponseBytes(4096)},
+			wantCalls: 1,
+		},
+		"should disable the limits when negative": {
+			opts:      []providers.Option{providers.WithMaxRequestBytes(-1), providers.WithMaxResponseBytes(-1)},
+			wantCalls: 1,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var calls atomic.Int32
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					calls.Add(1)
+					return sseResponse(
+						`{"choices":[{"delta":{"content":"`+long+`"}}]}`,
+						"[DONE]",
+					), nil
+				}),
+			}
+			openai := providers.NewOpenAI([]string{"first-key", "second-key"}, tt.opts...)
+
+			res, err := openai.CompleteResponse(
+				context.Background(),
+				request.Completion{
+					Model:       models.GPT4OMini{},
+					UserMessage: "Say hello fifty times.",
+				},
+				client,
+				&response.Logging{},
+			)
+			assert.EqualValues(t, tt.wantCalls, calls.Load())
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, long, res.Content)
+		})
+	}
+}
+
+func TestOpenAIContentLimit(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		limit       int64
+		wantContent string
+		wantErr     error
+	}{
+		"should return the content up to the limit": {
+			limit:       8,
+			wantContent: "hello wo",
+			wantErr:     providers.ErrResponseTooLarge,
+		},
+		"should not split a multi-byte character": {
+			limit:       14,
+			wantContent: "hello world ",
+			wantErr:     providers.ErrResponseTooLarge,
+		},
+		"should allow content within the limit": {
+			limit:       64,
+			wantContent: "hello world 👋",
+		},
+		"should disable the limit when negative": {
+			limit:       -1,
+			wantContent: "hello world 👋",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var calls atomic.Int32
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					calls.Add(1)
+					return sseResponse(
+						`{"choices":[{"delta":{"content":"hello "}}]}`,
+						`{"choices":[{"delta":{"content":"world "}}]}`,
+						`{"choices":[{"delta":{"content":"👋"}}]}`,
+						"[DONE]",
+					), nil
+				}),
+			}
+			openai := providers.NewOpenAI(
+				[]string{"first-key", "second-key"},
+				providers.WithMaxContentBytes(tt.limit),
+			)
+
+			var streamed strings.Builder
+			res, err := openai.StreamResponse(
+				context.Background(),
+				client,
+				request.Completion{Model: models.GPT4OMini{}, UserMessage: "Say hello."},
+				func(chunk string) error {
+					streamed.WriteString(chunk)
+					return nil
+				},
+				&response.Logging{},
+			)
+			assert.EqualValues(t, 1, calls.Load(), "a response over the limit should not be retried")
+			assert.Equal(t, tt.wantContent, res.Content)
+			assert.Equal(t, tt.wantContent, streamed.String())
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				assert.True(t, res.Partial)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.False(t, res.Partial)
+		})
+	}
+}
+
+func TestOpenAIRawCaptureSampling(t *testing.T) {
+	t.Parallel()
+
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return sseResponse(`{"choices":[{"delta":{"content":"hi"}}]}`, "[DONE]"), nil
+		}),
+	}
+	complete := func(t *testing.T, openai providers.Openai, id string) response.Completion {
+		t.Helper()
+
+		res, err := openai.CompleteResponse(
+			request.WithID(context.Background(), id),
+			request.Completion{Model: models.GPT4OMini{}, UserMessage: "Hello"},
+			client,
+			&response.Logging{},
+		)
+		require.NoError(t, err)
+
+		return res
+	}
+
+	t.Run("should capture every response by default", func(t *testing.T) {
+		t.Parallel()
+
+		res := complete(t, providers.NewOpenAI([]string{"test-key"}), "req-1")
+		assert.NotEmpty(t, res.RawRequest)
+		assert.NotEmpty(t, res.RawResponse)
+	})
+
+	t.Run("should capture nothing at a zero rate", func(t *testing.T) {
+		t.Parallel()
+
+		openai := providers.NewOpenAI([]string{"test-key"}, providers.WithRawCaptureSampling(0))
+		res := complete(t, openai, "req-1")
+		assert.Empty(t, res.RawRequest)
+		assert.Empty(t, res.RawResponse)
+		assert.Equal(t, "hi", res.Content)
+	})
+
+	t.Run("should sample by request id", func(t *testing.T) {
+		t.Parallel()
+
+		openai := providers.NewOpenAI([]string{"test-key"}, providers.WithRawCaptureSampling(0.5))
+		captured := 0
+		for i := range 200 {
+			id := fmt.Sprintf("req-%d", i)
+			first := complete(t, openai, id)
+			second := complete(t, openai, id)
+			assert.Equal(t, first.RawRequest != nil, second.RawRequest != nil,
+				"every attempt of a request should be sampled alike")
+			if first.RawRequest != nil {
+				captured++
+			}
+		}
+		assert.InDelta(t, 100, captured, 40)
+	})
+}
+
+func TestOpenAIValidate(t *testing.T) {
+	t.Parallel()
+
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		t.Skip("OPENAI_API_KEY not set")
+	}
+
+	openai := providers.NewOpenAI([]string{apiKey, "sk-invalid"})
+
+	results := openai.Validate(context.Background())
+	require.Len(t, results, 2)
+	assert.NoError(t, results[apiKey])
+	assert.ErrorIs(t, results["sk-invalid"], providers.ErrInvalidKey)
+}
+
+func TestOpenAIStreamNonStreamingModel(t *testing.T) {
+	t.Parallel()
+
+	var path string
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			path = req.URL.Path
+			return jsonResponse(`{"created":1,"data":[{"b64_json":"aW1hZ2U="}]}`), nil
+		}),
+	}
+	openai := providers.NewOpenAI([]string{"test-key"})
+
+	var completed bool
+	collector := &providers.Collector{}
+	requestLog := &response.Logging{}
+	res, err := openai.StreamResponse(
+		context.Background(),
+		client,
+		request.Completion{
+			Model:       &models.GPTImage{},
+			UserMessage: "A lighthouse at dusk.",
+			Tags:        map[string]string{},
+			OnComplete: func(res response.Completion) error {
+				completed = true
+				return nil
+			},
+		},
+		collector.Handle,
+		requestLog,
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "/v1/images/generations", path)
+	assert.Equal(t, "aW1hZ2U=", res.Content)
+	assert.Equal(t, []string{"aW1hZ2U="}, collector.Chunks())
+	assert.True(t, completed)
+	assert.Contains(t, requestLog.Events[0].Description, "does not stream")
+}
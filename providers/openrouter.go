@@ -4,8 +4,6 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/rand"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,34 +20,54 @@ import (
 var openRouterBaseURL = "https://openrouter.ai/api/v1"
 
 type openRouterRequest struct {
-	Model          string         `json:"model"`
-	Messages       any            `json:"messages"`
-	Stream         bool           `json:"stream"`
-	StreamOptions  streamOptions  `json:"stream_options"`
-	Temperature    float32        `json:"temperature,omitempty"`
-	ResponseFormat map[string]any `json:"response_format,omitempty"`
+	Model           string               `json:"model"`
+	Messages        any                  `json:"messages"`
+	Stream          bool                 `json:"stream"`
+	StreamOptions   streamOptions        `json:"stream_options"`
+	Temperature     float32              `json:"temperature,omitempty"`
+	TopP            float32              `json:"top_p,omitempty"`
+	Stop            []string             `json:"stop,omitempty"`
+	PresencePenalty float32              `json:"presence_penalty,omitempty"`
+	ResponseFormat  map[string]any       `json:"response_format,omitempty"`
+	Reasoning       *openRouterReasoning `json:"reasoning,omitempty"`
+}
+
+type openRouterReasoning struct {
+	Effort    string `json:"effort,omitempty"`
+	MaxTokens int    `json:"max_tokens,omitempty"`
+	Exclude   bool   `json:"exclude,omitempty"`
 }
 
 type openRouterChunk struct {
 	Choices []struct {
 		Delta struct {
-			Content string `json:"content"`
+			Content   string `json:"content"`
+			Reasoning string `json:"reasoning"`
 		} `json:"delta"`
 	} `json:"choices"`
 	Usage struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
+		PromptTokens            int `json:"prompt_tokens"`
+		CompletionTokens        int `json:"completion_tokens"`
+		TotalTokens             int `json:"total_tokens"`
+		CompletionTokensDetails struct {
+			ReasoningTokens int `json:"reasoning_tokens"`
+		} `json:"completion_tokens_details"`
 	} `json:"usage"`
 }
 
 type OpenRouter struct {
 	apiKeys []string
+	keys    *KeyDistributor
+	opts    options
 }
 
-func NewOpenRouter(apiKeys []string) OpenRouter {
+func NewOpenRouter(apiKeys []string, opts ...Option) OpenRouter {
+	o := newOptions(opts)
+
 	return OpenRouter{
 		apiKeys: apiKeys,
+		keys:    o.keyDistributor(apiKeys),
+		opts:    o,
 	}
 }
 
@@ -57,6 +75,30 @@ func (or OpenRouter) Name() string {
 	return models.OpenRouterProvider
 }
 
+// Supports implements LLMProvider for models.OpenRouterModel, which carries
+// the upstream model name.
+func (or OpenRouter) Supports(m models.Model) bool {
+	_, ok := m.(models.OpenRouterModel)
+	return ok
+}
+
+// SupportsPrefill implements LLMProvider. The chat API answers a trailing
+// assistant turn afresh rather than continuing it.
+func (or OpenRouter) SupportsPrefill() bool {
+	return false
+}
+
+// Validate checks every API key against the API concurrently and returns the
+// outcome per key, nil meaning the key works. Keys the API rejects are
+// excluded from later requests.
+func (or OpenRouter) Validate(ctx context.Context) map[string]error {
+	return validateKeys(ctx, or.apiKeys, or.keys, func(ctx context.Context, key string) (int, error) {
+		return pingURL(ctx, or.opts, openRouterBaseURL+"/key", func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer "+key)
+		})
+	})
+}
+
 func (or OpenRouter) doRequest(
 	ctx context.Context,
 	req request.Completion,
@@ -64,16 +106,36 @@ func (or OpenRouter) doRequest(
 	chunkHandler func(chunk string) error,
 	key string,
 ) (response.Completion, int, error) {
+	key, release, err := or.keys.Acquire(ctx, key)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	defer release()
+
 	model, ok := req.Model.(models.OpenRouterModel)
 	if !ok {
 		return response.Completion{}, 0, errors.New("model must be OpenRouterModel")
 	}
 
 	openRouterReq := openRouterRequest{
-		Model:         model.ModelName,
-		Stream:        true,
-		StreamOptions: streamOptions{IncludeUsage: true},
-		Temperature:   1.0,
+		Model:           model.ModelName,
+		Stream:          true,
+		StreamOptions:   streamOptions{IncludeUsage: true},
+		Temperature:     1.0,
+		TopP:            req.TopP,
+		Stop:            req.StopSequences,
+		PresencePenalty: req.PresencePenalty,
+	}
+	if req.Temperature != 0 {
+		openRouterReq.Temperature = req.Temperature
+	}
+
+	if model.Reasoning != nil {
+		openRouterReq.Reasoning = &openRouterReasoning{
+			Effort:    model.Reasoning.Effort,
+			MaxTokens: model.Reasoning.MaxTokens,
+			Exclude:   model.Reasoning.Exclude,
+		}
 	}
 
 	if len(model.StructuredOutput) > 0 {
@@ -98,6 +160,21 @@ func (or OpenRouter) doRequest(
 	if err != nil {
 		return response.Completion{}, 0, err
 	}
+	body, err = withRawMessages(body, "messages", req.RawMessages)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	body, err = withExtraBody(body, req.ExtraBody)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	if err := or.opts.checkRequestSize(body); err != nil {
+		return response.Completion{}, 0, err
+	}
+
+	// Stop upstream generation if we return before the stream is drained.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	httpReq, err := http.NewRequestWithContext(ctx, "POST",
 		fmt.Sprintf("%s/chat/completions", openRouterBaseURL),
@@ -106,8 +183,16 @@ func (or OpenRouter) doRequest(
 		return response.Completion{}, 0, fmt.Errorf("create request: %w", err)
 	}
 
+	or.opts.setHeaders(httpReq)
+
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Authorization", "Bearer "+key)
+	if or.opts.appTitle != "" {
+		httpReq.Header.Set("X-Title", or.opts.appTitle)
+	}
+	if or.opts.appURL != "" {
+		httpReq.Header.Set("HTTP-Referer", or.opts.appURL)
+	}
 
 	resp, err := client.Do(httpReq)
 	if err != nil {
@@ -117,19 +202,24 @@ func (or OpenRouter) doRequest(
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return response.Completion{}, resp.StatusCode, fmt.Errorf(
-			"received status code %d: %s", resp.StatusCode, string(bodyBytes))
+		return response.Completion{}, resp.StatusCode, withBody(fmt.Errorf(
+			"received status code %d: %s", resp.StatusCode, string(bodyBytes)), bodyBytes)
 	}
 
-	reader := bufio.NewReader(resp.Body)
-	var fullContent strings.Builder
+	stream := newStreamBody(ctx, or.opts.limitResponse(resp.Body))
+	defer stream.Close()
+
+	reader := bufio.NewReader(stream)
+	replays := replayFilter{enabled: req.DedupeChunks}
+	fullContent := or.opts.newContent()
+	var thoughts strings.Builder
 	var usage response.Usage
 	var rawEvents []json.RawMessage
 	chunks := 0
 	now := time.Now()
 
 	for {
-		if chunks == 0 && time.Since(now).Seconds() > 3.0 {
+		if chunks == 0 && or.opts.firstChunkTimedOut(now) {
 			return response.Completion{}, 0, context.Canceled
 		}
 		line, err := reader.ReadString('\n')
@@ -140,9 +230,11 @@ func (or OpenRouter) doRequest(
 			return response.Completion{}, 0, fmt.Errorf("read line: %w", err)
 		}
 
-		line = strings.TrimPrefix(line, "data: ")
-		line = strings.TrimSpace(line)
-		if line == "" || line == "[DONE]" {
+		if replays.replayed(line) {
+			continue
+		}
+		line, ok := sseData(line)
+		if !ok {
 			continue
 		}
 
@@ -154,11 +246,25 @@ func (or OpenRouter) doRequest(
 		rawEvents = append(rawEvents, json.RawMessage(line))
 
 		if len(chunk.Choices) > 0 {
-			fullContent.WriteString(chunk.Choices[0].Delta.Content)
+			delta := chunk.Choices[0].Delta
+			if delta.Reasoning != "" {
+				thoughts.WriteString(delta.Reasoning)
+				if req.ThoughtHandler != nil {
+					if err := req.ThoughtHandler(delta.Reasoning); err != nil {
+						return response.Completion{}, 0, err
+					}
+				}
+			}
 
-			if chunkHandler != nil {
-				if err := chunkHandler(chunk.Choices[0].Delta.Content); err != nil {
-					return response.Completion{}, 0, err
+			if delta.Content != "" {
+				text, limitErr := fullContent.add(delta.Content)
+				if chunkHandler != nil {
+					if err := chunkHandler(text); err != nil {
+						return response.Completion{}, 0, err
+					}
+				}
+				if limitErr != nil {
+					return fullContent.partial(model.ModelName, or.Name()), 0, limitErr
 				}
 			}
 		}
@@ -169,22 +275,29 @@ func (or OpenRouter) doRequest(
 				PromptTokens:     chunk.Usage.PromptTokens,
 				CompletionTokens: chunk.Usage.CompletionTokens,
 				TotalTokens:      chunk.Usage.TotalTokens,
+				ReasoningTokens:  chunk.Usage.CompletionTokensDetails.ReasoningTokens,
 			}
 		}
 	}
 
+	if usage.TotalTokens == 0 {
+		usage = estimateUsage(req, fullContent.String())
+	}
+
 	rawResp, err := json.Marshal(rawEvents)
 	if err != nil {
 		return response.Completion{}, 0, fmt.Errorf("marshal raw response events: %w", err)
 	}
 
-	return response.Completion{
+	return finishStream(req, chunkHandler, or.opts.sampleRaw(ctx, response.Completion{
 		Content:     fullContent.String(),
+		Thoughts:    thoughts.String(),
 		Model:       model.ModelName,
+		Provider:    or.Name(),
 		Usage:       usage,
 		RawRequest:  body,
 		RawResponse: rawResp,
-	}, 0, nil
+	}))
 }
 
 func (or OpenRouter) tryWithBackup(
@@ -194,6 +307,10 @@ func (or OpenRouter) tryWithBackup(
 	chunkHandler func(chunk string) error,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if len(or.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
 	key := or.apiKeys[0]
 
 	maxRetries := 5
@@ -201,6 +318,9 @@ func (or OpenRouter) tryWithBackup(
 	maxBackoff := 10 * time.Second
 
 	var lastErr error
+	var lastStatusCode int
+	start := or.opts.now()
+	backoffStep := 0
 	for attempt := range maxRetries {
 		requestLog.Events = append(requestLog.Events, response.Event{
 			Timestamp:   time.Now(),
@@ -215,45 +335,51 @@ func (or OpenRouter) tryWithBackup(
 			})
 			return response.Completion{}, ctx.Err()
 		default:
-			res, resCode, err := or.doRequest(ctx, req, client, chunkHandler, key)
+			var progress streamProgress
+			res, resCode, err := or.doRequest(ctx, req, progress.client(client), progress.wrap(chunkHandler), key)
 			if err == nil {
 				return res, nil
 			}
+			dropped, delivered := progress.failed(ctx, err, requestLog)
+			if delivered {
+				return response.Completion{}, err
+			}
+			if dropped {
+				backoffStep = 0
+			}
 
 			requestLog.Events = append(requestLog.Events, response.Event{
 				Timestamp:   time.Now(),
 				Description: fmt.Sprintf("request failed: %v", err),
 			})
 
-			if !isRetryableError(resCode) {
-				return response.Completion{}, err
+			if !dropped && !or.opts.retryable(resCode, err) {
+				return res, err
 			}
 
 			lastErr = err
+			lastStatusCode = resCode
 
-			backoff := min(initialBackoff*time.Duration(1<<attempt), maxBackoff)
-
-			var randomBytes [8]byte
-			var jitter time.Duration
-			if _, err := rand.Read(randomBytes[:]); err != nil {
-				jitter = backoff
-			} else {
-				randFloat := float64(binary.LittleEndian.Uint64(randomBytes[:])) / (1 << 64)
-				jitter = time.Duration(float64(backoff) * (0.8 + 0.4*randFloat))
-			}
+			backoff := min(initialBackoff*time.Duration(1<<backoffStep), maxBackoff)
+			backoffStep++
 
-			timer := time.NewTimer(jitter)
+			timer := or.opts.newTimer(or.opts.jitter(backoff))
 			select {
 			case <-ctx.Done():
 				timer.Stop()
 				return response.Completion{}, ctx.Err()
-			case <-timer.C:
+			case <-timer.C():
 				continue
 			}
 		}
 	}
 
-	return response.Completion{}, fmt.Errorf("max retries exceeded: %w", lastErr)
+	return response.Completion{}, &RetryExhaustedError{
+		Attempts:       maxRetries,
+		Elapsed:        or.opts.now().Sub(start),
+		LastStatusCode: lastStatusCode,
+		Err:            lastErr,
+	}
 }
 
 func (or OpenRouter) CompleteResponse(
@@ -262,6 +388,10 @@ func (or OpenRouter) CompleteResponse(
 	client http.Client,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if len(or.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
 	reqLog := &response.Logging{}
 	if requestLog == nil {
 		req.Tags["request_type"] = "completion"
@@ -279,6 +409,12 @@ func (or OpenRouter) CompleteResponse(
 	}
 
 	for i, key := range or.apiKeys {
+		select {
+		case <-ctx.Done():
+			return response.Completion{}, ctx.Err()
+		default:
+		}
+
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp:   time.Now(),
 			Description: fmt.Sprintf("attempting request with key_number: %v", i),
@@ -287,6 +423,9 @@ func (or OpenRouter) CompleteResponse(
 		if err == nil {
 			return res, nil
 		}
+		if exceedsSizeLimit(err) {
+			return res, err
+		}
 
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp:   time.Now(),
@@ -304,6 +443,14 @@ func (or OpenRouter) StreamResponse(
 	chunkHandler func(chunk string) error,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if !streams(req.Model) {
+		return completeAsStream(ctx, or, client, req, chunkHandler, requestLog)
+	}
+
+	if len(or.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
 	reqLog := &response.Logging{}
 	if requestLog == nil {
 		req.Tags["request_type"] = "streaming"
@@ -320,7 +467,15 @@ func (or OpenRouter) StreamResponse(
 		reqLog = requestLog
 	}
 
+	chunkHandler, delivered := trackDelivery(chunkHandler)
+
 	for i, key := range or.apiKeys {
+		select {
+		case <-ctx.Done():
+			return response.Completion{}, ctx.Err()
+		default:
+		}
+
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp:   time.Now(),
 			Description: fmt.Sprintf("attempting request with key_number: %v", i),
@@ -329,6 +484,12 @@ func (or OpenRouter) StreamResponse(
 		if err == nil {
 			return res, nil
 		}
+		if exceedsSizeLimit(err) {
+			return res, err
+		}
+		if delivered() {
+			return response.Completion{}, err
+		}
 
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp:   time.Now(),
@@ -419,8 +580,10 @@ func prepareOpenRouterRequestWithImage(
 		reqMsgWithImage[lastIndex].Content = append(reqMsgWithImage[lastIndex].Content, ii)
 	}
 
-	reqMsgWithImage[lastIndex].Content = append(reqMsgWithImage[lastIndex].Content,
-		fileInputMessage{Type: "text", Text: userMsg})
+	if userMsg != "" {
+		reqMsgWithImage[lastIndex].Content = append(reqMsgWithImage[lastIndex].Content,
+			fileInputMessage{Type: "text", Text: userMsg})
+	}
 
 	req.Messages = reqMsgWithImage
 	return req, nil
@@ -482,8 +645,10 @@ func prepareOpenRouterRequestWithPdf(
 	}
 	reqMsgWithFile[lastIndex].Content = append(reqMsgWithFile[lastIndex].Content, fi)
 
-	reqMsgWithFile[lastIndex].Content = append(reqMsgWithFile[lastIndex].Content,
-		fileInputMessage{Type: "text", Text: userMsg})
+	if userMsg != "" {
+		reqMsgWithFile[lastIndex].Content = append(reqMsgWithFile[lastIndex].Content,
+			fileInputMessage{Type: "text", Text: userMsg})
+	}
 
 	req.Messages = reqMsgWithFile
 	return req, nil
@@ -510,16 +675,24 @@ func prepareOpenRouterBasicMessages(
 	}
 
 	for i := range history {
-		requestMessages = append(requestMessages, requestMessage{
+		msg := requestMessage{
 			Role:    history[i].Role,
 			Content: history[i].Content,
-		})
+		}
+		if len(history[i].Images) > 0 || len(history[i].Parts) > 0 {
+			msg.Content = historyContentParts(history[i])
+		}
+		requestMessages = append(requestMessages, msg)
 	}
 
-	requestMessages = append(requestMessages, requestMessage{
-		Role:    "user",
-		Content: userMsg,
-	})
+	// An empty user message leaves the last history turn as the one to
+	// answer.
+	if userMsg != "" || hisLen == 0 {
+		requestMessages = append(requestMessages, requestMessage{
+			Role:    "user",
+			Content: userMsg,
+		})
+	}
 
 	req.Messages = requestMessages
 	return req, nil
@@ -0,0 +1,285 @@
+package providers_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/providers"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenRouterRequestHeaders(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		opts          []providers.Option
+		wantUserAgent string
+		wantTitle     string
+		wantReferer   string
+	}{
+		{
+			name:          "should send the default user agent",
+			wantUserAgent: "heimdall/",
+		},
+		{
+			name: "should send configured user agent and attribution",
+			opts: []providers.Option{
+				providers.WithUserAgent("my-app/1.2"),
+				providers.WithAppTitle("My App"),
+				providers.WithAppURL("https://example.com"),
+			},
+			wantUserAgent: "my-app/1.2",
+			wantTitle:     "My App",
+			wantReferer:   "https://example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent http.Header
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					sent = req.Header.Clone()
+					return sseResponse(
+						`{"choices":[{"delta":{"content":"hi"}}]}`,
+						"[DONE]",
+					), nil
+				}),
+			}
+			openRouter := providers.NewOpenRouter([]string{"test-key"}, tt.opts...)
+
+			_, err := openRouter.CompleteResponse(
+				request.WithID(context.Background(), "req-123"),
+				request.Completion{
+					Model:       models.OpenRouterModel{ModelName: "openai/gpt-4o-mini"},
+					UserMessage: "Say hello in one sentence.",
+				},
+				client,
+				&response.Logging{},
+			)
+			require.NoError(t, err)
+
+			assert.Equal(t, "req-123", sent.Get("X-Request-ID"))
+
+			assert.True(
+				t,
+				strings.HasPrefix(sent.Get("User-Agent"), tt.wantUserAgent),
+				"unexpected user agent: %s",
+				sent.Get("User-Agent"),
+			)
+			assert.Equal(t, tt.wantTitle, sent.Get("X-Title"))
+			assert.Equal(t, tt.wantReferer, sent.Get("HTTP-Referer"))
+		})
+	}
+}
+
+func TestOpenRouterWithoutAPIKeys(t *testing.T) {
+	t.Parallel()
+
+	provider := providers.NewOpenRouter(nil)
+	req := request.Completion{
+		Model:       models.OpenRouterModel{ModelName: "openai/gpt-4o-mini"},
+		UserMessage: "Say hello in one sentence.",
+	}
+
+	_, err := provider.CompleteResponse(context.Background(), req, http.Client{}, nil)
+	require.ErrorIs(t, err, providers.ErrNoAPIKeys)
+
+	_, err = provider.StreamResponse(
+		context.Background(),
+		http.Client{},
+		req,
+		func(chunk string) error { return nil },
+		nil,
+	)
+	require.ErrorIs(t, err, providers.ErrNoAPIKeys)
+}
+
+func TestOpenRouterStreamingUsage(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		events        []string
+		wantTotal     int
+		wantEstimated bool
+	}{
+		{
+			name: "should use usage reported after the final chunk",
+			events: []string{
+				`{"choices":[{"delta":{"content":"hello there"}}]}`,
+				"[DONE]",
+				`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":3,"total_tokens":15}}`,
+			},
+			wantTotal: 15,
+		},
+		{
+			name: "should estimate usage when the stream omits it",
+			events: []string{
+				`{"choices":[{"delta":{"content":"hello there"}}]}`,
+				"[DONE]",
+			},
+			wantEstimated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					return sseResponse(tt.events...), nil
+				}),
+			}
+			openRouter := providers.NewOpenRouter([]string{"test-key"})
+
+			res, err := openRouter.StreamResponse(
+				context.Background(),
+				client,
+				request.Completion{
+					Model:       models.OpenRouterModel{ModelName: "openai/gpt-4o-mini"},
+					UserMessage: "Say hello in one sentence.",
+				},
+				func(chunk string) error { return nil },
+				&response.Logging{},
+			)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantEstimated, res.Usage.Estimated)
+			assert.NotZero(t, res.Usage.TotalTokens)
+			if tt.wantTotal != 0 {
+				assert.Equal(t, tt.wantTotal, res.Usage.TotalTokens)
+			}
+		})
+	}
+}
+
+func TestOpenRouterReasoning(t *testing.T) {
+	t.Parallel()
+
+	var body map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"","reasoning":"The user wants "}}]}`,
+				`{"choices":[{"delta":{"content":"","reasoning":"a greeting."}}]}`,
+				`{"choices":[{"delta":{"content":"Hello!"}}]}`,
+				`{"choices":[],"usage":{"prompt_tokens":10,"completion_tokens":20,"total_tokens":30,"completion_tokens_details":{"reasoning_tokens":18}}}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	openRouter := providers.NewOpenRouter([]string{"test-key"})
+
+	var thoughts []string
+	collector := &providers.Collector{}
+	res, err := openRouter.StreamResponse(
+		context.Background(),
+		client,
+		request.Completion{
+			Model: models.OpenRouterModel{
+				ModelName: "deepseek/deepseek-r1",
+				Reasoning: &models.OpenRouterReasoning{Effort: "high"},
+			},
+			UserMessage: "Say hello.",
+			ThoughtHandler: func(thought string) error {
+				thoughts = append(thoughts, thought)
+				return nil
+			},
+		},
+		collector.Handle,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]any{"effort": "high"}, body["reasoning"])
+	assert.Equal(t, []string{"The user wants ", "a greeting."}, thoughts)
+	assert.Equal(t, "The user wants a greeting.", res.Thoughts)
+	assert.Equal(t, []string{"Hello!"}, collector.Chunks())
+	assert.Equal(t, "Hello!", res.Content)
+	assert.Equal(t, 18, res.Usage.ReasoningTokens)
+}
+
+func TestOpenRouterDedupeChunks(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		body   string
+		dedupe bool
+		want   []string
+	}{
+		"should drop events re-sent with the same id": {
+			body: "id: 1\ndata: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n" +
+				"id: 2\ndata: {\"choices\":[{\"delta\":{\"content\":\" world\"}}]}\n\n" +
+				"id: 2\ndata: {\"choices\":[{\"delta\":{\"content\":\" world\"}}]}\n\n" +
+				"id: 1\ndata: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n" +
+				"data: [DONE]\n\n",
+			dedupe: true,
+			want:   []string{"Hello", " world"},
+		},
+		"should drop events re-sent with the same sequence number": {
+			body: "data: {\"sequence_number\":1,\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n" +
+				"data: {\"sequence_number\":1,\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n" +
+				"data: {\"sequence_number\":2,\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n",
+			dedupe: true,
+			want:   []string{"Hi", "Hi"},
+		},
+		"should keep repeated events without an identity": {
+			body: "data: {\"choices\":[{\"delta\":{\"content\":\"the \"}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"the \"}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"end\"}}]}\n\n",
+			dedupe: true,
+			want:   []string{"the ", "the ", "end"},
+		},
+		"should keep re-sent events when not enabled": {
+			body: "id: 1\ndata: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n" +
+				"id: 1\ndata: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n",
+			want: []string{"Hello", "Hello"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			client := http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+						Body:       io.NopCloser(strings.NewReader(tt.body)),
+					}, nil
+				}),
+			}
+			openRouter := providers.NewOpenRouter([]string{"test-key"})
+
+			collector := &providers.Collector{}
+			res, err := openRouter.StreamResponse(
+				context.Background(),
+				client,
+				request.Completion{
+					Model:        models.OpenRouterModel{ModelName: "openai/gpt-4o-mini"},
+					UserMessage:  "Say hello.",
+					DedupeChunks: tt.dedupe,
+				},
+				collector.Handle,
+				&response.Logging{},
+			)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, collector.Chunks())
+			assert.Equal(t, strings.Join(tt.want, ""), res.Content)
+		})
+	}
+}
@@ -0,0 +1,462 @@
+package providers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+)
+
+const modulePath = "github.com/flyx-ai/heimdall"
+
+// Option configures a provider at construction time.
+type Option func(*options)
+
+// options holds the configuration shared by every provider. The zero value is
+// valid, so providers built as struct literals behave like the defaults.
+type options struct {
+	userAgent string
+	logger    *slog.Logger
+
+	// maxConcurrencyPerKey caps simultaneous requests per API key. Zero means
+	// unbounded.
+	maxConcurrencyPerKey int
+
+	// appTitle and appURL identify the calling application to OpenRouter
+	// through the X-Title and HTTP-Referer headers.
+	appTitle string
+	appURL   string
+
+	// qwenBaseURL overrides the DashScope endpoint Qwen requests are sent
+	// to.
+	qwenBaseURL string
+
+	// anthropicVersion and anthropicBetas set the Anthropic-Version and
+	// anthropic-beta headers sent to Anthropic.
+	anthropicVersion string
+	anthropicBetas   []string
+
+	// firstChunkTimeout bounds the wait for the first streamed chunk. Zero
+	// means defaultFirstChunkTimeout and a negative value disables it.
+	firstChunkTimeout time.Duration
+
+	// jitterMin and jitterMax bound the random factor applied to retry
+	// backoffs. Both zero means the defaults.
+	jitterMin float64
+	jitterMax float64
+
+	// maxRequestBytes and maxResponseBytes cap the size of request and
+	// response bodies. Zero means the defaults and a negative value disables
+	// the limit.
+	maxRequestBytes  int64
+	maxResponseBytes int64
+
+	// maxContentBytes caps the content accumulated from a stream. Zero means
+	// defaultMaxContentBytes and a negative value disables the cap.
+	maxContentBytes int64
+
+	// rawCaptureRate is the fraction of requests that keep their raw request
+	// and response, once rawCaptureSampled is set. Unset keeps them all.
+	rawCaptureRate    float64
+	rawCaptureSampled bool
+
+	// clock drives retry backoffs and quota windows. Nil means the system
+	// clock.
+	clock Clock
+
+	// retryClassifier decides which failed attempts the backoff loop
+	// retries. Nil means isRetryableError.
+	retryClassifier func(statusCode int, body string, err error) bool
+
+	// floatingAliases retries a request for a retired snapshot with the
+	// model's undated alias.
+	floatingAliases bool
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// WithUserAgent sets the User-Agent header sent with every request. It
+// defaults to heimdall/<version>.
+func WithUserAgent(ua string) Option {
+	return func(o *options) {
+		o.userAgent = ua
+	}
+}
+
+// WithLogger routes the provider's internal diagnostics to logger. Providers
+// are silent by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithMaxConcurrencyPerKey caps the number of requests in flight on each API
+// key. A request whose key is at the cap moves to another key with a free
+// slot, and queues for its own key until a slot frees up or its context is
+// done only when every key is at the cap.
+func WithMaxConcurrencyPerKey(n int) Option {
+	return func(o *options) {
+		o.maxConcurrencyPerKey = n
+	}
+}
+
+// WithAppTitle sets the application name reported to OpenRouter in the
+// X-Title header.
+func WithAppTitle(title string) Option {
+	return func(o *options) {
+		o.appTitle = title
+	}
+}
+
+// WithAppURL sets the application URL reported to OpenRouter in the
+// HTTP-Referer header.
+func WithAppURL(url string) Option {
+	return func(o *options) {
+		o.appURL = url
+	}
+}
+
+// WithQwenBaseURL sets the DashScope OpenAI-compatible endpoint used by the
+// Qwen provider. It defaults to the international endpoint; use
+// https://dashscope.aliyuncs.com/compatible-mode/v1 for the Beijing region.
+func WithQwenBaseURL(url string) Option {
+	return func(o *options) {
+		o.qwenBaseURL = url
+	}
+}
+
+// WithAnthropicVersion sets the Anthropic-Version header sent to Anthropic.
+// It defaults to 2023-06-01, the current stable API version.
+func WithAnthropicVersion(version string) Option {
+	return func(o *options) {
+		o.anthropicVersion = version
+	}
+}
+
+// WithAnthropicBetas enables Anthropic beta features on every request, in
+// addition to the betas a model turns on itself, such as the 1M context
+// window.
+func WithAnthropicBetas(betas ...string) Option {
+	return func(o *options) {
+		o.anthropicBetas = append(o.anthropicBetas, betas...)
+	}
+}
+
+// WithFirstChunkTimeout sets how long a stream may go without producing its
+// first chunk before the attempt is abandoned and retried. It defaults to
+// three seconds.
+//
+// A negative timeout disables the check entirely, leaving only the context
+// deadline to bound the request. That suits batch jobs where a cold or queued
+// model can take minutes to start, at the cost of no longer failing fast on a
+// stalled connection.
+func WithFirstChunkTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.firstChunkTimeout = timeout
+	}
+}
+
+// WithJitter sets the range of the random factor each retry backoff is
+// multiplied by. It defaults to 0.8–1.2. WithJitter(0, 1) gives full jitter,
+// spreading clients that retry the same outage as widely as possible, and
+// WithJitter(1, 1) disables jitter for predictable retry timing. A maxFactor
+// below minFactor is raised to it.
+func WithJitter(minFactor, maxFactor float64) Option {
+	return func(o *options) {
+		o.jitterMin = minFactor
+		o.jitterMax = max(minFactor, maxFactor)
+	}
+}
+
+// WithMaxRequestBytes caps the size of the request body sent to the
+// provider. Larger requests, usually ones carrying big base64 files, fail
+// with ErrRequestTooLarge before anything is sent. It defaults to 64 MiB and
+// a negative limit disables the check.
+func WithMaxRequestBytes(n int64) Option {
+	return func(o *options) {
+		o.maxRequestBytes = n
+	}
+}
+
+// WithMaxResponseBytes caps the size of the response body read from the
+// provider, streamed or not, failing the request with ErrResponseTooLarge
+// once it is exceeded. It defaults to 32 MiB and a negative limit disables
+// the check.
+func WithMaxResponseBytes(n int64) Option {
+	return func(o *options) {
+		o.maxResponseBytes = n
+	}
+}
+
+// WithMaxContentBytes caps the content accumulated from a streamed response.
+// Once it is exceeded the provider stops reading and returns the content so
+// far, marked Partial, together with ErrResponseTooLarge. It defaults to
+// 16 MiB and a negative limit disables the cap.
+func WithMaxContentBytes(n int64) Option {
+	return func(o *options) {
+		o.maxContentBytes = n
+	}
+}
+
+// WithRawCaptureSampling keeps the raw request and response on only a
+// fraction of responses, between 0 and 1, dropping RawRequest and
+// RawResponse from the rest to save storage. Requests are sampled by a hash
+// of their request ID, so every attempt and fallback of a traced request is
+// captured alike; requests without an ID are sampled at random. By default
+// every response keeps them.
+func WithRawCaptureSampling(rate float64) Option {
+	return func(o *options) {
+		o.rawCaptureRate = min(max(rate, 0), 1)
+		o.rawCaptureSampled = true
+	}
+}
+
+// WithClock sets the clock used for retry backoffs and for tracking when key
+// quotas reset. It defaults to the system clock; tests can pass a fake to
+// exercise both without sleeping.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithRetryClassifier replaces the default decision of which failed requests
+// are retried with backoff, which is 429 and 5xx responses. classify receives
+// the status code, zero when no response arrived, the response body when the
+// provider read one, and the error; returning true retries the request. It
+// lets deployments retry quirky gateway statuses or transient 400s, or stop
+// retrying errors that will not clear.
+func WithRetryClassifier(classify func(statusCode int, body string, err error) bool) Option {
+	return func(o *options) {
+		o.retryClassifier = classify
+	}
+}
+
+// WithFloatingAliasFallback retries a request whose dated model snapshot,
+// such as "gpt-4o-2024-11-20", the provider has retired with the model's
+// floating alias, "gpt-4o", instead of failing with ErrModelDeprecated. The
+// alias may serve a newer snapshot that behaves differently. Only OpenAI
+// supports it so far.
+func WithFloatingAliasFallback() Option {
+	return func(o *options) {
+		o.floatingAliases = true
+	}
+}
+
+// retryable reports whether an attempt that failed with statusCode and err
+// should be retried.
+func (o options) retryable(statusCode int, err error) bool {
+	if o.retryClassifier != nil {
+		return o.retryClassifier(statusCode, responseBody(err), err)
+	}
+
+	return isRetryableError(statusCode)
+}
+
+func (o options) getUserAgent() string {
+	if o.userAgent != "" {
+		return o.userAgent
+	}
+
+	return defaultUserAgent()
+}
+
+const defaultFirstChunkTimeout = 3 * time.Second
+
+// firstChunkTimedOut reports whether a stream started at start has waited
+// too long for its first chunk.
+func (o options) firstChunkTimedOut(start time.Time) bool {
+	timeout := o.firstChunkTimeout
+	switch {
+	case timeout < 0:
+		return false
+	case timeout == 0:
+		timeout = defaultFirstChunkTimeout
+	}
+
+	return time.Since(start) > timeout
+}
+
+const (
+	defaultJitterMin = 0.8
+	defaultJitterMax = 1.2
+)
+
+// jitter scales backoff by a random factor within the configured range.
+func (o options) jitter(backoff time.Duration) time.Duration {
+	lo, hi := o.jitterMin, o.jitterMax
+	if lo == 0 && hi == 0 {
+		lo, hi = defaultJitterMin, defaultJitterMax
+	}
+
+	var randomBytes [8]byte
+	if _, err := rand.Read(randomBytes[:]); err != nil {
+		return backoff
+	}
+	randFloat := float64(binary.LittleEndian.Uint64(randomBytes[:])) / (1 << 64)
+
+	return time.Duration(float64(backoff) * (lo + (hi-lo)*randFloat))
+}
+
+const (
+	defaultMaxRequestBytes  = 64 << 20
+	defaultMaxResponseBytes = 32 << 20
+	defaultMaxContentBytes  = 16 << 20
+)
+
+// checkRequestSize returns ErrRequestTooLarge when body exceeds the request
+// size limit.
+func (o options) checkRequestSize(body []byte) error {
+	limit := o.maxRequestBytes
+	switch {
+	case limit < 0:
+		return nil
+	case limit == 0:
+		limit = defaultMaxRequestBytes
+	}
+
+	if int64(len(body)) > limit {
+		return fmt.Errorf(
+			"%w: %d bytes exceeds the limit of %d",
+			ErrRequestTooLarge,
+			len(body),
+			limit,
+		)
+	}
+
+	return nil
+}
+
+// limitResponse wraps a response body so reads fail with
+// ErrResponseTooLarge once it exceeds the response size limit.
+func (o options) limitResponse(body io.ReadCloser) io.ReadCloser {
+	limit := o.maxResponseBytes
+	switch {
+	case limit < 0:
+		return body
+	case limit == 0:
+		limit = defaultMaxResponseBytes
+	}
+
+	return &limitedBody{body: body, limit: limit}
+}
+
+// newContent returns a builder for streamed content that enforces the
+// content size limit.
+func (o options) newContent() *contentBuilder {
+	limit := o.maxContentBytes
+	switch {
+	case limit < 0:
+		limit = math.MaxInt64
+	case limit == 0:
+		limit = defaultMaxContentBytes
+	}
+
+	return &contentBuilder{limit: limit}
+}
+
+// sampleRaw drops the raw request and response from resp unless the
+// request falls within the capture sample.
+func (o options) sampleRaw(ctx context.Context, resp response.Completion) response.Completion {
+	if !o.rawCaptureSampled || o.rawCaptureRate >= 1 {
+		return resp
+	}
+
+	var sample uint64
+	if id := request.IDFromContext(ctx); id != "" {
+		h := fnv.New64a()
+		h.Write([]byte(id))
+		sample = h.Sum64()
+	} else {
+		var randomBytes [8]byte
+		if _, err := rand.Read(randomBytes[:]); err != nil {
+			return resp
+		}
+		sample = binary.LittleEndian.Uint64(randomBytes[:])
+	}
+
+	if float64(sample)/(1<<64) >= o.rawCaptureRate {
+		resp.RawRequest = nil
+		resp.RawResponse = nil
+	}
+
+	return resp
+}
+
+func (o options) now() time.Time {
+	if o.clock != nil {
+		return o.clock.Now()
+	}
+
+	return time.Now()
+}
+
+func (o options) newTimer(d time.Duration) Timer {
+	if o.clock != nil {
+		return o.clock.NewTimer(d)
+	}
+
+	return systemClock{}.NewTimer(d)
+}
+
+// keyDistributor returns the distributor for a provider's keys, limited and
+// clocked as configured.
+func (o options) keyDistributor(keys []string) *KeyDistributor {
+	d := NewKeyDistributor(keys, o.maxConcurrencyPerKey)
+	if o.clock != nil {
+		d.SetClock(o.clock)
+	}
+
+	return d
+}
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+func (o options) log() *slog.Logger {
+	if o.logger != nil {
+		return o.logger
+	}
+
+	return discardLogger
+}
+
+// setHeaders applies the headers every provider sends regardless of API.
+func (o options) setHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", o.getUserAgent())
+	if id := request.IDFromContext(req.Context()); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+}
+
+var defaultUserAgent = sync.OnceValue(func() string {
+	version := "dev"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				version = dep.Version
+				break
+			}
+		}
+	}
+
+	return "heimdall/" + version
+})
@@ -6,13 +6,10 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/rand"
-	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/flyx-ai/heimdall/models"
@@ -22,13 +19,34 @@ import (
 
 const perplexityBaseUrl = "https://api.perplexity.ai/chat/completions"
 
+type perplexityRequest struct {
+	openAIRequest
+	SearchRecencyFilter string   `json:"search_recency_filter,omitempty"`
+	SearchDomainFilter  []string `json:"search_domain_filter,omitempty"`
+}
+
+type perplexityChunk struct {
+	openAIChunk
+	SearchResults []struct {
+		Title string `json:"title"`
+		URL   string `json:"url"`
+		Date  string `json:"date"`
+	} `json:"search_results"`
+}
+
 type Perplexity struct {
 	apiKeys []string
+	keys    *KeyDistributor
+	opts    options
 }
 
-func NewPerplexity(apiKeys []string) Perplexity {
+func NewPerplexity(apiKeys []string, opts ...Option) Perplexity {
+	o := newOptions(opts)
+
 	return Perplexity{
-		apiKeys,
+		apiKeys: apiKeys,
+		keys:    o.keyDistributor(apiKeys),
+		opts:    o,
 	}
 }
 
@@ -39,6 +57,10 @@ func (p Perplexity) CompleteResponse(
 	client http.Client,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if len(p.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
 	reqLog := &response.Logging{}
 	if requestLog == nil {
 		req.Tags["request_type"] = "streaming"
@@ -60,6 +82,12 @@ func (p Perplexity) CompleteResponse(
 	}
 
 	for i, key := range p.apiKeys {
+		select {
+		case <-ctx.Done():
+			return response.Completion{}, ctx.Err()
+		default:
+		}
+
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
 			Description: fmt.Sprintf(
@@ -71,6 +99,9 @@ func (p Perplexity) CompleteResponse(
 		if err == nil {
 			return res, nil
 		}
+		if exceedsSizeLimit(err) {
+			return res, err
+		}
 
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
@@ -92,6 +123,12 @@ func (p Perplexity) doRequest(
 	chunkHandler func(chunk string) error,
 	key string,
 ) (response.Completion, int, error) {
+	key, release, err := p.keys.Acquire(ctx, key)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	defer release()
+
 	hisLen := len(req.History)
 	requestMessages := make([]requestMessage, hisLen+2)
 	for i, his := range req.History {
@@ -122,26 +159,36 @@ func (p Perplexity) doRequest(
 		})
 	}
 
-	apiReq := openAIRequest{
-		Model:         req.Model.GetName(),
-		Messages:      requestMessages,
-		Stream:        true,
-		StreamOptions: streamOptions{IncludeUsage: true},
-		Temperature:   1.0,
+	apiReq := perplexityRequest{
+		openAIRequest: openAIRequest{
+			Model:         req.Model.GetName(),
+			Messages:      requestMessages,
+			Stream:        true,
+			StreamOptions: streamOptions{IncludeUsage: true},
+			Temperature:   1.0,
+		}.withSampling(req),
 	}
 
 	var structuredOutput map[string]any
+	var search models.SonarSearch
 	switch m := req.Model.(type) {
 	case models.SonarReasoningPro:
 		structuredOutput = m.StructuredOutput
+		search = m.Search
 	case models.SonarReasoning:
 		structuredOutput = m.StructuredOutput
+		search = m.Search
 	case models.SonarPro:
 		structuredOutput = m.StructuredOutput
+		search = m.Search
 	case models.Sonar:
 		structuredOutput = m.StructuredOutput
+		search = m.Search
 	}
 
+	apiReq.SearchRecencyFilter = search.RecencyFilter
+	apiReq.SearchDomainFilter = search.DomainFilter
+
 	if len(structuredOutput) > 0 {
 		apiReq.ResponseFormat = map[string]any{
 			"type": "json_schema",
@@ -155,6 +202,21 @@ func (p Perplexity) doRequest(
 	if err != nil {
 		return response.Completion{}, 0, err
 	}
+	body, err = withRawMessages(body, "messages", req.RawMessages)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	body, err = withExtraBody(body, req.ExtraBody)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	if err := p.opts.checkRequestSize(body); err != nil {
+		return response.Completion{}, 0, err
+	}
+
+	// Stop upstream generation if we return before the stream is drained.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	httpReq, err := http.NewRequestWithContext(ctx, "POST",
 		perplexityBaseUrl,
@@ -166,6 +228,8 @@ func (p Perplexity) doRequest(
 		)
 	}
 
+	p.opts.setHeaders(httpReq)
+
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Authorization", "Bearer "+key)
 
@@ -178,11 +242,11 @@ func (p Perplexity) doRequest(
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		if readErr == nil {
-			return response.Completion{}, resp.StatusCode, fmt.Errorf(
+			return response.Completion{}, resp.StatusCode, withBody(fmt.Errorf(
 				"perplexity returned status %d: %s",
 				resp.StatusCode,
 				string(bodyBytes),
-			)
+			), bodyBytes)
 		}
 		return response.Completion{}, resp.StatusCode, fmt.Errorf(
 			"perplexity returned status %d",
@@ -190,15 +254,20 @@ func (p Perplexity) doRequest(
 		)
 	}
 
-	reader := bufio.NewReader(resp.Body)
-	var fullContent strings.Builder
+	stream := newStreamBody(ctx, p.opts.limitResponse(resp.Body))
+	defer stream.Close()
+
+	reader := bufio.NewReader(stream)
+	replays := replayFilter{enabled: req.DedupeChunks}
+	fullContent := p.opts.newContent()
 	var usage response.Usage
+	var searchResults []response.SearchResult
 	var rawEvents []json.RawMessage
 	chunks := 0
 	now := time.Now()
 
 	for {
-		if chunks == 0 && time.Since(now).Seconds() > 3.0 {
+		if chunks == 0 && p.opts.firstChunkTimedOut(now) {
 			return response.Completion{}, 0, context.Canceled
 		}
 		line, err := reader.ReadString('\n')
@@ -212,13 +281,15 @@ func (p Perplexity) doRequest(
 			)
 		}
 
-		line = strings.TrimPrefix(line, "data: ")
-		line = strings.TrimSpace(line)
-		if line == "" || line == "[DONE]" {
+		if replays.replayed(line) {
+			continue
+		}
+		line, ok := sseData(line)
+		if !ok {
 			continue
 		}
 
-		var chunk openAIChunk
+		var chunk perplexityChunk
 		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
 			return response.Completion{}, 0, fmt.Errorf(
 				"unmarshal chunk: %w",
@@ -228,15 +299,29 @@ func (p Perplexity) doRequest(
 
 		rawEvents = append(rawEvents, json.RawMessage(line))
 
+		// The full list is repeated on later chunks, so the last one wins.
+		if len(chunk.SearchResults) > 0 {
+			searchResults = make([]response.SearchResult, len(chunk.SearchResults))
+			for i, result := range chunk.SearchResults {
+				searchResults[i] = response.SearchResult{
+					Title: result.Title,
+					URL:   result.URL,
+					Date:  result.Date,
+				}
+			}
+		}
+
 		if len(chunk.Choices) > 0 {
-			contentDelta := chunk.Choices[0].Delta.Content
-			fullContent.WriteString(contentDelta)
+			contentDelta, limitErr := fullContent.add(chunk.Choices[0].Delta.Content)
 
 			if chunkHandler != nil {
 				if err := chunkHandler(contentDelta); err != nil {
 					return response.Completion{}, 0, err
 				}
 			}
+			if limitErr != nil {
+				return fullContent.partial(req.Model.GetName(), p.Name()), 0, limitErr
+			}
 		}
 
 		chunks++
@@ -250,24 +335,42 @@ func (p Perplexity) doRequest(
 	}
 
 	finalContent := fullContent.String()
+	if usage.TotalTokens == 0 {
+		usage = estimateUsage(req, finalContent)
+	}
+
 	rawResp, err := json.Marshal(rawEvents)
 	if err != nil {
 		return response.Completion{}, 0, fmt.Errorf("marshal raw response events: %w", err)
 	}
 
-	return response.Completion{
-		Content:     finalContent,
-		Model:       req.Model.GetName(),
-		Usage:       usage,
-		RawRequest:  body,
-		RawResponse: rawResp,
-	}, 0, nil
+	return finishStream(req, chunkHandler, p.opts.sampleRaw(ctx, response.Completion{
+		Content:       finalContent,
+		Model:         req.Model.GetName(),
+		Provider:      p.Name(),
+		SearchResults: searchResults,
+		Usage:         usage,
+		RawRequest:    body,
+		RawResponse:   rawResp,
+	}))
 }
 
 func (p Perplexity) Name() string {
 	return models.PerplexityProvider
 }
 
+// Supports implements LLMProvider. Any Perplexity model is served; search
+// filters are only read from the Sonar types.
+func (p Perplexity) Supports(m models.Model) bool {
+	return m != nil && m.GetProvider() == p.Name()
+}
+
+// SupportsPrefill implements LLMProvider. The chat API answers a trailing
+// assistant turn afresh rather than continuing it.
+func (p Perplexity) SupportsPrefill() bool {
+	return false
+}
+
 // StreamResponse implements LLMProvider.
 func (p Perplexity) StreamResponse(
 	ctx context.Context,
@@ -276,6 +379,14 @@ func (p Perplexity) StreamResponse(
 	chunkHandler func(chunk string) error,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if !streams(req.Model) {
+		return completeAsStream(ctx, p, client, req, chunkHandler, requestLog)
+	}
+
+	if len(p.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
 	reqLog := &response.Logging{}
 	if requestLog == nil {
 		req.Tags["request_type"] = "streaming"
@@ -296,7 +407,15 @@ func (p Perplexity) StreamResponse(
 		reqLog = requestLog
 	}
 
+	chunkHandler, delivered := trackDelivery(chunkHandler)
+
 	for i, key := range p.apiKeys {
+		select {
+		case <-ctx.Done():
+			return response.Completion{}, ctx.Err()
+		default:
+		}
+
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
 			Description: fmt.Sprintf(
@@ -308,6 +427,12 @@ func (p Perplexity) StreamResponse(
 		if err == nil {
 			return res, nil
 		}
+		if exceedsSizeLimit(err) {
+			return res, err
+		}
+		if delivered() {
+			return response.Completion{}, err
+		}
 
 		reqLog.Events = append(reqLog.Events, response.Event{
 			Timestamp: time.Now(),
@@ -329,6 +454,10 @@ func (p Perplexity) tryWithBackup(
 	chunkHandler func(chunk string) error,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if len(p.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
 	key := p.apiKeys[0]
 
 	maxRetries := 5
@@ -336,6 +465,9 @@ func (p Perplexity) tryWithBackup(
 	maxBackoff := 10 * time.Second
 
 	var lastErr error
+	var lastStatusCode int
+	start := p.opts.now()
+	backoffStep := 0
 	for attempt := range maxRetries {
 		requestLog.Events = append(requestLog.Events, response.Event{
 			Timestamp: time.Now(),
@@ -356,16 +488,24 @@ func (p Perplexity) tryWithBackup(
 			})
 			return response.Completion{}, ctx.Err()
 		default:
+			var progress streamProgress
 			res, resCode, err := p.doRequest(
 				ctx,
 				req,
-				client,
-				chunkHandler,
+				progress.client(client),
+				progress.wrap(chunkHandler),
 				key,
 			)
 			if err == nil {
 				return res, nil
 			}
+			dropped, delivered := progress.failed(ctx, err, requestLog)
+			if delivered {
+				return response.Completion{}, err
+			}
+			if dropped {
+				backoffStep = 0
+			}
 			requestLog.Events = append(requestLog.Events, response.Event{
 				Timestamp: time.Now(),
 				Description: fmt.Sprintf(
@@ -374,7 +514,7 @@ func (p Perplexity) tryWithBackup(
 				),
 			})
 
-			if !isRetryableError(resCode) {
+			if !dropped && !p.opts.retryable(resCode, err) {
 				requestLog.Events = append(requestLog.Events, response.Event{
 					Timestamp: time.Now(),
 					Description: fmt.Sprintf(
@@ -382,39 +522,34 @@ func (p Perplexity) tryWithBackup(
 						err,
 					),
 				})
-				return response.Completion{}, err
+				return res, err
 			}
 
 			lastErr = err
+			lastStatusCode = resCode
 
 			backoff := min(initialBackoff*time.Duration(
-				1<<attempt,
+				1<<backoffStep,
 			), maxBackoff)
+			backoffStep++
 
-			var randomBytes [8]byte
-			var jitter time.Duration
-			if _, err := rand.Read(randomBytes[:]); err != nil {
-				jitter = backoff
-			} else {
-				randFloat := float64(binary.LittleEndian.Uint64(randomBytes[:])) / (1 << 64)
-				jitter = time.Duration(float64(backoff) * (0.8 + 0.4*randFloat))
-			}
-
-			timer := time.NewTimer(jitter)
+			timer := p.opts.newTimer(p.opts.jitter(backoff))
 			select {
 			case <-ctx.Done():
 				timer.Stop()
 				return response.Completion{}, ctx.Err()
-			case <-timer.C:
+			case <-timer.C():
 				continue
 			}
 		}
 	}
 
-	return response.Completion{}, fmt.Errorf(
-		"max retries exceeded: %w",
-		lastErr,
-	)
+	return response.Completion{}, &RetryExhaustedError{
+		Attempts:       maxRetries,
+		Elapsed:        p.opts.now().Sub(start),
+		LastStatusCode: lastStatusCode,
+		Err:            lastErr,
+	}
 }
 
 var _ LLMProvider = new(Perplexity)
@@ -4,6 +4,7 @@ package providers_test
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"os"
 	"testing"
@@ -12,6 +13,7 @@ import (
 	"github.com/flyx-ai/heimdall/models"
 	"github.com/flyx-ai/heimdall/providers"
 	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -73,19 +75,120 @@ func TestPerplexityModelsWithStreaming(t *testing.T) {
 		},
 	}
 
-	var chunkHandlerCollection string
+	var collected providers.Collector
 	res, err := perplexity.StreamResponse(
 		context.Background(),
 		client,
 		req,
-		func(chunk string) error {
-			chunkHandlerCollection = chunkHandlerCollection + chunk
-			return nil
-		},
+		collected.Handle,
 		nil,
 	)
 	require.NoError(t, err, "StreamResponse returned an unexpected error")
-	assert.NotEmpty(t, chunkHandlerCollection, "chunkHandlerCollection should not be empty")
+	assert.NotEmpty(t, collected.String(), "collected chunks should not be empty")
 	assert.NotEmpty(t, res.Content, "content should not be empty")
 	assert.NotEmpty(t, res.Model, "model should not be empty")
 }
+
+func TestPerplexityWithoutAPIKeys(t *testing.T) {
+	t.Parallel()
+
+	provider := providers.NewPerplexity(nil)
+	req := request.Completion{
+		Model:       models.Sonar{},
+		UserMessage: "Say hello in one sentence.",
+	}
+
+	_, err := provider.CompleteResponse(context.Background(), req, http.Client{}, nil)
+	require.ErrorIs(t, err, providers.ErrNoAPIKeys)
+
+	_, err = provider.StreamResponse(
+		context.Background(),
+		http.Client{},
+		req,
+		func(chunk string) error { return nil },
+		nil,
+	)
+	require.ErrorIs(t, err, providers.ErrNoAPIKeys)
+}
+
+func TestPerplexityStreamingUsage(t *testing.T) {
+	t.Parallel()
+
+	var sent map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&sent); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"hello there"}}]}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	perplexity := providers.NewPerplexity([]string{"test-key"})
+
+	res, err := perplexity.StreamResponse(
+		context.Background(),
+		client,
+		request.Completion{
+			Model:         models.Sonar{},
+			SystemMessage: "you are a helpful assistant.",
+			UserMessage:   "Say hello in one sentence.",
+		},
+		func(chunk string) error { return nil },
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]any{"include_usage": true}, sent["stream_options"])
+	assert.NotZero(t, res.Usage.TotalTokens)
+	assert.True(t, res.Usage.Estimated)
+}
+
+func TestPerplexitySearch(t *testing.T) {
+	t.Parallel()
+
+	var sent map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&sent); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"Rates rose."}}]}`,
+				`{"choices":[{"delta":{"content":""},"finish_reason":"stop"}],`+
+					`"search_results":[{"title":"Fed raises rates","url":"https://example.com/fed","date":"2025-03-19"},`+
+					`{"title":"Markets react","url":"https://example.com/markets"}],`+
+					`"usage":{"prompt_tokens":10,"completion_tokens":3,"total_tokens":13}}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	perplexity := providers.NewPerplexity([]string{"test-key"})
+
+	res, err := perplexity.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model: models.Sonar{
+				Search: models.SonarSearch{
+					RecencyFilter: "week",
+					DomainFilter:  []string{"example.com", "-spam.com"},
+				},
+			},
+			SystemMessage: "you are a helpful assistant.",
+			UserMessage:   "What did the Fed do?",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "week", sent["search_recency_filter"])
+	assert.Equal(t, []any{"example.com", "-spam.com"}, sent["search_domain_filter"])
+	assert.Equal(t, "sonar", sent["model"])
+	assert.Equal(t, []response.SearchResult{
+		{Title: "Fed raises rates", URL: "https://example.com/fed", Date: "2025-03-19"},
+		{Title: "Markets react", URL: "https://example.com/markets"},
+	}, res.SearchResults)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/flyx-ai/heimdall/models"
 	"github.com/flyx-ai/heimdall/request"
 	"github.com/flyx-ai/heimdall/response"
 )
@@ -37,4 +38,23 @@ type LLMProvider interface {
 		key string,
 	) (response.Completion, int, error)
 	Name() string
+	// Supports reports whether the provider can serve m: m belongs to the
+	// provider and, where the provider builds requests per model, is one it
+	// knows.
+	Supports(m models.Model) bool
+	// SupportsPrefill reports whether a trailing assistant turn in History
+	// is continued in place, so the router can resume or extend a response
+	// by sending it back. Chat APIs without prefill answer such a turn
+	// afresh instead.
+	SupportsPrefill() bool
+}
+
+// EmbeddingProvider turns text into vectors for search and retrieval.
+type EmbeddingProvider interface {
+	Embed(
+		ctx context.Context,
+		req request.Embedding,
+		client http.Client,
+	) (response.Embedding, error)
+	Name() string
 }
@@ -0,0 +1,65 @@
+package providers_test
+
+import (
+	"testing"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupports(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		provider providers.LLMProvider
+		model    models.Model
+		want     bool
+	}{
+		"openai should serve its own models": {
+			provider: providers.NewOpenAI(nil),
+			model:    models.GPT4O{},
+			want:     true,
+		},
+		"openai should serve unlisted openai models": {
+			provider: providers.NewOpenAI(nil),
+			model:    unlistedOpenAIModel{},
+			want:     true,
+		},
+		"openai should reject gemini models": {
+			provider: providers.NewOpenAI(nil),
+			model:    models.Gemini25Flash{},
+		},
+		"anthropic should serve known claude models": {
+			provider: providers.NewAnthropic(nil),
+			model:    models.Claude45Haiku{},
+			want:     true,
+		},
+		"google should serve known gemini models": {
+			provider: providers.NewGoogle(nil),
+			model:    models.Gemini25Flash{},
+			want:     true,
+		},
+		"google should serve image models": {
+			provider: providers.NewGoogle(nil),
+			model:    &models.Gemini25FlashImage{},
+			want:     true,
+		},
+		"google should reject claude models": {
+			provider: providers.NewGoogle(nil),
+			model:    models.Claude45Haiku{},
+		},
+		"openrouter should only serve openrouter models": {
+			provider: providers.NewOpenRouter(nil),
+			model:    models.GPT4O{},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, tt.provider.Supports(tt.model))
+		})
+	}
+}
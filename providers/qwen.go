@@ -0,0 +1,514 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+)
+
+// defaultQwenBaseURL is DashScope's international OpenAI-compatible
+// endpoint.
+const defaultQwenBaseURL = "https://dashscope-intl.aliyuncs.com/compatible-mode/v1"
+
+type qwenChatRequest struct {
+	openAIRequest
+	EnableSearch bool `json:"enable_search,omitempty"`
+}
+
+// Qwen serves Alibaba's Qwen models through DashScope's OpenAI-compatible
+// mode.
+type Qwen struct {
+	apiKeys []string
+	keys    *KeyDistributor
+	opts    options
+}
+
+func NewQwen(apiKeys []string, opts ...Option) Qwen {
+	o := newOptions(opts)
+
+	return Qwen{
+		apiKeys: apiKeys,
+		keys:    o.keyDistributor(apiKeys),
+		opts:    o,
+	}
+}
+
+func (q Qwen) Name() string {
+	return models.QwenProvider
+}
+
+// Supports implements LLMProvider. Any Qwen model is served, including ones
+// without a dedicated type, which are sent as plain chat messages.
+func (q Qwen) Supports(m models.Model) bool {
+	return m != nil && m.GetProvider() == q.Name()
+}
+
+// SupportsPrefill implements LLMProvider. The chat API answers a trailing
+// assistant turn afresh rather than continuing it.
+func (q Qwen) SupportsPrefill() bool {
+	return false
+}
+
+// Validate checks every API key against the API concurrently and returns the
+// outcome per key, nil meaning the key works. Keys the API rejects are
+// excluded from later requests.
+func (q Qwen) Validate(ctx context.Context) map[string]error {
+	return validateKeys(ctx, q.apiKeys, q.keys, func(ctx context.Context, key string) (int, error) {
+		return pingURL(ctx, q.opts, q.baseURL()+"/models", func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer "+key)
+		})
+	})
+}
+
+// RemainingQuota returns the number of requests the provider's keys can still
+// make and when the earliest rate-limit window resets, as last reported by
+// the API and reduced by requests dispatched since.
+func (q Qwen) RemainingQuota() (requests uint32, resetAt time.Time) {
+	return q.keys.RemainingQuota()
+}
+
+func (q Qwen) baseURL() string {
+	if q.opts.qwenBaseURL != "" {
+		return q.opts.qwenBaseURL
+	}
+
+	return defaultQwenBaseURL
+}
+
+func (q Qwen) doRequest(
+	ctx context.Context,
+	req request.Completion,
+	client http.Client,
+	chunkHandler func(chunk string) error,
+	key string,
+) (response.Completion, int, error) {
+	key, release, err := q.keys.Acquire(ctx, key)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	defer release()
+
+	qwenRequest := openAIRequest{
+		Model:         req.Model.GetName(),
+		Stream:        true,
+		StreamOptions: streamOptions{IncludeUsage: true},
+		Temperature:   1.0,
+	}.withSampling(req)
+
+	var enableSearch bool
+	switch m := req.Model.(type) {
+	case models.QwenMax:
+		enableSearch = m.EnableSearch
+	case models.QwenPlus:
+		enableSearch = m.EnableSearch
+	case models.QwenTurbo:
+		enableSearch = m.EnableSearch
+	}
+
+	request, err := prepareBasicMessages(
+		qwenRequest,
+		req.SystemMessage,
+		req.UserMessage,
+		req.History,
+	)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+
+	body, err := json.Marshal(qwenChatRequest{
+		openAIRequest: request,
+		EnableSearch:  enableSearch,
+	})
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	body, err = withRawMessages(body, "messages", req.RawMessages)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	body, err = withExtraBody(body, req.ExtraBody)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	if err := q.opts.checkRequestSize(body); err != nil {
+		return response.Completion{}, 0, err
+	}
+
+	// Stop upstream generation if we return before the stream is drained.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST",
+		fmt.Sprintf("%s/chat/completions", q.baseURL()),
+		bytes.NewReader(body))
+	if err != nil {
+		return response.Completion{}, 0, fmt.Errorf(
+			"create request: %w",
+			err,
+		)
+	}
+
+	q.opts.setHeaders(httpReq)
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+key)
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return response.Completion{}, 0, err
+	}
+	defer resp.Body.Close()
+
+	q.keys.Observe(key, resp.Header)
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return response.Completion{}, resp.StatusCode, withBody(errors.New(
+			"received non-200 status code",
+		), bodyBytes)
+	}
+
+	stream := newStreamBody(ctx, q.opts.limitResponse(resp.Body))
+	defer stream.Close()
+
+	reader := bufio.NewReader(stream)
+	replays := replayFilter{enabled: req.DedupeChunks}
+	fullContent := q.opts.newContent()
+	var usage response.Usage
+	var finishReason string
+	var rawEvents []json.RawMessage
+	chunks := 0
+	now := time.Now()
+
+	for {
+		if chunks == 0 && q.opts.firstChunkTimedOut(now) {
+			return response.Completion{}, 0, context.Canceled
+		}
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return response.Completion{}, 0, fmt.Errorf(
+				"read line: %w",
+				err,
+			)
+		}
+
+		if replays.replayed(line) {
+			continue
+		}
+		line, ok := sseData(line)
+		if !ok {
+			continue
+		}
+
+		var chunk openAIChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return response.Completion{}, 0, fmt.Errorf(
+				"unmarshal chunk: %w",
+				err,
+			)
+		}
+
+		rawEvents = append(rawEvents, json.RawMessage(line))
+
+		if len(chunk.Choices) > 0 {
+			text, limitErr := fullContent.add(chunk.Choices[0].Delta.Content)
+			if chunk.Choices[0].FinishReason != "" {
+				finishReason = chunk.Choices[0].FinishReason
+			}
+
+			if chunkHandler != nil {
+				if err := chunkHandler(text); err != nil {
+					return response.Completion{}, 0, err
+				}
+			}
+			if limitErr != nil {
+				return fullContent.partial(req.Model.GetName(), q.Name()), 0, limitErr
+			}
+		}
+
+		chunks++
+		// DashScope reports usage on a final chunk without choices.
+		if chunk.Usage.TotalTokens != 0 {
+			usage = response.Usage{
+				PromptTokens:     chunk.Usage.PromptTokens,
+				CompletionTokens: chunk.Usage.CompletionTokens,
+				TotalTokens:      chunk.Usage.TotalTokens,
+				CachedTokens:     chunk.Usage.PromptTokensDetails.CachedTokens,
+				ReasoningTokens:  chunk.Usage.CompletionTokensDetails.ReasoningTokens,
+			}
+		}
+	}
+
+	rawResp, err := json.Marshal(rawEvents)
+	if err != nil {
+		return response.Completion{}, 0, fmt.Errorf("marshal raw response events: %w", err)
+	}
+
+	return finishStream(req, chunkHandler, q.opts.sampleRaw(ctx, response.Completion{
+		Content:      fullContent.String(),
+		Model:        req.Model.GetName(),
+		Provider:     q.Name(),
+		FinishReason: mapOpenAIFinish(finishReason),
+		Usage:        usage,
+		RawRequest:   body,
+		RawResponse:  rawResp,
+	}))
+}
+
+func (q Qwen) tryWithBackup(
+	ctx context.Context,
+	req request.Completion,
+	client http.Client,
+	chunkHandler func(chunk string) error,
+	requestLog *response.Logging,
+) (response.Completion, error) {
+	if len(q.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
+	key := q.apiKeys[0]
+
+	maxRetries := 5
+	initialBackoff := 100 * time.Millisecond
+	maxBackoff := 10 * time.Second
+
+	var lastErr error
+	var lastStatusCode int
+	start := q.opts.now()
+	backoffStep := 0
+	for attempt := range maxRetries {
+		requestLog.Events = append(requestLog.Events, response.Event{
+			Timestamp: time.Now(),
+			Description: fmt.Sprintf(
+				"attempting to complete request with exponential backoff. attempt: %v",
+				attempt,
+			),
+		})
+
+		select {
+		case <-ctx.Done():
+			requestLog.Events = append(requestLog.Events, response.Event{
+				Timestamp: time.Now(),
+				Description: fmt.Sprintf(
+					"context was cancelled with error: %v",
+					ctx.Err(),
+				),
+			})
+			return response.Completion{}, ctx.Err()
+		default:
+			var progress streamProgress
+			res, resCode, err := q.doRequest(
+				ctx,
+				req,
+				progress.client(client),
+				progress.wrap(chunkHandler),
+				key,
+			)
+			if err == nil {
+				return res, nil
+			}
+			dropped, delivered := progress.failed(ctx, err, requestLog)
+			if delivered {
+				return response.Completion{}, err
+			}
+			if dropped {
+				backoffStep = 0
+			}
+			requestLog.Events = append(requestLog.Events, response.Event{
+				Timestamp: time.Now(),
+				Description: fmt.Sprintf(
+					"request could not be completed, err: %v",
+					err,
+				),
+			})
+
+			if !dropped && !q.opts.retryable(resCode, err) {
+				requestLog.Events = append(requestLog.Events, response.Event{
+					Timestamp: time.Now(),
+					Description: fmt.Sprintf(
+						"request was not retryable due to err: %v",
+						err,
+					),
+				})
+				return res, err
+			}
+
+			lastErr = err
+			lastStatusCode = resCode
+
+			backoff := min(initialBackoff*time.Duration(
+				1<<backoffStep,
+			), maxBackoff)
+			backoffStep++
+
+			timer := q.opts.newTimer(q.opts.jitter(backoff))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return response.Completion{}, ctx.Err()
+			case <-timer.C():
+				continue
+			}
+		}
+	}
+
+	return response.Completion{}, &RetryExhaustedError{
+		Attempts:       maxRetries,
+		Elapsed:        q.opts.now().Sub(start),
+		LastStatusCode: lastStatusCode,
+		Err:            lastErr,
+	}
+}
+
+func (q Qwen) CompleteResponse(
+	ctx context.Context,
+	req request.Completion,
+	client http.Client,
+	requestLog *response.Logging,
+) (response.Completion, error) {
+	if len(q.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
+	reqLog := &response.Logging{}
+	if requestLog == nil {
+		req.Tags["request_type"] = "completion"
+
+		reqLog = &response.Logging{
+			Events: []response.Event{
+				{
+					Timestamp:   time.Now(),
+					Description: "start of call to CompleteResponse",
+				},
+			},
+			SystemMsg: req.SystemMessage,
+			UserMsg:   req.UserMessage,
+			Start:     time.Now(),
+		}
+	}
+	if requestLog != nil {
+		reqLog = requestLog
+	}
+
+	for i, key := range q.apiKeys {
+		select {
+		case <-ctx.Done():
+			return response.Completion{}, ctx.Err()
+		default:
+		}
+
+		reqLog.Events = append(reqLog.Events, response.Event{
+			Timestamp: time.Now(),
+			Description: fmt.Sprintf(
+				"attempting to complete request with key_number: %v",
+				i,
+			),
+		})
+		res, _, err := q.doRequest(ctx, req, client, nil, key)
+		if err == nil {
+			return res, nil
+		}
+		if exceedsSizeLimit(err) {
+			return res, err
+		}
+
+		reqLog.Events = append(reqLog.Events, response.Event{
+			Timestamp: time.Now(),
+			Description: fmt.Sprintf(
+				"request could not be completed, err: %v",
+				err,
+			),
+		})
+	}
+
+	return q.tryWithBackup(ctx, req, client, nil, reqLog)
+}
+
+func (q Qwen) StreamResponse(
+	ctx context.Context,
+	client http.Client,
+	req request.Completion,
+	chunkHandler func(chunk string) error,
+	requestLog *response.Logging,
+) (response.Completion, error) {
+	if !streams(req.Model) {
+		return completeAsStream(ctx, q, client, req, chunkHandler, requestLog)
+	}
+
+	if len(q.apiKeys) == 0 {
+		return response.Completion{}, ErrNoAPIKeys
+	}
+
+	reqLog := &response.Logging{}
+	if requestLog == nil {
+		req.Tags["request_type"] = "streaming"
+
+		reqLog = &response.Logging{
+			Events: []response.Event{
+				{
+					Timestamp:   time.Now(),
+					Description: "start of call to StreamResponse",
+				},
+			},
+			SystemMsg: req.SystemMessage,
+			UserMsg:   req.UserMessage,
+			Start:     time.Now(),
+		}
+	}
+	if requestLog != nil {
+		reqLog = requestLog
+	}
+
+	chunkHandler, delivered := trackDelivery(chunkHandler)
+
+	for i, key := range q.apiKeys {
+		select {
+		case <-ctx.Done():
+			return response.Completion{}, ctx.Err()
+		default:
+		}
+
+		reqLog.Events = append(reqLog.Events, response.Event{
+			Timestamp: time.Now(),
+			Description: fmt.Sprintf(
+				"attempting to complete request with key_number: %v",
+				i,
+			),
+		})
+		res, _, err := q.doRequest(ctx, req, client, chunkHandler, key)
+		if err == nil {
+			return res, nil
+		}
+		if exceedsSizeLimit(err) {
+			return res, err
+		}
+		if delivered() {
+			return response.Completion{}, err
+		}
+
+		reqLog.Events = append(reqLog.Events, response.Event{
+			Timestamp: time.Now(),
+			Description: fmt.Sprintf(
+				"request could not be completed, err: %v",
+				err,
+			),
+		})
+	}
+
+	return q.tryWithBackup(ctx, req, client, chunkHandler, reqLog)
+}
+
+var _ LLMProvider = new(Qwen)
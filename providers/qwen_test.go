@@ -0,0 +1,132 @@
+package providers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/providers"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQwenModelsWithCompletion(t *testing.T) {
+	t.Parallel()
+
+	apiKey := os.Getenv("DASHSCOPE_API_KEY")
+	if apiKey == "" {
+		t.Skip("DASHSCOPE_API_KEY not set")
+	}
+
+	client := http.Client{
+		Timeout: 2 * time.Minute,
+	}
+	qwenProvider := providers.NewQwen([]string{apiKey})
+
+	req := request.Completion{
+		Model:         models.QwenTurbo{},
+		SystemMessage: "you are a helpful assistant.",
+		UserMessage:   "Say hello in one sentence.",
+		Tags: map[string]string{
+			"type": "testing",
+		},
+	}
+
+	res, err := qwenProvider.CompleteResponse(
+		context.Background(),
+		req,
+		client,
+		nil,
+	)
+	require.NoError(t, err, "CompleteResponse returned an unexpected error")
+	assert.NotEmpty(t, res.Content, "Expected non-empty content")
+	assert.Equal(t, req.Model.GetName(), res.Model, "Model mismatch")
+}
+
+func TestQwenEnableSearch(t *testing.T) {
+	t.Parallel()
+
+	var (
+		url  string
+		auth string
+		body map[string]any
+	)
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			url = req.URL.String()
+			auth = req.Header.Get("Authorization")
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(
+				`{"choices":[{"delta":{"content":"It is sunny."},"finish_reason":null}]}`,
+				`{"choices":[{"delta":{"content":""},"finish_reason":"stop"}]}`,
+				`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":4,"total_tokens":16,"prompt_tokens_details":{"cached_tokens":8}}}`,
+				"[DONE]",
+			), nil
+		}),
+	}
+	qwen := providers.NewQwen(
+		[]string{"test-key"},
+		providers.WithQwenBaseURL("https://dashscope.aliyuncs.com/compatible-mode/v1"),
+	)
+
+	res, err := qwen.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.QwenPlus{EnableSearch: true},
+			UserMessage: "What is the weather in Hangzhou?",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://dashscope.aliyuncs.com/compatible-mode/v1/chat/completions", url)
+	assert.Equal(t, "Bearer test-key", auth)
+	assert.Equal(t, "qwen-plus", body["model"])
+	assert.Equal(t, true, body["enable_search"])
+	assert.Equal(t, "It is sunny.", res.Content)
+	assert.Equal(t, models.QwenProvider, res.Provider)
+	assert.Equal(t, response.FinishStop, res.FinishReason)
+	assert.Equal(t, response.Usage{
+		PromptTokens:     12,
+		CompletionTokens: 4,
+		TotalTokens:      16,
+		CachedTokens:     8,
+	}, res.Usage)
+}
+
+func TestQwenWithoutSearch(t *testing.T) {
+	t.Parallel()
+
+	var body map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return sseResponse(`{"choices":[{"delta":{"content":"hi"}}]}`, "[DONE]"), nil
+		}),
+	}
+	qwen := providers.NewQwen([]string{"test-key"})
+
+	_, err := qwen.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:       models.QwenMax{},
+			UserMessage: "Say hello in one sentence.",
+		},
+		client,
+		&response.Logging{},
+	)
+	require.NoError(t, err)
+
+	assert.NotContains(t, body, "enable_search")
+}
@@ -0,0 +1,40 @@
+package providers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// parseRateLimit reads the request-quota headers sent by OpenAI-compatible
+// APIs and by Anthropic. ok is false when the response carries neither.
+func parseRateLimit(header http.Header, now time.Time) (remaining uint32, resetAt time.Time, ok bool) {
+	if v := header.Get("x-ratelimit-remaining-requests"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return 0, time.Time{}, false
+		}
+		// OpenAI reports the reset as a duration such as "1s" or "6m0s".
+		reset, err := time.ParseDuration(header.Get("x-ratelimit-reset-requests"))
+		if err != nil {
+			return 0, time.Time{}, false
+		}
+
+		return uint32(n), now.Add(reset), true
+	}
+
+	if v := header.Get("anthropic-ratelimit-requests-remaining"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return 0, time.Time{}, false
+		}
+		reset, err := time.Parse(time.RFC3339, header.Get("anthropic-ratelimit-requests-reset"))
+		if err != nil {
+			return 0, time.Time{}, false
+		}
+
+		return uint32(n), reset, true
+	}
+
+	return 0, time.Time{}, false
+}
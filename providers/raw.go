@@ -0,0 +1,50 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// withRawMessages replaces field in the marshalled request body with the
+// caller's provider-native messages, leaving body untouched when raw is
+// empty. See request.Completion.RawMessages.
+func withRawMessages(body []byte, field string, raw json.RawMessage) ([]byte, error) {
+	if len(raw) == 0 {
+		return body, nil
+	}
+	if !json.Valid(raw) {
+		return nil, errors.New("raw messages are not valid JSON")
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("decode request body: %w", err)
+	}
+	fields[field] = raw
+
+	return json.Marshal(fields)
+}
+
+// withExtraBody sets each key of extra at the top level of the marshalled
+// request body, replacing any field heimdall set. See
+// request.Completion.ExtraBody.
+func withExtraBody(body []byte, extra map[string]any) ([]byte, error) {
+	if len(extra) == 0 {
+		return body, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("decode request body: %w", err)
+	}
+	for key, value := range extra {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("encode extra body field %q: %w", key, err)
+		}
+		fields[key] = raw
+	}
+
+	return json.Marshal(fields)
+}
@@ -0,0 +1,362 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+)
+
+// streamBody wraps a streaming response body so that a read blocked on an
+// upstream that has gone silent returns as soon as ctx is done, rather than
+// waiting for the next byte or for the connection to close.
+type streamBody struct {
+	ctx  context.Context
+	body io.ReadCloser
+	stop func() bool
+}
+
+func newStreamBody(ctx context.Context, body io.ReadCloser) *streamBody {
+	return &streamBody{
+		ctx:  ctx,
+		body: body,
+		stop: context.AfterFunc(ctx, func() { body.Close() }),
+	}
+}
+
+// Read reports ctx's error instead of the one caused by closing the body when
+// the read was interrupted by cancellation.
+func (s *streamBody) Read(p []byte) (int, error) {
+	n, err := s.body.Read(p)
+	if err != nil && s.ctx.Err() != nil {
+		return n, s.ctx.Err()
+	}
+	return n, err
+}
+
+func (s *streamBody) Close() error {
+	s.stop()
+	return s.body.Close()
+}
+
+// limitedBody fails reads with ErrResponseTooLarge once more than limit
+// bytes have been read from body.
+type limitedBody struct {
+	body  io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	n, err := l.body.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n, fmt.Errorf(
+			"%w: more than %d bytes",
+			ErrResponseTooLarge,
+			l.limit,
+		)
+	}
+	return n, err
+}
+
+func (l *limitedBody) Close() error {
+	return l.body.Close()
+}
+
+// contentBuilder accumulates the content of a streamed response up to a
+// limit, see WithMaxContentBytes.
+type contentBuilder struct {
+	strings.Builder
+	limit int64
+}
+
+// add appends as much of s as fits within the limit and returns the part
+// appended, along with ErrResponseTooLarge when s did not fit whole. The
+// cut never splits a UTF-8 sequence.
+func (c *contentBuilder) add(s string) (string, error) {
+	room := c.limit - int64(c.Len())
+	if int64(len(s)) <= room {
+		c.WriteString(s)
+		return s, nil
+	}
+
+	cut := int(max(room, 0))
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	c.WriteString(s[:cut])
+
+	return s[:cut], fmt.Errorf(
+		"%w: content exceeds %d bytes",
+		ErrResponseTooLarge,
+		c.limit,
+	)
+}
+
+// partial returns the content accumulated so far as a response cut off by
+// the content limit.
+func (c *contentBuilder) partial(model, provider string) response.Completion {
+	return response.Completion{
+		Content:  c.String(),
+		Model:    model,
+		Provider: provider,
+		Partial:  true,
+	}
+}
+
+// sseData returns the JSON payload of a server-sent event line. Blank lines,
+// comments and keepalive pings, other event fields, the [DONE] sentinel and
+// anything that is not JSON report false, so they are skipped rather than
+// aborting the stream.
+func sseData(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, ":") {
+		return "", false
+	}
+	for _, field := range []string{"event:", "id:", "retry:"} {
+		if strings.HasPrefix(line, field) {
+			return "", false
+		}
+	}
+
+	line = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if line == "[DONE]" || !json.Valid([]byte(line)) {
+		return "", false
+	}
+
+	return line, true
+}
+
+// replayFilter drops server-sent events that an upstream or proxy sends a
+// second time, for requests with DedupeChunks. Only events that identify
+// themselves, by an SSE id field or a sequence_number in the payload, are
+// compared: a repeat of both the identity and the payload is a re-send,
+// while the same text sent as a new event, such as a repeated word, is kept.
+type replayFilter struct {
+	enabled bool
+	id      string
+	seen    map[string]struct{}
+}
+
+// replayed reports whether the stream line is the data of an event already
+// seen. It must be given every line, so it can track the event ids.
+func (f *replayFilter) replayed(line string) bool {
+	if !f.enabled {
+		return false
+	}
+
+	line = strings.TrimSpace(line)
+	if line == "" {
+		// Unlike an SSE client, forget the id at the end of each event, so an
+		// event that does not carry its own is never treated as a repeat.
+		f.id = ""
+		return false
+	}
+	if id, ok := strings.CutPrefix(line, "id:"); ok {
+		f.id = strings.TrimSpace(id)
+		return false
+	}
+
+	data, ok := sseData(line)
+	if !ok {
+		return false
+	}
+
+	key := f.id
+	if key == "" {
+		var event struct {
+			SequenceNumber *int64 `json:"sequence_number"`
+		}
+		if json.Unmarshal([]byte(data), &event) != nil || event.SequenceNumber == nil {
+			return false
+		}
+		key = "sequence:" + strconv.FormatInt(*event.SequenceNumber, 10)
+	}
+	key += "\n" + data
+
+	if _, ok := f.seen[key]; ok {
+		return true
+	}
+	if f.seen == nil {
+		f.seen = map[string]struct{}{}
+	}
+	f.seen[key] = struct{}{}
+
+	return false
+}
+
+// trackDelivery wraps chunkHandler to report whether any chunk has been
+// passed on to it. A stream that fails after delivering chunks cannot be
+// retried from the start without repeating them, so its error is returned,
+// for the router to resume when the request has ResumeOnDrop.
+func trackDelivery(
+	chunkHandler func(chunk string) error,
+) (func(chunk string) error, func() bool) {
+	var delivered bool
+	if chunkHandler == nil {
+		return nil, func() bool { return delivered }
+	}
+
+	return func(chunk string) error {
+		delivered = true
+		return chunkHandler(chunk)
+	}, func() bool { return delivered }
+}
+
+// streamProgress tracks one attempt of a backoff loop: whether the
+// response body broke off after yielding bytes, whether it streamed any
+// chunk and whether the chunk handler failed, which is the caller's error
+// and never retried.
+type streamProgress struct {
+	received   bool
+	cutOff     bool
+	delivered  bool
+	handlerErr error
+}
+
+// client returns a copy of client whose response bodies record the
+// attempt's progress as they are read.
+func (p *streamProgress) client(client http.Client) http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = progressTransport{progress: p, next: next}
+
+	return client
+}
+
+// wrap returns chunkHandler instrumented to record the attempt's progress.
+func (p *streamProgress) wrap(chunkHandler func(chunk string) error) func(chunk string) error {
+	if chunkHandler == nil {
+		return nil
+	}
+
+	return func(chunk string) error {
+		if err := chunkHandler(chunk); err != nil {
+			p.handlerErr = err
+			return err
+		}
+		p.delivered = true
+		return nil
+	}
+}
+
+// dropped reports whether the attempt failed with err part way through a
+// successful response, through no fault of the chunk handler or the
+// content.
+func (p *streamProgress) dropped(err error) bool {
+	return (p.cutOff || p.delivered) && p.handlerErr == nil &&
+		!errors.Is(err, response.ErrContentFiltered) && !exceedsSizeLimit(err)
+}
+
+// failed records the attempt's failure with err in requestLog. It reports
+// whether the response broke off part way, a hiccup rather than an outage
+// that restarts the backoff from its shortest delay, and whether chunks
+// already reached the chunk handler, in which case the attempt must not be
+// retried as the handler would receive them again.
+func (p *streamProgress) failed(
+	ctx context.Context,
+	err error,
+	requestLog *response.Logging,
+) (dropped, delivered bool) {
+	dropped = p.dropped(err) && ctx.Err() == nil
+	if dropped {
+		requestLog.MidStreamFailures++
+		if !p.delivered {
+			requestLog.Events = append(requestLog.Events, response.Event{
+				Timestamp:   time.Now(),
+				Description: "request failed part way through the response, resetting backoff",
+			})
+		}
+	}
+
+	return dropped, p.delivered
+}
+
+type progressTransport struct {
+	progress *streamProgress
+	next     http.RoundTripper
+}
+
+func (t progressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+	res.Body = progressBody{ReadCloser: res.Body, progress: t.progress}
+
+	return res, nil
+}
+
+type progressBody struct {
+	io.ReadCloser
+	progress *streamProgress
+}
+
+func (b progressBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.progress.received = true
+	}
+	if err != nil && err != io.EOF && b.progress.received {
+		b.progress.cutOff = true
+	}
+
+	return n, err
+}
+
+// streams reports whether responses for model can be streamed chunk by
+// chunk, see models.Capabilities.
+func streams(model models.Model) bool {
+	return model == nil || model.Capabilities().Streaming
+}
+
+// completeAsStream serves StreamResponse for a model that cannot stream, such
+// as an image generation model: it makes a regular completion and hands the
+// whole content to chunkHandler as one chunk, so streaming works for every
+// model.
+func completeAsStream(
+	ctx context.Context,
+	provider LLMProvider,
+	client http.Client,
+	req request.Completion,
+	chunkHandler func(chunk string) error,
+	requestLog *response.Logging,
+) (response.Completion, error) {
+	if requestLog != nil {
+		requestLog.Events = append(requestLog.Events, response.Event{
+			Timestamp: time.Now(),
+			Description: fmt.Sprintf(
+				"model: %s does not stream, delegating StreamResponse to CompleteResponse",
+				req.Model.GetName(),
+			),
+		})
+	}
+
+	res, err := provider.CompleteResponse(ctx, req, client, requestLog)
+	if err != nil {
+		return response.Completion{}, err
+	}
+
+	if chunkHandler != nil && res.Content != "" {
+		if err := chunkHandler(res.Content); err != nil {
+			return response.Completion{}, err
+		}
+	}
+
+	res, _, err = finishStream(req, chunkHandler, res)
+
+	return res, err
+}
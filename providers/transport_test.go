@@ -0,0 +1,38 @@
+package providers_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// roundTripFunc lets tests stub out the HTTP transport handed to providers.
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// sseResponse builds a 200 response whose body is the given server-sent
+// events, one "data: " line per event.
+func sseResponse(events ...string) *http.Response {
+	var body strings.Builder
+	for _, event := range events {
+		body.WriteString("data: " + event + "\n\n")
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(body.String())),
+	}
+}
+
+// jsonResponse builds a 200 response whose body is the given JSON document.
+func jsonResponse(body string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
@@ -0,0 +1,25 @@
+package providers
+
+import (
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+)
+
+// estimateUsage approximates token counts at four characters per token, the
+// same heuristic the models' EstimateCost uses. It is a fallback for streams
+// that end without reporting usage.
+func estimateUsage(req request.Completion, completion string) response.Usage {
+	promptChars := len(req.SystemMessage) + len(req.UserMessage)
+	for _, msg := range req.History {
+		promptChars += len(msg.Content)
+	}
+
+	usage := response.Usage{
+		PromptTokens:     (promptChars + 3) / 4,
+		CompletionTokens: (len(completion) + 3) / 4,
+		Estimated:        true,
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	return usage
+}
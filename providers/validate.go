@@ -0,0 +1,83 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// validateKeys pings every key concurrently and marks the keys the API
+// rejects as invalid on d, so later requests skip straight past them. The
+// result has an entry per key, nil for the keys that answered successfully.
+// Keys that fail for other reasons, such as a timeout or rate limit, are
+// reported but left in use.
+func validateKeys(
+	ctx context.Context,
+	keys []string,
+	d *KeyDistributor,
+	ping func(ctx context.Context, key string) (int, error),
+) map[string]error {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]error, len(keys))
+	)
+
+	for _, key := range keys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			status, err := ping(ctx, key)
+			if status == http.StatusUnauthorized || status == http.StatusForbidden {
+				d.MarkInvalid(key)
+				err = fmt.Errorf("%w: %w", ErrInvalidKey, err)
+			}
+
+			mu.Lock()
+			results[key] = err
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// pingURL sends an authenticated GET to url, returning the status code and an
+// error for any non-200 response.
+func pingURL(
+	ctx context.Context,
+	opts options,
+	url string,
+	auth func(req *http.Request),
+) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("create request: %w", err)
+	}
+
+	opts.setHeaders(req)
+	auth(req)
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf(
+			"received status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	return resp.StatusCode, nil
+}
@@ -2,9 +2,9 @@ package providers
 
 import (
 	"context"
-	"crypto/rand"
 	"encoding/base64"
-	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -193,6 +193,8 @@ func convertToolsToGenai(tools models.GoogleTool) []*genai.Tool {
 
 type VertexAI struct {
 	vertexAIClient *genai.Client
+	httpClient     *http.Client
+	opts           options
 }
 
 // CompleteResponse implements LLMProvider.
@@ -230,6 +232,19 @@ func (v *VertexAI) Name() string {
 	return models.VertexProvider
 }
 
+// Supports implements LLMProvider for models.VertexModel, which carries the
+// Vertex AI model ID.
+func (v *VertexAI) Supports(m models.Model) bool {
+	vm, ok := m.(models.VertexModel)
+	return ok && vm.GetProvider() == v.Name()
+}
+
+// SupportsPrefill implements LLMProvider. Gemini does not continue a
+// trailing model turn.
+func (v *VertexAI) SupportsPrefill() bool {
+	return false
+}
+
 func (v *VertexAI) StreamResponse(
 	ctx context.Context,
 	client http.Client,
@@ -237,6 +252,10 @@ func (v *VertexAI) StreamResponse(
 	chunkHandler func(chunk string) error,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	if !streams(req.Model) {
+		return completeAsStream(ctx, v, client, req, chunkHandler, requestLog)
+	}
+
 	reqLog := &response.Logging{}
 	if requestLog == nil {
 		req.Tags["request_type"] = "streaming"
@@ -257,6 +276,8 @@ func (v *VertexAI) StreamResponse(
 		reqLog = requestLog
 	}
 
+	chunkHandler, delivered := trackDelivery(chunkHandler)
+
 	reqLog.Events = append(reqLog.Events, response.Event{
 		Timestamp: time.Now(),
 		Description: fmt.Sprintf(
@@ -268,6 +289,12 @@ func (v *VertexAI) StreamResponse(
 	if err == nil {
 		return res, nil
 	}
+	if exceedsSizeLimit(err) {
+		return res, err
+	}
+	if delivered() {
+		return response.Completion{}, err
+	}
 
 	reqLog.Events = append(reqLog.Events, response.Event{
 		Timestamp: time.Now(),
@@ -284,6 +311,7 @@ func (v *VertexAI) StreamResponse(
 type vertexModelConfig struct {
 	Tools            models.GoogleTool
 	StructuredOutput map[string]any
+	ResponseMimeType string
 	PdfFiles         []models.GooglePdf
 	ImageFile        []models.GoogleImagePayload
 	Files            []models.GoogleFilePayload
@@ -306,6 +334,7 @@ func extractVertexModelConfig(model models.Model) vertexModelConfig {
 	case models.VertexGemini20Flash:
 		config.Tools = m.Tools
 		config.StructuredOutput = m.StructuredOutput
+		config.ResponseMimeType = m.ResponseMimeType
 		config.PdfFiles = m.PdfFiles
 		config.ImageFile = m.ImageFile
 		config.Files = m.Files
@@ -313,6 +342,7 @@ func extractVertexModelConfig(model models.Model) vertexModelConfig {
 	case models.VertexGemini20FlashLite:
 		config.Tools = m.Tools
 		config.StructuredOutput = m.StructuredOutput
+		config.ResponseMimeType = m.ResponseMimeType
 		config.PdfFiles = m.PdfFiles
 		config.ImageFile = m.ImageFile
 		config.Files = m.Files
@@ -320,6 +350,7 @@ func extractVertexModelConfig(model models.Model) vertexModelConfig {
 	case models.VertexGemini25Pro:
 		config.Tools = m.Tools
 		config.StructuredOutput = m.StructuredOutput
+		config.ResponseMimeType = m.ResponseMimeType
 		config.PdfFiles = m.PdfFiles
 		config.ImageFile = m.ImageFile
 		config.Files = m.Files
@@ -327,6 +358,7 @@ func extractVertexModelConfig(model models.Model) vertexModelConfig {
 	case models.VertexGemini25Flash:
 		config.Tools = m.Tools
 		config.StructuredOutput = m.StructuredOutput
+		config.ResponseMimeType = m.ResponseMimeType
 		config.PdfFiles = m.PdfFiles
 		config.ImageFile = m.ImageFile
 		config.Files = m.Files
@@ -334,6 +366,7 @@ func extractVertexModelConfig(model models.Model) vertexModelConfig {
 	case models.VertexGemini25FlashLite:
 		config.Tools = m.Tools
 		config.StructuredOutput = m.StructuredOutput
+		config.ResponseMimeType = m.ResponseMimeType
 		config.PdfFiles = m.PdfFiles
 		config.ImageFile = m.ImageFile
 		config.Files = m.Files
@@ -341,6 +374,7 @@ func extractVertexModelConfig(model models.Model) vertexModelConfig {
 	case models.VertexGemini3ProPreview:
 		config.Tools = m.Tools
 		config.StructuredOutput = m.StructuredOutput
+		config.ResponseMimeType = m.ResponseMimeType
 		config.PdfFiles = m.PdfFiles
 		config.ImageFile = m.ImageFile
 		config.Files = m.Files
@@ -349,6 +383,7 @@ func extractVertexModelConfig(model models.Model) vertexModelConfig {
 	case models.VertexGemini3FlashPreview:
 		config.Tools = m.Tools
 		config.StructuredOutput = m.StructuredOutput
+		config.ResponseMimeType = m.ResponseMimeType
 		config.PdfFiles = m.PdfFiles
 		config.ImageFile = m.ImageFile
 		config.Files = m.Files
@@ -382,6 +417,25 @@ func (v *VertexAI) doRequest(
 	chunkHandler func(chunk string) error,
 	key string,
 ) (response.Completion, int, error) {
+	vertexModel, ok := req.Model.(models.VertexModel)
+	if !ok {
+		return response.Completion{}, 0, fmt.Errorf(
+			"%w: %s is not a Vertex AI model",
+			ErrUnsupportedModel,
+			req.Model.GetName(),
+		)
+	}
+	if len(req.RawMessages) > 0 {
+		return response.Completion{}, 0, errors.New(
+			"vertex ai does not support raw messages",
+		)
+	}
+	if len(req.ExtraBody) > 0 {
+		return response.Completion{}, 0, errors.New(
+			"vertex ai does not support extra body fields",
+		)
+	}
+
 	// Extract model configuration
 	modelConfig := extractVertexModelConfig(req.Model)
 
@@ -452,6 +506,34 @@ func (v *VertexAI) doRequest(
 
 	// Build generation config
 	genConfig := &genai.GenerateContentConfig{}
+	if id := request.IDFromContext(ctx); id != "" {
+		genConfig.HTTPOptions = &genai.HTTPOptions{
+			Headers: http.Header{"X-Request-ID": []string{id}},
+		}
+	}
+
+	// Add sampling parameters
+	if req.Temperature != 0 {
+		genConfig.Temperature = genai.Ptr(req.Temperature)
+	}
+	if req.TopP != 0 {
+		genConfig.TopP = genai.Ptr(req.TopP)
+	}
+	if req.TopK != 0 {
+		genConfig.TopK = genai.Ptr(float32(req.TopK))
+	}
+	if req.CandidateCount != 0 {
+		genConfig.CandidateCount = int32(req.CandidateCount)
+	}
+	if req.MaxTokens != 0 {
+		genConfig.MaxOutputTokens = int32(req.MaxTokens)
+	}
+	if len(req.StopSequences) > 0 {
+		genConfig.StopSequences = req.StopSequences
+	}
+	if req.PresencePenalty != 0 {
+		genConfig.PresencePenalty = genai.Ptr(req.PresencePenalty)
+	}
 
 	// Add system instruction
 	if req.SystemMessage != "" {
@@ -460,9 +542,12 @@ func (v *VertexAI) doRequest(
 
 	// Add structured output (response schema)
 	if len(modelConfig.StructuredOutput) > 0 {
-		genConfig.ResponseMIMEType = "application/json"
+		genConfig.ResponseMIMEType = models.GoogleMimeJSON
 		genConfig.ResponseSchema = convertSchemaToGenai(modelConfig.StructuredOutput)
 	}
+	if modelConfig.ResponseMimeType != "" {
+		genConfig.ResponseMIMEType = modelConfig.ResponseMimeType
+	}
 
 	// Add tools
 	if len(modelConfig.Tools) > 0 {
@@ -491,13 +576,25 @@ func (v *VertexAI) doRequest(
 
 	stream := v.vertexAIClient.Models.GenerateContentStream(
 		ctx,
-		req.Model.GetName(),
+		vertexModel.VertexModelID(),
 		parts,
 		genConfig,
 	)
 
-	var fullContent strings.Builder
+	rawReq, err := json.Marshal(struct {
+		Model    string                       `json:"model"`
+		Contents []*genai.Content             `json:"contents"`
+		Config   *genai.GenerateContentConfig `json:"config"`
+	}{vertexModel.VertexModelID(), parts, genConfig})
+	if err != nil {
+		return response.Completion{}, 0, fmt.Errorf("marshal raw request: %w", err)
+	}
+
+	fullContent := v.opts.newContent()
+	var images []response.Image
 	var usage response.Usage
+	var finishReason response.FinishReason
+	var rawEvents []json.RawMessage
 
 	now := time.Now()
 	isAnalyzing := true
@@ -507,8 +604,15 @@ func (v *VertexAI) doRequest(
 			if err != nil {
 				return response.Completion{}, 0, err
 			}
+
+			rawEvent, err := json.Marshal(streamPart)
+			if err != nil {
+				return response.Completion{}, 0, fmt.Errorf("marshal raw response event: %w", err)
+			}
+			rawEvents = append(rawEvents, rawEvent)
+
 			if len(streamPart.Candidates) == 0 &&
-				time.Since(now).Seconds() > 3.0 {
+				v.opts.firstChunkTimedOut(now) {
 				return response.Completion{}, 0, context.Canceled
 			}
 
@@ -518,31 +622,38 @@ func (v *VertexAI) doRequest(
 				for _, part := range streamPart.Candidates[0].Content.Parts {
 					// Handle image data for image generation models
 					if part.InlineData != nil && len(part.InlineData.Data) > 0 {
+						images = append(images, response.Image{
+							MimeType: part.InlineData.MIMEType,
+							Data:     part.InlineData.Data,
+						})
 						imageData := base64.StdEncoding.EncodeToString(part.InlineData.Data)
-						_, err := fullContent.WriteString(imageData)
-						if err != nil {
-							return response.Completion{}, 0, err
-						}
+						text, limitErr := fullContent.add(imageData)
 						if chunkHandler != nil {
-							if err := chunkHandler(imageData); err != nil {
+							if err := chunkHandler(text); err != nil {
 								return response.Completion{}, 0, err
 							}
 						}
+						if limitErr != nil {
+							return fullContent.partial(req.Model.GetName(), v.Name()), 0, limitErr
+						}
 					} else if part.Text != "" && part.Text != "Analyzing" {
 						// Handle text responses
-						_, err := fullContent.WriteString(part.Text)
-						if err != nil {
-							return response.Completion{}, 0, err
-						}
-
+						text, limitErr := fullContent.add(part.Text)
 						if chunkHandler != nil {
-							if err := chunkHandler(part.Text); err != nil {
+							if err := chunkHandler(text); err != nil {
 								return response.Completion{}, 0, err
 							}
 						}
+						if limitErr != nil {
+							return fullContent.partial(req.Model.GetName(), v.Name()), 0, limitErr
+						}
 					}
 				}
 
+				if streamPart.Candidates[0].FinishReason != "" {
+					finishReason = mapGeminiFinish(string(streamPart.Candidates[0].FinishReason))
+				}
+
 				if streamPart.Candidates[0].FinishReason == "STOP" {
 					isAnalyzing = false
 
@@ -557,6 +668,9 @@ func (v *VertexAI) doRequest(
 							TotalTokens: int(
 								streamPart.UsageMetadata.TotalTokenCount,
 							),
+							CachedTokens: int(
+								streamPart.UsageMetadata.CachedContentTokenCount,
+							),
 						}
 					}
 				}
@@ -564,11 +678,21 @@ func (v *VertexAI) doRequest(
 		}
 	}
 
-	return response.Completion{
-		Content: fullContent.String(),
-		Model:   req.Model.GetName(),
-		Usage:   usage,
-	}, 0, nil
+	rawResp, err := json.Marshal(rawEvents)
+	if err != nil {
+		return response.Completion{}, 0, fmt.Errorf("marshal raw response events: %w", err)
+	}
+
+	return finishStream(req, chunkHandler, v.opts.sampleRaw(ctx, response.Completion{
+		Content:      fullContent.String(),
+		Images:       images,
+		Model:        req.Model.GetName(),
+		Provider:     v.Name(),
+		FinishReason: finishReason,
+		Usage:        usage,
+		RawRequest:   rawReq,
+		RawResponse:  rawResp,
+	}))
 }
 
 func (v *VertexAI) tryWithBackup(
@@ -583,6 +707,9 @@ func (v *VertexAI) tryWithBackup(
 	maxBackoff := 10 * time.Second
 
 	var lastErr error
+	var lastStatusCode int
+	start := v.opts.now()
+	backoffStep := 0
 	for attempt := range maxRetries {
 		requestLog.Events = append(requestLog.Events, response.Event{
 			Timestamp: time.Now(),
@@ -603,16 +730,24 @@ func (v *VertexAI) tryWithBackup(
 			})
 			return response.Completion{}, ctx.Err()
 		default:
+			var progress streamProgress
 			res, resCode, err := v.doRequest(
 				ctx,
 				req,
-				client,
-				chunkHandler,
+				progress.client(client),
+				progress.wrap(chunkHandler),
 				"",
 			)
 			if err == nil {
 				return res, nil
 			}
+			dropped, delivered := progress.failed(ctx, err, requestLog)
+			if delivered {
+				return response.Completion{}, err
+			}
+			if dropped {
+				backoffStep = 0
+			}
 			requestLog.Events = append(requestLog.Events, response.Event{
 				Timestamp: time.Now(),
 				Description: fmt.Sprintf(
@@ -621,7 +756,7 @@ func (v *VertexAI) tryWithBackup(
 				),
 			})
 
-			if !isRetryableError(resCode) {
+			if !dropped && !v.opts.retryable(resCode, err) {
 				requestLog.Events = append(requestLog.Events, response.Event{
 					Timestamp: time.Now(),
 					Description: fmt.Sprintf(
@@ -629,7 +764,7 @@ func (v *VertexAI) tryWithBackup(
 						err,
 					),
 				})
-				return response.Completion{}, err
+				return res, err
 			}
 
 			requestLog.Events = append(requestLog.Events, response.Event{
@@ -641,54 +776,57 @@ func (v *VertexAI) tryWithBackup(
 			})
 
 			lastErr = err
+			lastStatusCode = resCode
 
 			backoff := min(initialBackoff*time.Duration(
-				1<<attempt,
+				1<<backoffStep,
 			), maxBackoff)
+			backoffStep++
 
-			var randomBytes [8]byte
-			var jitter time.Duration
-			if _, err := rand.Read(randomBytes[:]); err != nil {
-				jitter = backoff
-			} else {
-				randFloat := float64(binary.LittleEndian.Uint64(randomBytes[:])) / (1 << 64)
-				jitter = time.Duration(float64(backoff) * (0.8 + 0.4*randFloat))
-			}
-
-			timer := time.NewTimer(jitter)
+			timer := v.opts.newTimer(v.opts.jitter(backoff))
 			select {
 			case <-ctx.Done():
 				timer.Stop()
 				return response.Completion{}, ctx.Err()
-			case <-timer.C:
+			case <-timer.C():
 				continue
 			}
 		}
 	}
 
-	return response.Completion{}, fmt.Errorf(
-		"max retries exceeded: %w",
-		lastErr,
-	)
+	return response.Completion{}, &RetryExhaustedError{
+		Attempts:       maxRetries,
+		Elapsed:        v.opts.now().Sub(start),
+		LastStatusCode: lastStatusCode,
+		Err:            lastErr,
+	}
 }
 
+// NewVertexAI returns a provider for Gemini models on Vertex AI in the given
+// project and location. credentialsJSON is a service account key; nil uses
+// the application default credentials.
 func NewVertexAI(
 	ctx context.Context,
 	projectID string,
 	location string,
-	credentialsJSON ...[]byte,
+	credentialsJSON []byte,
+	opts ...Option,
 ) (VertexAI, error) {
+	o := newOptions(opts)
 	httpClient := &http.Client{}
 
 	// If credentials JSON is provided, create an authenticated HTTP client
-	if len(credentialsJSON) > 0 && len(credentialsJSON[0]) > 0 {
-		creds, err := google.CredentialsFromJSON(ctx, credentialsJSON[0],
+	if len(credentialsJSON) > 0 {
+		creds, err := google.CredentialsFromJSON(ctx, credentialsJSON,
 			"https://www.googleapis.com/auth/cloud-platform")
 		if err != nil {
 			return VertexAI{}, fmt.Errorf("could not parse credentials JSON: %w", err)
 		}
 		httpClient = oauth2.NewClient(ctx, creds.TokenSource)
 	}
+	// The genai client does not use the client handed to each call, so a
+	// RateLimited wrapper reaches it through the request context.
+	httpClient.Transport = contextLimited(httpClient.Transport)
 
 	client, err := genai.NewClient(
 		ctx,
@@ -700,6 +838,7 @@ func NewVertexAI(
 			// Use the base endpoint instead of regional endpoint for Gemini 3 compatibility
 			HTTPOptions: genai.HTTPOptions{
 				BaseURL: "https://aiplatform.googleapis.com/",
+				Headers: http.Header{"User-Agent": []string{o.getUserAgent()}},
 			},
 		},
 	)
@@ -709,7 +848,20 @@ func NewVertexAI(
 
 	return VertexAI{
 		vertexAIClient: client,
+		httpClient:     httpClient,
+		opts:           o,
 	}, nil
 }
 
+// Close releases the connections held by the provider's client. The genai
+// client has no Close of its own, so this drops the idle connections of the
+// HTTP client it was built on. The provider must not be used afterwards.
+func (v *VertexAI) Close() error {
+	if v.httpClient != nil {
+		v.httpClient.CloseIdleConnections()
+	}
+
+	return nil
+}
+
 var _ LLMProvider = new(VertexAI)
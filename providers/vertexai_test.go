@@ -35,6 +35,7 @@ func TestVertexAIModelsWithCompletion(t *testing.T) {
 		context.Background(),
 		projectID,
 		location,
+		nil,
 	)
 	require.NoError(t, err, "error creating VertexAI provider", "error", err)
 
@@ -113,6 +114,7 @@ func TestVertexAIModelsWithStreaming(t *testing.T) {
 		context.Background(),
 		projectID,
 		location,
+		nil,
 	)
 	require.NoError(t, err, "error creating VertexAI provider", "error", err)
 
@@ -152,15 +154,12 @@ func TestVertexAIModelsWithStreaming(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			var chunkHandlerCollection string
+			var collected providers.Collector
 			res, err := vertexai.StreamResponse(
 				context.Background(),
 				client,
 				tt.req,
-				func(chunk string) error {
-					chunkHandlerCollection = chunkHandlerCollection + chunk
-					return nil
-				},
+				collected.Handle,
 				nil,
 			)
 			require.NoError(
@@ -171,11 +170,32 @@ func TestVertexAIModelsWithStreaming(t *testing.T) {
 
 			assert.NotEmpty(
 				t,
-				chunkHandlerCollection,
-				"chunkHandlerCollection should not be empty",
+				collected.String(),
+				"collected chunks should not be empty",
 			)
 			assert.NotEmpty(t, res.Content, "content should not be empty")
 			assert.NotEmpty(t, res.Model, "model should not be empty")
 		})
 	}
 }
+
+func TestVertexAIRejectsNonVertexModels(t *testing.T) {
+	t.Parallel()
+
+	vertexai := &providers.VertexAI{}
+
+	_, err := vertexai.CompleteResponse(
+		context.Background(),
+		request.Completion{
+			Model:         models.Gemini25Flash{},
+			SystemMessage: "you are a helpful assistant.",
+			UserMessage:   "Say hello in one sentence.",
+			Tags: map[string]string{
+				"type": "testing",
+			},
+		},
+		http.Client{},
+		nil,
+	)
+	require.ErrorIs(t, err, providers.ErrUnsupportedModel)
+}
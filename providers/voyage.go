@@ -0,0 +1,165 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+)
+
+const voyageBaseURL = "https://api.voyageai.com/v1"
+
+type voyageRequest struct {
+	Input     []string `json:"input"`
+	Model     string   `json:"model"`
+	InputType string   `json:"input_type,omitempty"`
+}
+
+type voyageResponse struct {
+	Data []struct {
+		Embedding []float32 `json:"embedding"`
+		Index     int       `json:"index"`
+	} `json:"data"`
+	Model string `json:"model"`
+	Usage struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// Voyage serves Voyage AI embeddings, which accept a query or document input
+// type to improve recall in asymmetric retrieval.
+type Voyage struct {
+	apiKeys []string
+	keys    *KeyDistributor
+	opts    options
+}
+
+func NewVoyage(apiKeys []string, opts ...Option) Voyage {
+	o := newOptions(opts)
+
+	return Voyage{
+		apiKeys: apiKeys,
+		keys:    o.keyDistributor(apiKeys),
+		opts:    o,
+	}
+}
+
+func (v Voyage) Name() string {
+	return models.VoyageProvider
+}
+
+// Embed implements EmbeddingProvider. Each key is tried in turn until one
+// succeeds.
+func (v Voyage) Embed(
+	ctx context.Context,
+	req request.Embedding,
+	client http.Client,
+) (response.Embedding, error) {
+	if len(v.apiKeys) == 0 {
+		return response.Embedding{}, ErrNoAPIKeys
+	}
+	if req.Model == nil || req.Model.GetProvider() != models.VoyageProvider {
+		return response.Embedding{}, ErrUnsupportedModel
+	}
+
+	var errs []error
+	for _, key := range v.apiKeys {
+		res, err := v.doEmbed(ctx, req, client, key)
+		if err == nil {
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			return response.Embedding{}, ctx.Err()
+		}
+		if exceedsSizeLimit(err) {
+			return response.Embedding{}, err
+		}
+
+		errs = append(errs, err)
+	}
+
+	return response.Embedding{}, errors.Join(errs...)
+}
+
+func (v Voyage) doEmbed(
+	ctx context.Context,
+	req request.Embedding,
+	client http.Client,
+	key string,
+) (response.Embedding, error) {
+	key, release, err := v.keys.Acquire(ctx, key)
+	if err != nil {
+		return response.Embedding{}, err
+	}
+	defer release()
+
+	body, err := json.Marshal(voyageRequest{
+		Input:     req.Input,
+		Model:     req.Model.GetName(),
+		InputType: string(req.InputType),
+	})
+	if err != nil {
+		return response.Embedding{}, fmt.Errorf("marshal request: %w", err)
+	}
+	if err := v.opts.checkRequestSize(body); err != nil {
+		return response.Embedding{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		voyageBaseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return response.Embedding{}, fmt.Errorf("create request: %w", err)
+	}
+
+	v.opts.setHeaders(httpReq)
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+key)
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return response.Embedding{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return response.Embedding{}, fmt.Errorf(
+			"received status code %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var voyageResp voyageResponse
+	if err := json.NewDecoder(v.opts.limitResponse(resp.Body)).Decode(&voyageResp); err != nil {
+		return response.Embedding{}, fmt.Errorf("decode response: %w", err)
+	}
+
+	vectors := make([][]float32, len(req.Input))
+	for _, data := range voyageResp.Data {
+		if data.Index < 0 || data.Index >= len(vectors) {
+			return response.Embedding{}, fmt.Errorf(
+				"embedding index %d out of range for %d inputs",
+				data.Index, len(vectors),
+			)
+		}
+		vectors[data.Index] = data.Embedding
+	}
+
+	return response.Embedding{
+		Vectors:  vectors,
+		Model:    req.Model.GetName(),
+		Provider: v.Name(),
+		Usage: response.Usage{
+			PromptTokens: voyageResp.Usage.TotalTokens,
+			TotalTokens:  voyageResp.Usage.TotalTokens,
+		},
+	}, nil
+}
+
+var _ EmbeddingProvider = Voyage{}
@@ -0,0 +1,69 @@
+package providers_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/providers"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVoyageEmbed(t *testing.T) {
+	t.Parallel()
+
+	var body map[string]any
+	client := http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "https://api.voyageai.com/v1/embeddings", req.URL.String())
+			assert.Equal(t, "Bearer test-key", req.Header.Get("Authorization"))
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body: io.NopCloser(strings.NewReader(`{
+					"data": [
+						{"embedding": [0.3, 0.4], "index": 1},
+						{"embedding": [0.1, 0.2], "index": 0}
+					],
+					"model": "voyage-3",
+					"usage": {"total_tokens": 7}
+				}`)),
+			}, nil
+		}),
+	}
+	voyage := providers.NewVoyage([]string{"test-key"})
+
+	res, err := voyage.Embed(context.Background(), request.Embedding{
+		Model:     models.Voyage3{},
+		Input:     []string{"what is heimdall?", "heimdall routes LLM requests"},
+		InputType: request.EmbeddingInputQuery,
+	}, client)
+	require.NoError(t, err)
+
+	assert.Equal(t, "voyage-3", body["model"])
+	assert.Equal(t, "query", body["input_type"])
+	assert.Equal(t, [][]float32{{0.1, 0.2}, {0.3, 0.4}}, res.Vectors)
+	assert.Equal(t, 7, res.Usage.TotalTokens)
+	assert.Equal(t, models.VoyageProvider, res.Provider)
+}
+
+func TestVoyageRejectsOtherModels(t *testing.T) {
+	t.Parallel()
+
+	voyage := providers.NewVoyage([]string{"test-key"})
+
+	_, err := voyage.Embed(context.Background(), request.Embedding{
+		Model: models.GPT4OMini{},
+		Input: []string{"hello"},
+	}, http.Client{})
+	require.ErrorIs(t, err, providers.ErrUnsupportedModel)
+}
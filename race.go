@@ -0,0 +1,61 @@
+package heimdall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+)
+
+// Race sends every request concurrently and returns the first successful
+// response, cancelling the requests still in flight. Each request goes through
+// Complete, so its own fallback models are still tried. If every request
+// fails, the returned error joins all of their errors.
+func (r *Router) Race(
+	ctx context.Context,
+	reqs []request.Completion,
+) (response.Completion, error) {
+	if len(reqs) == 0 {
+		return response.Completion{}, errors.New("race requires at least one request")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		model string
+		resp  response.Completion
+		err   error
+	}
+
+	results := make(chan result, len(reqs))
+	for _, req := range reqs {
+		req = req.ApplyDefaults(request.DefaultsFromContext(ctx))
+		// Requests are often copies of one another; give each its own tags so
+		// concurrent calls do not write to a shared map.
+		req.Tags = maps.Clone(req.Tags)
+		if req.Tags == nil {
+			req.Tags = map[string]string{}
+		}
+
+		go func() {
+			resp, err := r.Complete(ctx, req)
+			results <- result{req.Model.GetName(), resp, err}
+		}()
+	}
+
+	errs := make([]error, 0, len(reqs))
+	for range reqs {
+		res := <-results
+		if res.err == nil {
+			return res.resp, nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", res.model, res.err))
+	}
+
+	return response.Completion{}, errors.Join(errs...)
+}
@@ -0,0 +1,79 @@
+package heimdall_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/flyx-ai/heimdall"
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterRace(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should return the first success and cancel the rest", func(t *testing.T) {
+		cancelled := make(chan struct{})
+		router := heimdall.New(time.Second, []heimdall.LLMProvider{
+			fakeProvider{name: models.OpenaiProvider, delay: time.Millisecond},
+			fakeProvider{name: models.AnthropicProvider, delay: time.Minute, cancelled: cancelled},
+		})
+
+		res, err := router.Race(context.Background(), []request.Completion{
+			{Model: models.GPT4OMini{}},
+			{Model: models.Claude45Haiku{}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, models.OpenaiProvider, res.Content)
+
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("losing request was not cancelled")
+		}
+	})
+
+	t.Run("should join every error when all requests fail", func(t *testing.T) {
+		errOpenAI := errors.New("openai down")
+		errAnthropic := errors.New("anthropic down")
+		router := heimdall.New(time.Second, []heimdall.LLMProvider{
+			fakeProvider{name: models.OpenaiProvider, err: errOpenAI},
+			fakeProvider{name: models.AnthropicProvider, err: errAnthropic},
+		})
+
+		_, err := router.Race(context.Background(), []request.Completion{
+			{Model: models.GPT4OMini{}},
+			{Model: models.Claude45Haiku{}},
+		})
+		require.ErrorIs(t, err, errOpenAI)
+		require.ErrorIs(t, err, errAnthropic)
+	})
+
+	t.Run("should not take a request without a provider as the winner", func(t *testing.T) {
+		router := heimdall.New(time.Second, []heimdall.LLMProvider{
+			fakeProvider{name: models.OpenaiProvider, delay: 10 * time.Millisecond},
+		})
+
+		res, err := router.Race(context.Background(), []request.Completion{
+			{Model: models.GPT4OMini{}},
+			{Model: models.Claude45Haiku{}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, models.OpenaiProvider, res.Content)
+	})
+
+	t.Run("should fail when no request has a provider", func(t *testing.T) {
+		router := heimdall.New(time.Second, []heimdall.LLMProvider{
+			fakeProvider{name: models.OpenaiProvider},
+		})
+
+		_, err := router.Race(context.Background(), []request.Completion{
+			{Model: models.Claude45Haiku{}},
+		})
+		require.ErrorIs(t, err, heimdall.ErrUnsupportedProvider)
+	})
+}
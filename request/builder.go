@@ -0,0 +1,124 @@
+package request
+
+import (
+	"errors"
+	"maps"
+	"slices"
+
+	"github.com/flyx-ai/heimdall/models"
+)
+
+var (
+	// ErrNoModel is returned by Builder.Build for a request without a model.
+	ErrNoModel = errors.New("request has no model")
+	// ErrNoMessage is returned by Builder.Build for a request with nothing
+	// to send: neither a user message nor history.
+	ErrNoMessage = errors.New("request has no message")
+)
+
+// Builder assembles a Completion one field at a time, starting from safe
+// defaults such as non-nil Tags:
+//
+//	req, err := request.New(models.GPT4OMini{}).
+//		System("You are terse.").
+//		User("Say hello.").
+//		Temp(0.2).
+//		Tag("team", "search").
+//		Build()
+//
+// Fields without a builder method can be set on the built Completion.
+type Builder struct {
+	c Completion
+}
+
+// New starts a Completion for model.
+func New(model models.Model) *Builder {
+	return &Builder{c: Completion{
+		Model: model,
+		Tags:  map[string]string{},
+	}}
+}
+
+// System sets the system message.
+func (b *Builder) System(msg string) *Builder {
+	b.c.SystemMessage = msg
+	return b
+}
+
+// User sets the user message.
+func (b *Builder) User(msg string) *Builder {
+	b.c.UserMessage = msg
+	return b
+}
+
+// History appends earlier turns of the conversation.
+func (b *Builder) History(msgs ...Message) *Builder {
+	b.c.History = append(b.c.History, msgs...)
+	return b
+}
+
+// Fallback appends models to try, in order, when the model fails.
+func (b *Builder) Fallback(fallbacks ...models.Model) *Builder {
+	b.c.Fallback = append(b.c.Fallback, fallbacks...)
+	return b
+}
+
+// Temp sets the sampling temperature.
+func (b *Builder) Temp(temperature float32) *Builder {
+	b.c.Temperature = temperature
+	return b
+}
+
+// TopP sets nucleus sampling.
+func (b *Builder) TopP(topP float32) *Builder {
+	b.c.TopP = topP
+	return b
+}
+
+// MaxTokens caps the number of tokens generated.
+func (b *Builder) MaxTokens(n int) *Builder {
+	b.c.MaxTokens = n
+	return b
+}
+
+// Stop appends stop sequences.
+func (b *Builder) Stop(sequences ...string) *Builder {
+	b.c.StopSequences = append(b.c.StopSequences, sequences...)
+	return b
+}
+
+// Tools appends tools the model may call.
+func (b *Builder) Tools(tools ...Tool) *Builder {
+	b.c.Tools = append(b.c.Tools, tools...)
+	return b
+}
+
+// Tag sets a tag, replacing any earlier value for key.
+func (b *Builder) Tag(key, value string) *Builder {
+	b.c.Tags[key] = value
+	return b
+}
+
+// Build returns the Completion, or an error when it has no model, nothing
+// to send, or inputs the model cannot consume, see Completion.Validate. The
+// builder can be changed and built again without affecting the result.
+func (b *Builder) Build() (Completion, error) {
+	c := b.c
+	if c.Model == nil {
+		return Completion{}, ErrNoModel
+	}
+	if c.UserMessage == "" && len(c.History) == 0 {
+		return Completion{}, ErrNoMessage
+	}
+	if err := c.Validate(); err != nil {
+		return Completion{}, err
+	}
+
+	c.Tags = maps.Clone(c.Tags)
+	c.History = slices.Clone(c.History)
+	c.Fallback = slices.Clone(c.Fallback)
+	c.StopSequences = slices.Clone(c.StopSequences)
+	c.Tools = slices.Clone(c.Tools)
+
+	return c, nil
+}
@@ -0,0 +1,86 @@
+package request_test
+
+import (
+	"testing"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilder(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should build the request", func(t *testing.T) {
+		t.Parallel()
+
+		req, err := request.New(models.GPT4OMini{}).
+			System("You are terse.").
+			User("Say hello.").
+			History(request.Message{Role: "user", Content: "Hi"}).
+			Fallback(models.GPT4O{}).
+			Temp(0.2).
+			TopP(0.9).
+			MaxTokens(100).
+			Stop("\n\n").
+			Tag("team", "search").
+			Build()
+		require.NoError(t, err)
+
+		assert.Equal(t, request.Completion{
+			Model:         models.GPT4OMini{},
+			SystemMessage: "You are terse.",
+			UserMessage:   "Say hello.",
+			History:       []request.Message{{Role: "user", Content: "Hi"}},
+			Fallback:      []models.Model{models.GPT4O{}},
+			Temperature:   0.2,
+			TopP:          0.9,
+			MaxTokens:     100,
+			StopSequences: []string{"\n\n"},
+			Tags:          map[string]string{"team": "search"},
+		}, req)
+	})
+
+	t.Run("should initialize tags", func(t *testing.T) {
+		t.Parallel()
+
+		req, err := request.New(models.GPT4OMini{}).User("Say hello.").Build()
+		require.NoError(t, err)
+		assert.NotNil(t, req.Tags)
+	})
+
+	t.Run("should not share state with later builds", func(t *testing.T) {
+		t.Parallel()
+
+		b := request.New(models.GPT4OMini{}).User("Say hello.").Tag("run", "one")
+		first, err := b.Build()
+		require.NoError(t, err)
+
+		b.Tag("run", "two")
+		assert.Equal(t, "one", first.Tags["run"])
+	})
+
+	tests := map[string]struct {
+		builder *request.Builder
+		wantErr error
+	}{
+		"should require a model": {
+			builder: request.New(nil).User("Say hello."),
+			wantErr: request.ErrNoModel,
+		},
+		"should require a message": {
+			builder: request.New(models.GPT4OMini{}).System("You are terse."),
+			wantErr: request.ErrNoMessage,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := tt.builder.Build()
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package request
+
+import (
+	"context"
+	"maps"
+
+	"github.com/flyx-ai/heimdall/models"
+)
+
+// Defaults are request fields set once for a scope, such as an HTTP request,
+// instead of on every Completion. The router fills them into any Completion
+// that leaves the field zero.
+type Defaults struct {
+	Model models.Model
+	// Tags are merged key by key; a tag set on the Completion wins.
+	Tags        map[string]string
+	Temperature float32
+}
+
+type defaultsKey struct{}
+
+// WithDefaults returns a copy of ctx carrying d. Defaults already on ctx
+// fill whatever d leaves zero, so nested scopes only override what they set.
+func WithDefaults(ctx context.Context, d Defaults) context.Context {
+	d = d.merge(DefaultsFromContext(ctx))
+	return context.WithValue(ctx, defaultsKey{}, d)
+}
+
+// DefaultsFromContext returns the defaults stored by WithDefaults, or the
+// zero Defaults.
+func DefaultsFromContext(ctx context.Context) Defaults {
+	d, _ := ctx.Value(defaultsKey{}).(Defaults)
+	return d
+}
+
+// ApplyDefaults returns a copy of the request with the fields it leaves zero
+// taken from d. Tags are copied rather than shared, so the result can be
+// tagged without affecting d.
+func (c Completion) ApplyDefaults(d Defaults) Completion {
+	if c.Model == nil {
+		c.Model = d.Model
+	}
+	if c.Temperature == 0 {
+		c.Temperature = d.Temperature
+	}
+	if len(d.Tags) > 0 {
+		c.Tags = mergeTags(c.Tags, d.Tags)
+	}
+
+	return c
+}
+
+// merge fills the fields d leaves zero from outer.
+func (d Defaults) merge(outer Defaults) Defaults {
+	if d.Model == nil {
+		d.Model = outer.Model
+	}
+	if d.Temperature == 0 {
+		d.Temperature = outer.Temperature
+	}
+	if len(outer.Tags) > 0 {
+		d.Tags = mergeTags(d.Tags, outer.Tags)
+	}
+
+	return d
+}
+
+// mergeTags returns a new map holding tags and every entry of defaults whose
+// key tags does not set.
+func mergeTags(tags, defaults map[string]string) map[string]string {
+	merged := maps.Clone(defaults)
+	maps.Copy(merged, tags)
+
+	return merged
+}
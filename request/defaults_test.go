@@ -0,0 +1,76 @@
+package request_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithDefaults(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should return zero defaults for a plain context", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, request.Defaults{}, request.DefaultsFromContext(context.Background()))
+	})
+
+	t.Run("should layer nested scopes", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := request.WithDefaults(context.Background(), request.Defaults{
+			Model:       models.GPT4OMini{},
+			Tags:        map[string]string{"tenant": "acme", "env": "prod"},
+			Temperature: 0.2,
+		})
+		ctx = request.WithDefaults(ctx, request.Defaults{
+			Tags: map[string]string{"env": "staging", "route": "/chat"},
+		})
+
+		assert.Equal(t, request.Defaults{
+			Model:       models.GPT4OMini{},
+			Tags:        map[string]string{"tenant": "acme", "env": "staging", "route": "/chat"},
+			Temperature: 0.2,
+		}, request.DefaultsFromContext(ctx))
+	})
+}
+
+func TestApplyDefaults(t *testing.T) {
+	t.Parallel()
+
+	defaults := request.Defaults{
+		Model:       models.GPT4OMini{},
+		Tags:        map[string]string{"tenant": "acme"},
+		Temperature: 0.2,
+	}
+
+	t.Run("should fill the fields a request leaves zero", func(t *testing.T) {
+		t.Parallel()
+
+		req := request.Completion{UserMessage: "Hello"}.ApplyDefaults(defaults)
+
+		assert.Equal(t, models.GPT4OMini{}, req.Model)
+		assert.Equal(t, float32(0.2), req.Temperature)
+		assert.Equal(t, map[string]string{"tenant": "acme"}, req.Tags)
+
+		req.Tags["request_type"] = "completion"
+		assert.NotContains(t, defaults.Tags, "request_type", "the default tags should be copied")
+	})
+
+	t.Run("should keep the fields a request sets", func(t *testing.T) {
+		t.Parallel()
+
+		req := request.Completion{
+			Model:       models.GPT4O{},
+			Temperature: 0.9,
+			Tags:        map[string]string{"tenant": "globex"},
+		}.ApplyDefaults(defaults)
+
+		assert.Equal(t, models.GPT4O{}, req.Model)
+		assert.Equal(t, float32(0.9), req.Temperature)
+		assert.Equal(t, map[string]string{"tenant": "globex"}, req.Tags)
+	})
+}
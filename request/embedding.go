@@ -0,0 +1,21 @@
+package request
+
+import "github.com/flyx-ai/heimdall/models"
+
+// EmbeddingInputType tells providers that support asymmetric retrieval
+// whether the inputs are search queries or the documents being searched.
+type EmbeddingInputType string
+
+const (
+	EmbeddingInputQuery    EmbeddingInputType = "query"
+	EmbeddingInputDocument EmbeddingInputType = "document"
+)
+
+type Embedding struct {
+	Model models.Model
+	Input []string
+	// InputType is optional; leaving it empty embeds the inputs without a
+	// retrieval hint.
+	InputType EmbeddingInputType
+	Tags      map[string]string `json:"tags"`
+}
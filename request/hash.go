@@ -0,0 +1,134 @@
+package request
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/flyx-ai/heimdall/response"
+)
+
+// hashedCompletion is the normalized form of a Completion that Hash digests.
+// It holds only what shapes the provider's answer: routing settings such as
+// Fallback, Tags, IdempotencyKey and the handlers are left out.
+type hashedCompletion struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	// Config is the model struct, carrying its schema, tools, media and
+	// provider options.
+	Config          json.RawMessage `json:"config,omitempty"`
+	SystemMessage   string          `json:"system,omitempty"`
+	UserMessage     string          `json:"user,omitempty"`
+	History         []hashedMessage `json:"history,omitempty"`
+	Temperature     float32         `json:"temperature,omitempty"`
+	TopP            float32         `json:"top_p,omitempty"`
+	MaxTokens       int             `json:"max_tokens,omitempty"`
+	TopK            int             `json:"top_k,omitempty"`
+	CandidateCount  int             `json:"candidate_count,omitempty"`
+	StopSequences   []string        `json:"stop,omitempty"`
+	PresencePenalty float32         `json:"presence_penalty,omitempty"`
+	ServiceTier     string          `json:"service_tier,omitempty"`
+	Prediction      string          `json:"prediction,omitempty"`
+	RawMessages     json.RawMessage `json:"raw_messages,omitempty"`
+	ExtraBody       map[string]any  `json:"extra_body,omitempty"`
+	Tools           []Tool          `json:"tools,omitempty"`
+}
+
+type hashedMessage struct {
+	Role       string              `json:"role"`
+	Parts      []hashedPart        `json:"parts"`
+	ToolCalls  []response.ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string              `json:"tool_call_id,omitempty"`
+}
+
+// hashedPart is a message part with any inline image replaced by the
+// SHA-256 of its decoded bytes.
+type hashedPart struct {
+	Text      string   `json:"text,omitempty"`
+	ImageURL  string   `json:"image_url,omitempty"`
+	MimeType  MimeType `json:"mime_type,omitempty"`
+	ImageHash string   `json:"image_hash,omitempty"`
+}
+
+// Hash returns a stable SHA-256 hex digest of the request, for features that
+// key on the logical request, such as caching and deduplication. Two requests
+// hash alike when they send the same model, messages, sampling settings and
+// model inputs, regardless of Tags, Fallback, IdempotencyKey or handlers.
+// History is compared by its parts, so Content and an equivalent Parts slice
+// hash the same, and inline images are hashed by their decoded content.
+// Map keys are sorted, so the digest is the same across runs and processes.
+func (c Completion) Hash() string {
+	h := hashedCompletion{
+		SystemMessage:   c.SystemMessage,
+		UserMessage:     c.UserMessage,
+		Temperature:     c.Temperature,
+		TopP:            c.TopP,
+		MaxTokens:       c.MaxTokens,
+		TopK:            c.TopK,
+		CandidateCount:  c.CandidateCount,
+		StopSequences:   c.StopSequences,
+		PresencePenalty: c.PresencePenalty,
+		ServiceTier:     c.ServiceTier,
+		Prediction:      c.Prediction,
+		RawMessages:     c.RawMessages,
+		ExtraBody:       c.ExtraBody,
+		Tools:           c.Tools,
+	}
+	if c.Model != nil {
+		h.Provider = strings.ToLower(c.Model.GetProvider())
+		h.Model = strings.ToLower(strings.TrimSpace(c.Model.GetName()))
+		if config, err := json.Marshal(c.Model); err == nil {
+			h.Config = config
+		} else {
+			h.Config, _ = json.Marshal(fmt.Sprintf("%#v", c.Model))
+		}
+	}
+	for _, msg := range c.History {
+		h.History = append(h.History, hashMessage(msg))
+	}
+
+	digest := sha256.New()
+	if err := json.NewEncoder(digest).Encode(h); err != nil {
+		// Only ExtraBody can hold values JSON cannot encode.
+		h.ExtraBody = nil
+		digest.Reset()
+		_ = json.NewEncoder(digest).Encode(h)
+		fmt.Fprintf(digest, "%#v", c.ExtraBody)
+	}
+
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+func hashMessage(msg Message) hashedMessage {
+	parts := msg.ContentParts()
+	hashed := hashedMessage{
+		Role:       strings.ToLower(msg.Role),
+		Parts:      make([]hashedPart, 0, len(parts)),
+		ToolCalls:  msg.ToolCalls,
+		ToolCallID: msg.ToolCallID,
+	}
+	for _, part := range parts {
+		if part.Image == nil {
+			if part.Text != "" {
+				hashed.Parts = append(hashed.Parts, hashedPart{Text: part.Text})
+			}
+			continue
+		}
+
+		img := hashedPart{ImageURL: part.Image.URL, MimeType: part.Image.MimeType}
+		if part.Image.Data != "" {
+			data, err := base64.StdEncoding.DecodeString(part.Image.Data)
+			if err != nil {
+				data = []byte(part.Image.Data)
+			}
+			sum := sha256.Sum256(data)
+			img.ImageHash = hex.EncodeToString(sum[:])
+		}
+		hashed.Parts = append(hashed.Parts, img)
+	}
+
+	return hashed
+}
@@ -0,0 +1,131 @@
+package request_test
+
+import (
+	"testing"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompletionHash(t *testing.T) {
+	t.Parallel()
+
+	base := request.Completion{
+		Model: models.GPT4OMini{
+			StructuredOutput: map[string]any{"b": 1, "a": map[string]any{"y": true, "x": false}},
+		},
+		SystemMessage: "You are terse.",
+		UserMessage:   "Say hello.",
+		Temperature:   0.2,
+		Tags:          map[string]string{"request_id": "one"},
+	}
+
+	tests := map[string]struct {
+		change   func(req request.Completion) request.Completion
+		wantSame bool
+	}{
+		"should ignore tags and idempotency keys": {
+			change: func(req request.Completion) request.Completion {
+				req.Tags = map[string]string{"request_id": "two"}
+				req.IdempotencyKey = "retry-key"
+				return req
+			},
+			wantSame: true,
+		},
+		"should ignore fallbacks": {
+			change: func(req request.Completion) request.Completion {
+				req.Fallback = []models.Model{models.GPT4O{}}
+				return req
+			},
+			wantSame: true,
+		},
+		"should not depend on schema map order": {
+			change: func(req request.Completion) request.Completion {
+				req.Model = models.GPT4OMini{
+					StructuredOutput: map[string]any{"a": map[string]any{"x": false, "y": true}, "b": 1},
+				}
+				return req
+			},
+			wantSame: true,
+		},
+		"should change with the model": {
+			change: func(req request.Completion) request.Completion {
+				req.Model = models.GPT4O{StructuredOutput: req.Model.(models.GPT4OMini).StructuredOutput}
+				return req
+			},
+		},
+		"should change with the schema": {
+			change: func(req request.Completion) request.Completion {
+				req.Model = models.GPT4OMini{StructuredOutput: map[string]any{"b": 2}}
+				return req
+			},
+		},
+		"should change with the temperature": {
+			change: func(req request.Completion) request.Completion {
+				req.Temperature = 0.3
+				return req
+			},
+		},
+		"should change with the messages": {
+			change: func(req request.Completion) request.Completion {
+				req.UserMessage = "Say goodbye."
+				return req
+			},
+		},
+		"should change with the history": {
+			change: func(req request.Completion) request.Completion {
+				req.History = []request.Message{{Role: "user", Content: "Hi"}}
+				return req
+			},
+		},
+		"should change with the prediction": {
+			change: func(req request.Completion) request.Completion {
+				req.Prediction = "Hello."
+				return req
+			},
+		},
+		"should change with the tools": {
+			change: func(req request.Completion) request.Completion {
+				req.Tools = []request.Tool{{Name: "weather"}}
+				return req
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := tt.change(base).Hash()
+			assert.Len(t, got, 64)
+			if tt.wantSame {
+				assert.Equal(t, base.Hash(), got)
+			} else {
+				assert.NotEqual(t, base.Hash(), got)
+			}
+		})
+	}
+
+	t.Run("should hash equivalent history forms alike", func(t *testing.T) {
+		t.Parallel()
+
+		img := request.Image{MimeType: request.MimeTypePNG, Data: "aGVsbG8="}
+		content := request.Completion{
+			Model:   models.GPT4OMini{},
+			History: []request.Message{{Role: "user", Content: "Describe it.", Images: []request.Image{img}}},
+		}
+		parts := request.Completion{
+			Model: models.GPT4OMini{},
+			History: []request.Message{{Role: "User", Parts: []request.Part{
+				{Image: &img},
+				{Text: "Describe it."},
+			}}},
+		}
+		assert.Equal(t, content.Hash(), parts.Hash())
+
+		other := request.Image{MimeType: request.MimeTypePNG, Data: "d29ybGQ="}
+		parts.History[0].Parts[0].Image = &other
+		assert.NotEqual(t, content.Hash(), parts.Hash())
+	})
+}
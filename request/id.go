@@ -0,0 +1,17 @@
+package request
+
+import "context"
+
+type idKey struct{}
+
+// WithID returns a copy of ctx carrying the request ID used to correlate logs
+// and outbound provider calls.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// IDFromContext returns the request ID stored by WithID, or an empty string.
+func IDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
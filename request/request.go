@@ -1,6 +1,13 @@
 package request
 
-import "github.com/flyx-ai/heimdall/models"
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/response"
+)
 
 type MimeType string
 
@@ -34,11 +41,239 @@ type Completion struct {
 	Fallback      []models.Model
 	Temperature   float32
 	TopP          float32
-	Tags          map[string]string `json:"tags"`
+	// MaxTokens caps the number of tokens generated. Zero leaves the
+	// provider default in place. Where the model sets its own cap, such as
+	// Claude46Opus.MaxOutputTokens, the lower of the two applies.
+	MaxTokens int
+	// TopK limits sampling to the K most likely tokens. Zero leaves the
+	// provider default in place.
+	TopK int
+	// CandidateCount asks for that many alternative responses where the
+	// provider supports it.
+	CandidateCount int
+	// StopSequences end generation as soon as any of them is produced.
+	StopSequences []string
+	// PresencePenalty penalises tokens that already appeared in the
+	// response, encouraging new topics.
+	PresencePenalty float32
+	// IdempotencyKey is sent to providers that deduplicate retried requests,
+	// so a retry of a request that already succeeded upstream returns the
+	// original result. Providers generate one per call when it is empty. A
+	// key set here is sent with a suffix derived from the request body, so
+	// the follow-up requests the router derives from one request, such as
+	// AutoContinue continuations or RunAgent steps, are not mistaken for
+	// retries of it.
+	IdempotencyKey string
+	// DegradeOnRateLimit lets the router retry a rate-limited request on the
+	// model's smaller sibling in the same family, see models.Downgrader,
+	// before moving on to Fallback.
+	DegradeOnRateLimit bool
+	// AutoContinue is the number of times Router.Complete re-requests a
+	// response cut off by the token limit, sending the text generated so far
+	// back as the assistant's turn to be continued. The parts are returned
+	// as one response with summed usage. Only providers that continue an
+	// assistant turn in place, such as Anthropic, are asked; on others the
+	// truncated response is returned as it stands. A failed continuation
+	// returns the text so far, marked Partial, with the error.
+	AutoContinue int
+	// SalvagePartialJSON lets Router.Complete repair structured output that
+	// is still cut off by the token limit once any AutoContinue attempts are
+	// used up, which otherwise leaves unparseable JSON. Open strings,
+	// objects and arrays are closed, the response is marked Partial and the
+	// content as generated is kept in TruncatedContent. Prefer AutoContinue
+	// where the provider supports it, as salvage loses everything past the
+	// cut.
+	SalvagePartialJSON bool
+	// ResumeOnDrop lets Router.Stream recover a stream that fails part way,
+	// such as a connection cut by a proxy's idle timeout. The text streamed
+	// so far is sent back as the assistant's turn for the model to continue,
+	// up to three times, and the chunk handler only receives the new text.
+	// Like AutoContinue, it needs a provider that continues an assistant
+	// turn in place, such as Anthropic; on others the drop is returned as
+	// the stream's error.
+	ResumeOnDrop bool
+	// DedupeChunks drops streamed events that the upstream, or a proxy in
+	// front of it, sends again, which would otherwise double the output.
+	// It is conservative: only events carrying an SSE id or a sequence
+	// number are compared, and only a repeat of both that identity and the
+	// whole event is dropped, so repeated words are never collapsed.
+	DedupeChunks bool
+	// ServiceTier selects OpenAI's processing tier: "auto", "default",
+	// "flex" for cheaper, slower processing or "priority" for faster,
+	// pricier processing. Empty leaves the account default in place.
+	ServiceTier string
+	// Prediction is text most of the response is expected to repeat, such
+	// as the draft of a document being edited. OpenAI's predicted outputs
+	// use it to generate faster; tokens that do not match are still billed,
+	// see response.Usage.RejectedPredictionTokens. Other providers ignore
+	// it.
+	Prediction string
+	// Store asks OpenAI to keep the completion for its dashboard and evals
+	// tooling. Tags prefixed with MetadataTagPrefix are attached to it as
+	// metadata, see Metadata.
+	Store bool
+	// ThoughtHandler receives reasoning deltas as they stream in from models
+	// that expose their thinking, separately from the answer chunks passed to
+	// the chunk handler. Returning an error aborts the stream.
+	ThoughtHandler func(thought string) error `json:"-"`
+	// OnComplete is called once a streamed response has finished cleanly,
+	// after the last chunk and before the stream call returns, so handlers
+	// that buffer chunks can flush. Returning an error fails the stream.
+	OnComplete func(res response.Completion) error `json:"-"`
+	// RawMessages, when set, is sent verbatim as the provider's native
+	// conversation, the "messages" array or Gemini's "contents", in place of
+	// the one built from UserMessage and History. SystemMessage is still
+	// sent where the provider takes it outside the conversation, as Anthropic
+	// and Gemini do. It is an escape hatch for provider features heimdall
+	// does not model: the format is provider-specific and is not translated
+	// for fallbacks to another provider. VertexAI does not support it.
+	RawMessages json.RawMessage `json:"-"`
+	// Tools are functions the model may ask to call. Their calls come back
+	// in response.Completion.ToolCalls; see Router.RunAgent for a loop that
+	// runs them. Models whose Capabilities do not report Tools, which so far
+	// is every model outside OpenAI, fail validation.
+	Tools []Tool `json:"-"`
+	// ExtraBody is shallow-merged into the top level of the provider's
+	// request JSON, for parameters heimdall does not model yet, such as
+	// OpenAI's "logit_bias". Keys in ExtraBody replace the fields heimdall
+	// sets, including nested objects like Gemini's "generationConfig", which
+	// must then be given whole. It is not translated for fallbacks to
+	// another provider. VertexAI does not support it.
+	ExtraBody map[string]any    `json:"-"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// MetadataTagPrefix marks the Tags that are forwarded to OpenAI as request
+// metadata, with the prefix stripped from the key.
+const MetadataTagPrefix = "metadata."
+
+// Metadata returns the Tags designated as provider metadata by
+// MetadataTagPrefix, keyed without the prefix, or nil when there are none.
+func (c Completion) Metadata() map[string]string {
+	var metadata map[string]string
+	for k, v := range c.Tags {
+		key, ok := strings.CutPrefix(k, MetadataTagPrefix)
+		if !ok || key == "" {
+			continue
+		}
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		metadata[key] = v
+	}
+
+	return metadata
+}
+
+// Validate checks the request's images and tools against the Capabilities
+// of the primary and fallback models and returns a
+// *models.UnsupportedInputError for the first a model cannot consume, so
+// they fail before any network call instead of being silently dropped
+// while the provider request is built. Media set on a model itself is that
+// model's own concern.
+func (c Completion) Validate() error {
+	images := c.hasImages()
+	for _, model := range append([]models.Model{c.Model}, c.Fallback...) {
+		if model == nil {
+			continue
+		}
+
+		caps := model.Capabilities()
+		switch {
+		case images && !caps.Vision:
+			return &models.UnsupportedInputError{Model: model.GetName(), Input: "image"}
+		case len(c.Tools) > 0 && !caps.Tools:
+			return &models.UnsupportedInputError{Model: model.GetName(), Input: "tool"}
+		}
+	}
+
+	return nil
+}
+
+// hasImages reports whether any History message carries an image, in its
+// Images or its Parts.
+func (c Completion) hasImages() bool {
+	for _, msg := range c.History {
+		for _, part := range msg.ContentParts() {
+			if part.Image != nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// WithAssistantReply returns a copy of the request for the next turn of the
+// conversation: the current user message and resp's content and tool calls
+// are appended to History and UserMessage is cleared, ready for the caller
+// to set the next one, or to append the results of the tool calls. The
+// receiver's History is left untouched.
+func (c Completion) WithAssistantReply(resp response.Completion) Completion {
+	history := slices.Clone(c.History)
+	if c.UserMessage != "" {
+		history = append(history, Message{Role: "user", Content: c.UserMessage})
+	}
+	c.History = append(history, Message{
+		Role:      "assistant",
+		Content:   resp.Content,
+		ToolCalls: resp.ToolCalls,
+	})
+	c.UserMessage = ""
+
+	return c
 }
 
 type Message struct {
-	// Role should either be 'user' or 'assistant'
+	// Role should either be 'user' or 'assistant', or 'tool' for the result
+	// of a tool call.
 	Role    string
 	Content string
+	// ToolCalls are the tool calls an assistant turn made.
+	ToolCalls []response.ToolCall
+	// ToolCallID names the call a 'tool' turn is the result of.
+	ToolCallID string
+	// Images attached to this turn are sent with it, so vision models keep
+	// the visual context of earlier turns.
+	Images []Image
+	// Parts, when set, replaces Content and Images and is sent in the order
+	// given, so text can come before, after or between images.
+	Parts []Part
+}
+
+// Part is one piece of a message: Text, or an Image when Image is set.
+type Part struct {
+	Text  string
+	Image *Image
+}
+
+// ContentParts returns the message as ordered parts: Parts when set,
+// otherwise its Images followed by Content.
+func (m Message) ContentParts() []Part {
+	if len(m.Parts) > 0 {
+		return m.Parts
+	}
+
+	parts := make([]Part, 0, len(m.Images)+1)
+	for i := range m.Images {
+		parts = append(parts, Part{Image: &m.Images[i]})
+	}
+
+	return append(parts, Part{Text: m.Content})
+}
+
+// Tool describes a function the model may call.
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters is the JSON schema of the call's arguments object.
+	Parameters map[string]any
+}
+
+// Image is an image attached to a message. Set either URL, or Data holding
+// the base64-encoded image together with its MimeType.
+type Image struct {
+	URL      string
+	MimeType MimeType
+	Data     string
 }
@@ -0,0 +1,168 @@
+package request_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithAssistantReply(t *testing.T) {
+	t.Parallel()
+
+	first := request.Completion{
+		SystemMessage: "you are a helpful assistant.",
+		UserMessage:   "What is the capital of France?",
+		History: []request.Message{
+			{Role: "user", Content: "Hi"},
+			{Role: "assistant", Content: "Hello!"},
+		},
+	}
+
+	next := first.WithAssistantReply(response.Completion{Content: "Paris."})
+	next.UserMessage = "And of Italy?"
+
+	assert.Equal(t, []request.Message{
+		{Role: "user", Content: "Hi"},
+		{Role: "assistant", Content: "Hello!"},
+		{Role: "user", Content: "What is the capital of France?"},
+		{Role: "assistant", Content: "Paris."},
+	}, next.History)
+	assert.Equal(t, "you are a helpful assistant.", next.SystemMessage)
+
+	assert.Len(t, first.History, 2, "the original request should be unchanged")
+	assert.Equal(t, "What is the capital of France?", first.UserMessage)
+}
+
+func TestWithAssistantReplyToolCalls(t *testing.T) {
+	t.Parallel()
+
+	calls := []response.ToolCall{
+		{ID: "call_1", Name: "get_weather", Arguments: json.RawMessage(`{"city":"Paris"}`)},
+	}
+	req := request.Completion{UserMessage: "What is the weather in Paris?"}
+
+	next := req.WithAssistantReply(response.Completion{ToolCalls: calls})
+
+	assert.Equal(t, []request.Message{
+		{Role: "user", Content: "What is the weather in Paris?"},
+		{Role: "assistant", ToolCalls: calls},
+	}, next.History)
+	assert.Empty(t, next.UserMessage)
+}
+
+func TestMessageContentParts(t *testing.T) {
+	t.Parallel()
+
+	imgA := request.Image{URL: "https://example.com/a.png"}
+	imgB := request.Image{URL: "https://example.com/b.png"}
+
+	t.Run("should put images before the content by default", func(t *testing.T) {
+		t.Parallel()
+
+		msg := request.Message{Content: "Compare them.", Images: []request.Image{imgA, imgB}}
+		assert.Equal(t, []request.Part{
+			{Image: &msg.Images[0]},
+			{Image: &msg.Images[1]},
+			{Text: "Compare them."},
+		}, msg.ContentParts())
+	})
+
+	t.Run("should keep the order of explicit parts", func(t *testing.T) {
+		t.Parallel()
+
+		parts := []request.Part{
+			{Text: "Here is image A:"},
+			{Image: &imgA},
+			{Text: "and image B:"},
+			{Image: &imgB},
+			{Text: "Compare them."},
+		}
+		msg := request.Message{Content: "ignored", Images: []request.Image{imgA}, Parts: parts}
+		assert.Equal(t, parts, msg.ContentParts())
+	})
+}
+
+func TestCompletionMetadata(t *testing.T) {
+	t.Parallel()
+
+	req := request.Completion{
+		Tags: map[string]string{
+			"metadata.experiment": "onboarding-v2",
+			"metadata.":           "ignored",
+			"request_type":        "completion",
+		},
+	}
+	assert.Equal(t, map[string]string{"experiment": "onboarding-v2"}, req.Metadata())
+
+	assert.Nil(t, request.Completion{}.Metadata())
+}
+
+func TestCompletionValidate(t *testing.T) {
+	t.Parallel()
+
+	image := request.Image{URL: "https://example.com/a.png"}
+	imageHistory := []request.Message{{Role: "user", Content: "What is this?", Images: []request.Image{image}}}
+	partHistory := []request.Message{{Role: "user", Parts: []request.Part{{Text: "What is this?"}, {Image: &image}}}}
+	tools := []request.Tool{{Name: "weather"}}
+
+	tests := map[string]struct {
+		req       request.Completion
+		wantInput string
+	}{
+		"should accept a request without media or tools": {
+			req: request.Completion{Model: models.O3Mini{}, UserMessage: "Hi"},
+		},
+		"should accept history images for a vision model": {
+			req: request.Completion{Model: models.GPT4OMini{}, History: imageHistory},
+		},
+		"should reject history images for a model without vision": {
+			req:       request.Completion{Model: models.O3Mini{}, History: imageHistory},
+			wantInput: "image",
+		},
+		"should reject image parts for a fallback without vision": {
+			req: request.Completion{
+				Model:    models.GPT4OMini{},
+				Fallback: []models.Model{models.O3Mini{}},
+				History:  partHistory,
+			},
+			wantInput: "image",
+		},
+		"should accept tools for a model that takes them": {
+			req: request.Completion{Model: models.GPT4OMini{}, Tools: tools},
+		},
+		"should reject tools for a model without tool support": {
+			req:       request.Completion{Model: models.Claude45Haiku{}, Tools: tools},
+			wantInput: "tool",
+		},
+		"should reject tools for a fallback without tool support": {
+			req: request.Completion{
+				Model:    models.GPT4OMini{},
+				Fallback: []models.Model{models.Gemini25Flash{}},
+				Tools:    tools,
+			},
+			wantInput: "tool",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.req.Validate()
+			if tt.wantInput == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, models.ErrUnsupportedInput)
+
+			var unsupported *models.UnsupportedInputError
+			require.ErrorAs(t, err, &unsupported)
+			assert.Equal(t, tt.wantInput, unsupported.Input)
+		})
+	}
+}
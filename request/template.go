@@ -0,0 +1,58 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Template is a prompt with {{.Name}} placeholders, rendered with
+// text/template. Nothing is escaped, and rendering fails when the data lacks
+// a key the prompt refers to.
+type Template struct {
+	tmpl *template.Template
+}
+
+// NewTemplate parses text into a Template.
+func NewTemplate(text string) (*Template, error) {
+	tmpl, err := template.New("prompt").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse prompt template: %w", err)
+	}
+
+	return &Template{tmpl: tmpl}, nil
+}
+
+// Render substitutes data into the template.
+func (t *Template) Render(data map[string]any) (string, error) {
+	var out strings.Builder
+	if err := t.tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("render prompt template: %w", err)
+	}
+
+	return out.String(), nil
+}
+
+// WithSystemTemplate returns a copy of the request whose SystemMessage is t
+// rendered with data.
+func (c Completion) WithSystemTemplate(t *Template, data map[string]any) (Completion, error) {
+	msg, err := t.Render(data)
+	if err != nil {
+		return Completion{}, err
+	}
+	c.SystemMessage = msg
+
+	return c, nil
+}
+
+// WithUserTemplate returns a copy of the request whose UserMessage is t
+// rendered with data.
+func (c Completion) WithUserTemplate(t *Template, data map[string]any) (Completion, error) {
+	msg, err := t.Render(data)
+	if err != nil {
+		return Completion{}, err
+	}
+	c.UserMessage = msg
+
+	return c, nil
+}
@@ -0,0 +1,59 @@
+package request_test
+
+import (
+	"testing"
+
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplate(t *testing.T) {
+	t.Parallel()
+
+	tmpl, err := request.NewTemplate("Analyze {{.Company}} valuation & <risks> for {{.Year}}")
+	require.NoError(t, err)
+
+	t.Run("should substitute without escaping", func(t *testing.T) {
+		t.Parallel()
+
+		out, err := tmpl.Render(map[string]any{"Company": "AT&T", "Year": 2025})
+		require.NoError(t, err)
+		assert.Equal(t, "Analyze AT&T valuation & <risks> for 2025", out)
+	})
+
+	t.Run("should fail on a missing key", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := tmpl.Render(map[string]any{"Company": "Acme"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Year")
+	})
+
+	t.Run("should fail to parse a malformed template", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := request.NewTemplate("Analyze {{.Company")
+		require.Error(t, err)
+	})
+}
+
+func TestCompletionTemplates(t *testing.T) {
+	t.Parallel()
+
+	system, err := request.NewTemplate("You are a {{.Role}}.")
+	require.NoError(t, err)
+	user, err := request.NewTemplate("Summarize {{.Company}}.")
+	require.NoError(t, err)
+
+	req, err := request.Completion{}.WithSystemTemplate(system, map[string]any{"Role": "financial analyst"})
+	require.NoError(t, err)
+	req, err = req.WithUserTemplate(user, map[string]any{"Company": "Acme"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "You are a financial analyst.", req.SystemMessage)
+	assert.Equal(t, "Summarize Acme.", req.UserMessage)
+
+	_, err = req.WithUserTemplate(user, map[string]any{})
+	require.Error(t, err)
+}
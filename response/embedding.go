@@ -0,0 +1,9 @@
+package response
+
+type Embedding struct {
+	// Vectors holds one embedding per input, in input order.
+	Vectors  [][]float32
+	Model    string
+	Provider string
+	Usage    Usage
+}
@@ -0,0 +1,27 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrContentFiltered matches every *ContentFilteredError with errors.Is.
+var ErrContentFiltered = errors.New("content filtered")
+
+// ContentFilteredError reports a response the provider stopped or withheld on
+// safety grounds. Content holds whatever was generated before the cut-off.
+type ContentFilteredError struct {
+	Provider string
+	// Reason is the provider's own finish or block reason, such as
+	// "content_filter", "refusal" or "SAFETY".
+	Reason  string
+	Content string
+}
+
+func (e *ContentFilteredError) Error() string {
+	return fmt.Sprintf("%s filtered the response: %s", e.Provider, e.Reason)
+}
+
+func (e *ContentFilteredError) Unwrap() error {
+	return ErrContentFiltered
+}
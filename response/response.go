@@ -1,6 +1,7 @@
 package response
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/flyx-ai/heimdall/models"
@@ -9,30 +10,228 @@ import (
 type Event struct {
 	Timestamp   time.Time
 	Description string
+	RequestID   string
 }
 
 type Logging struct {
+	// RequestID correlates this request across the router, providers and
+	// the X-Request-ID header sent upstream.
+	RequestID string
 	Completed bool
 	Start     time.Time
 	End       time.Time
-	Events    []Event
-	Model     models.Model
-	SystemMsg string
-	UserMsg   string
-	Response  string
+	// TimeToFirstToken is how long a stream took to deliver its first
+	// chunk, measured from Start. It is zero for completions.
+	TimeToFirstToken time.Duration
+	Events           []Event
+	Model            models.Model
+	SystemMsg        string
+	UserMsg          string
+	Response         string
+	// Provider, Usage and the costs describe the attempt that succeeded and
+	// are left empty when every model failed.
+	Provider string
+	Usage    Usage
+	// InputCost and OutputCost price the prompt and the response, in USD,
+	// from Usage and the model's per-token rates, or from the model's
+	// EstimateCost of the text when either is unavailable. Cost is their
+	// sum.
+	InputCost  float64
+	OutputCost float64
+	Cost       float64
+	// DegradedFrom names the rate-limited model the request was moved off
+	// when the router degraded it to a cheaper sibling.
+	DegradedFrom string
+	// MidStreamFailures counts the attempts that failed part way through a
+	// response, after streaming at least one chunk or after the response
+	// body broke off. Failures before any chunk reached the caller restart
+	// the retry backoff from its shortest delay, as they point to a sporadic
+	// drop rather than an outage; later ones are not retried.
+	MidStreamFailures int
+	Tags              map[string]string
+}
+
+// StampRequestID copies the log's RequestID onto every event that does not
+// carry one yet.
+func (l *Logging) StampRequestID() {
+	for i := range l.Events {
+		if l.Events[i].RequestID == "" {
+			l.Events[i].RequestID = l.RequestID
+		}
+	}
 }
 
 type Usage struct {
 	PromptTokens     int
 	CompletionTokens int
 	TotalTokens      int
+	// CachedTokens is the part of PromptTokens served from a prompt cache,
+	// explicit or implicit, and billed at the discounted cache rate.
+	CachedTokens int
+	// ReasoningTokens is the part of CompletionTokens the model spent on
+	// hidden reasoning before answering.
+	ReasoningTokens int
+	// AcceptedPredictionTokens and RejectedPredictionTokens are the parts of
+	// CompletionTokens that matched and did not match the request's
+	// Prediction. Rejected tokens are billed like any other output.
+	AcceptedPredictionTokens int
+	RejectedPredictionTokens int
+	// Estimated is set when the provider did not report usage and the counts
+	// were approximated from the request and response text.
+	Estimated bool
+}
+
+// Add returns the sum of two usages, for a response assembled from several
+// requests.
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:             u.PromptTokens + other.PromptTokens,
+		CompletionTokens:         u.CompletionTokens + other.CompletionTokens,
+		TotalTokens:              u.TotalTokens + other.TotalTokens,
+		CachedTokens:             u.CachedTokens + other.CachedTokens,
+		ReasoningTokens:          u.ReasoningTokens + other.ReasoningTokens,
+		AcceptedPredictionTokens: u.AcceptedPredictionTokens + other.AcceptedPredictionTokens,
+		RejectedPredictionTokens: u.RejectedPredictionTokens + other.RejectedPredictionTokens,
+		Estimated:                u.Estimated || other.Estimated,
+	}
+}
+
+// SearchResult is a web source consulted while generating a response.
+type SearchResult struct {
+	Title string
+	URL   string
+	// Date is the publication date as reported by the provider, typically
+	// YYYY-MM-DD. It is empty when the provider does not know it.
+	Date string
+}
+
+// ToolCall is a call the model asks the caller to make to one of the tools
+// given in the request.
+type ToolCall struct {
+	// ID is echoed back with the tool's result so the model can match them.
+	ID   string
+	Name string
+	// Arguments are the call's arguments as a JSON object.
+	Arguments json.RawMessage
+}
+
+// Step is one turn of an agent run: the model's reply and the results of the
+// tools it called.
+type Step struct {
+	Content   string
+	ToolCalls []ToolCall
+	// Results holds what each tool returned, in the order of ToolCalls. A
+	// tool that failed is reported to the model, and here, as
+	// "error: " followed by its error.
+	Results []string
+	Usage   Usage
+}
+
+// ServerToolCall is a tool call the provider executed itself, such as
+// Anthropic's web search or code execution.
+type ServerToolCall struct {
+	ID string
+	// Name is the tool called, such as "web_search" or "code_execution".
+	Name string
+	// Input holds the arguments the model passed to the tool, as JSON.
+	Input json.RawMessage
+	// Result holds the provider's result block for the call, as JSON. It is
+	// empty when the response ended before the result arrived.
+	Result json.RawMessage
+}
+
+// Audio is spoken output returned by a model asked to answer with audio.
+type Audio struct {
+	// ID identifies the audio so a later turn can refer back to it.
+	ID string
+	// Data is the decoded audio, encoded as Format.
+	Data   []byte
+	Format string
+	// Transcript is the text of what was spoken.
+	Transcript string
 }
+
+// Image is an image generated by the model.
+type Image struct {
+	MimeType string
+	// Data is the decoded image, encoded as MimeType.
+	Data []byte
+}
+
+// FinishReason is the normalised reason a provider gave for ending
+// generation. Each provider maps its own values, such as Anthropic's
+// "end_turn" or Gemini's "MAX_TOKENS", onto these constants.
+type FinishReason string
+
+const (
+	// FinishStop means the model finished naturally or hit a stop sequence.
+	FinishStop FinishReason = "stop"
+	// FinishLength means generation reached the output token limit.
+	FinishLength FinishReason = "length"
+	// FinishContentFilter means the provider stopped generation for safety
+	// or policy reasons.
+	FinishContentFilter FinishReason = "content_filter"
+	// FinishToolCalls means the model stopped to call a tool.
+	FinishToolCalls FinishReason = "tool_calls"
+	// FinishError means generation ended for any other reason, such as a
+	// malformed tool call.
+	FinishError FinishReason = "error"
+)
+
 type Completion struct {
-	Content     string
-	Thoughts    string
-	Model       string
-	Usage       Usage
-	RequestLog  Logging
-	RawRequest  []byte
+	Content string
+	// Choices holds the text of every candidate, in index order, when the
+	// request asked for more than one. Content is the first of them.
+	Choices  []string
+	Thoughts string
+	Model    string
+	// Provider is the name of the provider that served the response, as
+	// reported by its Name method.
+	Provider string
+	// FinishReason is why generation ended, normalised across providers.
+	// It is empty when the provider did not report a reason.
+	FinishReason FinishReason
+	// Partial is set when Content is JSON repaired from a response cut off
+	// by the token limit, see request.Completion.SalvagePartialJSON. Fields
+	// past the cut are missing and the last value may be incomplete. It is
+	// also set, alongside an error, on the content read before a stream
+	// outgrew the provider's content limit.
+	Partial bool
+	// TruncatedContent holds the content as generated, before the repair,
+	// when Partial is set.
+	TruncatedContent string
+	// ServiceTier is the processing tier the provider reports having applied,
+	// where it reports one.
+	ServiceTier string
+	// SearchResults lists the web sources a search-backed model consulted.
+	SearchResults []SearchResult
+	// Citations lists the URLs of the sources a search-backed model cited.
+	Citations []string
+	// ToolCalls lists the tools the model asks the caller to run, in call
+	// order, when generation stopped with FinishToolCalls.
+	ToolCalls []ToolCall
+	// ServerToolCalls lists the tools the provider ran on its own side while
+	// generating, in call order.
+	ServerToolCalls []ServerToolCall
+	// Audio holds the spoken response, for models asked to answer with
+	// audio. Its transcript is not part of Content.
+	Audio *Audio
+	// Images holds the images generated by image output models, decoded, in
+	// the order the provider returned them.
+	Images     []Image
+	Usage      Usage
+	RequestLog Logging
+	// RawRequest is the JSON body sent to the provider.
+	RawRequest []byte
+	// RawResponse preserves the provider's native response for fields the
+	// normalised struct does not model, such as OpenAI's system_fingerprint
+	// or Gemini's safetyRatings. Streamed responses are a JSON array of
+	// every event received, in order.
 	RawResponse []byte
 }
+
+// Truncated reports whether generation stopped because it reached the
+// output token limit rather than finishing naturally.
+func (c Completion) Truncated() bool {
+	return c.FinishReason == FinishLength
+}
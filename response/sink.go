@@ -0,0 +1,154 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// LoggingSink receives the log of every request the router finishes.
+type LoggingSink interface {
+	Write(log *Logging) error
+}
+
+type jsonlEvent struct {
+	Type        string    `json:"type"`
+	RequestID   string    `json:"request_id,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	Description string    `json:"description"`
+}
+
+type jsonlUsage struct {
+	PromptTokens     int  `json:"prompt_tokens"`
+	CompletionTokens int  `json:"completion_tokens"`
+	TotalTokens      int  `json:"total_tokens"`
+	CachedTokens     int  `json:"cached_tokens"`
+	ReasoningTokens  int  `json:"reasoning_tokens"`
+	Estimated        bool `json:"estimated"`
+}
+
+type jsonlSummary struct {
+	Type               string            `json:"type"`
+	RequestID          string            `json:"request_id,omitempty"`
+	Model              string            `json:"model,omitempty"`
+	Provider           string            `json:"provider,omitempty"`
+	Completed          bool              `json:"completed"`
+	Start              time.Time         `json:"start"`
+	End                time.Time         `json:"end"`
+	DurationMS         int64             `json:"duration_ms"`
+	TimeToFirstTokenMS int64             `json:"time_to_first_token_ms,omitempty"`
+	Usage              jsonlUsage        `json:"usage"`
+	InputCost          float64           `json:"input_cost"`
+	OutputCost         float64           `json:"output_cost"`
+	Cost               float64           `json:"cost"`
+	DegradedFrom       string            `json:"degraded_from,omitempty"`
+	MidStreamFailures  int               `json:"mid_stream_failures,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty"`
+}
+
+// MarshalJSONL encodes the log as JSON Lines: one "event" record per event
+// followed by a single "summary" record, each terminated by a newline so the
+// output can be appended to a .jsonl file as is.
+func (l *Logging) MarshalJSONL() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	for _, event := range l.Events {
+		requestID := event.RequestID
+		if requestID == "" {
+			requestID = l.RequestID
+		}
+
+		if err := enc.Encode(jsonlEvent{
+			Type:        "event",
+			RequestID:   requestID,
+			Timestamp:   event.Timestamp,
+			Description: event.Description,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	summary := jsonlSummary{
+		Type:               "summary",
+		RequestID:          l.RequestID,
+		Provider:           l.Provider,
+		Completed:          l.Completed,
+		Start:              l.Start,
+		End:                l.End,
+		DurationMS:         l.End.Sub(l.Start).Milliseconds(),
+		TimeToFirstTokenMS: l.TimeToFirstToken.Milliseconds(),
+		Usage: jsonlUsage{
+			PromptTokens:     l.Usage.PromptTokens,
+			CompletionTokens: l.Usage.CompletionTokens,
+			TotalTokens:      l.Usage.TotalTokens,
+			CachedTokens:     l.Usage.CachedTokens,
+			ReasoningTokens:  l.Usage.ReasoningTokens,
+			Estimated:        l.Usage.Estimated,
+		},
+		InputCost:         l.InputCost,
+		OutputCost:        l.OutputCost,
+		Cost:              l.Cost,
+		DegradedFrom:      l.DegradedFrom,
+		MidStreamFailures: l.MidStreamFailures,
+		Tags:              l.Tags,
+	}
+	if l.Model != nil {
+		summary.Model = l.Model.GetName()
+	}
+	if err := enc.Encode(summary); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriterSink writes each log as JSON Lines to an io.Writer. It is safe for
+// concurrent use.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink returns a sink that appends JSON Lines to w.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// Write implements LoggingSink.
+func (s *WriterSink) Write(log *Logging) error {
+	b, err := log.MarshalJSONL()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(b)
+	return err
+}
+
+// FileSink appends each log as JSON Lines to a file.
+type FileSink struct {
+	*WriterSink
+	f *os.File
+}
+
+// NewFileSink opens path for appending, creating it if needed.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{WriterSink: NewWriterSink(f), f: f}, nil
+}
+
+// Close closes the underlying file.
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
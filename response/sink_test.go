@@ -0,0 +1,95 @@
+package response_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLogging() *response.Logging {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	return &response.Logging{
+		RequestID: "req-1",
+		Completed: true,
+		Start:     start,
+		End:       start.Add(1500 * time.Millisecond),
+		Events: []response.Event{
+			{Timestamp: start, Description: "start of call to Complete"},
+			{Timestamp: start.Add(time.Second), Description: "attempting tryWithModel"},
+		},
+		Model:      models.GPT4OMini{},
+		Provider:   models.OpenaiProvider,
+		Usage:      response.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		InputCost:  0.125,
+		OutputCost: 0.125,
+		Cost:       0.25,
+		Tags:       map[string]string{"team": "data"},
+	}
+}
+
+func decodeJSONL(t *testing.T, b []byte) []map[string]any {
+	t.Helper()
+
+	var records []map[string]any
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+
+	return records
+}
+
+func TestLoggingMarshalJSONL(t *testing.T) {
+	t.Parallel()
+
+	b, err := testLogging().MarshalJSONL()
+	require.NoError(t, err)
+
+	records := decodeJSONL(t, b)
+	require.Len(t, records, 3)
+
+	assert.Equal(t, "event", records[0]["type"])
+	assert.Equal(t, "req-1", records[0]["request_id"])
+	assert.Equal(t, "start of call to Complete", records[0]["description"])
+
+	summary := records[2]
+	assert.Equal(t, "summary", summary["type"])
+	assert.Equal(t, models.GPT4OMiniAlias, summary["model"])
+	assert.Equal(t, models.OpenaiProvider, summary["provider"])
+	assert.Equal(t, true, summary["completed"])
+	assert.Equal(t, float64(1500), summary["duration_ms"])
+	assert.Equal(t, 0.125, summary["input_cost"])
+	assert.Equal(t, 0.125, summary["output_cost"])
+	assert.Equal(t, 0.25, summary["cost"])
+	assert.Equal(t, float64(15), summary["usage"].(map[string]any)["total_tokens"])
+	assert.Equal(t, map[string]any{"team": "data"}, summary["tags"])
+}
+
+func TestFileSink(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	sink, err := response.NewFileSink(path)
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Write(testLogging()))
+	require.NoError(t, sink.Write(testLogging()))
+	require.NoError(t, sink.Close())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Len(t, decodeJSONL(t, b), 6)
+}
@@ -0,0 +1,237 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// StreamStructured returns a chunk handler for streamed structured output. It
+// accumulates the JSON object arriving in chunks and calls handler with the
+// key and decoded value of each top-level field as soon as that field is
+// complete, so callers can render fields before the whole object has arrived.
+//
+// Any text before the opening brace, such as a markdown code fence, is
+// ignored. The returned handler errors if a completed field is not valid JSON.
+func StreamStructured(handler func(path string, value any)) func(chunk string) error {
+	s := &structuredStream{handler: handler}
+	return s.write
+}
+
+type structuredStream struct {
+	handler func(path string, value any)
+
+	buf         []byte
+	pos         int
+	depth       int
+	inString    bool
+	escaped     bool
+	memberStart int
+	done        bool
+}
+
+func (s *structuredStream) write(chunk string) error {
+	if s.done {
+		return nil
+	}
+
+	s.buf = append(s.buf, chunk...)
+
+	for ; s.pos < len(s.buf); s.pos++ {
+		c := s.buf[s.pos]
+
+		if s.inString {
+			switch {
+			case s.escaped:
+				s.escaped = false
+			case c == '\\':
+				s.escaped = true
+			case c == '"':
+				s.inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			if s.depth > 0 {
+				s.inString = true
+			}
+		case '{', '[':
+			if s.depth == 0 && c == '[' {
+				continue
+			}
+			s.depth++
+			if s.depth == 1 {
+				s.memberStart = s.pos + 1
+			}
+		case '}', ']':
+			if s.depth == 0 {
+				continue
+			}
+			s.depth--
+			if s.depth == 0 {
+				s.done = true
+				return s.emit(s.buf[s.memberStart:s.pos])
+			}
+		case ',':
+			if s.depth == 1 {
+				if err := s.emit(s.buf[s.memberStart:s.pos]); err != nil {
+					return err
+				}
+				s.memberStart = s.pos + 1
+			}
+		}
+	}
+
+	return nil
+}
+
+// emit decodes a single `"key": value` member and hands it to the handler.
+func (s *structuredStream) emit(member []byte) error {
+	member = bytes.TrimSpace(member)
+	if len(member) == 0 {
+		return nil
+	}
+
+	var field map[string]any
+	wrapped := append(append([]byte{'{'}, member...), '}')
+	if err := json.Unmarshal(wrapped, &field); err != nil {
+		return fmt.Errorf("decode structured output field: %w", err)
+	}
+
+	for key, value := range field {
+		s.handler(key, value)
+	}
+
+	return nil
+}
+
+// RepairJSON makes a best-effort attempt to turn JSON cut off part way, such
+// as structured output truncated by the token limit, into a valid document.
+// It closes an open string and every open object and array, dropping
+// whatever trailing key or literal cannot be completed, and returns the
+// repaired JSON with ok set. A leading markdown code fence is skipped.
+// Content that does not start with an object or array, or cannot be
+// repaired, reports false.
+//
+// The result is only as complete as the input: a string or number cut off
+// part way is kept as far as it got.
+func RepairJSON(content string) (repaired string, ok bool) {
+	content = strings.TrimSpace(content)
+	if fenced, found := strings.CutPrefix(content, "```"); found {
+		_, content, _ = strings.Cut(fenced, "\n")
+		content = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(content), "```"))
+	}
+	if content == "" || (content[0] != '{' && content[0] != '[') {
+		return "", false
+	}
+	if json.Valid([]byte(content)) {
+		return content, true
+	}
+
+	// Try the whole input first, then cut back one delimiter at a time to
+	// drop a trailing member that cannot be completed.
+	cuts := append(jsonDelimiters(content), len(content))
+	for _, cut := range slices.Backward(cuts) {
+		candidate := closeJSON(content[:cut])
+		if json.Valid([]byte(candidate)) {
+			return candidate, true
+		}
+	}
+
+	return "", false
+}
+
+// jsonDelimiters returns the offsets just after each comma, colon and
+// opening bracket of content that is not inside a string.
+func jsonDelimiters(content string) []int {
+	var cuts []int
+	inString, escaped := false, false
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case ',', ':', '{', '[':
+			cuts = append(cuts, i+1)
+		}
+	}
+
+	return cuts
+}
+
+// closeJSON completes a prefix of a JSON document by closing an open string
+// and the open objects and arrays, in order. A trailing comma is dropped and
+// a key left without a value is given null.
+func closeJSON(prefix string) string {
+	var open []byte
+	inString, escaped := false, false
+	for i := 0; i < len(prefix); i++ {
+		c := prefix[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			open = append(open, '}')
+		case '[':
+			open = append(open, ']')
+		case '}', ']':
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	if inString {
+		if escaped {
+			trimmed := strings.TrimSuffix(b.String(), "\\")
+			b.Reset()
+			b.WriteString(trimmed)
+		}
+		b.WriteByte('"')
+	}
+
+	out := strings.TrimRightFunc(b.String(), func(r rune) bool {
+		return r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	})
+	out = strings.TrimSuffix(out, ",")
+	if strings.HasSuffix(out, ":") {
+		out += "null"
+	}
+
+	closed := []byte(out)
+	for i := len(open) - 1; i >= 0; i-- {
+		closed = append(closed, open[i])
+	}
+
+	return string(closed)
+}
@@ -0,0 +1,127 @@
+package response_test
+
+import (
+	"testing"
+
+	"github.com/flyx-ai/heimdall/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamStructured(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should emit each top-level field once it is complete", func(t *testing.T) {
+		var paths []string
+		values := map[string]any{}
+		handler := response.StreamStructured(func(path string, value any) {
+			paths = append(paths, path)
+			values[path] = value
+		})
+
+		chunks := []string{
+			"```json\n{\"tit",
+			"le\": \"Hello, {world}\", \"tags\": [\"a\",",
+			" \"b\"], \"meta\": {\"n\": 1, \"q\": \"\\\"x\\\"\"}",
+			", \"count\": 3}\n```",
+		}
+
+		require.NoError(t, handler(chunks[0]))
+		assert.Empty(t, paths)
+
+		require.NoError(t, handler(chunks[1]))
+		assert.Equal(t, []string{"title"}, paths)
+
+		require.NoError(t, handler(chunks[2]))
+		assert.Equal(t, []string{"title", "tags"}, paths)
+
+		require.NoError(t, handler(chunks[3]))
+		assert.Equal(t, []string{"title", "tags", "meta", "count"}, paths)
+
+		assert.Equal(t, "Hello, {world}", values["title"])
+		assert.Equal(t, []any{"a", "b"}, values["tags"])
+		assert.Equal(t, map[string]any{"n": float64(1), "q": `"x"`}, values["meta"])
+		assert.Equal(t, float64(3), values["count"])
+	})
+
+	t.Run("should error on a malformed field", func(t *testing.T) {
+		handler := response.StreamStructured(func(path string, value any) {})
+
+		require.Error(t, handler(`{"title": nope, "count": 3}`))
+	})
+}
+
+func TestRepairJSON(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+		want    string
+		wantOK  bool
+	}{
+		"should keep valid JSON": {
+			content: `{"a": 1}`,
+			want:    `{"a": 1}`,
+			wantOK:  true,
+		},
+		"should close an open string and object": {
+			content: `{"title": "Hello, wor`,
+			want:    `{"title": "Hello, wor"}`,
+			wantOK:  true,
+		},
+		"should close nested arrays and objects in order": {
+			content: `{"items": [{"id": 1, "tags": ["a", "b"`,
+			want:    `{"items": [{"id": 1, "tags": ["a", "b"]}]}`,
+			wantOK:  true,
+		},
+		"should drop a trailing comma": {
+			content: `[1, 2, `,
+			want:    `[1, 2]`,
+			wantOK:  true,
+		},
+		"should drop a key cut off before its value": {
+			content: `{"a": 1, "bet`,
+			want:    `{"a": 1}`,
+			wantOK:  true,
+		},
+		"should null a key whose value is missing": {
+			content: `{"a": 1, "b":`,
+			want:    `{"a": 1, "b":null}`,
+			wantOK:  true,
+		},
+		"should drop a literal cut off part way": {
+			content: `{"a": 1, "ok": tr`,
+			want:    `{"a": 1, "ok":null}`,
+			wantOK:  true,
+		},
+		"should drop a dangling escape": {
+			content: `{"q": "say \`,
+			want:    `{"q": "say "}`,
+			wantOK:  true,
+		},
+		"should ignore brackets inside strings": {
+			content: `{"s": "{[", "n": [1`,
+			want:    `{"s": "{[", "n": [1]}`,
+			wantOK:  true,
+		},
+		"should skip a markdown code fence": {
+			content: "```json\n{\"a\": [1, 2",
+			want:    `{"a": [1, 2]}`,
+			wantOK:  true,
+		},
+		"should reject prose": {
+			content: "1, 2 and then",
+		},
+		"should reject empty content": {},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := response.RepairJSON(tt.content)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -2,7 +2,9 @@ package heimdall
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/flyx-ai/heimdall/models"
@@ -21,9 +23,18 @@ func (r *Router) Stream(
 		return response.Completion{}, ErrNoChunkHandler
 	}
 
+	req = req.ApplyDefaults(request.DefaultsFromContext(ctx))
+	if err := req.Validate(); err != nil {
+		return response.Completion{}, err
+	}
+
 	req.Tags["request_type"] = "stream"
 
-	models := append([]models.Model{req.Model}, req.Fallback...)
+	id := requestID(req)
+	ctx = request.WithID(ctx, id)
+
+	var served models.Model
+	candidates := r.streamLatency.order(append([]models.Model{req.Model}, req.Fallback...))
 	var resp response.Completion
 	var err error
 
@@ -34,12 +45,13 @@ func (r *Router) Stream(
 				Description: "start of call to Stream",
 			},
 		},
+		RequestID: id,
 		SystemMsg: req.SystemMessage,
 		UserMsg:   req.UserMessage,
 		Start:     now,
 	}
 
-	for _, model := range models {
+	for _, model := range candidates {
 		if r.providers[model.GetProvider()] == nil {
 			requestLog.Events = append(requestLog.Events, response.Event{
 				Timestamp: time.Now(),
@@ -49,6 +61,26 @@ func (r *Router) Stream(
 					model.GetProvider(),
 				),
 			})
+			if err == nil {
+				// Fail rather than return an empty response when no
+				// model has a provider, but keep a real provider error.
+				err = fmt.Errorf("%w: %s", ErrUnsupportedProvider, model.GetProvider())
+			}
+
+			continue
+		}
+		if supportErr := r.checkSupported(model); supportErr != nil {
+			requestLog.Events = append(requestLog.Events, response.Event{
+				Timestamp: time.Now(),
+				Description: fmt.Sprintf(
+					"attempting tryStreamWithModel using model: %s but provider: %s does not support it. attempting with next model.",
+					model.GetName(),
+					model.GetProvider(),
+				),
+			})
+			if err == nil {
+				err = supportErr
+			}
 
 			continue
 		}
@@ -67,23 +99,31 @@ func (r *Router) Stream(
 			chunkHandler,
 			&requestLog,
 		)
+		if err != nil && resp.Partial {
+			// The caller already holds the content up to the provider's
+			// content limit, so it is not streamed again by a fallback.
+			break
+		}
+		if err != nil {
+			resp, model, err = r.degrade(ctx, req, model, err, chunkHandler, &requestLog)
+		}
 		if err == nil {
+			served = model
 			break
 		}
 	}
 
-	requestLog.Completed = err == nil
-	if err == nil {
-		requestLog.Response = resp.Content
-	}
-
-	requestLog.End = time.Now()
+	r.finishLog(&requestLog, req, served, resp, err)
 
 	resp.RequestLog = requestLog
 
 	return resp, err
 }
 
+// maxStreamResumes caps how many times a dropped stream is resumed, see
+// request.Completion.ResumeOnDrop.
+const maxStreamResumes = 3
+
 func (r *Router) tryStreamWithModel(
 	ctx context.Context,
 	req request.Completion,
@@ -91,12 +131,80 @@ func (r *Router) tryStreamWithModel(
 	chunkHandler func(chunk string) error,
 	requestLog *response.Logging,
 ) (response.Completion, error) {
+	req.Model = model
 	provider := r.providers[model.GetProvider()]
-	return provider.StreamResponse(
+
+	// Keep the text handed to the caller so a dropped stream can be resumed
+	// from where it stopped.
+	var (
+		streamed   strings.Builder
+		handlerErr error
+		firstChunk time.Duration
+	)
+	start := time.Now()
+	handler := func(chunk string) error {
+		if firstChunk == 0 {
+			firstChunk = time.Since(start)
+			if requestLog.TimeToFirstToken == 0 {
+				requestLog.TimeToFirstToken = time.Since(requestLog.Start)
+			}
+		}
+		if err := chunkHandler(chunk); err != nil {
+			handlerErr = err
+			return err
+		}
+		streamed.WriteString(chunk)
+		return nil
+	}
+
+	resp, err := provider.StreamResponse(
 		ctx,
 		r.client,
 		req,
-		chunkHandler,
+		handler,
 		requestLog,
 	)
+	if err == nil && firstChunk > 0 {
+		r.streamLatency.observe(model, firstChunk)
+	}
+
+	// Only a provider that continues the streamed text in place can resume
+	// it; others would start a new answer, so their drops are returned.
+	resumable := req.ResumeOnDrop && supportsPrefill(provider)
+	for i := 0; resumable && i < maxStreamResumes; i++ {
+		dropped := err != nil && handlerErr == nil && ctx.Err() == nil &&
+			streamed.Len() > 0 && !resp.Partial &&
+			!errors.Is(err, response.ErrContentFiltered)
+		if !dropped {
+			break
+		}
+
+		requestLog.Events = append(requestLog.Events, response.Event{
+			Timestamp: time.Now(),
+			Description: fmt.Sprintf(
+				"stream dropped after %v bytes, resuming. err: %v, resume: %v",
+				streamed.Len(),
+				err,
+				i+1,
+			),
+		})
+
+		prefix := streamed.String()
+		resp, err = provider.StreamResponse(
+			ctx,
+			r.client,
+			req.WithAssistantReply(response.Completion{Content: prefix}),
+			handler,
+			requestLog,
+		)
+		if err == nil {
+			resp.Content = prefix + resp.Content
+		}
+	}
+
+	if err == nil && resp.Provider == "" {
+		resp.Provider = provider.Name()
+	}
+
+	return resp, err
 }
@@ -0,0 +1,278 @@
+package heimdall_test
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/flyx-ai/heimdall"
+	"github.com/flyx-ai/heimdall/models"
+	"github.com/flyx-ai/heimdall/request"
+	"github.com/flyx-ai/heimdall/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterStreamStopsWhenClientDisconnects(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	router := heimdall.New(time.Minute, []heimdall.LLMProvider{
+		fakeProvider{
+			name:      models.OpenaiProvider,
+			delay:     time.Minute,
+			cancelled: cancelled,
+			onCall: func(ctx context.Context, requestLog *response.Logging) {
+				close(started)
+			},
+		},
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = router.Stream(r.Context(), request.Completion{
+			Model:       models.GPT4OMini{},
+			UserMessage: "Write a long story.",
+			Tags:        map[string]string{},
+		}, func(chunk string) error {
+			_, err := w.Write([]byte(chunk))
+			return err
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+
+	go func() {
+		resp, err := srv.Client().Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("provider was never called")
+	}
+	cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("provider context was not cancelled after the client disconnected")
+	}
+}
+
+// streamAttempt is one scripted call to a droppingProvider: the chunks it
+// streams before failing with err, or finishing when err is nil. A partial
+// attempt returns its chunks along with err, as a provider does at its
+// content limit.
+type streamAttempt struct {
+	chunks  []string
+	err     error
+	partial bool
+}
+
+// droppingProvider answers successive stream calls with the next of its
+// attempts and records every request it receives.
+type droppingProvider struct {
+	// name defaults to Anthropic's, whose prefill lets a stream resume.
+	name     string
+	attempts []streamAttempt
+	requests *[]request.Completion
+}
+
+func (p droppingProvider) CompleteResponse(
+	ctx context.Context,
+	req request.Completion,
+	client http.Client,
+	requestLog *response.Logging,
+) (response.Completion, error) {
+	return p.StreamResponse(ctx, client, req, nil, requestLog)
+}
+
+func (p droppingProvider) StreamResponse(
+	ctx context.Context,
+	client http.Client,
+	req request.Completion,
+	chunkHandler func(chunk string) error,
+	requestLog *response.Logging,
+) (response.Completion, error) {
+	*p.requests = append(*p.requests, req)
+	attempt := p.attempts[len(*p.requests)-1]
+
+	var content string
+	for _, chunk := range attempt.chunks {
+		if err := chunkHandler(chunk); err != nil {
+			return response.Completion{}, err
+		}
+		content += chunk
+	}
+	if attempt.partial {
+		return response.Completion{Content: content, Partial: true}, attempt.err
+	}
+	if attempt.err != nil {
+		return response.Completion{}, attempt.err
+	}
+
+	return response.Completion{Content: content, FinishReason: response.FinishStop}, nil
+}
+
+func (p droppingProvider) Name() string {
+	return cmp.Or(p.name, models.AnthropicProvider)
+}
+
+func (p droppingProvider) SupportsPrefill() bool {
+	return p.Name() == models.AnthropicProvider
+}
+
+func TestRouterStreamResumeOnDrop(t *testing.T) {
+	t.Parallel()
+
+	drop := streamAttempt{chunks: []string{"Once upon"}, err: io.ErrUnexpectedEOF}
+	finish := streamAttempt{chunks: []string{" a time."}}
+
+	tests := map[string]struct {
+		resume      bool
+		attempts    []streamAttempt
+		wantCalls   int
+		wantContent string
+		wantErr     bool
+	}{
+		"should resume a dropped stream": {
+			resume:      true,
+			attempts:    []streamAttempt{drop, finish},
+			wantCalls:   2,
+			wantContent: "Once upon a time.",
+		},
+		"should fail a dropped stream when disabled": {
+			attempts:  []streamAttempt{drop, finish},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		"should not resume a stream that failed before any output": {
+			resume:    true,
+			attempts:  []streamAttempt{{err: io.ErrUnexpectedEOF}, finish},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		"should stop at the resume cap": {
+			resume: true,
+			attempts: []streamAttempt{
+				drop,
+				{chunks: []string{" a"}, err: io.ErrUnexpectedEOF},
+				{chunks: []string{" time"}, err: io.ErrUnexpectedEOF},
+				{chunks: []string{","}, err: io.ErrUnexpectedEOF},
+				finish,
+			},
+			wantCalls: 4,
+			wantErr:   true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var requests []request.Completion
+			router := heimdall.New(time.Second, []heimdall.LLMProvider{
+				droppingProvider{attempts: tt.attempts, requests: &requests},
+			})
+
+			var streamed string
+			res, err := router.Stream(context.Background(), request.Completion{
+				Model:        models.Claude45Haiku{},
+				UserMessage:  "Tell me a story.",
+				ResumeOnDrop: tt.resume,
+				Tags:         map[string]string{},
+			}, func(chunk string) error {
+				streamed += chunk
+				return nil
+			})
+
+			require.Len(t, requests, tt.wantCalls)
+			if tt.wantErr {
+				require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantContent, res.Content)
+			assert.Equal(t, tt.wantContent, streamed, "chunks should not be repeated")
+			assert.Empty(t, requests[1].UserMessage)
+			assert.Equal(t, []request.Message{
+				{Role: "user", Content: "Tell me a story."},
+				{Role: "assistant", Content: "Once upon"},
+			}, requests[1].History)
+		})
+	}
+}
+
+func TestRouterStreamResumeOnDropWithoutPrefill(t *testing.T) {
+	t.Parallel()
+
+	var requests []request.Completion
+	router := heimdall.New(time.Second, []heimdall.LLMProvider{
+		droppingProvider{
+			name: models.OpenaiProvider,
+			attempts: []streamAttempt{
+				{chunks: []string{"Once upon"}, err: io.ErrUnexpectedEOF},
+				{chunks: []string{"Here is a new story."}},
+			},
+			requests: &requests,
+		},
+	})
+
+	_, err := router.Stream(context.Background(), request.Completion{
+		Model:        models.GPT4OMini{},
+		UserMessage:  "Tell me a story.",
+		ResumeOnDrop: true,
+		Tags:         map[string]string{},
+	}, func(chunk string) error { return nil })
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	assert.Len(t, requests, 1, "a provider without prefill would start a new answer")
+}
+
+func TestRouterStreamReturnsPartialContent(t *testing.T) {
+	t.Parallel()
+
+	errTooLarge := errors.New("content too large")
+
+	var requests []request.Completion
+	router := heimdall.New(time.Second, []heimdall.LLMProvider{
+		droppingProvider{
+			attempts: []streamAttempt{
+				{chunks: []string{"Once upon"}, err: errTooLarge, partial: true},
+				{chunks: []string{"Once upon a time."}},
+			},
+			requests: &requests,
+		},
+	})
+
+	var streamed string
+	res, err := router.Stream(context.Background(), request.Completion{
+		Model:        models.Claude45Haiku{},
+		Fallback:     []models.Model{models.Claude45Sonnet{}},
+		UserMessage:  "Tell me a story.",
+		ResumeOnDrop: true,
+		Tags:         map[string]string{},
+	}, func(chunk string) error {
+		streamed += chunk
+		return nil
+	})
+	require.ErrorIs(t, err, errTooLarge)
+
+	assert.Len(t, requests, 1, "partial content should be neither resumed nor retried on a fallback")
+	assert.True(t, res.Partial)
+	assert.Equal(t, "Once upon", res.Content)
+	assert.Equal(t, "Once upon", streamed)
+}